@@ -30,6 +30,7 @@ import (
 	"strings"
 
 	"github.com/kasvith/kache/internal/resp/resp3"
+	"github.com/kasvith/kache/pkg/util"
 
 	"github.com/c-bata/go-prompt"
 )
@@ -61,7 +62,13 @@ func Executor(s string) {
 		return
 	}
 
-	if err := c.Write(resp3.NewSliceResp3(strings.Split(s, " "))); err != nil {
+	args, err := util.SplitSpacesWithQuotes(s)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := c.Write(resp3.NewSliceResp3(args)); err != nil {
 		fmt.Println(err)
 		return
 	}
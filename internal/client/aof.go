@@ -0,0 +1,153 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+)
+
+var errAOFDisabled = errors.New("append only file is disabled")
+
+var (
+	// aof logs commands which modify the key space, nil when append only mode is disabled
+	aof *persistence.AOF
+
+	// aofRewriting is 1 while a rewrite is in progress
+	aofRewriting int32
+
+	// lastAOFRewriteFailed is 1 when the last rewrite failed
+	lastAOFRewriteFailed int32
+)
+
+// appendToAOF logs entries when append only mode is enabled, caller must hold the key space lock
+func appendToAOF(entries []persistence.Entry) {
+	if aof == nil || len(entries) == 0 {
+		return
+	}
+
+	if err := aof.Append(entries...); err != nil {
+		klogs.Logger.Error("error writing to append only file: ", err.Error())
+	}
+}
+
+// LoadAOF replays the append only file into databases, a missing file is not an error
+// When repair is true a file ending with an incomplete command is truncated to the last complete one
+// This should be called before accepting clients and opening the file with OpenAOF
+func LoadAOF(path string, repair bool) error {
+	start := time.Now()
+
+	replay := &Client{Protocol: RESP2, Writer: bufio.NewWriter(io.Discard), Database: databases[0]}
+	truncated, err := persistence.ReplayAOF(path, repair, func(cmd *protocol.Command) {
+		Execute(replay, cmd.Name, cmd.Args)
+	})
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if truncated {
+		klogs.Logger.Warnf("append only file %s ended with an incomplete command and was truncated", path)
+	}
+
+	// loaded data is not a change
+	atomic.StoreInt64(&dirty, 0)
+	klogs.Logger.Infof("append only file loaded from %s in %v", path, time.Since(start))
+	return nil
+}
+
+// OpenAOF starts logging commands to the append only file at path
+func OpenAOF(path, policy string) error {
+	f, err := persistence.OpenAOF(path, policy)
+	if err != nil {
+		return err
+	}
+
+	aof = f
+	return nil
+}
+
+// RewriteAOF compacts the append only file synchronously, this should be called before accepting clients
+func RewriteAOF() error {
+	base := persistence.RewriteCommands(databases)
+	if err := aof.StartRewrite(); err != nil {
+		return err
+	}
+
+	return aof.FinishRewrite(base)
+}
+
+// BgRewriteAOF compacts the append only file in background
+func BgRewriteAOF(client *Client, args []string) {
+	if aof == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errAOFDisabled})
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&aofRewriting, 0, 1) {
+		client.WriteError(&protocol.ErrGeneric{Err: persistence.ErrRewriteInProgress})
+		return
+	}
+
+	// the key space lock is held so no commands are appended between forking and starting the rewrite
+	if err := aof.StartRewrite(); err != nil {
+		atomic.StoreInt32(&aofRewriting, 0)
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+	forks, release := db.Fork(databases)
+
+	go func() {
+		start := time.Now()
+		base := persistence.RewriteCommands(forks)
+		release()
+
+		if err := aof.FinishRewrite(base); err != nil {
+			klogs.Logger.Error("background append only file rewrite failed: ", err.Error())
+			atomic.StoreInt32(&lastAOFRewriteFailed, 1)
+		} else {
+			klogs.Logger.Infof("background append only file rewrite finished in %v", time.Since(start))
+			atomic.StoreInt32(&lastAOFRewriteFailed, 0)
+		}
+
+		atomic.StoreInt32(&aofRewriting, 0)
+	}()
+
+	client.WriteSimpleString("Background append only file rewriting started")
+}
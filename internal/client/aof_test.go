@@ -0,0 +1,94 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/kasvith/kache/internal/persistence"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestBgRewriteAOF(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	keyspaceMux.Lock()
+	err := OpenAOF(path, persistence.FsyncNo)
+	keyspaceMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writes are pipelined in batches
+	for i := 0; i < 20000; i += 1000 {
+		for j := i; j < i+1000; j++ {
+			c.send("set", "k"+strconv.Itoa(j), strconv.Itoa(j))
+		}
+		for j := i; j < i+1000; j++ {
+			c.read()
+		}
+	}
+	c.do("rpush", "list", "a", "b")
+	c.do("hset", "hash", "f", "v")
+
+	assert.Equal("Background append only file rewriting started", c.do("bgrewriteaof"))
+
+	// commands run while the rewrite is in progress are kept once it finishes
+	for i := 0; i < 100; i++ {
+		c.do("incr", "k"+strconv.Itoa(i))
+	}
+	c.do("rpush", "list", "c")
+	c.do("hset", "hash", "f", "changed")
+	c.do("del", "k100")
+
+	eventually(t, func() bool { return c.info("aof_rewrite_in_progress") == "0" }, "the rewrite finished")
+	assert.Equal("ok", c.info("aof_last_bgrewrite_status"))
+	c.do("set", "after", "rewrite")
+
+	// replaying the rewritten file restores the same keys
+	keyspaceMux.Lock()
+	before, live := dumpKeys(t, databases[0]), databases
+	aof.Close()
+	aof, databases = nil, newDatabases(len(live))
+	keyspaceMux.Unlock()
+
+	err = LoadAOF(path, false)
+
+	keyspaceMux.Lock()
+	after := dumpKeys(t, databases[0])
+	databases = live
+	keyspaceMux.Unlock()
+
+	assert.NoError(err)
+	assert.Len(before, 20002)
+	assert.Equal(before, after)
+}
@@ -0,0 +1,318 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+)
+
+var (
+	errTimeoutNotFloat = errors.New("timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("timeout is negative")
+)
+
+const (
+	// unblockTimeout ends blocking with the reply of a timeout
+	unblockTimeout = iota
+
+	// unblockServed ends blocking after the client was served by the command which made a key ready
+	unblockServed
+
+	// unblockError ends blocking with an error
+	unblockError
+)
+
+// blockKey is a key of a database clients can block on
+type blockKey struct {
+	db  int
+	key string
+}
+
+// blockState holds a client blocked until one of its keys is ready
+type blockState struct {
+	client  *Client
+	keys    []blockKey
+	timeout time.Duration
+
+	// try serves the client when one of the keys is ready, it returns false without replying when nothing was served
+	try func(client *Client) bool
+
+	// writes are the keys try can modify
+	writes []string
+
+	// timeoutReply replies when the client is unblocked without being served
+	timeoutReply func(client *Client)
+
+	// done and reason are guarded by blockedMux
+	done   bool
+	reason int
+	wake   chan struct{}
+}
+
+var (
+	// blockedKeys holds the clients blocked on a key in the order they blocked
+	blockedKeys = make(map[blockKey][]*blockState)
+
+	// blockedClients is the number of blocked clients
+	blockedClients int64
+
+	// blockedMux guards the blocked clients, it is taken after the key space lock
+	blockedMux sync.Mutex
+
+	// readyKeys are keys which might serve blocked clients, they are guarded by the key space lock
+	readyKeys   []blockKey
+	readyKeySet = make(map[blockKey]struct{})
+)
+
+// parseTimeout converts a timeout in seconds which can have a fraction, 0 blocks forever
+func parseTimeout(arg string) (time.Duration, error) {
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v*float64(time.Second) > math.MaxInt64 {
+		return 0, &protocol.ErrGeneric{Err: errTimeoutNotFloat}
+	}
+
+	if v < 0 {
+		return 0, &protocol.ErrGeneric{Err: errTimeoutNegative}
+	}
+
+	return time.Duration(v * float64(time.Second)), nil
+}
+
+// canBlock reports whether the executing command is allowed to block the client
+// Commands of transactions and internal clients never block
+func (client *Client) canBlock() bool {
+	return client.Connection != nil && client.reader != nil && !client.denyBlocking
+}
+
+// block blocks the client on keys of the selected database until try serves it or timeout passes
+// Caller must hold the key space lock, the client waits once the command returns
+func (client *Client) block(keys []string, timeout time.Duration, try func(*Client) bool, timeoutReply func(*Client)) {
+	b := &blockState{client: client, timeout: timeout, try: try, writes: client.writes, timeoutReply: timeoutReply, wake: make(chan struct{})}
+	for _, key := range keys {
+		b.keys = append(b.keys, blockKey{db: client.DatabaseIndex, key: key})
+	}
+
+	blockedMux.Lock()
+	for _, k := range b.keys {
+		blockedKeys[k] = append(blockedKeys[k], b)
+	}
+	client.blocked = b
+	blockedMux.Unlock()
+
+	atomic.AddInt64(&blockedClients, 1)
+
+	// nothing is logged until the client is served
+	client.propagateAs()
+}
+
+// unblockLocked removes a blocked client from its keys, caller must hold blockedMux
+func (b *blockState) unblockLocked(reason int) {
+	if b.done {
+		return
+	}
+
+	for _, k := range b.keys {
+		waiters := blockedKeys[k]
+		for i, w := range waiters {
+			if w == b {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+
+		if len(waiters) == 0 {
+			delete(blockedKeys, k)
+		} else {
+			blockedKeys[k] = waiters
+		}
+	}
+
+	b.done, b.reason = true, reason
+	atomic.AddInt64(&blockedClients, -1)
+	close(b.wake)
+}
+
+// waitUnblocked waits until the blocked client is served, unblocked, times out or disconnects
+// It must be called without holding the key space lock
+func (client *Client) waitUnblocked() {
+	b := client.blocked
+
+	var timeout <-chan time.Time
+	if b.timeout > 0 {
+		timer := time.NewTimer(b.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	disconnected, stopWatching := client.watchConnection()
+	select {
+	case <-b.wake:
+	case <-timeout:
+	case <-disconnected:
+	}
+	stopWatching()
+
+	blockedMux.Lock()
+	b.unblockLocked(unblockTimeout)
+	reason := b.reason
+	client.blocked = nil
+	blockedMux.Unlock()
+
+	switch reason {
+	case unblockServed:
+		// the reply was written by the client which served us
+	case unblockError:
+		client.WriteError(&protocol.ErrUnblocked{})
+	default:
+		b.timeoutReply(client)
+	}
+}
+
+// watchConnection reports when a blocked client disconnects, stop must be called before reading from the client again
+func (client *Client) watchConnection() (disconnected <-chan struct{}, stop func()) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		// pipelined commands stay buffered for the parser
+		if _, err := client.reader.Peek(1); err != nil {
+			close(closed)
+		}
+	}()
+
+	return closed, func() {
+		// interrupt the pending read
+		client.Connection.SetReadDeadline(time.Now())
+		<-done
+		client.Connection.SetReadDeadline(time.Time{})
+	}
+}
+
+// unblockClient unblocks a blocked client as if it timed out or with an error, it returns false when it is not blocked
+func unblockClient(client *Client, withError bool) bool {
+	blockedMux.Lock()
+	defer blockedMux.Unlock()
+
+	b := client.blocked
+	if b == nil || b.done {
+		return false
+	}
+
+	if withError {
+		b.unblockLocked(unblockError)
+	} else {
+		b.unblockLocked(unblockTimeout)
+	}
+
+	return true
+}
+
+// signalKeyReady marks a key of the selected database which might serve blocked clients
+// Caller must hold the key space lock
+func signalKeyReady(client *Client, key string) {
+	signalReady(blockKey{db: client.DatabaseIndex, key: key})
+}
+
+// signalDBReady marks all keys of a database clients are blocked on, caller must hold the key space lock
+func signalDBReady(db int) {
+	if atomic.LoadInt64(&blockedClients) == 0 {
+		return
+	}
+
+	blockedMux.Lock()
+	var keys []blockKey
+	for k := range blockedKeys {
+		if k.db == db {
+			keys = append(keys, k)
+		}
+	}
+	blockedMux.Unlock()
+
+	for _, k := range keys {
+		signalReady(k)
+	}
+}
+
+func signalReady(k blockKey) {
+	if atomic.LoadInt64(&blockedClients) == 0 {
+		return
+	}
+
+	if _, ok := readyKeySet[k]; ok {
+		return
+	}
+
+	readyKeySet[k] = struct{}{}
+	readyKeys = append(readyKeys, k)
+}
+
+// serveBlockedClients serves clients blocked on ready keys in the order they blocked
+// Caller must hold the key space lock, it returns the entries logged for the served clients
+func serveBlockedClients() []persistence.Entry {
+	var entries []persistence.Entry
+
+	// serving a client can make other keys ready
+	for len(readyKeys) > 0 {
+		k := readyKeys[0]
+		readyKeys = readyKeys[1:]
+		delete(readyKeySet, k)
+
+		blockedMux.Lock()
+		// a waiter which is not served stays blocked without holding back the ones behind it, a stream
+		// reader can be waiting for entries another reader of the same key already has
+		for i := 0; i < len(blockedKeys[k]); {
+			b := blockedKeys[k][i]
+			c := b.client
+
+			c.propagate, c.failed = nil, false
+			unshare(c.Database, b.writes)
+			if !b.try(c) {
+				i++
+				continue
+			}
+
+			if !c.failed {
+				for _, cmd := range c.propagate {
+					entries = append(entries, persistence.Entry{DB: c.DatabaseIndex, Args: cmd})
+				}
+			}
+			b.unblockLocked(unblockServed)
+		}
+		blockedMux.Unlock()
+	}
+
+	return entries
+}
@@ -0,0 +1,165 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// waitBlocked waits until n clients are blocked
+func waitBlocked(t *testing.T, c *testConn, n int) {
+	t.Helper()
+
+	eventually(t, func() bool { return c.info("blocked_clients") == strconv.Itoa(n) }, strconv.Itoa(n)+" clients are blocked")
+}
+
+func TestBlockingFIFO(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	waiters := make([]*testConn, 3)
+	for i := range waiters {
+		waiters[i] = newTestConn(t)
+		waiters[i].send("blpop", "list", "0")
+		waitBlocked(t, c, i+1)
+	}
+
+	// clients are served in the order they blocked
+	c.do("rpush", "list", "a")
+	assert.Equal([]interface{}{"list", "a"}, waiters[0].read())
+	waitBlocked(t, c, 2)
+
+	c.do("rpush", "list", "b", "c")
+	assert.Equal([]interface{}{"list", "b"}, waiters[1].read())
+	assert.Equal([]interface{}{"list", "c"}, waiters[2].read())
+	waitBlocked(t, c, 0)
+	assert.Equal(0, c.do("exists", "list"))
+
+	// a waiter blocked on several keys is served by the first ready one
+	waiters[0].send("brpop", "first", "second", "0")
+	waitBlocked(t, c, 1)
+	waiters[1].send("brpop", "second", "0")
+	waitBlocked(t, c, 2)
+
+	c.do("rpush", "second", "x")
+	assert.Equal([]interface{}{"second", "x"}, waiters[0].read())
+	c.do("rpush", "second", "y")
+	assert.Equal([]interface{}{"second", "y"}, waiters[1].read())
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	tests := []struct {
+		timeout string
+		min     time.Duration
+	}{
+		{timeout: "0.2", min: 200 * time.Millisecond},
+		{timeout: ".05", min: 50 * time.Millisecond},
+		{timeout: "1e-1", min: 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		assert.Nil(c.do("blpop", "list", tt.timeout), tt.timeout)
+
+		elapsed := time.Since(start)
+		assert.True(elapsed >= tt.min && elapsed < tt.min+time.Second, "BLPOP with timeout %s took %v", tt.timeout, elapsed)
+	}
+
+	for _, timeout := range []string{"-1", "x", "inf", "nan", "1e300"} {
+		assert.IsType(replyError(""), c.do("blpop", "list", timeout), timeout)
+	}
+	waitBlocked(t, c, 0)
+}
+
+func TestBlockingInMulti(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	// blocking commands of transactions reply as if they timed out right away
+	c.do("multi")
+	assert.Equal("QUEUED", c.do("blpop", "list", "0"))
+	assert.Equal("QUEUED", c.do("blmove", "list", "dst", "left", "right", "0"))
+	assert.Equal("QUEUED", c.do("rpush", "list", "a"))
+	assert.Equal("QUEUED", c.do("blpop", "list", "0"))
+	assert.Equal([]interface{}{nil, nil, 1, []interface{}{"list", "a"}}, c.do("exec"))
+	waitBlocked(t, c, 0)
+}
+
+func TestClientUnblock(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c, blocked := newTestConn(t), newTestConn(t)
+	c.flushAll()
+
+	id := strconv.Itoa(blocked.do("client", "id").(int))
+	assert.Equal(0, c.do("client", "unblock", id))
+
+	blocked.send("blpop", "list", "0")
+	waitBlocked(t, c, 1)
+	assert.Equal(1, c.do("client", "unblock", id))
+	assert.Nil(blocked.read())
+
+	blocked.send("blpop", "list", "0")
+	waitBlocked(t, c, 1)
+	assert.Equal(1, c.do("client", "unblock", id, "TIMEOUT"))
+	assert.Nil(blocked.read())
+
+	blocked.send("blpop", "list", "0")
+	waitBlocked(t, c, 1)
+	assert.IsType(replyError(""), c.do("client", "unblock", id, "later"))
+	assert.Equal(1, c.do("client", "unblock", id, "error"))
+	assert.Contains(string(blocked.read().(replyError)), "UNBLOCKED")
+	waitBlocked(t, c, 0)
+
+	// unblocked clients do not consume elements pushed later
+	c.do("rpush", "list", "a")
+	assert.Equal([]interface{}{"a"}, c.do("lrange", "list", "0", "-1"))
+	assert.Equal("PONG", blocked.do("ping"))
+}
+
+func TestBlockedDisconnect(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c, blocked := newTestConn(t), newTestConn(t)
+	c.flushAll()
+
+	blocked.send("blpop", "list", "0")
+	waitBlocked(t, c, 1)
+
+	blocked.conn.Close()
+	waitBlocked(t, c, 0)
+
+	c.do("rpush", "list", "a")
+	assert.Equal([]interface{}{"a"}, c.do("lrange", "list", "0", "-1"))
+}
@@ -0,0 +1,152 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+var errChangeFeedDisabled = errors.New("the change feed is disabled")
+
+// changeFeed records the changes of all databases, nil when the change feed is disabled
+var changeFeed *db.EventRing
+
+// InitChangeFeed starts recording the changes of all databases keeping the latest size events
+// A negative size disables the change feed, it should be called after loading the data set
+func InitChangeFeed(size int) {
+	if size < 0 {
+		return
+	}
+
+	changeFeed = db.NewEventRing(size)
+	for _, database := range databases {
+		database.Observe(changeFeed.Record)
+	}
+}
+
+// touchModified reports the keys written by entries to the observers of their databases, keys which were not set or
+// removed get EventModify since their values were changed in place. Caller must hold the key space lock
+func touchModified(entries []persistence.Entry) {
+	written := make([][]string, len(databases))
+	for _, entry := range entries {
+		if entry.DB < 0 || entry.DB >= len(databases) {
+			continue
+		}
+
+		command, err := GetCommand(strings.ToLower(entry.Args[0]))
+		if err != nil {
+			continue
+		}
+
+		written[entry.DB] = append(written[entry.DB], command.Keys(entry.Args[1:])...)
+	}
+
+	// every database is visited, so keys reported by commands which wrote no entry for them are forgotten too
+	for i, database := range databases {
+		database.TouchUnreported(written[i])
+	}
+}
+
+// eventReply converts an event to an array of its sequence number, db, kind, key and type
+func eventReply(event db.Event) protocol.Reply {
+	return resp2.NewArrayReply(false, []protocol.Reply{
+		resp2.NewIntegerReply(int(event.Seq)),
+		resp2.NewIntegerReply(event.DB),
+		resp2.NewBulkStringReply(false, event.Kind.String()),
+		resp2.NewBulkStringReply(false, event.Key),
+		resp2.NewBulkStringReply(false, event.Type.String()),
+		resp2.NewIntegerReply(int(event.Time)),
+	})
+}
+
+// ChangeFeed returns the changes of the key space recorded after a sequence number
+// CHANGEFEED seq [COUNT count] [CLASS set | overwrite | modify | del | expire | flush ...] [MATCH pattern]
+func ChangeFeed(client *Client, args []string) {
+	seq, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[0]})
+		return
+	}
+
+	count, pattern := 0, ""
+	classes := make(map[db.EventKind]bool)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count <= 0 {
+				client.WriteError(&protocol.ErrCastFailedToInt{Val: args[i+1]})
+				return
+			}
+		case "class":
+			kind, ok := db.ParseEventKind(strings.ToLower(args[i+1]))
+			if !ok {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+			classes[kind] = true
+		case "match":
+			pattern = args[i+1]
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	if changeFeed == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errChangeFeedDisabled})
+		return
+	}
+
+	// flushes have no key, they match every pattern
+	events, last, lost := changeFeed.Since(seq, count, func(event db.Event) bool {
+		return (len(classes) == 0 || classes[event.Kind]) &&
+			(pattern == "" || event.Kind == db.EventFlush || util.GlobMatch(pattern, event.Key))
+	})
+
+	reps := make([]protocol.Reply, len(events))
+	for i, event := range events {
+		reps[i] = eventReply(event)
+	}
+
+	client.WriteProtocolReply(mapReply(client, []protocol.Reply{
+		resp2.NewBulkStringReply(false, "last"), resp2.NewIntegerReply(int(last)),
+		resp2.NewBulkStringReply(false, "lost"), resp2.NewIntegerReply(int(lost)),
+		resp2.NewBulkStringReply(false, "events"), resp2.NewArrayReply(false, reps),
+	}))
+}
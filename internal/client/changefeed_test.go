@@ -0,0 +1,110 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// changes reads the change feed after seq and returns the sequence number to continue from, the number of lost events
+// and the kind and the key of every event
+func changes(c *testConn, seq int, opts ...string) (last, lost int, events [][2]interface{}) {
+	c.t.Helper()
+
+	reply, ok := c.do(append([]string{"changefeed", strconv.Itoa(seq)}, opts...)...).([]interface{})
+	if !ok || len(reply) != 6 {
+		c.t.Fatalf("CHANGEFEED replied with %v", reply)
+	}
+
+	for _, event := range reply[5].([]interface{}) {
+		fields := event.([]interface{})
+		events = append(events, [2]interface{}{fields[2], fields[3]})
+	}
+
+	return reply[1].(int), reply[3].(int), events
+}
+
+func TestChangeFeed(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	start, _, _ := changes(c, 1<<62)
+
+	c.do("set", "k", "v")
+	c.do("set", "k", "w")
+	c.do("rpush", "l", "a")
+	c.do("rpush", "l", "b")
+	c.do("hset", "h", "f", "v")
+	c.do("hset", "h", "g", "v")
+	c.do("del", "k")
+	c.do("set", "e", "v", "px", "10")
+	time.Sleep(30 * time.Millisecond)
+	c.do("get", "e")
+
+	// failed commands and commands which changed nothing are not reported
+	c.do("rpush", "k2")
+	c.do("del", "missing")
+	c.do("hset", "h", "g", "v")
+
+	end, lost, events := changes(c, start)
+	assert.Equal([][2]interface{}{
+		{"set", "k"}, {"overwrite", "k"}, {"set", "l"}, {"modify", "l"}, {"set", "h"}, {"modify", "h"},
+		{"del", "k"}, {"set", "e"}, {"expire", "e"}, {"modify", "h"},
+	}, events)
+	assert.Equal(start+10, end)
+	assert.Equal(0, lost)
+
+	_, _, events = changes(c, start, "class", "modify")
+	assert.Equal([][2]interface{}{{"modify", "l"}, {"modify", "h"}, {"modify", "h"}}, events)
+
+	_, _, events = changes(c, start, "class", "set", "class", "del", "match", "k*")
+	assert.Equal([][2]interface{}{{"set", "k"}, {"del", "k"}}, events)
+
+	last, _, events := changes(c, start, "count", "2")
+	assert.Len(events, 2)
+	assert.Equal(start+2, last)
+
+	// a key created by a transaction is reported once however many commands write it
+	c.do("multi")
+	c.do("rpush", "t", "a")
+	c.do("rpush", "t", "b")
+	c.do("exec")
+	end, _, events = changes(c, end)
+	assert.Equal([][2]interface{}{{"set", "t"}}, events)
+
+	// flushes have no key and match every pattern
+	c.do("flushdb")
+	_, _, events = changes(c, end, "match", "nothing")
+	assert.Equal([][2]interface{}{{"flush", ""}}, events)
+
+	assert.IsType(replyError(""), c.do("changefeed", "0", "class", "unknown"))
+	assert.IsType(replyError(""), c.do("changefeed", "0", "count", "0"))
+	assert.IsType(replyError(""), c.do("changefeed", "0", "count"))
+}
@@ -27,22 +27,55 @@ package client
 
 import (
 	"bufio"
+	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/resp/resp3"
 	"github.com/kasvith/kache/internal/wire"
 
 	"io"
+	"math/big"
 
+	"github.com/kasvith/kache/internal/config"
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
 	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/sys"
 )
 
-// TODO: Need refactoring this to allow multiple DBs for use
+// databases are the logical databases, clients select one of them by index
+var databases = newDatabases(config.DefaultDatabases)
 
-// DB is the database used
-var dbase = db.NewDB()
+// expirer runs active expire cycles on the databases
+var expirer *db.ActiveExpirer
+
+func newDatabases(n int) []*db.DB {
+	dbs := make([]*db.DB, n)
+	for i := range dbs {
+		dbs[i] = db.NewIndexedDB(i)
+	}
+
+	return dbs
+}
+
+// InitDatabases creates n databases, this should be called before accepting clients
+func InitDatabases(n int) {
+	if n <= 0 {
+		n = config.DefaultDatabases
+	}
+
+	databases = newDatabases(n)
+}
+
+// StartActiveExpire starts removing expired keys of all databases in background
+func StartActiveExpire(conf db.ExpireConfig) {
+	expirer = db.NewActiveExpirer(conf, databases...)
+	expirer.Start()
+}
 
 const (
 	// RESP2 represents protocol version resp2
@@ -51,14 +84,31 @@ const (
 	RESP3 = "resp3"
 )
 
+const (
+	// replyBufferLimit is the number of reply bytes buffered for a client after which it is disconnected
+	replyBufferLimit = 512 << 20
+
+	// replyWriteTimeout is how long writing replies may take, a client which does not read them is disconnected
+	replyWriteTimeout = 30 * time.Second
+)
+
+// nextClientID is the ID of the last connected client
+var nextClientID int64
+
 // Client represents a structure to manage connected client
 type Client struct {
+	// ID is an unique ID of the client
+	ID int64
+
 	// Connection for client
 	Connection net.Conn
 
-	// DB selected database for client, default to 0
+	// Database selected database for client, default to 0
 	Database *db.DB
 
+	// DatabaseIndex is the index of the selected database
+	DatabaseIndex int
+
 	// Parser is used for parsing a request
 	Parser protocol.CommandParser
 
@@ -74,6 +124,79 @@ type Client struct {
 	// Commands store a list of queued commands in a multi transaction
 	Commands []*Command
 
+	// multiSlot is the hash slot of the keys queued in a transaction in cluster mode, -1 when no key was queued
+	multiSlot int
+
+	// asking allows the next command to access a slot imported by this node
+	asking bool
+
+	// watched are the keys watched for a transaction with whether they existed when they were watched
+	watched map[blockKey]bool
+
+	// watchDirty is set when a watched key was changed, it is guarded by watchMux
+	watchDirty bool
+
+	// propagate holds the commands logged for the executing command, nil when nothing should be logged
+	// commands which are not deterministic replace it, e.g. relative expirations are logged as absolute ones
+	propagate [][]string
+
+	// failed indicates the executing command replied with an error
+	failed bool
+
+	// writes are the keys the executing command can modify, blocked clients modify them once they are served
+	writes []string
+
+	// propagated collects the entries logged for executed commands
+	propagated []persistence.Entry
+
+	// master indicates the client applies the replication stream of our master
+	master bool
+
+	// pendingStream holds the part of the replication stream received from our master which is not forwarded yet
+	pendingStream []byte
+
+	// replica is set once the client is a replica fed with the replication stream
+	replica *replica
+
+	// listeningPort is the port announced by a replica
+	listeningPort int
+
+	// reader buffers the connection for the parser
+	reader *bufio.Reader
+
+	// blocked is set while the client waits in a blocking command
+	blocked *blockState
+
+	// denyBlocking makes blocking commands reply as if they timed out, e.g. inside transactions
+	denyBlocking bool
+
+	// clock overrides the current time of commands replicated through raft, 0 uses the system clock
+	clock int64
+
+	// channels are the channels the client is subscribed to
+	channels map[string]struct{}
+
+	// patterns are the channel patterns the client is subscribed to
+	patterns map[string]struct{}
+
+	// name is set by CLIENT SETNAME or HELLO, empty when the client has no name
+	name string
+
+	// tracking holds the options of CLIENT TRACKING, nil when tracking is off
+	tracking *tracker
+
+	// caching is set by CLIENT CACHING to yes or no for the next command
+	caching string
+
+	// subscriber writes the replies of the client once it subscribed, so messages and replies are not interleaved
+	subscriber *subscriber
+
+	// reply buffers the replies of the executing command, they are written once the key space lock is released
+	reply []byte
+
+	// replyOverflow is set once the buffered replies exceeded replyBufferLimit and the client was disconnected
+	replyOverflow bool
+
 	// Writer is used to write out data to client connection
 	*bufio.Writer
 }
@@ -82,7 +205,7 @@ type Client struct {
 // Note all clients will be initialized to use RESP2 as the default reply protocol
 // This can be changed in future
 func NewClient(conn net.Conn) *Client {
-	return &Client{Connection: conn, Protocol: RESP2, Writer: bufio.NewWriter(conn), Database: dbase}
+	return &Client{ID: atomic.AddInt64(&nextClientID, 1), Connection: conn, Protocol: RESP2, Writer: bufio.NewWriter(conn), Database: databases[0]}
 }
 
 // RemoteAddr returns remote address of client
@@ -114,6 +237,7 @@ func (client *Client) Handle() {
 					klogs.Logger.Debug(client.RemoteAddr(), ": ", err.Error())
 
 					client.WriteError(err)
+					client.flushReply()
 					continue
 				}
 			}
@@ -126,6 +250,11 @@ func (client *Client) Handle() {
 			break
 		}
 
+		// empty lines and arrays are skipped
+		if command == nil || command.Name == "" {
+			continue
+		}
+
 		// executes the command
 		Execute(client, command.Name, command.Args)
 	}
@@ -134,6 +263,13 @@ func (client *Client) Handle() {
 }
 
 func (client *Client) logAndRemove() {
+	if client.replica != nil {
+		removeReplica(client.replica)
+	}
+	client.unwatch()
+	client.untrack()
+	client.unsubscribeAll()
+
 	ConnectedClients.Remove(client.RemoteAddr().String())
 	_ = client.Connection.Close()
 	ConnectedClients.LogClientCount()
@@ -149,6 +285,7 @@ func (client *Client) detectParser() error {
 	if err != nil {
 		return err
 	}
+	client.reader = reader
 
 	switch b {
 	case resp2.TypeArray:
@@ -162,8 +299,69 @@ func (client *Client) detectParser() error {
 	return nil
 }
 
+// execute runs a command which modifies the key space and collects the commands it propagates
+func (client *Client) execute(command *Command, args []string) {
+	client.propagate = [][]string{append([]string{command.Name}, args...)}
+	client.failed = false
+
+	client.writes = command.Keys(args)
+	unshare(client.Database, client.writes)
+
+	command.Fn(client, args)
+
+	if client.failed {
+		return
+	}
+
+	for _, cmd := range client.propagate {
+		client.propagated = append(client.propagated, persistence.Entry{DB: client.DatabaseIndex, Args: cmd})
+	}
+}
+
+// now returns the current time in milliseconds commands should use
+func (client *Client) now() int64 {
+	if client.clock != 0 {
+		return client.clock
+	}
+
+	return sys.NowMillis()
+}
+
+// setProtocol changes the protocol replies are sent with, messages of subscriptions follow it
+func (client *Client) setProtocol(proto string) {
+	client.Protocol = proto
+
+	if s := client.subscriber; s != nil {
+		s.mux.Lock()
+		s.resp3 = proto == RESP3
+		s.mux.Unlock()
+	}
+}
+
+// propagateAs replaces the commands logged for the executing command
+func (client *Client) propagateAs(cmds ...[]string) {
+	client.propagate = cmds
+}
+
+// writeChanges replies with the number of changes made by a command, nothing is logged when it is 0
+func (client *Client) writeChanges(n int) {
+	if n == 0 {
+		client.propagateAs()
+	}
+
+	client.WriteInteger(n)
+}
+
+// flushPropagated returns the collected entries and clears them
+func (client *Client) flushPropagated() []persistence.Entry {
+	entries := client.propagated
+	client.propagated = nil
+	return entries
+}
+
 // WriteError will write an error message to the connection
 func (client *Client) WriteError(err error) {
+	client.failed = true
 	switch client.Protocol {
 	case RESP2, RESP3:
 		client.WriteProtocolReply(resp2.NewErrorReply(err))
@@ -180,17 +378,244 @@ func (client *Client) WriteInteger(n int) {
 	}
 }
 
+// WriteSimpleString will write a simple string to the client
+func (client *Client) WriteSimpleString(str string) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		client.WriteProtocolReply(resp2.NewSimpleStringReply(str))
+	}
+}
+
+// WriteOK will write OK status to the client
+func (client *Client) WriteOK() {
+	client.WriteSimpleString("OK")
+}
+
+// WriteBulkString will write a bulk string to the client
+func (client *Client) WriteBulkString(str string) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		client.WriteProtocolReply(resp2.NewBulkStringReply(false, str))
+	}
+}
+
+// WriteNil will write a null value to the client
+func (client *Client) WriteNil() {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewBulkStringReply(true, ""))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewNullReply())
+	}
+}
+
+// WriteNilArray will write a null array to the client, RESP3 clients receive a null value
+func (client *Client) WriteNilArray() {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewArrayReply(true, nil))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewNullReply())
+	}
+}
+
+// WriteDouble will write a floating point number, RESP2 clients receive it as a bulk string
+func (client *Client) WriteDouble(f float64) {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewBulkStringReply(false, resp3.FormatDouble(f)))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewDoubleReply(f))
+	}
+}
+
+// WriteBoolean will write true or false, RESP2 clients receive 1 or 0
+func (client *Client) WriteBoolean(b bool) {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewIntegerReply(boolToInt(b)))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewBooleanReply(b))
+	}
+}
+
+// WriteBigNumber will write an integer of any size, RESP2 clients receive it as a bulk string
+func (client *Client) WriteBigNumber(n *big.Int) {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewBulkStringReply(false, n.String()))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewBigNumberReply(n))
+	}
+}
+
+// WriteVerbatimString will write text meant to be shown as it is, format is a three letter type like txt or mkd
+// RESP2 clients receive the text as a bulk string
+func (client *Client) WriteVerbatimString(format, str string) {
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewBulkStringReply(false, str))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewVerbatimStringReply(format, str))
+	}
+}
+
+// WriteSet will write distinct strings as a set, RESP2 clients receive them as an array
+func (client *Client) WriteSet(strs []string) {
+	arr := make([]protocol.Reply, len(strs))
+	for i := 0; i < len(strs); i++ {
+		arr[i] = resp2.NewBulkStringReply(false, strs[i])
+	}
+
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewSetReply(arr))
+	}
+}
+
+// WriteStringArray will write a list of strings as an array of bulk strings
+func (client *Client) WriteStringArray(strs []string) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		arr := make([]protocol.Reply, len(strs))
+		for i := 0; i < len(strs); i++ {
+			arr[i] = resp2.NewBulkStringReply(false, strs[i])
+		}
+
+		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
+	}
+}
+
+// WriteNullableStringArray will write a list of strings where elements which are not found are sent as null values
+func (client *Client) WriteNullableStringArray(strs []string, found []bool) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		arr := make([]protocol.Reply, len(strs))
+		for i := 0; i < len(strs); i++ {
+			arr[i] = resp2.NewBulkStringReply(!found[i], strs[i])
+		}
+
+		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
+	}
+}
+
+// WriteIntegerArray will write a list of integers as an array
+func (client *Client) WriteIntegerArray(nums []int) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		arr := make([]protocol.Reply, len(nums))
+		for i := 0; i < len(nums); i++ {
+			arr[i] = resp2.NewIntegerReply(nums[i])
+		}
+
+		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
+	}
+}
+
+// WriteArrayLength will write the length of an array whose elements are written as separate replies
+func (client *Client) WriteArrayLength(n int) {
+	switch client.Protocol {
+	case RESP2, RESP3:
+		client.WriteProtocolReply(rawReply(fmt.Sprintf("%c%d%s", resp2.TypeArray, n, resp2.CRLF)))
+	}
+}
+
+// writeChunk writes an encoded part of a reply which is sent in pieces, it returns false once the client is gone
+// Subscribed clients which do not read their replies are disconnected like slow subscribers
+func (client *Client) writeChunk(data []byte) bool {
+	if client.subscriber != nil {
+		return client.subscriber.push(data, true)
+	}
+
+	return client.bufferReply(data)
+}
+
+// bufferReply appends data to the buffered replies, it returns false once the client was disconnected since the
+// replies exceeded replyBufferLimit. Clients without a connection, e.g. the AOF loader, are not limited
+func (client *Client) bufferReply(data []byte) bool {
+	if client.replyOverflow {
+		return false
+	}
+
+	if client.Connection != nil && len(client.reply)+len(data) > replyBufferLimit {
+		klogs.Logger.Warnf("disconnecting client %s, its replies exceeded %d bytes", client.RemoteAddr(), replyBufferLimit)
+		client.replyOverflow = true
+		client.reply = nil
+		client.Connection.Close()
+		return false
+	}
+
+	client.reply = append(client.reply, data...)
+	return true
+}
+
+// flushReply writes the buffered replies, it must be called without holding the key space lock
+// A client which does not read its replies within replyWriteTimeout is disconnected
+func (client *Client) flushReply() {
+	if len(client.reply) == 0 {
+		return
+	}
+
+	if client.Connection != nil {
+		client.Connection.SetWriteDeadline(time.Now().Add(replyWriteTimeout))
+	}
+
+	_, err := client.Write(client.reply)
+	if err == nil {
+		err = client.Flush()
+	}
+
+	// large replies do not keep their memory
+	if cap(client.reply) > 64<<10 {
+		client.reply = nil
+	} else {
+		client.reply = client.reply[:0]
+	}
+
+	if err != nil && client.Connection != nil {
+		klogs.Logger.Debug(client.RemoteAddr(), ": ", err.Error())
+		client.Connection.Close()
+	}
+}
+
+// rawReply is a reply which is already encoded
+type rawReply string
+
+// ToBytes returns byte representation of rawReply
+func (r rawReply) ToBytes() []byte {
+	return []byte(r)
+}
+
+// WriteMap will write key value pairs to the client
+// fields should contain keys and values one after another, RESP2 clients will receive them as a flat array
+func (client *Client) WriteMap(fields []string) {
+	arr := make([]protocol.Reply, len(fields))
+	for i := 0; i < len(fields); i++ {
+		arr[i] = resp2.NewBulkStringReply(false, fields[i])
+	}
+
+	switch client.Protocol {
+	case RESP2:
+		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
+	case RESP3:
+		client.WriteProtocolReply(resp3.NewMapReply(arr))
+	}
+}
+
 // WriteProtocolReply will write a protocol reply
+// Replies built from RESP2 types are sent to RESP3 clients with RESP3 nulls
 func (client *Client) WriteProtocolReply(reply protocol.Reply) {
-	// ok we are clear to send
-	_, err := client.Write(reply.ToBytes())
-	if err != nil {
-		client.logAndRemove()
-		return
+	if client.Protocol == RESP3 {
+		reply = resp3.FromResp2(reply)
 	}
-	err = client.Flush()
-	if err != nil {
-		client.logAndRemove()
+
+	if client.subscriber != nil {
+		client.subscriber.push(reply.ToBytes(), false)
 		return
 	}
+
+	// replies are written once the key space lock is released, so a client which does not read them blocks nobody
+	client.bufferReply(reply.ToBytes())
 }
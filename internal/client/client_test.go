@@ -0,0 +1,304 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/replication"
+	"github.com/kasvith/kache/internal/resp/resp3"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// testServerEnv makes the test binary run a server instead of the tests, so tests can talk to a second server
+const testServerEnv = "KACHE_TEST_SERVER"
+
+// testReplyTimeout is how long a test waits for a reply
+const testReplyTimeout = 5 * time.Second
+
+// testAddr is the address of the server started by TestMain
+var testAddr string
+
+func TestMain(m *testing.M) {
+	klogs.InitLoggers(config.AppConfig{LogType: "default"})
+
+	dir, err := ioutil.TempDir("", "kache-client")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	InitSnapshots(filepath.Join(dir, "dump.kache"), nil)
+	InitReplication(replication.Config{ReadOnly: true})
+	InitChangeFeed(0)
+	StartReplication()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	go serveTestClients(listener)
+	testAddr = listener.Addr().String()
+
+	// a child process only serves clients, its address is the first line of its output
+	if os.Getenv(testServerEnv) != "" {
+		fmt.Println(testAddr)
+		select {}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// serveTestClients handles the connections accepted by listener like the tcp server
+func serveTestClients(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := NewClient(conn)
+		ConnectedClients.Add(c)
+		go c.Handle()
+	}
+}
+
+// startTestProcess starts a server in a child process and returns its address, the process is killed after the test
+func startTestProcess(t *testing.T) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), testServerEnv+"=1")
+	cmd.Dir = t.TempDir()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("test server did not start: %s", err)
+	}
+
+	return strings.TrimSpace(addr)
+}
+
+// testConn is a connection of a test to a server
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	parser *resp3.Parser
+}
+
+// replyError is an error reply read by a testConn
+type replyError string
+
+// dialTest connects to the server at addr, the connection is closed after the test
+func dialTest(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+	})
+
+	return &testConn{t: t, conn: conn, parser: resp3.NewResp3Parser(bufio.NewReader(conn))}
+}
+
+// newTestConn connects to the server started by TestMain
+func newTestConn(t *testing.T) *testConn {
+	return dialTest(t, testAddr)
+}
+
+// send writes a command without waiting for its reply
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+
+	if _, err := c.conn.Write([]byte(resp3.NewSliceResp3(args))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read waits for the next reply
+// Strings are returned as string, integers as int, errors as replyError, nulls as nil and aggregates as []interface{}
+func (c *testConn) read() interface{} {
+	c.t.Helper()
+
+	reply, err := c.readTimeout(testReplyTimeout)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return reply
+}
+
+// readTimeout waits for the next reply up to timeout
+func (c *testConn) readTimeout(timeout time.Duration) (interface{}, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	reply, err := c.parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return replyValue(reply), nil
+}
+
+// do sends a command and waits for its reply
+func (c *testConn) do(args ...string) interface{} {
+	c.t.Helper()
+
+	c.send(args...)
+	return c.read()
+}
+
+// flushAll removes the keys of all databases
+func (c *testConn) flushAll() {
+	c.t.Helper()
+
+	if reply := c.do("flushall"); reply != "OK" {
+		c.t.Fatalf("FLUSHALL replied with %v", reply)
+	}
+}
+
+// info returns the value of a field of the INFO reply
+func (c *testConn) info(field string) string {
+	c.t.Helper()
+
+	info, ok := c.do("info").(string)
+	if !ok {
+		c.t.Fatalf("INFO did not reply with a string")
+	}
+
+	for _, line := range strings.Split(info, "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return strings.TrimPrefix(line, field+":")
+		}
+	}
+
+	return ""
+}
+
+func replyValue(reply *resp3.Resp3) interface{} {
+	switch reply.Type {
+	case resp3.Resp3SimpleString, resp3.Resp3BlobString, resp3.Resp3VerbatimString:
+		return reply.Str
+	case resp3.Resp3SimpleError, resp3.Resp3BolbError:
+		return replyError(reply.Err.Error())
+	case resp3.Resp3Number:
+		return reply.Integer
+	case resp3.Resp3Double:
+		return reply.Double
+	case resp3.Resp3BigNumber:
+		return reply.BigInt.String()
+	case resp3.Resp3Boolean:
+		return reply.Boolean
+	case resp3.Resp3Null:
+		return nil
+	}
+
+	elems := make([]interface{}, len(reply.Elems))
+	for i, elem := range reply.Elems {
+		elems[i] = replyValue(elem)
+	}
+
+	return elems
+}
+
+// eventually retries cond until it holds or the reply timeout passes
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testReplyTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", msg)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSlowReader(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	value := strings.Repeat("v", 1024)
+	for i := 0; i < 30; i++ {
+		args := []string{"rpush", "list"}
+		for j := 0; j < 1000; j++ {
+			args = append(args, value)
+		}
+		c.send(args...)
+	}
+	for i := 0; i < 30; i++ {
+		c.read()
+	}
+
+	// the replies of the slow client fill the socket buffers while it never reads
+	slow := newTestConn(t)
+	slow.conn.(*net.TCPConn).SetReadBuffer(4096)
+	for i := 0; i < 10; i++ {
+		slow.send("lrange", "list", "0", "-1")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		assert.Equal("OK", c.do("set", "k", strconv.Itoa(i)))
+		assert.Equal(strconv.Itoa(i), c.do("get", "k"))
+	}
+	assert.True(time.Since(start) < time.Second, "other clients waited %v", time.Since(start))
+}
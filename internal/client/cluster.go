@@ -0,0 +1,684 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/resp/resp3"
+)
+
+var (
+	errClusterDisabled = errors.New("This instance has cluster support disabled")
+	errSlotHasKeys     = errors.New("can't assign a hash slot which still holds keys to another node")
+	errInvalidPort     = errors.New("invalid node port")
+)
+
+var (
+	// clusterState is the view of the cluster, nil when cluster mode is disabled
+	clusterState *cluster.State
+
+	// clusterConf configures cluster mode
+	clusterConf cluster.Config
+
+	// clusterSaveMux serializes writes of the cluster config file
+	clusterSaveMux sync.Mutex
+
+	// peers are the connections used to poll other nodes by node ID
+	peers    = make(map[string]*peer)
+	peersMux sync.Mutex
+
+	// slotKeys tracks the keys of database 0 by slot, nil when cluster mode is disabled
+	slotKeys *slotCounter
+)
+
+// InitCluster enables cluster mode, the state is restored from the cluster config file when it exists
+func InitCluster(conf cluster.Config) error {
+	clusterConf = conf.WithDefaults()
+
+	f, err := os.Open(clusterConf.ConfigFile)
+	switch {
+	case os.IsNotExist(err):
+		clusterState = cluster.NewState(cluster.NewID(), clusterConf.Host, clusterConf.Port, clusterConf.NodeTimeout)
+		return saveClusterConfig()
+	case err != nil:
+		return err
+	}
+	defer f.Close()
+
+	clusterState, err = cluster.ReadConfig(f, clusterConf.Host, clusterConf.Port, clusterConf.NodeTimeout)
+	return err
+}
+
+// StartCluster starts polling the other nodes for their view of the cluster
+func StartCluster() {
+	if clusterState == nil {
+		return
+	}
+
+	slotKeys = newSlotCounter(databases[0])
+
+	go func() {
+		for range time.Tick(cluster.GossipPeriod) {
+			gossip()
+		}
+	}()
+}
+
+// saveClusterConfig persists the cluster state to the cluster config file
+func saveClusterConfig() error {
+	clusterSaveMux.Lock()
+	defer clusterSaveMux.Unlock()
+
+	return persistence.WriteFileAtomic(clusterConf.ConfigFile, clusterState.WriteConfig)
+}
+
+// clusterChanged persists the cluster state after it was changed
+func clusterChanged() {
+	if err := saveClusterConfig(); err != nil {
+		klogs.Logger.Errorf("error saving cluster config: %s", err.Error())
+	}
+}
+
+// peer is a connection to another node
+type peer struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	polling bool
+}
+
+// gossip polls every known node which is not polled already and drops connections of forgotten nodes
+func gossip() {
+	known := make(map[string]cluster.Node)
+	for _, n := range clusterState.Nodes() {
+		if !n.Myself {
+			known[n.ID] = n
+		}
+	}
+
+	peersMux.Lock()
+	defer peersMux.Unlock()
+
+	for id, p := range peers {
+		if _, ok := known[id]; !ok && !p.polling {
+			p.close()
+			delete(peers, id)
+		}
+	}
+
+	for id, n := range known {
+		p, ok := peers[id]
+		if !ok {
+			p = &peer{}
+			peers[id] = p
+		}
+
+		if !p.polling {
+			p.polling = true
+			go p.poll(n)
+		}
+	}
+}
+
+// poll asks a node for its view of the cluster and merges it
+func (p *peer) poll(n cluster.Node) {
+	view, err := p.request(n.Addr())
+	if err != nil {
+		klogs.Logger.Debugf("error polling cluster node %s: %s", n.Addr(), err.Error())
+		p.close()
+		clusterState.LinkDown(n.ID)
+	} else if clusterState.Merge(n.ID, view) {
+		clusterChanged()
+	}
+
+	peersMux.Lock()
+	p.polling = false
+	peersMux.Unlock()
+}
+
+// request sends CLUSTER NODES to a node and parses the reply
+func (p *peer) request(addr string) ([]cluster.Node, error) {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, clusterConf.NodeTimeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conn, p.reader = conn, bufio.NewReader(conn)
+
+		// introduce ourselves so the node polls us as well
+		myself := clusterState.Myself()
+		if _, err := p.send("cluster", "meet", myself.Host, strconv.Itoa(myself.Port)); err != nil {
+			return nil, err
+		}
+	}
+
+	line, err := p.send("cluster", "nodes")
+	if err != nil {
+		return nil, err
+	}
+
+	if line[0] != resp2.TypeBulkString {
+		return nil, fmt.Errorf("unexpected reply to CLUSTER NODES: %s", line)
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid reply length %s", line)
+	}
+
+	buf := make([]byte, size+len(resp2.CRLF))
+	if _, err := io.ReadFull(p.reader, buf); err != nil {
+		return nil, err
+	}
+
+	return cluster.ParseNodes(string(buf[:size]))
+}
+
+// send sends a command to the node and reads the first line of the reply
+func (p *peer) send(args ...string) (string, error) {
+	if err := p.conn.SetDeadline(time.Now().Add(clusterConf.NodeTimeout)); err != nil {
+		return "", err
+	}
+
+	if _, err := p.conn.Write(persistence.AppendCommand(nil, args)); err != nil {
+		return "", err
+	}
+
+	line, err := readLine(p.reader)
+	if err != nil {
+		return "", err
+	}
+
+	if line[0] == resp2.TypeError {
+		return "", fmt.Errorf("node replied to %s %s with %s", args[0], args[1], line[1:])
+	}
+
+	return line, nil
+}
+
+func (p *peer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.reader = nil, nil
+	}
+}
+
+// clusterRedirect checks that the keys of a command are served by this node
+// Clients applying the stream of our master or replaying the append only file are not redirected
+func clusterRedirect(client *Client, command *Command, args []string) error {
+	if clusterState == nil || client.master || client.Connection == nil {
+		return nil
+	}
+
+	// ASKING only affects the next command or the next transaction
+	asking := client.asking
+	if command.Name == "exec" || (!client.Multi && command.Name != "multi") {
+		client.asking = false
+	}
+
+	keys := command.Keys(args)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	slot := cluster.KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.KeySlot(key) != slot {
+			return protocol.ErrCrossSlot{}
+		}
+	}
+
+	// all keys of a transaction should be in the same slot
+	if client.Multi {
+		if client.multiSlot != -1 && client.multiSlot != slot {
+			return protocol.ErrCrossSlot{}
+		}
+		client.multiSlot = slot
+	}
+
+	info := clusterState.Slot(slot)
+	switch {
+	case info.Mine && info.MigratingTo != "" && command.Name != "migrate":
+		// keys which were already moved are served by the importing node
+		switch missing := missingKeys(client, keys); {
+		case missing == len(keys):
+			return protocol.ErrAsk{Slot: slot, Addr: info.MigratingTo}
+		case missing > 0:
+			return protocol.ErrTryAgain{}
+		}
+	case info.Mine:
+	case info.Importing && (asking || command.Name == "asking"):
+		if missing := missingKeys(client, keys); missing > 0 && missing < len(keys) {
+			return protocol.ErrTryAgain{}
+		}
+	case info.Owner == "":
+		return protocol.ErrClusterDown{}
+	default:
+		return protocol.ErrMoved{Slot: slot, Addr: info.Owner}
+	}
+
+	return nil
+}
+
+// missingKeys counts the keys which do not exist in the selected database of client
+func missingKeys(client *Client, keys []string) int {
+	missing := 0
+	for _, key := range keys {
+		if client.Database.Exists(key) == 0 {
+			missing++
+		}
+	}
+
+	return missing
+}
+
+// keysInSlot returns up to count keys of a slot, count < 0 returns all keys
+func keysInSlot(slot, count int) []string {
+	return slotKeys.keys(slot, count)
+}
+
+// slotCounter tracks the keys of a database by slot as they are added and removed
+type slotCounter struct {
+	mux sync.Mutex
+
+	// slots holds the keys of every slot, sets of slots which never had a key are nil
+	slots [cluster.Slots]map[string]struct{}
+}
+
+// newSlotCounter tracks the keys of database and keeps them up to date
+// database must not be swapped with another one, which cluster mode does not allow
+func newSlotCounter(database *db.DB) *slotCounter {
+	c := &slotCounter{}
+	database.ObserveExisting(func(event db.Event) {
+		c.mux.Lock()
+		defer c.mux.Unlock()
+
+		switch event.Kind {
+		case db.EventSet:
+			slot := cluster.KeySlot(event.Key)
+			if c.slots[slot] == nil {
+				c.slots[slot] = make(map[string]struct{})
+			}
+			c.slots[slot][event.Key] = struct{}{}
+		case db.EventDel, db.EventExpire:
+			delete(c.slots[cluster.KeySlot(event.Key)], event.Key)
+		case db.EventFlush:
+			clear(c.slots[:])
+		}
+	})
+
+	return c
+}
+
+// count returns the number of keys of a slot including expired keys which were not removed yet
+func (c *slotCounter) count(slot int) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return len(c.slots[slot])
+}
+
+// keys returns up to count keys of a slot including expired keys which were not removed yet, count < 0 returns all
+func (c *slotCounter) keys(slot, count int) []string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	n := len(c.slots[slot])
+	if count >= 0 {
+		n = min(n, count)
+	}
+
+	keys := make([]string, 0, n)
+	for key := range c.slots[slot] {
+		if len(keys) == count {
+			break
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// notInCluster returns the error of commands which use databases other than 0 in cluster mode
+func notInCluster(cmd string) error {
+	return &protocol.ErrGeneric{Err: fmt.Errorf("%s is not allowed in cluster mode", cmd)}
+}
+
+// Asking allows the next command to access a slot which is imported by this node
+func Asking(client *Client, args []string) {
+	if clusterState == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errClusterDisabled})
+		return
+	}
+
+	client.asking = true
+	client.WriteOK()
+}
+
+// ClusterCmd inspects and configures the cluster
+// CLUSTER INFO | MYID | NODES | SLOTS | SHARDS | KEYSLOT key | COUNTKEYSINSLOT slot | GETKEYSINSLOT slot count |
+// MEET ip port | FORGET id | ADDSLOTS slot... | ADDSLOTSRANGE start end... | DELSLOTS slot... |
+// DELSLOTSRANGE start end... | SETSLOT slot IMPORTING id | MIGRATING id | NODE id | STABLE | SAVECONFIG
+func ClusterCmd(client *Client, args []string) {
+	if clusterState == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errClusterDisabled})
+		return
+	}
+
+	var err error
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "info" && len(args) == 1:
+		clusterInfo(client)
+	case sub == "myid" && len(args) == 1:
+		client.WriteBulkString(clusterState.ID())
+	case sub == "nodes" && len(args) == 1:
+		client.WriteBulkString(cluster.FormatNodes(clusterState.Nodes()))
+	case sub == "slots" && len(args) == 1:
+		clusterSlots(client)
+	case sub == "shards" && len(args) == 1:
+		clusterShards(client)
+	case sub == "keyslot" && len(args) == 2:
+		client.WriteInteger(cluster.KeySlot(args[1]))
+	case sub == "countkeysinslot" && len(args) == 2:
+		var slot int
+		if slot, err = cluster.ParseSlot(args[1]); err == nil {
+			client.WriteInteger(slotKeys.count(slot))
+		}
+	case sub == "getkeysinslot" && len(args) == 3:
+		err = clusterGetKeysInSlot(client, args[1], args[2])
+	case sub == "meet" && (len(args) == 3 || len(args) == 4):
+		err = clusterMeet(client, args[1], args[2])
+	case sub == "forget" && len(args) == 2:
+		err = clusterState.Forget(args[1])
+		if err == nil {
+			peersMux.Lock()
+			if p, ok := peers[args[1]]; ok && !p.polling {
+				p.close()
+				delete(peers, args[1])
+			}
+			peersMux.Unlock()
+			clusterChanged()
+			client.WriteOK()
+		}
+	case (sub == "addslots" || sub == "delslots") && len(args) > 1:
+		err = clusterSetSlots(client, sub == "addslots", args[1:], false)
+	case (sub == "addslotsrange" || sub == "delslotsrange") && len(args) > 1 && len(args)%2 == 1:
+		err = clusterSetSlots(client, sub == "addslotsrange", args[1:], true)
+	case sub == "setslot" && (len(args) == 3 || len(args) == 4):
+		err = clusterSetSlot(client, args[1:])
+	case sub == "saveconfig" && len(args) == 1:
+		if err = saveClusterConfig(); err == nil {
+			client.WriteOK()
+		}
+	default:
+		err = fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])
+	}
+
+	if err != nil {
+		if _, ok := err.(protocol.RecoverableError); !ok {
+			err = &protocol.ErrGeneric{Err: err}
+		}
+		client.WriteError(err)
+	}
+}
+
+func clusterInfo(client *Client) {
+	info := clusterState.Info()
+	state := "ok"
+	if info.SlotsAssigned < cluster.Slots {
+		state = "fail"
+	}
+
+	var b strings.Builder
+	fields := []infoField{
+		{"cluster_state", state},
+		{"cluster_slots_assigned", info.SlotsAssigned},
+		{"cluster_slots_ok", info.SlotsAssigned - info.SlotsPFail},
+		{"cluster_slots_pfail", info.SlotsPFail},
+		{"cluster_slots_fail", 0},
+		{"cluster_known_nodes", info.KnownNodes},
+		{"cluster_size", info.Size},
+		{"cluster_current_epoch", info.CurrentEpoch},
+		{"cluster_my_epoch", info.MyEpoch},
+	}
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%s:%v\r\n", field.name, field.value)
+	}
+
+	client.WriteBulkString(b.String())
+}
+
+// clusterSlots replies with the slot ranges and the nodes serving them
+func clusterSlots(client *Client) {
+	type slotRange struct {
+		cluster.SlotRange
+		node cluster.Node
+	}
+
+	var ranges []slotRange
+	for _, n := range clusterState.Nodes() {
+		for _, r := range n.Slots {
+			ranges = append(ranges, slotRange{r, n})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	reply := make([]protocol.Reply, len(ranges))
+	for i, r := range ranges {
+		reply[i] = resp2.NewArrayReply(false, []protocol.Reply{
+			resp2.NewIntegerReply(r.Start),
+			resp2.NewIntegerReply(r.End),
+			resp2.NewArrayReply(false, []protocol.Reply{
+				resp2.NewBulkStringReply(false, r.node.Host),
+				resp2.NewIntegerReply(r.node.Port),
+				resp2.NewBulkStringReply(false, r.node.ID),
+			}),
+		})
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, reply))
+}
+
+// clusterShards replies with every shard, its slot ranges and its nodes
+func clusterShards(client *Client) {
+	var shards []protocol.Reply
+	for _, n := range clusterState.Nodes() {
+		if n.Handshake {
+			continue
+		}
+
+		slots := make([]protocol.Reply, 0, len(n.Slots)*2)
+		for _, r := range n.Slots {
+			slots = append(slots, resp2.NewIntegerReply(r.Start), resp2.NewIntegerReply(r.End))
+		}
+
+		health := "online"
+		if n.Fail {
+			health = "fail"
+		}
+
+		node := mapReply(client, []protocol.Reply{
+			resp2.NewBulkStringReply(false, "id"), resp2.NewBulkStringReply(false, n.ID),
+			resp2.NewBulkStringReply(false, "port"), resp2.NewIntegerReply(n.Port),
+			resp2.NewBulkStringReply(false, "ip"), resp2.NewBulkStringReply(false, n.Host),
+			resp2.NewBulkStringReply(false, "endpoint"), resp2.NewBulkStringReply(false, n.Host),
+			resp2.NewBulkStringReply(false, "role"), resp2.NewBulkStringReply(false, "master"),
+			resp2.NewBulkStringReply(false, "health"), resp2.NewBulkStringReply(false, health),
+		})
+
+		shards = append(shards, mapReply(client, []protocol.Reply{
+			resp2.NewBulkStringReply(false, "slots"), resp2.NewArrayReply(false, slots),
+			resp2.NewBulkStringReply(false, "nodes"), resp2.NewArrayReply(false, []protocol.Reply{node}),
+		}))
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, shards))
+}
+
+// mapReply builds a map of fields, RESP2 clients receive it as a flat array
+func mapReply(client *Client, fields []protocol.Reply) protocol.Reply {
+	if client.Protocol == RESP3 {
+		return resp3.NewMapReply(fields)
+	}
+
+	return resp2.NewArrayReply(false, fields)
+}
+
+// pairsReply builds an array of pairs of elems, RESP2 clients receive it as a flat array
+func pairsReply(client *Client, elems []protocol.Reply) protocol.Reply {
+	if client.Protocol != RESP3 {
+		return resp2.NewArrayReply(false, elems)
+	}
+
+	pairs := make([]protocol.Reply, len(elems)/2)
+	for i := range pairs {
+		pairs[i] = resp2.NewArrayReply(false, elems[i*2:i*2+2])
+	}
+
+	return resp2.NewArrayReply(false, pairs)
+}
+
+func clusterGetKeysInSlot(client *Client, slotArg, countArg string) error {
+	slot, err := cluster.ParseSlot(slotArg)
+	if err != nil {
+		return err
+	}
+
+	count, err := strconv.Atoi(countArg)
+	if err != nil || count < 0 {
+		return errors.New("invalid number of keys")
+	}
+
+	client.WriteStringArray(keysInSlot(slot, count))
+	return nil
+}
+
+func clusterMeet(client *Client, host, portArg string) error {
+	port, err := strconv.Atoi(portArg)
+	if err != nil || port <= 0 || port > 65535 {
+		return errInvalidPort
+	}
+
+	clusterState.Meet(host, port)
+	client.WriteOK()
+	return nil
+}
+
+// clusterSetSlots adds or deletes slots given one by one or as ranges
+func clusterSetSlots(client *Client, add bool, args []string, ranges bool) error {
+	var slots []int
+	for i := 0; i < len(args); i++ {
+		start, err := cluster.ParseSlot(args[i])
+		if err != nil {
+			return err
+		}
+
+		end := start
+		if ranges {
+			i++
+			if end, err = cluster.ParseSlot(args[i]); err != nil {
+				return err
+			}
+
+			if end < start {
+				return fmt.Errorf("start slot number %d is greater than end slot number %d", start, end)
+			}
+		}
+
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+
+	var err error
+	if add {
+		err = clusterState.AddSlots(slots)
+	} else {
+		err = clusterState.DelSlots(slots)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	clusterChanged()
+	client.WriteOK()
+	return nil
+}
+
+// clusterSetSlot moves a slot between nodes
+// SETSLOT slot IMPORTING id | MIGRATING id | NODE id | STABLE
+func clusterSetSlot(client *Client, args []string) error {
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return err
+	}
+
+	switch opt := strings.ToLower(args[1]); {
+	case opt == "stable" && len(args) == 2:
+		clusterState.SetSlotStable(slot)
+	case opt == "migrating" && len(args) == 3:
+		err = clusterState.SetSlotMigrating(slot, args[2])
+	case opt == "importing" && len(args) == 3:
+		err = clusterState.SetSlotImporting(slot, args[2])
+	case opt == "node" && len(args) == 3:
+		if args[2] != clusterState.ID() && clusterState.Slot(slot).Mine && slotKeys.count(slot) > 0 {
+			return errSlotHasKeys
+		}
+		err = clusterState.SetSlotNode(slot, args[2])
+	default:
+		return &protocol.ErrSyntax{}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	clusterChanged()
+	client.WriteOK()
+	return nil
+}
+
+func clusterInfoSection() []infoField {
+	return []infoField{
+		{"cluster_enabled", boolToInt(clusterState != nil)},
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"testing"
+
+	"github.com/kasvith/kache/internal/cluster"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestSlotCounter(t *testing.T) {
+	assert := testifyAssert.New(t)
+	database := db.NewDB()
+	database.Set("{user}:1", db.NewDataNode(db.TypeString, -1, "v"))
+	database.Set("{user}:expired", db.NewDataNode(db.TypeString, sys.NowMillis()-1, "v"))
+
+	counter := newSlotCounter(database)
+	slot := cluster.KeySlot("user")
+	assert.Equal(2, counter.count(slot))
+
+	database.Set("{user}:2", db.NewDataNode(db.TypeString, -1, "v"))
+	database.Set("{user}:2", db.NewDataNode(db.TypeString, -1, "overwritten"))
+	database.Set("other", db.NewDataNode(db.TypeString, -1, "v"))
+	assert.Equal(3, counter.count(slot))
+	assert.Equal(1, counter.count(cluster.KeySlot("other")))
+	assert.ElementsMatch([]string{"{user}:1", "{user}:2", "{user}:expired"}, counter.keys(slot, -1))
+	assert.Len(counter.keys(slot, 2), 2)
+	assert.Equal([]string{}, counter.keys(slot, 0))
+	assert.Equal([]string{"other"}, counter.keys(cluster.KeySlot("other"), 10))
+	assert.Equal([]string{}, counter.keys(cluster.KeySlot("none"), 10))
+
+	// expired keys are counted until they are removed
+	assert.Equal(0, database.Exists("{user}:expired"))
+	assert.Equal(2, counter.count(slot))
+
+	database.Del([]string{"{user}:1", "{user}:missing"})
+	assert.Equal(1, counter.count(slot))
+	assert.Equal([]string{"{user}:2"}, counter.keys(slot, -1))
+
+	database.Move("{user}:2", db.NewDB())
+	assert.Equal(0, counter.count(slot))
+
+	database.Flush()
+	assert.Equal(0, counter.count(cluster.KeySlot("other")))
+	assert.Equal([]string{}, counter.keys(cluster.KeySlot("other"), -1))
+}
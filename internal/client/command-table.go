@@ -28,19 +28,179 @@ package client
 // CommandTable holds all commands that are supported by kache
 var CommandTable = map[string]Command{
 	// server
-	"ping":  {ModifyKeySpace: false, Fn: Ping, MinArgs: 0, MaxArgs: 1},
-	"multi": {ModifyKeySpace: true, Fn: Multi, MinArgs: 0, MaxArgs: 0},
-	"exec":  {ModifyKeySpace: true, Fn: Exec, MinArgs: 0, MaxArgs: 0},
+	"ping":    {ModifyKeySpace: false, Fn: Ping, MinArgs: 0, MaxArgs: 1},
+	"info":    {ModifyKeySpace: false, Fn: Info, MinArgs: 0, MaxArgs: -1},
+	"client":  {ModifyKeySpace: false, Fn: ClientCmd, MinArgs: 1, MaxArgs: -1},
+	"hello":   {ModifyKeySpace: false, Fn: Hello, MinArgs: 0, MaxArgs: -1},
+	"multi":   {ModifyKeySpace: true, Fn: Multi, MinArgs: 0, MaxArgs: 0},
+	"exec":    {ModifyKeySpace: true, Fn: Exec, MinArgs: 0, MaxArgs: 0},
+	"discard": {ModifyKeySpace: false, Fn: Discard, MinArgs: 0, MaxArgs: 0},
+	"watch":   {ModifyKeySpace: false, Fn: Watch, MinArgs: 1, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"unwatch": {ModifyKeySpace: false, Fn: Unwatch, MinArgs: 0, MaxArgs: 0},
+
+	// persistence
+	"save":         {ModifyKeySpace: false, Fn: Save, MinArgs: 0, MaxArgs: 0},
+	"bgsave":       {ModifyKeySpace: false, Fn: BgSave, MinArgs: 0, MaxArgs: 0},
+	"lastsave":     {ModifyKeySpace: false, Fn: LastSave, MinArgs: 0, MaxArgs: 0},
+	"bgrewriteaof": {ModifyKeySpace: false, Fn: BgRewriteAOF, MinArgs: 0, MaxArgs: 0},
+
+	// replication
+	"replicaof": {ModifyKeySpace: false, Fn: ReplicaOf, MinArgs: 2, MaxArgs: 2},
+	"slaveof":   {ModifyKeySpace: false, Fn: ReplicaOf, MinArgs: 2, MaxArgs: 2},
+	"role":      {ModifyKeySpace: false, Fn: Role, MinArgs: 0, MaxArgs: 0},
+	"replconf":  {ModifyKeySpace: false, Fn: ReplConf, MinArgs: 2, MaxArgs: -1},
+	"psync":     {ModifyKeySpace: false, Fn: PSync, MinArgs: 2, MaxArgs: 2},
+
+	// cluster
+	"cluster": {ModifyKeySpace: false, Fn: ClusterCmd, MinArgs: 1, MaxArgs: -1},
+	"asking":  {ModifyKeySpace: false, Fn: Asking, MinArgs: 0, MaxArgs: 0},
+
+	// change feed
+	"changefeed": {ModifyKeySpace: false, Fn: ChangeFeed, MinArgs: 1, MaxArgs: -1, Unlocked: true},
+
+	// raft
+	"raft": {ModifyKeySpace: false, Fn: RaftCmd, MinArgs: 1, MaxArgs: 2, Unlocked: true},
+
+	// pub/sub
+	"subscribe":    {ModifyKeySpace: false, Fn: Subscribe, MinArgs: 1, MaxArgs: -1, Unlocked: true},
+	"unsubscribe":  {ModifyKeySpace: false, Fn: Unsubscribe, MinArgs: 0, MaxArgs: -1, Unlocked: true},
+	"psubscribe":   {ModifyKeySpace: false, Fn: PSubscribe, MinArgs: 1, MaxArgs: -1, Unlocked: true},
+	"punsubscribe": {ModifyKeySpace: false, Fn: PUnsubscribe, MinArgs: 0, MaxArgs: -1, Unlocked: true},
+	"publish":      {ModifyKeySpace: false, Fn: Publish, MinArgs: 2, MaxArgs: 2, Unlocked: true},
+	"pubsub":       {ModifyKeySpace: false, Fn: PubSub, MinArgs: 1, MaxArgs: -1, Unlocked: true},
+	"quit":         {ModifyKeySpace: false, Fn: Quit, MinArgs: 0, MaxArgs: -1, Unlocked: true},
+
+	// databases
+	"select":    {ModifyKeySpace: false, Fn: Select, MinArgs: 1, MaxArgs: 1},
+	"swapdb":    {ModifyKeySpace: true, Fn: SwapDB, MinArgs: 2, MaxArgs: 2},
+	"move":      {ModifyKeySpace: true, Fn: Move, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"flushdb":   {ModifyKeySpace: true, Fn: FlushDB, MinArgs: 0, MaxArgs: 1},
+	"flushall":  {ModifyKeySpace: true, Fn: FlushAll, MinArgs: 0, MaxArgs: 1},
+	"dbsize":    {ModifyKeySpace: false, Fn: DBSize, MinArgs: 0, MaxArgs: 0},
+	"randomkey": {ModifyKeySpace: false, Fn: RandomKey, MinArgs: 0, MaxArgs: 0},
 
 	// key space
-	"exists": {ModifyKeySpace: false, Fn: Exists, MinArgs: 1, MaxArgs: 1},
-	"del":    {ModifyKeySpace: true, Fn: Del, MinArgs: 1, MaxArgs: -1},
-	"keys":   {ModifyKeySpace: false, Fn: Keys, MinArgs: 0, MaxArgs: 0},
-	"expire": {ModifyKeySpace: false, Fn: Expire, MinArgs: 2, MaxArgs: 2},
+	"exists":      {ModifyKeySpace: false, Fn: Exists, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"del":         {ModifyKeySpace: true, Fn: Del, MinArgs: 1, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"keys":        {ModifyKeySpace: false, Fn: Keys, MinArgs: 1, MaxArgs: 1},
+	"scan":        {ModifyKeySpace: false, Fn: Scan, MinArgs: 1, MaxArgs: 7},
+	"expire":      {ModifyKeySpace: true, Fn: Expire, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"pexpire":     {ModifyKeySpace: true, Fn: PExpire, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"expireat":    {ModifyKeySpace: true, Fn: ExpireAt, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"pexpireat":   {ModifyKeySpace: true, Fn: PExpireAt, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"persist":     {ModifyKeySpace: true, Fn: Persist, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"ttl":         {ModifyKeySpace: false, Fn: TTL, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"pttl":        {ModifyKeySpace: false, Fn: PTTL, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"expiretime":  {ModifyKeySpace: false, Fn: ExpireTime, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"pexpiretime": {ModifyKeySpace: false, Fn: PExpireTime, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"dump":        {ModifyKeySpace: false, Fn: Dump, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"restore":     {ModifyKeySpace: true, Fn: Restore, MinArgs: 3, MaxArgs: 7, FirstKey: 1, LastKey: 1},
+	"migrate":     {ModifyKeySpace: true, Fn: Migrate, MinArgs: 5, MaxArgs: -1, KeysFn: migrateKeys},
 
 	// strings
-	"get":  {ModifyKeySpace: false, Fn: Get, MinArgs: 1, MaxArgs: 1},
-	"set":  {ModifyKeySpace: true, Fn: Set, MinArgs: 2, MaxArgs: 2},
-	"incr": {ModifyKeySpace: true, Fn: Incr, MinArgs: 1, MaxArgs: 1},
-	"decr": {ModifyKeySpace: true, Fn: Decr, MinArgs: 1, MaxArgs: 1},
+	"get":    {ModifyKeySpace: false, Fn: Get, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"set":    {ModifyKeySpace: true, Fn: Set, MinArgs: 2, MaxArgs: 6, FirstKey: 1, LastKey: 1},
+	"setnx":  {ModifyKeySpace: true, Fn: SetNX, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"setex":  {ModifyKeySpace: true, Fn: SetEX, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"psetex": {ModifyKeySpace: true, Fn: PSetEX, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"getset": {ModifyKeySpace: true, Fn: GetSet, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"getdel": {ModifyKeySpace: true, Fn: GetDel, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"getex":  {ModifyKeySpace: true, Fn: GetEX, MinArgs: 1, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"incr":   {ModifyKeySpace: true, Fn: Incr, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"decr":   {ModifyKeySpace: true, Fn: Decr, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+
+	// lists
+	"lpush":   {ModifyKeySpace: true, Fn: LPush, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"rpush":   {ModifyKeySpace: true, Fn: RPush, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"lpushx":  {ModifyKeySpace: true, Fn: LPushX, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"rpushx":  {ModifyKeySpace: true, Fn: RPushX, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"lpop":    {ModifyKeySpace: true, Fn: LPop, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"rpop":    {ModifyKeySpace: true, Fn: RPop, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"llen":    {ModifyKeySpace: false, Fn: LLen, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"lrange":  {ModifyKeySpace: false, Fn: LRange, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"ltrim":   {ModifyKeySpace: true, Fn: LTrim, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"lindex":  {ModifyKeySpace: false, Fn: LIndex, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"lset":    {ModifyKeySpace: true, Fn: LSet, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"linsert": {ModifyKeySpace: true, Fn: LInsert, MinArgs: 4, MaxArgs: 4, FirstKey: 1, LastKey: 1},
+	"lrem":    {ModifyKeySpace: true, Fn: LRem, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"lpos":    {ModifyKeySpace: false, Fn: LPos, MinArgs: 2, MaxArgs: 8, FirstKey: 1, LastKey: 1},
+	"lmove":   {ModifyKeySpace: true, Fn: LMove, MinArgs: 4, MaxArgs: 4, FirstKey: 1, LastKey: 2},
+	"lmpop":   {ModifyKeySpace: true, Fn: LMPop, MinArgs: 3, MaxArgs: -1, KeysFn: numKeys(0)},
+	"blpop":   {ModifyKeySpace: true, Fn: BLPop, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: -2},
+	"brpop":   {ModifyKeySpace: true, Fn: BRPop, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: -2},
+	"blmove":  {ModifyKeySpace: true, Fn: BLMove, MinArgs: 5, MaxArgs: 5, FirstKey: 1, LastKey: 2},
+	"blmpop":  {ModifyKeySpace: true, Fn: BLMPop, MinArgs: 4, MaxArgs: -1, KeysFn: numKeys(1)},
+
+	// hashes
+	"hset":         {ModifyKeySpace: true, Fn: HSet, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"hsetnx":       {ModifyKeySpace: true, Fn: HSetNX, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"hget":         {ModifyKeySpace: false, Fn: HGet, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"hmget":        {ModifyKeySpace: false, Fn: HMGet, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"hgetall":      {ModifyKeySpace: false, Fn: HGetAll, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"hkeys":        {ModifyKeySpace: false, Fn: HKeys, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"hvals":        {ModifyKeySpace: false, Fn: HVals, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"hdel":         {ModifyKeySpace: true, Fn: HDel, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"hexists":      {ModifyKeySpace: false, Fn: HExists, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"hlen":         {ModifyKeySpace: false, Fn: HLen, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"hstrlen":      {ModifyKeySpace: false, Fn: HStrLen, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"hincrby":      {ModifyKeySpace: true, Fn: HIncrBy, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"hincrbyfloat": {ModifyKeySpace: true, Fn: HIncrByFloat, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"hrandfield":   {ModifyKeySpace: false, Fn: HRandField, MinArgs: 1, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"hscan":        {ModifyKeySpace: false, Fn: HScan, MinArgs: 2, MaxArgs: 7, FirstKey: 1, LastKey: 1},
+
+	// sets
+	"sadd":        {ModifyKeySpace: true, Fn: SAdd, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"srem":        {ModifyKeySpace: true, Fn: SRem, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"smembers":    {ModifyKeySpace: false, Fn: SMembers, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"sismember":   {ModifyKeySpace: false, Fn: SIsMember, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"smismember":  {ModifyKeySpace: false, Fn: SMIsMember, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"scard":       {ModifyKeySpace: false, Fn: SCard, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"smove":       {ModifyKeySpace: true, Fn: SMove, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 2},
+	"sdiff":       {ModifyKeySpace: false, Fn: SDiff, MinArgs: 1, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sinter":      {ModifyKeySpace: false, Fn: SInter, MinArgs: 1, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sunion":      {ModifyKeySpace: false, Fn: SUnion, MinArgs: 1, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sdiffstore":  {ModifyKeySpace: true, Fn: SDiffStore, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sinterstore": {ModifyKeySpace: true, Fn: SInterStore, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sunionstore": {ModifyKeySpace: true, Fn: SUnionStore, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: -1},
+	"sintercard":  {ModifyKeySpace: false, Fn: SInterCard, MinArgs: 2, MaxArgs: -1, KeysFn: numKeys(0)},
+	"spop":        {ModifyKeySpace: true, Fn: SPop, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"srandmember": {ModifyKeySpace: false, Fn: SRandMember, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"sscan":       {ModifyKeySpace: false, Fn: SScan, MinArgs: 2, MaxArgs: 6, FirstKey: 1, LastKey: 1},
+
+	// sorted sets
+	"zadd":             {ModifyKeySpace: true, Fn: ZAdd, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"zincrby":          {ModifyKeySpace: true, Fn: ZIncrBy, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zcard":            {ModifyKeySpace: false, Fn: ZCard, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"zscore":           {ModifyKeySpace: false, Fn: ZScore, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"zrank":            {ModifyKeySpace: false, Fn: ZRank, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"zrevrank":         {ModifyKeySpace: false, Fn: ZRevRank, MinArgs: 2, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"zrem":             {ModifyKeySpace: true, Fn: ZRem, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"zrange":           {ModifyKeySpace: false, Fn: ZRange, MinArgs: 3, MaxArgs: 9, FirstKey: 1, LastKey: 1},
+	"zcount":           {ModifyKeySpace: false, Fn: ZCount, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zlexcount":        {ModifyKeySpace: false, Fn: ZLexCount, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zremrangebyrank":  {ModifyKeySpace: true, Fn: ZRemRangeByRank, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zremrangebyscore": {ModifyKeySpace: true, Fn: ZRemRangeByScore, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zremrangebylex":   {ModifyKeySpace: true, Fn: ZRemRangeByLex, MinArgs: 3, MaxArgs: 3, FirstKey: 1, LastKey: 1},
+	"zpopmin":          {ModifyKeySpace: true, Fn: ZPopMin, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"zpopmax":          {ModifyKeySpace: true, Fn: ZPopMax, MinArgs: 1, MaxArgs: 2, FirstKey: 1, LastKey: 1},
+	"zunionstore":      {ModifyKeySpace: true, Fn: ZUnionStore, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1, KeysFn: numKeys(1)},
+	"zinterstore":      {ModifyKeySpace: true, Fn: ZInterStore, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1, KeysFn: numKeys(1)},
+	"zscan":            {ModifyKeySpace: false, Fn: ZScan, MinArgs: 2, MaxArgs: 6, FirstKey: 1, LastKey: 1},
+
+	// streams
+	"xadd":       {ModifyKeySpace: true, Fn: XAdd, MinArgs: 4, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xrange":     {ModifyKeySpace: false, Fn: XRange, MinArgs: 3, MaxArgs: 5, FirstKey: 1, LastKey: 1},
+	"xrevrange":  {ModifyKeySpace: false, Fn: XRevRange, MinArgs: 3, MaxArgs: 5, FirstKey: 1, LastKey: 1},
+	"xlen":       {ModifyKeySpace: false, Fn: XLen, MinArgs: 1, MaxArgs: 1, FirstKey: 1, LastKey: 1},
+	"xdel":       {ModifyKeySpace: true, Fn: XDel, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xtrim":      {ModifyKeySpace: true, Fn: XTrim, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xsetid":     {ModifyKeySpace: true, Fn: XSetID, MinArgs: 2, MaxArgs: 6, FirstKey: 1, LastKey: 1},
+	"xread":      {ModifyKeySpace: false, Fn: XRead, MinArgs: 3, MaxArgs: -1, KeysFn: streamReadKeys},
+	"xreadgroup": {ModifyKeySpace: true, Fn: XReadGroup, MinArgs: 6, MaxArgs: -1, KeysFn: streamReadKeys},
+	"xgroup":     {ModifyKeySpace: true, Fn: XGroup, MinArgs: 2, MaxArgs: -1, FirstKey: 2, LastKey: 2},
+	"xack":       {ModifyKeySpace: true, Fn: XAck, MinArgs: 3, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xpending":   {ModifyKeySpace: false, Fn: XPending, MinArgs: 2, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xclaim":     {ModifyKeySpace: true, Fn: XClaim, MinArgs: 5, MaxArgs: -1, FirstKey: 1, LastKey: 1},
+	"xautoclaim": {ModifyKeySpace: true, Fn: XAutoClaim, MinArgs: 5, MaxArgs: 8, FirstKey: 1, LastKey: 1},
+	"xinfo":      {ModifyKeySpace: false, Fn: XInfo, MinArgs: 2, MaxArgs: -1, FirstKey: 2, LastKey: 2},
 }
@@ -26,7 +26,10 @@
 package client
 
 import (
-	"strings"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"github.com/kasvith/kache/internal/protocol"
 	"github.com/kasvith/kache/internal/resp/resp2"
@@ -37,16 +40,73 @@ type CommandFunc func(*Client, []string)
 
 // Command holds a command structure which is used to execute a kache command
 type Command struct {
+	Name           string
 	ModifyKeySpace bool
 	Fn             CommandFunc
 	MinArgs        int // 0
 	MaxArgs        int // -1 ~ +inf, -1 mean infinite
-	Args           []string
+
+	// FirstKey is the position of the first key in args starting from 1, 0 means the command has no keys
+	FirstKey int
+	// LastKey is the position of the last key, negative positions count from the end, e.g. -1 is the last arg
+	LastKey int
+	// KeyStep is the distance between keys, 0 means keys follow each other
+	KeyStep int
+	// KeysFn finds keys which can not be described with positions, e.g. keys preceded by their count
+	KeysFn func(args []string) []string
+
+	// Unlocked commands run without the key space lock, e.g. since they wait for others, they must not access the key space
+	Unlocked bool
+
+	Args []string
+}
+
+// Keys returns the keys given in args of the command
+func (cmd *Command) Keys(args []string) []string {
+	var keys []string
+	if cmd.FirstKey > 0 {
+		last := cmd.LastKey
+		if last < 0 {
+			last += len(args) + 1
+		}
+
+		step := cmd.KeyStep
+		if step == 0 {
+			step = 1
+		}
+
+		for i := cmd.FirstKey; i <= last && i <= len(args); i += step {
+			keys = append(keys, args[i-1])
+		}
+	}
+
+	if cmd.KeysFn != nil {
+		keys = append(keys, cmd.KeysFn(args)...)
+	}
+
+	return keys
+}
+
+// numKeys returns a KeysFn for commands which give the number of keys at index pos of args followed by the keys
+func numKeys(pos int) func(args []string) []string {
+	return func(args []string) []string {
+		if pos >= len(args) {
+			return nil
+		}
+
+		n, err := strconv.Atoi(args[pos])
+		if err != nil || n <= 0 || n > len(args)-pos-1 {
+			return nil
+		}
+
+		return args[pos+1 : pos+1+n]
+	}
 }
 
 // GetCommand will fetch the command from command table
 func GetCommand(cmd string) (*Command, error) {
 	if v, ok := CommandTable[cmd]; ok {
+		v.Name = cmd
 		return &v, nil
 	}
 
@@ -55,6 +115,8 @@ func GetCommand(cmd string) (*Command, error) {
 
 // Execute a single command on the given database with args
 func Execute(client *Client, cmd string, args []string) {
+	defer client.flushReply()
+
 	command, err := GetCommand(cmd)
 	if err != nil {
 		if client.Multi {
@@ -74,7 +136,31 @@ func Execute(client *Client, cmd string, args []string) {
 		return
 	}
 
-	if client.Multi && strings.ToLower(cmd) != "exec" {
+	// RESP2 connections are reserved for messages while they are subscribed
+	if client.subscriptions() > 0 && client.Protocol == RESP2 && !pubsubCommands[command.Name] {
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", cmd)})
+		return
+	}
+
+	if err := clusterRedirect(client, command, args); err != nil {
+		if client.Multi {
+			client.MultiError = true
+			client.Commands = []*Command{}
+		}
+		client.WriteError(err)
+		return
+	}
+
+	if readOnly(client, command) {
+		if client.Multi {
+			client.MultiError = true
+			client.Commands = []*Command{}
+		}
+		client.WriteError(protocol.ErrReadOnly{})
+		return
+	}
+
+	if client.Multi && !transactionCommands[command.Name] {
 		// store args for later use
 		command.Args = args
 		client.Commands = append(client.Commands, command)
@@ -82,6 +168,62 @@ func Execute(client *Client, cmd string, args []string) {
 		return
 	}
 
+	// in raft mode changes are applied once the raft log replicated them
+	if proposeToRaft(client, command, args) {
+		return
+	}
+
+	// CLIENT CACHING only applies to the command following it
+	caching := client.caching
+
 	// execute command directly
-	command.Fn(client, args)
+	run(command, client, args)
+	if caching != "" {
+		client.caching = ""
+	}
+
+	// blocking commands wait without holding the key space lock
+	if client.blocked != nil {
+		client.waitUnblocked()
+	}
+}
+
+// transactionCommands are executed immediately instead of being queued in a transaction
+var transactionCommands = map[string]bool{"multi": true, "exec": true, "discard": true, "watch": true}
+
+// keyspaceMux serializes commands which modify the key space while others run concurrently
+// This gives snapshots and transactions a consistent view of all databases
+var keyspaceMux sync.RWMutex
+
+// run executes a command holding the key space lock
+func run(command *Command, client *Client, args []string) {
+	if command.Unlocked {
+		command.Fn(client, args)
+		return
+	}
+
+	if !command.ModifyKeySpace {
+		keyspaceMux.RLock()
+		command.Fn(client, args)
+		client.trackRead(command, args)
+		if client.master {
+			replicate(client, nil)
+		}
+		keyspaceMux.RUnlock()
+		return
+	}
+
+	keyspaceMux.Lock()
+	client.execute(command, args)
+	entries := append(client.flushPropagated(), serveBlockedClients()...)
+	touchWatched(entries)
+	touchModified(entries)
+	invalidateEntries(client, entries)
+	appendToAOF(entries)
+	replicate(client, entries)
+	// failed commands and commands which changed nothing log no entries
+	if len(entries) > 0 {
+		atomic.AddInt64(&dirty, 1)
+	}
+	keyspaceMux.Unlock()
 }
@@ -0,0 +1,186 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+)
+
+var (
+	errInvalidDBIndex = errors.New("invalid DB index")
+	errDBOutOfRange   = errors.New("DB index is out of range")
+	errSameObject     = errors.New("source and destination objects are the same")
+)
+
+// parseDatabase finds the database for a given index
+func parseDatabase(arg string) (int, *db.DB, error) {
+	idx, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, nil, &protocol.ErrGeneric{Err: errInvalidDBIndex}
+	}
+
+	if idx < 0 || idx >= len(databases) {
+		return 0, nil, &protocol.ErrGeneric{Err: errDBOutOfRange}
+	}
+
+	return idx, databases[idx], nil
+}
+
+// parseFlushMode validates the optional ASYNC or SYNC argument of flush commands
+// Both modes behave the same, dropped keys are reclaimed by the garbage collector concurrently
+func parseFlushMode(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "async", "sync":
+		return nil
+	}
+
+	return &protocol.ErrSyntax{}
+}
+
+// Select changes the selected database of the client
+// SELECT index
+func Select(client *Client, args []string) {
+	idx, database, err := parseDatabase(args[0])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	// only the first database is partitioned in cluster mode
+	if clusterState != nil && idx != 0 {
+		client.WriteError(notInCluster("SELECT"))
+		return
+	}
+
+	client.DatabaseIndex, client.Database = idx, database
+	client.WriteOK()
+}
+
+// SwapDB exchanges the keys of two databases, clients connected to either see the keys of the other one
+// SWAPDB index1 index2
+func SwapDB(client *Client, args []string) {
+	if clusterState != nil {
+		client.WriteError(notInCluster("SWAPDB"))
+		return
+	}
+
+	i, first, err := parseDatabase(args[0])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	j, second, err := parseDatabase(args[1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	first.Swap(second)
+	signalDBReady(i)
+	signalDBReady(j)
+	client.WriteOK()
+}
+
+// Move moves a key from the selected database to another one
+// MOVE key db
+func Move(client *Client, args []string) {
+	if clusterState != nil {
+		client.WriteError(notInCluster("MOVE"))
+		return
+	}
+
+	idx, dst, err := parseDatabase(args[1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if dst == client.Database {
+		client.WriteError(&protocol.ErrGeneric{Err: errSameObject})
+		return
+	}
+
+	if client.Database.Move(args[0], dst) {
+		signalReady(blockKey{db: idx, key: args[0]})
+		client.WriteInteger(1)
+		return
+	}
+
+	client.WriteInteger(0)
+}
+
+// FlushDB removes all keys of the selected database
+// FLUSHDB [ASYNC | SYNC]
+func FlushDB(client *Client, args []string) {
+	if err := parseFlushMode(args); err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.Database.Flush()
+	client.WriteOK()
+}
+
+// FlushAll removes all keys of all databases
+// FLUSHALL [ASYNC | SYNC]
+func FlushAll(client *Client, args []string) {
+	if err := parseFlushMode(args); err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	for _, database := range databases {
+		database.Flush()
+	}
+
+	client.WriteOK()
+}
+
+// DBSize returns the number of keys in the selected database
+func DBSize(client *Client, args []string) {
+	client.WriteInteger(client.Database.Len())
+}
+
+// RandomKey returns a random key from the selected database
+func RandomKey(client *Client, args []string) {
+	key, found := client.Database.RandomKey()
+	if !found {
+		client.WriteNil()
+		return
+	}
+
+	client.WriteBulkString(key)
+}
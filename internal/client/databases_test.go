@@ -0,0 +1,140 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "k", "db0")
+	assert.Equal("OK", c.do("select", "1"))
+	assert.Nil(c.do("get", "k"))
+	c.do("set", "k", "db1")
+
+	assert.Equal("OK", c.do("select", "0"))
+	assert.Equal("db0", c.do("get", "k"))
+
+	assert.IsType(replyError(""), c.do("select", "-1"))
+	assert.IsType(replyError(""), c.do("select", "16"))
+	assert.IsType(replyError(""), c.do("select", "one"))
+	assert.Equal("db0", c.do("get", "k"))
+}
+
+func TestSwapDB(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c, other := newTestConn(t), newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "a", "db0")
+	c.do("pexpire", "a", "100000")
+	c.do("select", "1")
+	c.do("set", "b", "db1")
+	c.do("set", "c", "db1")
+	c.do("select", "0")
+
+	other.do("select", "1")
+
+	assert.Equal("OK", c.do("swapdb", "0", "1"))
+
+	// clients see the keys of the other database without selecting it
+	assert.Equal(2, c.do("dbsize"))
+	assert.Equal("db1", c.do("get", "b"))
+	assert.Nil(c.do("get", "a"))
+
+	assert.Equal(1, other.do("dbsize"))
+	assert.Equal("db0", other.do("get", "a"))
+	assert.InDelta(100000, other.do("pttl", "a"), 2000)
+
+	assert.Equal("OK", c.do("swapdb", "1", "1"))
+	assert.Equal(2, c.do("dbsize"))
+
+	assert.IsType(replyError(""), c.do("swapdb", "0", "16"))
+	assert.IsType(replyError(""), c.do("swapdb", "x", "1"))
+}
+
+func TestMove(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "a", "v")
+	c.do("pexpire", "a", "100000")
+	c.do("rpush", "l", "x", "y")
+
+	assert.Equal(1, c.do("move", "a", "2"))
+	assert.Equal(1, c.do("move", "l", "2"))
+	assert.Equal(0, c.do("exists", "a"))
+	assert.Equal(0, c.do("move", "missing", "2"))
+
+	c.do("set", "a", "other")
+	assert.Equal(0, c.do("move", "a", "2"))
+	assert.Equal("other", c.do("get", "a"))
+
+	assert.IsType(replyError(""), c.do("move", "a", "0"))
+	assert.IsType(replyError(""), c.do("move", "a", "16"))
+
+	c.do("select", "2")
+	assert.Equal("v", c.do("get", "a"))
+	assert.InDelta(100000, c.do("pttl", "a"), 2000)
+	assert.Equal([]interface{}{"x", "y"}, c.do("lrange", "l", "0", "-1"))
+}
+
+func TestFlush(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	for _, db := range []string{"0", "1", "2"} {
+		c.do("select", db)
+		c.do("set", "a", "v")
+		c.do("set", "b", "v", "px", "100000")
+	}
+
+	c.do("select", "1")
+	assert.Equal("OK", c.do("flushdb"))
+	assert.Equal(0, c.do("dbsize"))
+	assert.Equal("OK", c.do("flushdb", "async"))
+	assert.IsType(replyError(""), c.do("flushdb", "later"))
+
+	c.do("select", "0")
+	assert.Equal(2, c.do("dbsize"))
+	assert.Equal("keys=2,expires=1", c.info("db0"))
+	assert.Equal("", c.info("db1"))
+
+	assert.IsType(replyError(""), c.do("flushall", "now"))
+	assert.Equal("OK", c.do("flushall", "SYNC"))
+	assert.Equal(0, c.do("dbsize"))
+	c.do("select", "2")
+	assert.Equal(0, c.do("dbsize"))
+	assert.Nil(c.do("randomkey"))
+}
@@ -0,0 +1,460 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/sys"
+)
+
+// defaultMigrateTimeout is used when MIGRATE is given a timeout of 0
+const defaultMigrateTimeout = time.Second
+
+var (
+	errInvalidTTL       = errors.New("Invalid TTL value, must be >= 0")
+	errInvalidIdleTime  = errors.New("Invalid IDLETIME value, must be >= 0")
+	errMigrateKeys      = errors.New("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+	errMigrateNoKeys    = errors.New("MIGRATE requires a key or the KEYS option")
+	errInvalidTimeout   = errors.New("timeout is not an integer or out of range")
+	errMigrateBadDBPort = errors.New("invalid port or destination db")
+)
+
+// Dump serializes the value of a key, RESTORE creates a key from it with the ttl it is given
+// DUMP key
+func Dump(client *Client, args []string) {
+	node, found := client.Database.GetNode(args[0])
+	if !found {
+		client.WriteNil()
+		return
+	}
+
+	payload, err := persistence.Dump(node)
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteBulkString(string(payload))
+}
+
+// Restore creates a key from a value serialized by DUMP
+// Like redis, a ttl of 0 creates the key without an expiration, IDLETIME is accepted for compatibility and ignored
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds]
+func Restore(client *Client, args []string) {
+	key := args[0]
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	if ttl < 0 {
+		client.WriteError(&protocol.ErrGeneric{Err: errInvalidTTL})
+		return
+	}
+
+	replace, absTTL := false, false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime":
+			if i+1 >= len(args) {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			idle, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || idle < 0 {
+				client.WriteError(&protocol.ErrGeneric{Err: errInvalidIdleTime})
+				return
+			}
+			i++
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	node, err := persistence.Restore([]byte(args[2]))
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	exp := int64(-1)
+	if ttl > 0 {
+		at, ok := toMillis(ttl, time.Millisecond, absTTL)
+		if !ok {
+			client.WriteError(&protocol.ErrGeneric{Err: errInvalidExpire})
+			return
+		}
+		exp = at
+	}
+	node.SetExpiration(exp)
+
+	expired := exp != -1 && exp <= sys.NowMillis()
+
+	deleted := false
+	client.Database.Compute(key, func(old *db.DataNode) (*db.DataNode, bool) {
+		if old != nil && !replace {
+			err = protocol.ErrBusyKey{}
+			return nil, false
+		}
+
+		// a value which already expired only removes the key it replaces
+		if expired {
+			deleted = old != nil
+			return nil, deleted
+		}
+
+		return node, true
+	})
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	switch {
+	case deleted:
+		client.propagateAs([]string{"del", key})
+	case expired:
+		client.propagateAs()
+	default:
+		// relative expirations are logged as absolute ones
+		cmd := []string{"restore", key, strconv.FormatInt(max(exp, 0), 10), args[2], "absttl"}
+		if replace {
+			cmd = append(cmd, "replace")
+		}
+		client.propagateAs(cmd)
+		signalKeyReady(client, key)
+	}
+
+	client.WriteOK()
+}
+
+// migrateOptions are the options of MIGRATE
+type migrateOptions struct {
+	copy    bool
+	replace bool
+	auth    []string
+	keys    []string
+}
+
+// parseMigrateOptions parses the options following the timeout of MIGRATE
+func parseMigrateOptions(args []string) (migrateOptions, error) {
+	var opts migrateOptions
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			opts.copy = true
+		case "replace":
+			opts.replace = true
+		case "auth":
+			if i+1 >= len(args) {
+				return opts, &protocol.ErrSyntax{}
+			}
+			opts.auth = args[i+1 : i+2]
+			i++
+		case "auth2":
+			if i+2 >= len(args) {
+				return opts, &protocol.ErrSyntax{}
+			}
+			opts.auth = args[i+1 : i+3]
+			i += 2
+		case "keys":
+			if args[2] != "" {
+				return opts, &protocol.ErrGeneric{Err: errMigrateKeys}
+			}
+			opts.keys = args[i+1:]
+			i = len(args)
+		default:
+			return opts, &protocol.ErrSyntax{}
+		}
+	}
+
+	if args[2] != "" {
+		opts.keys = args[2:3]
+	}
+
+	if len(opts.keys) == 0 {
+		return opts, &protocol.ErrGeneric{Err: errMigrateNoKeys}
+	}
+
+	return opts, nil
+}
+
+// migrateKeys finds the keys of MIGRATE for the command table
+func migrateKeys(args []string) []string {
+	if len(args) < 5 {
+		return nil
+	}
+
+	opts, err := parseMigrateOptions(args)
+	if err != nil {
+		return nil
+	}
+
+	return opts.keys
+}
+
+// Migrate moves keys to another instance, the keys are restored in a single transaction on the target and
+// deleted here once they were restored unless COPY is given
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password | AUTH2 username password]
+// [KEYS key [key ...]]
+func Migrate(client *Client, args []string) {
+	// every member would move the keys
+	if raftNode != nil {
+		client.WriteError(notInRaft("MIGRATE"))
+		return
+	}
+
+	opts, err := parseMigrateOptions(args)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	port, portErr := strconv.Atoi(args[1])
+	dbIndex, dbErr := strconv.Atoi(args[3])
+	if portErr != nil || dbErr != nil || port <= 0 || port > 65535 || dbIndex < 0 {
+		client.WriteError(&protocol.ErrGeneric{Err: errMigrateBadDBPort})
+		return
+	}
+
+	timeout, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil || timeout < 0 || timeout > int64(time.Hour/time.Millisecond) {
+		client.WriteError(&protocol.ErrGeneric{Err: errInvalidTimeout})
+		return
+	}
+
+	wait := time.Duration(timeout) * time.Millisecond
+	if wait == 0 {
+		wait = defaultMigrateTimeout
+	}
+
+	// missing keys are skipped
+	var keys, payloads []string
+	var expires []int64
+	for _, key := range opts.keys {
+		node, found := client.Database.GetNode(key)
+		if !found {
+			continue
+		}
+
+		payload, err := persistence.Dump(node)
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+
+		keys = append(keys, key)
+		payloads = append(payloads, string(payload))
+		expires = append(expires, node.Expiration())
+	}
+
+	if len(keys) == 0 {
+		client.propagateAs()
+		client.WriteSimpleString("NOKEY")
+		return
+	}
+
+	results, err := migrateTo(net.JoinHostPort(args[0], args[1]), wait, dbIndex, opts, keys, payloads, expires)
+	if err != nil {
+		client.propagateAs()
+		client.WriteError(err)
+		return
+	}
+
+	var restored []string
+	var failure error
+	for i, result := range results {
+		if result != nil {
+			if failure == nil {
+				failure = result
+			}
+			continue
+		}
+
+		restored = append(restored, keys[i])
+	}
+
+	client.propagateAs()
+	if !opts.copy && len(restored) > 0 {
+		client.Database.Del(restored)
+		client.propagateAs(append([]string{"del"}, restored...))
+	}
+
+	if failure != nil {
+		client.WriteError(failure)
+
+		// keys restored before the failure were removed, so they should be logged even though the command failed
+		client.failed = false
+		return
+	}
+
+	client.WriteOK()
+}
+
+// migrateTo restores keys on the instance at addr wrapped in MULTI and EXEC
+// Keys are restored with their absolute expiration, -1 when they have none
+// It returns the result of restoring each key, err is set when the target could not be reached
+func migrateTo(addr string, timeout time.Duration, dbIndex int, opts migrateOptions, keys, payloads []string, expires []int64) ([]error, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, protocol.ErrIO{Op: "connecting to"}
+	}
+	defer conn.Close()
+
+	// commands which should reply with OK before the transaction starts
+	var setup [][]string
+	if len(opts.auth) > 0 {
+		setup = append(setup, append([]string{"auth"}, opts.auth...))
+	}
+	if dbIndex != 0 {
+		setup = append(setup, []string{"select", strconv.Itoa(dbIndex)})
+	}
+	if clusterState != nil {
+		// the target imports the slot of the keys
+		setup = append(setup, []string{"asking"})
+	}
+	setup = append(setup, []string{"multi"})
+
+	reader := bufio.NewReader(conn)
+	send := func(cmds [][]string) error {
+		var buf []byte
+		for _, cmd := range cmds {
+			buf = persistence.AppendCommand(buf, cmd)
+		}
+
+		conn.SetDeadline(time.Now().Add(timeout))
+		if _, err := conn.Write(buf); err != nil {
+			return protocol.ErrIO{Op: "writing to"}
+		}
+		return nil
+	}
+	read := func() (string, error) {
+		conn.SetDeadline(time.Now().Add(timeout))
+		line, err := readLine(reader)
+		if err != nil {
+			return "", protocol.ErrIO{Op: "reading from"}
+		}
+		return line, nil
+	}
+
+	// the transaction is only sent once the target is ready for it
+	if err := send(setup); err != nil {
+		return nil, err
+	}
+
+	var failure error
+	for range setup {
+		line, err := read()
+		if err != nil {
+			return nil, err
+		}
+
+		if line[0] == resp2.TypeError && failure == nil {
+			failure = targetError(line)
+		}
+	}
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	cmds := make([][]string, 0, len(keys)+1)
+	for i, key := range keys {
+		cmd := []string{"restore", key, strconv.FormatInt(max(expires[i], 0), 10), payloads[i], "absttl"}
+		if opts.replace {
+			cmd = append(cmd, "replace")
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	if err := send(append(cmds, []string{"exec"})); err != nil {
+		return nil, err
+	}
+
+	results := make([]error, len(keys))
+	for i := range keys {
+		line, err := read()
+		if err != nil {
+			return nil, err
+		}
+
+		if line[0] == resp2.TypeError {
+			results[i] = targetError(line)
+		}
+	}
+
+	// the transaction is discarded when a command could not be queued
+	line, err := read()
+	if err != nil {
+		return nil, err
+	}
+
+	if line[0] == resp2.TypeError {
+		for i := range results {
+			if results[i] == nil {
+				results[i] = targetError(line)
+			}
+		}
+		return results, nil
+	}
+
+	for i := range keys {
+		line, err := read()
+		if err != nil {
+			return nil, err
+		}
+
+		if line[0] == resp2.TypeError {
+			results[i] = targetError(line)
+		}
+	}
+
+	return results, nil
+}
+
+// targetError converts an error reply of the target instance
+func targetError(line string) error {
+	return &protocol.ErrGeneric{Err: fmt.Errorf("Target instance replied with error: %s", line[1:])}
+}
@@ -0,0 +1,458 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+)
+
+var errNotFloat = errors.New("value is not a valid float")
+
+// getHash finds the hashmap stored at key
+// When create is true a new hashmap will be stored for a missing key
+// A nil hashmap is returned when key is not found and create is false
+func getHash(client *Client, key string, create bool) (*hashmap.HashMap, error) {
+	var node *db.DataNode
+	if create {
+		node, _ = client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeHashMap, -1, hashmap.New()))
+	} else {
+		v, found := client.Database.GetNode(key)
+		if !found {
+			return nil, nil
+		}
+		node = v
+	}
+
+	if node.Type != db.TypeHashMap {
+		return nil, &protocol.ErrWrongType{}
+	}
+
+	return node.Value.(*hashmap.HashMap), nil
+}
+
+// removeIfEmptyHash will delete the key when the hashmap does not hold any field
+func removeIfEmptyHash(client *Client, key string, m *hashmap.HashMap) {
+	if m.Len() == 0 {
+		client.Database.Del([]string{key})
+	}
+}
+
+// HSet sets fields of the hashmap and returns the number of newly added fields
+func HSet(client *Client, args []string) {
+	fields := args[1:]
+	if len(fields)%2 != 0 {
+		client.WriteError(&protocol.ErrWrongNumberOfArgs{Cmd: "hset"})
+		return
+	}
+
+	m, err := getHash(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	added := 0
+	for i := 0; i < len(fields); i += 2 {
+		added += m.Set(fields[i], fields[i+1])
+	}
+
+	client.WriteInteger(added)
+}
+
+// HSetNX sets a field only if it does not exist
+func HSetNX(client *Client, args []string) {
+	m, err := getHash(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.WriteInteger(m.Setx(args[1], args[2]))
+}
+
+// HGet returns the value of a field
+func HGet(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteNil()
+		return
+	}
+
+	if val, found := m.Find(args[1]); found {
+		client.WriteBulkString(val)
+		return
+	}
+
+	client.WriteNil()
+}
+
+// HMGet returns the values of given fields, missing fields are returned as nil
+func HMGet(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	fields := args[1:]
+	vals := make([]string, len(fields))
+	found := make([]bool, len(fields))
+	if m != nil {
+		for i, field := range fields {
+			vals[i], found[i] = m.Find(field)
+		}
+	}
+
+	client.WriteNullableStringArray(vals, found)
+}
+
+// HGetAll returns all fields and values of the hashmap
+func HGetAll(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteMap([]string{})
+		return
+	}
+
+	client.WriteMap(m.Fields())
+}
+
+// HKeys returns all fields of the hashmap
+func HKeys(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	client.WriteStringArray(m.Keys())
+}
+
+// HVals returns all values of the hashmap
+func HVals(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	client.WriteStringArray(m.Vals())
+}
+
+// HDel deletes fields from the hashmap and returns the number of deleted fields
+func HDel(client *Client, args []string) {
+	key := args[0]
+	m, err := getHash(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.writeChanges(0)
+		return
+	}
+
+	deleted := m.Delete(args[1:])
+	removeIfEmptyHash(client, key, m)
+	client.writeChanges(deleted)
+}
+
+// HExists checks whether a field exists in the hashmap
+func HExists(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(m.Exists(args[1]))
+}
+
+// HLen returns the number of fields in the hashmap
+func HLen(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(m.Len())
+}
+
+// HStrLen returns the length of the value of a field
+func HStrLen(client *Client, args []string) {
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(m.FLen(args[1]))
+}
+
+// HIncrBy increments the integer value of a field
+func HIncrBy(client *Client, args []string) {
+	amount, err := strconv.Atoi(args[2])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[2]})
+		return
+	}
+
+	m, err := getHash(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	val, err := m.IncrementBy(args[1], amount)
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteInteger(val)
+}
+
+// HIncrByFloat increments the float value of a field
+func HIncrByFloat(client *Client, args []string) {
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		client.WriteError(&protocol.ErrGeneric{Err: errNotFloat})
+		return
+	}
+
+	m, err := getHash(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	val, err := m.IncrementByFloat(args[1], amount)
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteBulkString(strconv.FormatFloat(val, 'f', -1, 64))
+}
+
+// HRandField returns random fields from the hashmap
+// A positive count returns distinct fields while a negative count allows the same field multiple times
+func HRandField(client *Client, args []string) {
+	withValues := false
+	count, hasCount := 1, len(args) > 1
+	if hasCount {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+
+		// like redis, counts whose reply length could overflow are rejected
+		if v < -math.MaxInt/2 || v > math.MaxInt/2 {
+			client.WriteError(&protocol.ErrValueOutOfRange{})
+			return
+		}
+		count = v
+	}
+
+	if len(args) > 2 {
+		if len(args) > 3 || strings.ToLower(args[2]) != "withvalues" {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+		withValues = true
+	}
+
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		if hasCount {
+			client.WriteStringArray([]string{})
+		} else {
+			client.WriteNil()
+		}
+		return
+	}
+
+	if !hasCount {
+		key, _ := m.RandomKey()
+		client.WriteBulkString(key)
+		return
+	}
+
+	width := 1
+	if withValues {
+		width = 2
+	}
+
+	if count < 0 {
+		writeRandomElements(client, -count, width, func() []string {
+			key, _ := m.RandomKey()
+			if !withValues {
+				return []string{key}
+			}
+
+			val, _ := m.Find(key)
+			return []string{key, val}
+		})
+		return
+	}
+
+	keys := m.RandomKeys(count)
+	if !withValues {
+		client.WriteStringArray(keys)
+		return
+	}
+
+	reps := make([]protocol.Reply, 0, len(keys)*2)
+	for _, key := range keys {
+		val, _ := m.Find(key)
+		reps = append(reps, resp2.NewBulkStringReply(false, key), resp2.NewBulkStringReply(false, val))
+	}
+
+	client.WriteProtocolReply(pairsReply(client, reps))
+}
+
+// randomBatchSize is the number of elements writeRandomElements encodes before writing them out
+const randomBatchSize = 1024
+
+// writeRandomElements writes an array of count random elements which may repeat, pick returns width strings for
+// an element every time it is called
+// Like pairsReply, RESP3 clients receive elements of several strings as nested arrays
+// The array is buffered in batches, so a reply exceeding replyBufferLimit stops once the client was disconnected
+func writeRandomElements(client *Client, count, width int, pick func() []string) {
+	nested := width > 1 && client.Protocol == RESP3
+	if nested {
+		client.WriteArrayLength(count)
+	} else {
+		client.WriteArrayLength(count * width)
+	}
+
+	var batch []byte
+	for i := 1; i <= count; i++ {
+		if nested {
+			batch = append(batch, fmt.Sprintf("%c%d%s", resp2.TypeArray, width, resp2.CRLF)...)
+		}
+
+		for _, str := range pick() {
+			batch = append(batch, resp2.NewBulkStringReply(false, str).ToBytes()...)
+		}
+
+		if i%randomBatchSize != 0 && i != count {
+			continue
+		}
+
+		if !client.writeChunk(batch) {
+			return
+		}
+		batch = nil
+	}
+}
+
+// HScan iterates over fields of the hashmap with a cursor
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
+func HScan(client *Client, args []string) {
+	scan, err := parseScanArgs(args[1:], scanNoValues)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	m, err := getHash(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if m == nil {
+		writeScanReply(client, 0, []string{})
+		return
+	}
+
+	res := make([]string, 0, min(scan.count, m.Len())*2)
+	cursor := m.Scan(scan.cursor, scan.count, func(key, value string) {
+		if !scan.matches(key) {
+			return
+		}
+
+		res = append(res, key)
+		if !scan.noValues {
+			res = append(res, value)
+		}
+	})
+
+	writeScanReply(client, cursor, res)
+}
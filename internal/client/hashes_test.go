@@ -0,0 +1,148 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// fillHash stores n fields named f0, f1... with values v0, v1... at key
+func fillHash(c *testConn, key string, n int) {
+	c.t.Helper()
+
+	args := []string{"hset", key}
+	for i := 0; i < n; i++ {
+		args = append(args, "f"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+
+	if reply := c.do(args...); reply != n {
+		c.t.Fatalf("HSET replied with %v", reply)
+	}
+}
+
+func TestHRandField(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillHash(c, "h", 3)
+
+	assert.Contains([]interface{}{"f0", "f1", "f2"}, c.do("hrandfield", "h"))
+	assert.Len(c.do("hrandfield", "h", "2"), 2)
+	assert.ElementsMatch([]interface{}{"f0", "f1", "f2"}, c.do("hrandfield", "h", "10"))
+	assert.ElementsMatch([]interface{}{"f0", "v0", "f1", "v1", "f2", "v2"}, c.do("hrandfield", "h", "3", "withvalues"))
+
+	repeated := c.do("hrandfield", "h", "-5", "withvalues").([]interface{})
+	assert.Len(repeated, 10)
+	for i := 0; i < len(repeated); i += 2 {
+		assert.Equal("v"+repeated[i].(string)[1:], repeated[i+1])
+	}
+
+	assert.Equal([]interface{}{}, c.do("hrandfield", "missing", "-5"))
+	assert.Nil(c.do("hrandfield", "missing"))
+}
+
+func TestHRandFieldResp3(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillHash(c, "h", 3)
+	c.hello("3")
+
+	// fields with values are returned as pairs
+	assert.ElementsMatch([]interface{}{
+		[]interface{}{"f0", "v0"}, []interface{}{"f1", "v1"}, []interface{}{"f2", "v2"},
+	}, c.do("hrandfield", "h", "3", "withvalues"))
+
+	repeated := c.do("hrandfield", "h", "-2000", "withvalues").([]interface{})
+	assert.Len(repeated, 2000)
+	for _, pair := range repeated {
+		pair := pair.([]interface{})
+		assert.Len(pair, 2)
+		assert.Equal("v"+pair[0].(string)[1:], pair[1])
+	}
+
+	assert.Len(c.do("hrandfield", "h", "-5"), 5)
+	assert.Equal("PONG", c.do("ping"))
+}
+
+func TestHRandFieldLargeNegativeCount(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillHash(c, "h", 3)
+
+	assert.IsType(replyError(""), c.do("hrandfield", "h", "-4611686018427387904"))
+	assert.IsType(replyError(""), c.do("hrandfield", "h", "-9223372036854775808", "withvalues"))
+
+	// replies larger than a batch are written in pieces
+	assert.Len(c.do("hrandfield", "h", "-100000"), 100000)
+	assert.Equal("PONG", c.do("ping"))
+}
+
+func TestHScan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillHash(c, "h", 1000)
+
+	seen := make(map[string]string)
+	cursor, calls := "0", 0
+	for {
+		reply := c.do("hscan", "h", cursor, "count", "20").([]interface{})
+		cursor, calls = reply[0].(string), calls+1
+
+		fields := reply[1].([]interface{})
+		assert.True(len(fields) < 2000, "a single call returned %d fields", len(fields)/2)
+		for i := 0; i < len(fields); i += 2 {
+			seen[fields[i].(string)] = fields[i+1].(string)
+		}
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	assert.True(calls > 1)
+	assert.Len(seen, 1000)
+	assert.Equal("v42", seen["f42"])
+
+	// fields are filtered after they are visited
+	matched := 0
+	for cursor = "0"; ; {
+		reply := c.do("hscan", "h", cursor, "match", "f1?", "novalues").([]interface{})
+		cursor = reply[0].(string)
+		matched += len(reply[1].([]interface{}))
+
+		if cursor == "0" {
+			break
+		}
+	}
+
+	assert.Equal(10, matched)
+}
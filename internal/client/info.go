@@ -0,0 +1,177 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// startedAt is the time when the server was started
+var startedAt = time.Now()
+
+// infoField is a single name value pair of an INFO section
+type infoField struct {
+	name  string
+	value interface{}
+}
+
+// infoSection generates fields of a section in INFO reply
+type infoSection struct {
+	name   string
+	fields func() []infoField
+}
+
+// infoSections are the sections of INFO reply in order
+var infoSections = []infoSection{
+	{name: "server", fields: serverInfo},
+	{name: "clients", fields: clientsInfo},
+	{name: "persistence", fields: persistenceInfo},
+	{name: "stats", fields: statsInfo},
+	{name: "replication", fields: replicationInfo},
+	{name: "cluster", fields: clusterInfoSection},
+	{name: "raft", fields: raftInfoSection},
+	{name: "keyspace", fields: keyspaceInfo},
+}
+
+func serverInfo() []infoField {
+	uptime := time.Since(startedAt)
+	return []infoField{
+		{"go_version", runtime.Version()},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"process_id", os.Getpid()},
+		{"uptime_in_seconds", int64(uptime / time.Second)},
+		{"uptime_in_days", int64(uptime / (24 * time.Hour))},
+	}
+}
+
+func clientsInfo() []infoField {
+	return []infoField{
+		{"connected_clients", ConnectedClients.Count()},
+		{"blocked_clients", atomic.LoadInt64(&blockedClients)},
+		{"tracking_clients", trackingClients()},
+	}
+}
+
+func persistenceInfo() []infoField {
+	status := func(failed *int32) string {
+		if atomic.LoadInt32(failed) == 1 {
+			return "err"
+		}
+		return "ok"
+	}
+
+	fields := []infoField{
+		{"rdb_changes_since_last_save", atomic.LoadInt64(&dirty)},
+		{"rdb_bgsave_in_progress", atomic.LoadInt32(&bgSaving)},
+		{"rdb_last_save_time", atomic.LoadInt64(&lastSave)},
+		{"rdb_last_bgsave_status", status(&lastBgSaveFailed)},
+		{"aof_enabled", boolToInt(aof != nil)},
+		{"aof_rewrite_in_progress", atomic.LoadInt32(&aofRewriting)},
+		{"aof_last_bgrewrite_status", status(&lastAOFRewriteFailed)},
+	}
+
+	if aof != nil {
+		fields = append(fields, infoField{"aof_current_size", aof.Size()})
+	}
+
+	return fields
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func statsInfo() []infoField {
+	var cycles, timedOut int64
+	if expirer != nil {
+		cycles, timedOut = expirer.Cycles(), expirer.TimedOutCycles()
+	}
+
+	var expired int64
+	for _, database := range databases {
+		expired += database.ExpiredKeys()
+	}
+
+	return []infoField{
+		{"expired_keys", expired},
+		{"expire_cycles", cycles},
+		{"expire_cycles_timed_out", timedOut},
+		{"sync_full", atomic.LoadInt64(&syncFull)},
+		{"sync_partial_ok", atomic.LoadInt64(&syncPartialOK)},
+	}
+}
+
+func keyspaceInfo() []infoField {
+	var fields []infoField
+	for i, database := range databases {
+		if keys := database.Len(); keys > 0 {
+			fields = append(fields, infoField{fmt.Sprintf("db%d", i), fmt.Sprintf("keys=%d,expires=%d", keys, database.ExpiresCount())})
+		}
+	}
+
+	return fields
+}
+
+// Info returns information about the server
+// INFO [section [section ...]]
+func Info(client *Client, args []string) {
+	all := len(args) == 0
+	wanted := make(map[string]bool)
+	for _, arg := range args {
+		switch section := strings.ToLower(arg); section {
+		case "all", "default", "everything":
+			all = true
+		default:
+			wanted[section] = true
+		}
+	}
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, field := range section.fields() {
+			sb.WriteString(fmt.Sprintf("%s:%v\r\n", field.name, field.value))
+		}
+	}
+
+	client.WriteVerbatimString("txt", sb.String())
+}
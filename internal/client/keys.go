@@ -27,12 +27,12 @@ package client
 
 import (
 	"errors"
-
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/kasvith/kache/internal/resp/resp2"
-
+	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protocol"
 	"github.com/kasvith/kache/internal/sys"
 )
@@ -45,45 +45,164 @@ func Exists(client *Client, args []string) {
 
 // Del will delete set of keys and return number of deleted keys
 func Del(client *Client, args []string) {
-	deleted := client.Database.Del(args)
-	client.WriteInteger(deleted)
+	client.writeChanges(client.Database.Del(args))
 }
 
-// Keys will return all keys of the db as a list
+// Keys will return all keys of the db matching a glob pattern as a list
+// KEYS pattern
 func Keys(client *Client, args []string) {
-	keys := client.Database.Keys()
-
-	switch client.Protocol {
-	case RESP2, RESP3:
-		// TODO do a proper RESP3
-		arr := make([]protocol.Reply, len(keys))
-		for i := 0; i < len(keys); i++ {
-			arr[i] = *resp2.NewBulkStringReply(false, keys[i])
-		}
-
-		client.WriteProtocolReply(resp2.NewArrayReply(false, arr))
-	}
+	client.WriteStringArray(client.Database.Keys(args[0]))
 }
 
-// Expire a key
+var (
+	errExpireFlags   = errors.New("NX and XX, GT or LT options at the same time are not compatible")
+	errInvalidExpire = errors.New("invalid expire time")
+)
+
+// Expire sets a timeout in seconds for a key
+// EXPIRE key seconds [NX | XX | GT | LT]
 func Expire(client *Client, args []string) {
-	if v, ok := client.Database.GetNode(args[0]); ok {
-		val, err := strconv.Atoi(args[1])
-		if err != nil {
-			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
-			return
-		}
+	expire(client, args, time.Second, false)
+}
+
+// PExpire sets a timeout in milliseconds for a key
+func PExpire(client *Client, args []string) {
+	expire(client, args, time.Millisecond, false)
+}
+
+// ExpireAt sets the expiration of a key as an unix timestamp in seconds
+func ExpireAt(client *Client, args []string) {
+	expire(client, args, time.Second, true)
+}
+
+// PExpireAt sets the expiration of a key as an unix timestamp in milliseconds
+func PExpireAt(client *Client, args []string) {
+	expire(client, args, time.Millisecond, true)
+}
 
-		if val < 0 {
-			client.WriteError(errors.New("invalid seconds"))
+// expire sets the expiration of a key, val is given in unit and absolute indicates val is an unix timestamp
+func expire(client *Client, args []string, unit time.Duration, absolute bool) {
+	val, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	flags := 0
+	for _, opt := range args[2:] {
+		switch strings.ToLower(opt) {
+		case "nx":
+			flags |= db.ExpireNX
+		case "xx":
+			flags |= db.ExpireXX
+		case "gt":
+			flags |= db.ExpireGT
+		case "lt":
+			flags |= db.ExpireLT
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
 			return
 		}
+	}
 
-		ttl := sys.GetTTL(int64(val), time.Second)
-		v.SetExpiration(ttl)
+	if (flags&db.ExpireNX != 0 && flags != db.ExpireNX) || (flags&db.ExpireGT != 0 && flags&db.ExpireLT != 0) {
+		client.WriteError(&protocol.ErrGeneric{Err: errExpireFlags})
+		return
+	}
+
+	at, ok := toMillis(val, unit, absolute)
+	if !ok {
+		client.WriteError(&protocol.ErrGeneric{Err: errInvalidExpire})
+		return
+	}
+
+	if client.Database.SetExpire(args[0], at, flags) {
+		// relative expirations are logged as absolute ones
+		client.propagateAs([]string{"pexpireat", args[0], strconv.FormatInt(at, 10)})
 		client.WriteInteger(1)
 		return
 	}
 
+	client.propagateAs()
 	client.WriteInteger(0)
 }
+
+// toMillis converts val in unit to an unix timestamp in milliseconds
+// When absolute is false val is considered relative to the current time. ok is false on overflows
+func toMillis(val int64, unit time.Duration, absolute bool) (int64, bool) {
+	scale := int64(unit / time.Millisecond)
+	if val > math.MaxInt64/scale || val < math.MinInt64/scale {
+		return 0, false
+	}
+
+	val *= scale
+	if absolute {
+		return val, true
+	}
+
+	now := sys.NowMillis()
+	if val > 0 && now > math.MaxInt64-val {
+		return 0, false
+	}
+
+	return now + val, true
+}
+
+// Persist removes the expiration of a key
+func Persist(client *Client, args []string) {
+	if client.Database.Persist(args[0]) {
+		client.WriteInteger(1)
+		return
+	}
+
+	client.writeChanges(0)
+}
+
+// TTL returns the remaining time to live of a key in seconds
+func TTL(client *Client, args []string) {
+	ttl(client, args[0], time.Second, false)
+}
+
+// PTTL returns the remaining time to live of a key in milliseconds
+func PTTL(client *Client, args []string) {
+	ttl(client, args[0], time.Millisecond, false)
+}
+
+// ExpireTime returns the expiration of a key as an unix timestamp in seconds
+func ExpireTime(client *Client, args []string) {
+	ttl(client, args[0], time.Second, true)
+}
+
+// PExpireTime returns the expiration of a key as an unix timestamp in milliseconds
+func PExpireTime(client *Client, args []string) {
+	ttl(client, args[0], time.Millisecond, true)
+}
+
+// ttl replies with -2 when the key does not exist, -1 when key has no expiration or
+// the expiration of key in unit either as a remaining time or as an unix timestamp when absolute is true
+func ttl(client *Client, key string, unit time.Duration, absolute bool) {
+	at, found := client.Database.Expiration(key)
+	if !found {
+		client.WriteInteger(-2)
+		return
+	}
+
+	if at == -1 {
+		client.WriteInteger(-1)
+		return
+	}
+
+	scale := int64(unit / time.Millisecond)
+	if absolute {
+		client.WriteInteger(int(at / scale))
+		return
+	}
+
+	remaining := at - sys.NowMillis()
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	// round to the nearest unit
+	client.WriteInteger(int((remaining + scale/2) / scale))
+}
@@ -0,0 +1,220 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestExpireFlags(t *testing.T) {
+	c := newTestConn(t)
+
+	tests := []struct {
+		name   string
+		ttl    string // initial TTL in seconds, empty when the key does not expire
+		args   []string
+		expect interface{}
+		after  int // TTL after the command
+	}{
+		{name: "plain", args: []string{"100"}, expect: 1, after: 100},
+		{name: "nx without ttl", args: []string{"100", "nx"}, expect: 1, after: 100},
+		{name: "nx with ttl", ttl: "50", args: []string{"100", "NX"}, expect: 0, after: 50},
+		{name: "xx without ttl", args: []string{"100", "xx"}, expect: 0, after: -1},
+		{name: "xx with ttl", ttl: "50", args: []string{"100", "xx"}, expect: 1, after: 100},
+		{name: "gt without ttl", args: []string{"100", "gt"}, expect: 0, after: -1},
+		{name: "gt greater", ttl: "50", args: []string{"100", "gt"}, expect: 1, after: 100},
+		{name: "gt less", ttl: "50", args: []string{"10", "gt"}, expect: 0, after: 50},
+		{name: "lt without ttl", args: []string{"100", "lt"}, expect: 1, after: 100},
+		{name: "lt less", ttl: "50", args: []string{"10", "lt"}, expect: 1, after: 10},
+		{name: "lt greater", ttl: "50", args: []string{"100", "lt"}, expect: 0, after: 50},
+		{name: "xx gt", ttl: "50", args: []string{"100", "xx", "gt"}, expect: 1, after: 100},
+		{name: "nx xx", args: []string{"100", "nx", "xx"}, expect: anyError, after: -1},
+		{name: "nx gt", args: []string{"100", "nx", "gt"}, expect: anyError, after: -1},
+		{name: "gt lt", ttl: "50", args: []string{"100", "gt", "lt"}, expect: anyError, after: 50},
+		{name: "unknown flag", args: []string{"100", "always"}, expect: anyError, after: -1},
+		{name: "not an integer", args: []string{"1e3"}, expect: anyError, after: -1},
+		{name: "overflow", args: []string{"9223372036854775807"}, expect: anyError, after: -1},
+		{name: "overflow after scaling", args: []string{"9223372036854776"}, expect: anyError, after: -1},
+		{name: "negative overflow", args: []string{"-9223372036854775808"}, expect: anyError, after: -1},
+	}
+
+	for _, test := range tests {
+		c.flushAll()
+		c.do("set", "k", "v")
+		if test.ttl != "" {
+			c.do("expire", "k", test.ttl)
+		}
+
+		assertReply(t, test.expect, c.do(append([]string{"expire", "k"}, test.args...)...), test.name)
+		testifyAssert.InDelta(t, test.after, c.do("ttl", "k"), 1, test.name)
+	}
+}
+
+func TestExpireVariants(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Equal(0, c.do("expire", "missing", "100"))
+	assert.Equal(-2, c.do("ttl", "missing"))
+	assert.Equal(-2, c.do("pttl", "missing"))
+
+	c.do("set", "k", "v")
+	assert.Equal(-1, c.do("ttl", "k"))
+	assert.Equal(-1, c.do("expiretime", "k"))
+
+	assert.Equal(1, c.do("pexpire", "k", "100000"))
+	assert.InDelta(100000, c.do("pttl", "k"), 2000)
+
+	at := time.Now().Unix() + 1000
+	assert.Equal(1, c.do("expireat", "k", strconv.FormatInt(at, 10)))
+	assert.Equal(int(at), c.do("expiretime", "k"))
+	assert.Equal(int(at*1000), c.do("pexpiretime", "k"))
+
+	assert.Equal(1, c.do("pexpireat", "k", strconv.FormatInt(at*1000+500, 10)))
+	assert.Equal(int(at*1000+500), c.do("pexpiretime", "k"))
+
+	assert.Equal(1, c.do("persist", "k"))
+	assert.Equal(0, c.do("persist", "k"))
+	assert.Equal(-1, c.do("ttl", "k"))
+
+	// expirations in the past delete the key
+	assert.Equal(1, c.do("expire", "k", "-1"))
+	assert.Equal(0, c.do("exists", "k"))
+
+	c.do("set", "k", "v")
+	assert.Equal(1, c.do("pexpireat", "k", "1"))
+	assert.Equal(0, c.do("exists", "k"))
+}
+
+func TestExpireElapses(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "k", "v", "px", "50")
+	assert.Equal("v", c.do("get", "k"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(c.do("get", "k"))
+	assert.Equal(-2, c.do("ttl", "k"))
+}
+
+func TestActiveExpire(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	StartActiveExpire(db.ExpireConfig{Hz: 100})
+	t.Cleanup(func() {
+		expirer.Stop()
+		expirer = nil
+	})
+
+	before, _ := strconv.Atoi(c.info("expired_keys"))
+	for i := 0; i < 50; i++ {
+		c.do("set", "k"+strconv.Itoa(i), "v", "px", "10")
+	}
+	c.do("set", "persistent", "v")
+
+	// expired keys are removed without being accessed
+	eventually(t, func() bool {
+		return c.do("dbsize") == 1
+	}, "expired keys are removed")
+
+	after, _ := strconv.Atoi(c.info("expired_keys"))
+	assert.Equal(50, after-before)
+	assert.NotEqual("0", c.info("expire_cycles"))
+	assert.Equal("keys=1,expires=0", c.info("db0"))
+}
+
+// scanAll iterates a SCAN family command until the cursor returns to 0
+// The cursor is inserted at position pos of args, it returns every element with the number of calls
+func scanAll(c *testConn, pos int, args ...string) ([]interface{}, int) {
+	c.t.Helper()
+
+	var elems []interface{}
+	cursor, calls := "0", 0
+	for {
+		call := append(append(append([]string{}, args[:pos]...), cursor), args[pos:]...)
+		reply, ok := c.do(call...).([]interface{})
+		if !ok {
+			c.t.Fatalf("%s replied with %v", args[0], reply)
+		}
+
+		cursor, calls = reply[0].(string), calls+1
+		elems = append(elems, reply[1].([]interface{})...)
+		if cursor == "0" {
+			return elems, calls
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	for i := 0; i < 500; i++ {
+		c.do("set", "k"+strconv.Itoa(i), "v")
+	}
+	c.do("rpush", "list", "a")
+
+	keys, calls := scanAll(c, 1, "scan", "count", "20")
+	assert.True(calls > 1)
+	assert.Len(unique(keys), 501)
+
+	keys, _ = scanAll(c, 1, "scan", "match", "k1?", "count", "50")
+	assert.Len(unique(keys), 10)
+
+	keys, _ = scanAll(c, 1, "scan", "type", "list")
+	assert.Equal([]interface{}{"list"}, keys)
+
+	// huge counts are capped instead of being allocated
+	reply := c.do("scan", "0", "count", "9223372036854775807").([]interface{})
+	assert.Equal("0", reply[0])
+	assert.Len(reply[1], 501)
+
+	assert.IsType(replyError(""), c.do("scan", "0", "count", "0"))
+	assert.IsType(replyError(""), c.do("scan", "0", "count", "99999999999999999999"))
+	assert.IsType(replyError(""), c.do("scan", "-1"))
+}
+
+// unique returns the distinct elements of a scan
+func unique(elems []interface{}) map[interface{}]bool {
+	res := make(map[interface{}]bool, len(elems))
+	for _, elem := range elems {
+		res[elem] = true
+	}
+
+	return res
+}
@@ -0,0 +1,778 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/types/list"
+)
+
+var (
+	errInvalidRank      = errors.New("RANK can't be zero")
+	errNumKeys          = errors.New("numkeys should be greater than 0")
+	errCountNotPositive = errors.New("count should be greater than 0")
+)
+
+// getList finds the list stored at key
+// When create is true a new list will be stored for a missing key
+// A nil list is returned when key is not found and create is false
+func getList(client *Client, key string, create bool) (*list.TList, error) {
+	var node *db.DataNode
+	if create {
+		node, _ = client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeList, -1, list.New()))
+	} else {
+		v, found := client.Database.GetNode(key)
+		if !found {
+			return nil, nil
+		}
+		node = v
+	}
+
+	if node.Type != db.TypeList {
+		return nil, &protocol.ErrWrongType{}
+	}
+
+	return node.Value.(*list.TList), nil
+}
+
+// removeIfEmptyList will delete the key when the list does not hold any element
+func removeIfEmptyList(client *Client, key string, l *list.TList) {
+	if l.Len() == 0 {
+		client.Database.Del([]string{key})
+	}
+}
+
+// normalizeRange converts a start, stop range with negative indexes to a positive range for a collection of given
+// length. ok is false when the range is empty
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+
+	return start, stop, true
+}
+
+// parseInts converts given strings to ints
+func parseInts(args []string) ([]int, error) {
+	res := make([]int, len(args))
+	for i, arg := range args {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, &protocol.ErrCastFailedToInt{Val: arg}
+		}
+		res[i] = v
+	}
+
+	return res, nil
+}
+
+// LPush will insert values to the head of the list
+func LPush(client *Client, args []string) {
+	push(client, args[0], args[1:], true, true)
+}
+
+// RPush will insert values to the tail of the list
+func RPush(client *Client, args []string) {
+	push(client, args[0], args[1:], false, true)
+}
+
+// LPushX will insert values to the head of the list only if the list exists
+func LPushX(client *Client, args []string) {
+	push(client, args[0], args[1:], true, false)
+}
+
+// RPushX will insert values to the tail of the list only if the list exists
+func RPushX(client *Client, args []string) {
+	push(client, args[0], args[1:], false, false)
+}
+
+// push will insert values to the list and reply with the new length of the list
+func push(client *Client, key string, vals []string, head, create bool) {
+	l, err := getList(client, key, create)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.writeChanges(0)
+		return
+	}
+
+	if head {
+		l.HPush(vals)
+	} else {
+		l.TPush(vals)
+	}
+	signalKeyReady(client, key)
+
+	client.WriteInteger(l.Len())
+}
+
+// LPop will remove and return elements from the head of the list
+func LPop(client *Client, args []string) {
+	pop(client, args, true)
+}
+
+// RPop will remove and return elements from the tail of the list
+func RPop(client *Client, args []string) {
+	pop(client, args, false)
+}
+
+// pop removes elements from the list, when a count is given the reply will be an array
+func pop(client *Client, args []string, head bool) {
+	key := args[0]
+	count := -1
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+		count = v
+	}
+
+	l, err := getList(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		if count == -1 {
+			client.WriteNil()
+		} else {
+			client.WriteNilArray()
+		}
+		return
+	}
+
+	if count == -1 {
+		var val string
+		if head {
+			val = l.HPop()
+		} else {
+			val = l.TPop()
+		}
+
+		removeIfEmptyList(client, key, l)
+		client.WriteBulkString(val)
+		return
+	}
+
+	if count > l.Len() {
+		count = l.Len()
+	}
+
+	vals := make([]string, count)
+	for i := 0; i < count; i++ {
+		if head {
+			vals[i] = l.HPop()
+		} else {
+			vals[i] = l.TPop()
+		}
+	}
+
+	removeIfEmptyList(client, key, l)
+	client.WriteStringArray(vals)
+}
+
+// LLen returns the length of the list
+func LLen(client *Client, args []string) {
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(l.Len())
+}
+
+// LRange returns the elements of the list between start and stop
+func LRange(client *Client, args []string) {
+	idx, err := parseInts(args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	start, stop, ok := normalizeRange(idx[0], idx[1], l.Len())
+	if !ok {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	client.WriteStringArray(l.Range(start, stop))
+}
+
+// LTrim will trim the list to the range between start and stop
+func LTrim(client *Client, args []string) {
+	idx, err := parseInts(args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	key := args[0]
+	l, err := getList(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteOK()
+		return
+	}
+
+	start, stop, ok := normalizeRange(idx[0], idx[1], l.Len())
+	if !ok {
+		// an empty range removes the whole list
+		client.Database.Del([]string{key})
+		client.WriteOK()
+		return
+	}
+
+	l.Trim(start, stop)
+	removeIfEmptyList(client, key, l)
+	client.WriteOK()
+}
+
+// LIndex returns the element at index
+func LIndex(client *Client, args []string) {
+	idx, err := strconv.Atoi(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteNil()
+		return
+	}
+
+	if val, ok := l.Index(idx); ok {
+		client.WriteBulkString(val)
+		return
+	}
+
+	client.WriteNil()
+}
+
+// LSet sets the element at index
+func LSet(client *Client, args []string) {
+	idx, err := strconv.Atoi(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteError(&protocol.ErrNoSuchKey{})
+		return
+	}
+
+	if !l.Set(idx, args[2]) {
+		client.WriteError(&protocol.ErrIndexOutOfRange{})
+		return
+	}
+
+	client.WriteOK()
+}
+
+// LInsert inserts an element before or after the pivot
+func LInsert(client *Client, args []string) {
+	var before bool
+	switch strings.ToLower(args[1]) {
+	case "before":
+		before = true
+	case "after":
+		before = false
+	default:
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(l.Insert(before, args[2], args[3]))
+}
+
+// LRem removes count occurrences of an element
+func LRem(client *Client, args []string) {
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	key := args[0]
+	l, err := getList(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if l == nil {
+		client.writeChanges(0)
+		return
+	}
+
+	removed := l.Remove(count, args[2])
+	removeIfEmptyList(client, key, l)
+	client.writeChanges(removed)
+}
+
+// LPos returns the index of matching elements
+func LPos(client *Client, args []string) {
+	rank, count, maxLen := 1, -1, 0
+
+	opts := args[2:]
+	if len(opts)%2 != 0 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	for i := 0; i < len(opts); i += 2 {
+		v, err := strconv.Atoi(opts[i+1])
+		if err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[i+1]})
+			return
+		}
+
+		switch strings.ToLower(opts[i]) {
+		case "rank":
+			if v == 0 {
+				client.WriteError(&protocol.ErrGeneric{Err: errInvalidRank})
+				return
+			}
+			rank = v
+		case "count":
+			if v < 0 {
+				client.WriteError(&protocol.ErrValueOutOfRange{})
+				return
+			}
+			count = v
+		case "maxlen":
+			if v < 0 {
+				client.WriteError(&protocol.ErrValueOutOfRange{})
+				return
+			}
+			maxLen = v
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	l, err := getList(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if count == -1 {
+		if l == nil {
+			client.WriteNil()
+			return
+		}
+
+		if pos := l.Positions(args[1], rank, 1, maxLen); len(pos) > 0 {
+			client.WriteInteger(pos[0])
+			return
+		}
+
+		client.WriteNil()
+		return
+	}
+
+	if l == nil {
+		client.WriteIntegerArray([]int{})
+		return
+	}
+
+	client.WriteIntegerArray(l.Positions(args[1], rank, count, maxLen))
+}
+
+// parseDirection converts LEFT or RIGHT to whether the head of a list is used
+func parseDirection(arg string) (head bool, ok bool) {
+	switch strings.ToLower(arg) {
+	case "left":
+		return true, true
+	case "right":
+		return false, true
+	}
+
+	return false, false
+}
+
+// LMove atomically pops an element from source and pushes it to destination
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+func LMove(client *Client, args []string) {
+	fromHead, ok1 := parseDirection(args[2])
+	toHead, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	if !lmove(client, args[0], args[1], fromHead, toHead) {
+		client.WriteNil()
+	}
+}
+
+// lmove moves an element from src to dest and replies with it, it returns false without replying when src is empty
+func lmove(client *Client, src, dest string, fromHead, toHead bool) bool {
+	srcList, err := getList(client, src, false)
+	if err != nil {
+		client.WriteError(err)
+		return true
+	}
+
+	if srcList == nil {
+		return false
+	}
+
+	// check destination type before modifying the source
+	if node, found := client.Database.GetNode(dest); found && node.Type != db.TypeList {
+		client.WriteError(&protocol.ErrWrongType{})
+		return true
+	}
+
+	var val string
+	if fromHead {
+		val = srcList.HPop()
+	} else {
+		val = srcList.TPop()
+	}
+	removeIfEmptyList(client, src, srcList)
+
+	destList, err := getList(client, dest, true)
+	if err != nil {
+		client.WriteError(err)
+		return true
+	}
+
+	if toHead {
+		destList.HPush([]string{val})
+	} else {
+		destList.TPush([]string{val})
+	}
+	signalKeyReady(client, dest)
+
+	client.WriteBulkString(val)
+	return true
+}
+
+// mpop pops up to count elements from the first non empty list of keys, vals is nil when all lists are empty
+func mpop(client *Client, keys []string, head bool, count int) (key string, vals []string, err error) {
+	for _, key := range keys {
+		l, err := getList(client, key, false)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if l == nil {
+			continue
+		}
+
+		if count > l.Len() {
+			count = l.Len()
+		}
+
+		vals = make([]string, count)
+		for i := range vals {
+			if head {
+				vals[i] = l.HPop()
+			} else {
+				vals[i] = l.TPop()
+			}
+		}
+
+		removeIfEmptyList(client, key, l)
+		return key, vals, nil
+	}
+
+	return "", nil, nil
+}
+
+// popCommand returns the non blocking command which pops count elements from the given side of a list
+func popCommand(key string, head bool, count int) []string {
+	cmd := []string{"rpop", key}
+	if head {
+		cmd[0] = "lpop"
+	}
+
+	if count > 0 {
+		cmd = append(cmd, strconv.Itoa(count))
+	}
+
+	return cmd
+}
+
+// writeMPopReply replies with the key and the popped elements
+func writeMPopReply(client *Client, key string, vals []string) {
+	elems := make([]protocol.Reply, len(vals))
+	for i, val := range vals {
+		elems[i] = resp2.NewBulkStringReply(false, val)
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+		resp2.NewBulkStringReply(false, key),
+		resp2.NewArrayReply(false, elems),
+	}))
+}
+
+// BLPop pops an element from the head of the first non empty list, blocking until one is available
+// BLPOP key [key ...] timeout
+func BLPop(client *Client, args []string) {
+	blockingPop(client, args, true)
+}
+
+// BRPop pops an element from the tail of the first non empty list, blocking until one is available
+// BRPOP key [key ...] timeout
+func BRPop(client *Client, args []string) {
+	blockingPop(client, args, false)
+}
+
+func blockingPop(client *Client, args []string, head bool) {
+	keys := args[:len(args)-1]
+	timeout, err := parseTimeout(args[len(args)-1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	try := func(client *Client) bool {
+		key, vals, err := mpop(client, keys, head, 1)
+		if err != nil {
+			client.WriteError(err)
+			return true
+		}
+
+		if vals == nil {
+			return false
+		}
+
+		client.propagateAs(popCommand(key, head, 0))
+		client.WriteStringArray([]string{key, vals[0]})
+		return true
+	}
+
+	serveOrBlock(client, keys, timeout, try, (*Client).WriteNilArray)
+}
+
+// BLMove atomically moves an element from source to destination, blocking until source has one
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+func BLMove(client *Client, args []string) {
+	src, dest := args[0], args[1]
+	fromHead, ok1 := parseDirection(args[2])
+	toHead, ok2 := parseDirection(args[3])
+	if !ok1 || !ok2 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	timeout, err := parseTimeout(args[4])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	try := func(client *Client) bool {
+		client.propagateAs([]string{"lmove", src, dest, args[2], args[3]})
+		return lmove(client, src, dest, fromHead, toHead)
+	}
+
+	serveOrBlock(client, []string{src}, timeout, try, (*Client).WriteNil)
+}
+
+// LMPop pops elements from the first non empty list
+// LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func LMPop(client *Client, args []string) {
+	keys, head, count, err := parseMPopArgs(args)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	key, vals, err := mpop(client, keys, head, count)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if vals == nil {
+		client.propagateAs()
+		client.WriteNilArray()
+		return
+	}
+
+	client.propagateAs(popCommand(key, head, len(vals)))
+	writeMPopReply(client, key, vals)
+}
+
+// BLMPop pops elements from the first non empty list, blocking until one is available
+// BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func BLMPop(client *Client, args []string) {
+	timeout, err := parseTimeout(args[0])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	keys, head, count, err := parseMPopArgs(args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	try := func(client *Client) bool {
+		key, vals, err := mpop(client, keys, head, count)
+		if err != nil {
+			client.WriteError(err)
+			return true
+		}
+
+		if vals == nil {
+			return false
+		}
+
+		client.propagateAs(popCommand(key, head, len(vals)))
+		writeMPopReply(client, key, vals)
+		return true
+	}
+
+	serveOrBlock(client, keys, timeout, try, (*Client).WriteNilArray)
+}
+
+// parseMPopArgs parses numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func parseMPopArgs(args []string) (keys []string, head bool, count int, err error) {
+	numKeys, convErr := strconv.Atoi(args[0])
+	if convErr != nil || numKeys <= 0 {
+		return nil, false, 0, &protocol.ErrGeneric{Err: errNumKeys}
+	}
+
+	if len(args) < numKeys+2 {
+		return nil, false, 0, &protocol.ErrSyntax{}
+	}
+
+	keys = args[1 : numKeys+1]
+	head, ok := parseDirection(args[numKeys+1])
+	if !ok {
+		return nil, false, 0, &protocol.ErrSyntax{}
+	}
+
+	count = 1
+	switch opts := args[numKeys+2:]; {
+	case len(opts) == 0:
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "count":
+		count, convErr = strconv.Atoi(opts[1])
+		if convErr != nil || count <= 0 {
+			return nil, false, 0, &protocol.ErrGeneric{Err: errCountNotPositive}
+		}
+	default:
+		return nil, false, 0, &protocol.ErrSyntax{}
+	}
+
+	return keys, head, count, nil
+}
+
+// serveOrBlock serves the client right away when possible, otherwise the client is blocked on keys
+// Clients which are not allowed to block receive the reply of a timeout
+func serveOrBlock(client *Client, keys []string, timeout time.Duration, try func(*Client) bool, timeoutReply func(*Client)) {
+	if try(client) {
+		return
+	}
+
+	if !client.canBlock() {
+		client.propagateAs()
+		timeoutReply(client)
+		return
+	}
+
+	client.block(keys, timeout, try, timeoutReply)
+}
@@ -0,0 +1,186 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kasvith/kache/internal/protocol"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// isWrongType reports whether reply is a wrong type error
+func isWrongType(reply interface{}) bool {
+	err, ok := reply.(replyError)
+	return ok && strings.HasPrefix(string(err), protocol.PrefixWrongType)
+}
+
+func TestListsWrongType(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "s", "v")
+	c.do("rpush", "l", "a")
+
+	assert.True(isWrongType(c.do("lpush", "s", "a")))
+	assert.True(isWrongType(c.do("rpushx", "s", "a")))
+	assert.True(isWrongType(c.do("lpop", "s")))
+	assert.True(isWrongType(c.do("rpop", "s", "2")))
+	assert.True(isWrongType(c.do("llen", "s")))
+	assert.True(isWrongType(c.do("lrange", "s", "0", "-1")))
+	assert.True(isWrongType(c.do("lpos", "s", "a")))
+	assert.True(isWrongType(c.do("lmove", "s", "l", "left", "right")))
+
+	// the source is not modified when the destination has another type
+	assert.True(isWrongType(c.do("lmove", "l", "s", "left", "right")))
+	assert.Equal([]interface{}{"a"}, c.do("lrange", "l", "0", "-1"))
+	assert.Equal("v", c.do("get", "s"))
+}
+
+func TestListsRemoveEmpty(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("rpush", "l", "a", "b")
+	assert.Equal("a", c.do("lpop", "l"))
+	assert.Equal("b", c.do("rpop", "l"))
+	assert.Equal(0, c.do("exists", "l"))
+
+	c.do("rpush", "l", "a", "b")
+	assert.Equal([]interface{}{"a", "b"}, c.do("lpop", "l", "5"))
+	assert.Equal(0, c.do("exists", "l"))
+
+	c.do("rpush", "l", "a", "a")
+	assert.Equal(2, c.do("lrem", "l", "0", "a"))
+	assert.Equal(0, c.do("exists", "l"))
+
+	c.do("rpush", "l", "a", "b")
+	assert.Equal("OK", c.do("ltrim", "l", "5", "10"))
+	assert.Equal(0, c.do("exists", "l"))
+
+	c.do("rpush", "l", "a")
+	assert.Equal("a", c.do("lmove", "l", "other", "left", "left"))
+	assert.Equal(0, c.do("exists", "l"))
+	assert.Equal(1, c.do("exists", "other"))
+
+	// pushing to a missing key with PUSHX does not create it
+	assert.Equal(0, c.do("lpushx", "l", "a"))
+	assert.Equal(0, c.do("exists", "l"))
+}
+
+func TestLPos(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("rpush", "l", "a", "b", "c", "a", "b", "c", "a")
+
+	assert.Equal(0, c.do("lpos", "l", "a"))
+	assert.Equal(2, c.do("lpos", "l", "c"))
+	assert.Nil(c.do("lpos", "l", "x"))
+
+	assert.Equal(3, c.do("lpos", "l", "a", "rank", "2"))
+	assert.Nil(c.do("lpos", "l", "a", "rank", "4"))
+
+	// a negative rank searches from the tail
+	assert.Equal(6, c.do("lpos", "l", "a", "rank", "-1"))
+	assert.Equal(3, c.do("lpos", "l", "a", "rank", "-2"))
+	assert.Equal(5, c.do("lpos", "l", "c", "rank", "-1"))
+
+	// a count of 0 returns all matches
+	assert.Equal([]interface{}{0, 3, 6}, c.do("lpos", "l", "a", "count", "0"))
+	assert.Equal([]interface{}{0, 3}, c.do("lpos", "l", "a", "count", "2"))
+	assert.Equal([]interface{}{3, 6}, c.do("lpos", "l", "a", "rank", "2", "count", "0"))
+	assert.Equal([]interface{}{6, 3}, c.do("lpos", "l", "a", "rank", "-1", "count", "2"))
+	assert.Equal([]interface{}{}, c.do("lpos", "l", "x", "count", "0"))
+
+	// maxlen limits the compared elements from the starting end
+	assert.Equal([]interface{}{0}, c.do("lpos", "l", "a", "count", "0", "maxlen", "3"))
+	assert.Equal([]interface{}{6, 3}, c.do("lpos", "l", "a", "rank", "-1", "count", "0", "maxlen", "4"))
+	assert.Nil(c.do("lpos", "l", "c", "maxlen", "2"))
+	assert.Nil(c.do("lpos", "l", "c", "rank", "-1", "maxlen", "1"))
+	assert.Equal(5, c.do("lpos", "l", "c", "rank", "-1", "maxlen", "2"))
+
+	assert.Nil(c.do("lpos", "missing", "a"))
+	assert.Equal([]interface{}{}, c.do("lpos", "missing", "a", "count", "1"))
+
+	assert.IsType(replyError(""), c.do("lpos", "l", "a", "rank", "0"))
+	assert.IsType(replyError(""), c.do("lpos", "l", "a", "count", "-1"))
+	assert.IsType(replyError(""), c.do("lpos", "l", "a", "maxlen", "-1"))
+	assert.IsType(replyError(""), c.do("lpos", "l", "a", "rank"))
+	assert.IsType(replyError(""), c.do("lpos", "l", "a", "foo", "1"))
+}
+
+func TestLMoveSameKey(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("rpush", "l", "1", "2", "3")
+
+	// moving between the ends rotates the list
+	assert.Equal("1", c.do("lmove", "l", "l", "left", "right"))
+	assert.Equal([]interface{}{"2", "3", "1"}, c.do("lrange", "l", "0", "-1"))
+
+	assert.Equal("1", c.do("lmove", "l", "l", "right", "left"))
+	assert.Equal([]interface{}{"1", "2", "3"}, c.do("lrange", "l", "0", "-1"))
+
+	assert.Equal("3", c.do("lmove", "l", "l", "right", "right"))
+	assert.Equal([]interface{}{"1", "2", "3"}, c.do("lrange", "l", "0", "-1"))
+
+	// a single element list is kept
+	c.do("rpush", "single", "x")
+	assert.Equal("x", c.do("lmove", "single", "single", "left", "right"))
+	assert.Equal([]interface{}{"x"}, c.do("lrange", "single", "0", "-1"))
+
+	assert.Nil(c.do("lmove", "missing", "missing", "left", "right"))
+	assert.Equal(0, c.do("exists", "missing"))
+}
+
+func TestPopCountMissingKey(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Nil(c.do("lpop", "missing"))
+	assert.Nil(c.do("rpop", "missing"))
+	assert.Nil(c.do("lpop", "missing", "2"))
+	assert.Nil(c.do("rpop", "missing", "2"))
+	assert.Nil(c.do("lpop", "missing", "0"))
+	assert.Equal(0, c.do("exists", "missing"))
+
+	assert.IsType(replyError(""), c.do("lpop", "missing", "-1"))
+	assert.IsType(replyError(""), c.do("rpop", "missing", "x"))
+
+	c.do("rpush", "l", "a", "b", "c")
+	assert.Equal([]interface{}{"c", "b"}, c.do("rpop", "l", "2"))
+	assert.Equal([]interface{}{}, c.do("lpop", "l", "0"))
+	assert.Equal([]interface{}{"a"}, c.do("lrange", "l", "0", "-1"))
+}
@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	assert := testifyAssert.New(t)
+	src := newTestConn(t)
+	src.flushAll()
+	targetAddr := startTestProcess(t)
+	target := dialTest(t, targetAddr)
+	host, port, _ := net.SplitHostPort(targetAddr)
+
+	migrate := func(key, db string, opts ...string) interface{} {
+		return src.do(append([]string{"migrate", host, port, key, db, "5000"}, opts...)...)
+	}
+
+	// a single key is moved to the selected database of the target
+	src.do("rpush", "list", "a", "b")
+	assert.Equal("OK", migrate("list", "2"))
+	assert.Equal(0, src.do("exists", "list"))
+	target.do("select", "2")
+	assert.Equal([]interface{}{"a", "b"}, target.do("lrange", "list", "0", "-1"))
+	target.do("select", "0")
+
+	assert.Equal("NOKEY", migrate("missing", "0"))
+
+	// COPY keeps the source
+	src.do("set", "copied", "v")
+	assert.Equal("OK", migrate("copied", "0", "copy"))
+	assert.Equal("v", src.do("get", "copied"))
+	assert.Equal("v", target.do("get", "copied"))
+
+	// an existing key is only overwritten with REPLACE
+	src.do("set", "copied", "new")
+	assert.Contains(string(migrate("copied", "0", "copy").(replyError)), "BUSYKEY")
+	assert.Equal("v", target.do("get", "copied"))
+	assert.Equal("OK", migrate("copied", "0", "replace"))
+	assert.Equal("new", target.do("get", "copied"))
+	assert.Equal(0, src.do("exists", "copied"))
+
+	// KEYS moves several keys, missing ones are skipped
+	src.do("set", "k1", "1")
+	src.do("sadd", "k2", "m")
+	assert.Equal("OK", migrate("", "0", "keys", "k1", "missing", "k2"))
+	assert.Equal(0, src.do("exists", "k1"))
+	assert.Equal(0, src.do("exists", "k2"))
+	assert.Equal("1", target.do("get", "k1"))
+	assert.Equal([]interface{}{"m"}, target.do("smembers", "k2"))
+	assert.Equal(-1, target.do("pttl", "k1"))
+
+	// expirations are moved along with the keys
+	src.do("set", "expiring", "v", "px", "100000")
+	assert.Equal("OK", migrate("expiring", "0"))
+	ttl := target.do("pttl", "expiring").(int)
+	assert.True(ttl > 90000 && ttl <= 100000, "the key expires in %dms", ttl)
+}
+
+func TestRestoreTTL(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "k", "v", "px", "100000")
+	payload := c.do("dump", "k").(string)
+
+	// like redis, a ttl of 0 restores the key without an expiration even when it had one
+	assert.Equal("OK", c.do("restore", "copy", "0", payload))
+	assert.Equal("v", c.do("get", "copy"))
+	assert.Equal(-1, c.do("pttl", "copy"))
+
+	assert.Equal("OK", c.do("restore", "copy", "5000", payload, "replace"))
+	ttl := c.do("pttl", "copy").(int)
+	assert.True(ttl > 4000 && ttl <= 5000, "the key expires in %dms", ttl)
+
+	assert.Equal("OK", c.do("restore", "copy", "0", payload, "replace", "absttl"))
+	assert.Equal(-1, c.do("pttl", "copy"))
+
+	at := strconv.FormatInt(time.Now().Add(time.Minute).UnixMilli(), 10)
+	assert.Equal("OK", c.do("restore", "copy", at, payload, "replace", "absttl"))
+	ttl = c.do("pttl", "copy").(int)
+	assert.True(ttl > 50000 && ttl <= 60000, "the key expires in %dms", ttl)
+
+	// an expiration in the past only removes the replaced key
+	assert.Equal("OK", c.do("restore", "copy", "1", payload, "replace", "absttl"))
+	assert.Equal(0, c.do("exists", "copy"))
+
+	assert.Contains(string(c.do("restore", "k", "0", payload).(replyError)), "BUSYKEY")
+	assert.IsType(replyError(""), c.do("restore", "other", "-1", payload))
+	assert.IsType(replyError(""), c.do("restore", "other", "0", "garbage"))
+	assert.Equal(0, c.do("exists", "other"))
+}
+
+func TestMigrateTargetError(t *testing.T) {
+	assert := testifyAssert.New(t)
+	src := newTestConn(t)
+	src.flushAll()
+	targetAddr := startTestProcess(t)
+	target := dialTest(t, targetAddr)
+	host, port, _ := net.SplitHostPort(targetAddr)
+
+	src.do("set", "a", "1")
+	src.do("set", "b", "2")
+	src.do("set", "c", "3")
+	target.do("set", "b", "busy")
+
+	// the key failing in the middle of the transaction stays while the restored keys are removed
+	reply := src.do("migrate", host, port, "", "0", "5000", "keys", "a", "b", "c")
+	assert.Contains(string(reply.(replyError)), "BUSYKEY")
+
+	assert.Equal(0, src.do("exists", "a"))
+	assert.Equal(0, src.do("exists", "c"))
+	assert.Equal("2", src.do("get", "b"))
+	assert.Equal("1", target.do("get", "a"))
+	assert.Equal("busy", target.do("get", "b"))
+	assert.Equal("3", target.do("get", "c"))
+
+	// an unreachable target keeps every key
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, closedPort, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	assert.IsType(replyError(""), src.do("migrate", "127.0.0.1", closedPort, "b", "0", "1000"))
+	assert.Equal("2", src.do("get", "b"))
+}
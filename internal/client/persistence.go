@@ -0,0 +1,255 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/persistence/rdb"
+	"github.com/kasvith/kache/internal/protocol"
+)
+
+var errBgSaveInProgress = errors.New("background save already in progress")
+
+var (
+	// snapshotPath is the file where snapshots are saved
+	snapshotPath = "dump.kache"
+
+	// saveRules trigger automatic snapshots
+	saveRules []persistence.SaveRule
+
+	// dirty is the number of changes since the last successful save
+	dirty int64
+
+	// lastSave is the unix time of the last successful save
+	lastSave = time.Now().Unix()
+
+	// bgSaving is 1 while a background save is in progress
+	bgSaving int32
+
+	// lastBgSaveFailed is 1 when the last background save failed
+	lastBgSaveFailed int32
+
+	// saveMux serializes writes to the snapshot file
+	saveMux sync.Mutex
+)
+
+// InitSnapshots configures the snapshot file and the rules for automatic snapshots
+func InitSnapshots(path string, rules []persistence.SaveRule) {
+	snapshotPath = path
+	saveRules = rules
+}
+
+// LoadSnapshot loads the snapshot file into databases, a missing snapshot is not an error
+// This should be called before accepting clients
+func LoadSnapshot() error {
+	start := time.Now()
+	err := persistence.LoadSnapshotFile(snapshotPath, databases)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	klogs.Logger.Infof("snapshot loaded from %s in %v", snapshotPath, time.Since(start))
+	return nil
+}
+
+// ImportRDB loads a Redis RDB file into databases, imported keys count as changes so they are saved by the save rules
+// This should be called before accepting clients
+func ImportRDB(path string) error {
+	start := time.Now()
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := rdb.Read(f, databases); err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		atomic.AddInt64(&dirty, int64(database.Len()))
+	}
+
+	klogs.Logger.Infof("RDB file imported from %s in %v", path, time.Since(start))
+	return nil
+}
+
+// StartSnapshotter starts saving snapshots in background when a save rule matches
+func StartSnapshotter() {
+	if len(saveRules) == 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			if atomic.LoadInt32(&bgSaving) == 1 || !saveRuleMatches() {
+				continue
+			}
+
+			keyspaceMux.RLock()
+			err := bgSave()
+			keyspaceMux.RUnlock()
+
+			if err != nil {
+				klogs.Logger.Error("background save failed: ", err.Error())
+			}
+		}
+	}()
+}
+
+// saveRuleMatches reports whether any of the save rules triggers a snapshot
+func saveRuleMatches() bool {
+	changes := atomic.LoadInt64(&dirty)
+	since := time.Since(time.Unix(atomic.LoadInt64(&lastSave), 0))
+	for _, rule := range saveRules {
+		if rule.Matches(changes, since) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unshare copies the nodes of keys shared with a background save or rewrite before a command modifies them
+// Caller must hold the key space lock
+func unshare(database *db.DB, keys []string) {
+	for _, key := range keys {
+		if err := database.Unshare(key, persistence.Clone); err != nil {
+			klogs.Logger.Errorf("error copying %s for a background save: %s", key, err.Error())
+		}
+	}
+}
+
+// encodeSnapshot encodes dbs and returns the snapshot, dbs must not be modified meanwhile
+func encodeSnapshot(dbs []*db.DB) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := persistence.WriteSnapshot(&buf, dbs); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSnapshot writes an encoded snapshot to the snapshot file
+func writeSnapshot(data []byte, changes int64) error {
+	saveMux.Lock()
+	defer saveMux.Unlock()
+
+	err := persistence.WriteFileAtomic(snapshotPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&dirty, -changes)
+	atomic.StoreInt64(&lastSave, time.Now().Unix())
+	return nil
+}
+
+// bgSave forks the databases and saves them in background, caller must hold the key space lock
+// Commands keep running meanwhile since the fork is copied on write
+func bgSave() error {
+	if !atomic.CompareAndSwapInt32(&bgSaving, 0, 1) {
+		return errBgSaveInProgress
+	}
+
+	changes := atomic.LoadInt64(&dirty)
+	forks, release := db.Fork(databases)
+
+	go func() {
+		start := time.Now()
+		data, err := encodeSnapshot(forks)
+		release()
+
+		if err == nil {
+			err = writeSnapshot(data, changes)
+		}
+
+		if err != nil {
+			klogs.Logger.Error("background save failed: ", err.Error())
+			atomic.StoreInt32(&lastBgSaveFailed, 1)
+		} else {
+			klogs.Logger.Infof("background save finished in %v", time.Since(start))
+			atomic.StoreInt32(&lastBgSaveFailed, 0)
+		}
+
+		atomic.StoreInt32(&bgSaving, 0)
+	}()
+
+	return nil
+}
+
+// Save synchronously saves a snapshot of all databases
+func Save(client *Client, args []string) {
+	if atomic.LoadInt32(&bgSaving) == 1 {
+		client.WriteError(&protocol.ErrGeneric{Err: errBgSaveInProgress})
+		return
+	}
+
+	changes := atomic.LoadInt64(&dirty)
+	data, err := encodeSnapshot(databases)
+	if err == nil {
+		err = writeSnapshot(data, changes)
+	}
+
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteOK()
+}
+
+// BgSave saves a snapshot of all databases in background
+func BgSave(client *Client, args []string) {
+	if err := bgSave(); err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteSimpleString("Background saving started")
+}
+
+// LastSave returns the unix time of the last successful save
+func LastSave(client *Client, args []string) {
+	client.WriteInteger(int(atomic.LoadInt64(&lastSave)))
+}
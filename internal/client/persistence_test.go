@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persistence"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// dumpKeys serializes every key of database
+func dumpKeys(t *testing.T, database *db.DB) map[string]string {
+	dumps := make(map[string]string)
+	database.ForEach(func(key string, node *db.DataNode) bool {
+		payload, err := persistence.Dump(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dumps[key] = string(payload)
+		return true
+	})
+
+	return dumps
+}
+
+func TestForkCopyOnWrite(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c, blocked := newTestConn(t), newTestConn(t)
+	c.flushAll()
+
+	c.do("rpush", "list", "a", "b")
+	c.do("rpush", "dst", "x")
+	c.do("hset", "hash", "f", "v")
+	c.do("sadd", "set", "m")
+	c.do("zadd", "zset", "1", "m")
+	c.do("set", "str", "1")
+	c.do("set", "ttl", "v", "px", "100000")
+
+	keyspaceMux.RLock()
+	before := dumpKeys(t, databases[0])
+	forks, release := db.Fork(databases)
+	keyspaceMux.RUnlock()
+	defer release()
+
+	blocked.send("blmove", "src", "dst", "left", "right", "0")
+	eventually(t, func() bool { return c.info("blocked_clients") == "1" }, "the client is blocked")
+
+	c.do("rpush", "list", "c")
+	c.do("lset", "list", "0", "z")
+	c.do("hset", "hash", "f", "changed", "g", "v")
+	c.do("sadd", "set", "n")
+	c.do("zincrby", "zset", "5", "m")
+	c.do("incr", "str")
+	c.do("pexpire", "ttl", "5")
+	c.do("rpush", "src", "served")
+	assert.Equal("served", blocked.read())
+
+	c.do("multi")
+	c.do("srem", "set", "m")
+	c.do("rename", "hash", "renamed")
+	c.do("hdel", "renamed", "f")
+	c.do("exec")
+
+	assert.Equal([]interface{}{"x", "served"}, c.do("lrange", "dst", "0", "-1"))
+	assert.Equal(before, dumpKeys(t, forks[0]))
+}
+
+func TestDirtyChanges(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("set", "str", "v")
+
+	changes := func() int {
+		n, err := strconv.Atoi(c.info("rdb_changes_since_last_save"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		changes int
+	}{
+		{name: "write", args: []string{"set", "a", "1"}, changes: 1},
+		{name: "collection", args: []string{"rpush", "l", "1", "2", "3"}, changes: 1},
+		{name: "failed", args: []string{"incr", "str"}, changes: 0},
+		{name: "wrong type", args: []string{"sadd", "str", "m"}, changes: 0},
+		{name: "no op delete", args: []string{"del", "missing"}, changes: 0},
+		{name: "no op push", args: []string{"rpushx", "missing", "v"}, changes: 0},
+		{name: "no op add", args: []string{"sadd", "s", "m", "m"}, changes: 1},
+		{name: "existing member", args: []string{"sadd", "s", "m"}, changes: 0},
+		{name: "missing member", args: []string{"srem", "s", "x"}, changes: 0},
+		{name: "read", args: []string{"get", "a"}, changes: 0},
+	}
+
+	for _, tt := range tests {
+		start := changes()
+		c.do(tt.args...)
+		assert.Equal(tt.changes, changes()-start, tt.name)
+	}
+}
@@ -0,0 +1,429 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/resp/resp3"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+// errSubscribeNotAllowed is returned when a client without a connection subscribes, e.g. the raft applier
+var errSubscribeNotAllowed = errors.New("subscribing is not allowed in this context")
+
+// subscriberBufferLimit is the number of bytes queued for a subscriber after which it is disconnected
+const subscriberBufferLimit = 32 << 20
+
+var (
+	// channels holds the clients subscribed to a channel
+	channels = make(map[string]map[*Client]struct{})
+
+	// patterns holds the clients subscribed to a glob style pattern
+	patterns = make(map[string]map[*Client]struct{})
+
+	// pubsubMux guards channels and patterns
+	pubsubMux sync.RWMutex
+)
+
+// pubsubCommands are the commands RESP2 clients can send while they are subscribed
+var pubsubCommands = map[string]bool{
+	"subscribe": true, "unsubscribe": true, "psubscribe": true, "punsubscribe": true, "ping": true, "quit": true,
+}
+
+// subscriber writes the replies of a subscribed client and the messages published to it
+// Publishers only queue messages, so a slow subscriber never blocks them
+type subscriber struct {
+	client *Client
+
+	mux     sync.Mutex
+	queue   [][]byte
+	pending int
+	closed  bool
+
+	// resp3 is set when messages are sent as push frames, it is guarded by mux
+	resp3 bool
+
+	signal chan struct{}
+	done   chan struct{}
+}
+
+func newSubscriber(client *Client) *subscriber {
+	s := &subscriber{client: client, resp3: client.Protocol == RESP3, signal: make(chan struct{}, 1), done: make(chan struct{})}
+	go s.run()
+	return s
+}
+
+// push queues data, it returns false when the subscriber is too slow to keep up and was disconnected
+func (s *subscriber) push(data []byte, limited bool) bool {
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		return false
+	}
+
+	if limited && s.pending+len(data) > subscriberBufferLimit {
+		s.closed = true
+		s.mux.Unlock()
+
+		klogs.Logger.Warnf("disconnecting slow subscriber %s", s.client.RemoteAddr())
+		close(s.done)
+		s.client.Connection.Close()
+		return false
+	}
+
+	s.queue = append(s.queue, data)
+	s.pending += len(data)
+	s.mux.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// run writes queued data to the connection until the subscriber is closed
+func (s *subscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.signal:
+		}
+
+		s.mux.Lock()
+		queue := s.queue
+		s.queue, s.pending = nil, 0
+		s.mux.Unlock()
+
+		for _, data := range queue {
+			// nil is queued by QUIT to close the connection once the replies before it were written
+			if data == nil {
+				s.client.Flush()
+				s.client.Connection.Close()
+				return
+			}
+
+			s.client.Writer.Write(data)
+		}
+
+		if err := s.client.Flush(); err != nil {
+			s.client.Connection.Close()
+			return
+		}
+	}
+}
+
+func (s *subscriber) close() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// message encodes a published message for the subscriber
+func (s *subscriber) message(fields ...string) []byte {
+	s.mux.Lock()
+	push := s.resp3
+	s.mux.Unlock()
+
+	reps := make([]protocol.Reply, len(fields))
+	for i, field := range fields {
+		reps[i] = resp2.NewBulkStringReply(false, field)
+	}
+
+	if push {
+		return resp3.NewPushReply(reps).ToBytes()
+	}
+
+	return resp2.NewArrayReply(false, reps).ToBytes()
+}
+
+// pushReply queues data which was not requested by the client, RESP3 clients receive it as a push frame
+func (s *subscriber) pushReply(reply protocol.Reply) bool {
+	s.mux.Lock()
+	push := s.resp3
+	s.mux.Unlock()
+
+	if push {
+		reply = resp3.FromResp2(reply)
+	}
+
+	return s.push(reply.ToBytes(), true)
+}
+
+// pushFrame queues a push frame for a RESP3 client, RESP2 clients are sent nothing since they have no push type
+func (s *subscriber) pushFrame(reply protocol.Reply) bool {
+	s.mux.Lock()
+	push := s.resp3
+	s.mux.Unlock()
+
+	if !push {
+		return false
+	}
+
+	return s.push(resp3.FromResp2(reply).ToBytes(), true)
+}
+
+// subscriptions returns the number of channels and patterns the client is subscribed to
+func (client *Client) subscriptions() int {
+	return len(client.channels) + len(client.patterns)
+}
+
+// subscribe adds the client to the subscribers of a channel or a pattern and confirms it
+func (client *Client) subscribe(kind string, name string) {
+	if client.subscriber == nil {
+		client.subscriber = newSubscriber(client)
+	}
+	if client.channels == nil {
+		client.channels = make(map[string]struct{})
+		client.patterns = make(map[string]struct{})
+	}
+
+	own, registry := client.channels, channels
+	if kind == "psubscribe" {
+		own, registry = client.patterns, patterns
+	}
+
+	if _, ok := own[name]; !ok {
+		own[name] = struct{}{}
+
+		pubsubMux.Lock()
+		if registry[name] == nil {
+			registry[name] = make(map[*Client]struct{})
+		}
+		registry[name][client] = struct{}{}
+		pubsubMux.Unlock()
+	}
+
+	client.writePubSubReply(kind, name, client.subscriptions())
+}
+
+// unsubscribe removes the client from the subscribers of a channel or a pattern and confirms it
+func (client *Client) unsubscribe(kind string, name string) {
+	own, registry := client.channels, channels
+	if kind == "punsubscribe" {
+		own, registry = client.patterns, patterns
+	}
+
+	if _, ok := own[name]; ok {
+		delete(own, name)
+
+		pubsubMux.Lock()
+		delete(registry[name], client)
+		if len(registry[name]) == 0 {
+			delete(registry, name)
+		}
+		pubsubMux.Unlock()
+	}
+
+	client.writePubSubReply(kind, name, client.subscriptions())
+}
+
+// unsubscribeAll removes all subscriptions of a disconnected client
+func (client *Client) unsubscribeAll() {
+	if client.subscriber == nil {
+		return
+	}
+
+	pubsubMux.Lock()
+	for name := range client.channels {
+		delete(channels[name], client)
+		if len(channels[name]) == 0 {
+			delete(channels, name)
+		}
+	}
+	for pattern := range client.patterns {
+		delete(patterns[pattern], client)
+		if len(patterns[pattern]) == 0 {
+			delete(patterns, pattern)
+		}
+	}
+	pubsubMux.Unlock()
+
+	client.subscriber.close()
+}
+
+// writePubSubReply confirms a subscription change, name is sent as null when it is empty
+func (client *Client) writePubSubReply(kind, name string, count int) {
+	reps := []protocol.Reply{
+		resp2.NewBulkStringReply(false, kind),
+		resp2.NewBulkStringReply(name == "", name),
+		resp2.NewIntegerReply(count),
+	}
+
+	if client.Protocol == RESP3 {
+		client.WriteProtocolReply(resp3.NewPushReply(reps))
+		return
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, reps))
+}
+
+// publish delivers a message to the subscribers of channel and of patterns matching it
+// It returns the number of clients which received the message
+func publish(channel, message string) int {
+	pubsubMux.RLock()
+	defer pubsubMux.RUnlock()
+
+	receivers := 0
+	for client := range channels[channel] {
+		if client.subscriber.push(client.subscriber.message("message", channel, message), true) {
+			receivers++
+		}
+	}
+
+	for pattern, clients := range patterns {
+		if !util.GlobMatch(pattern, channel) {
+			continue
+		}
+
+		for client := range clients {
+			if client.subscriber.push(client.subscriber.message("pmessage", pattern, channel, message), true) {
+				receivers++
+			}
+		}
+	}
+
+	return receivers
+}
+
+// Subscribe subscribes the client to channels
+// SUBSCRIBE channel [channel ...]
+func Subscribe(client *Client, args []string) {
+	if client.Connection == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errSubscribeNotAllowed})
+		return
+	}
+
+	for _, channel := range args {
+		client.subscribe("subscribe", channel)
+	}
+}
+
+// PSubscribe subscribes the client to glob style patterns matching channels
+// PSUBSCRIBE pattern [pattern ...]
+func PSubscribe(client *Client, args []string) {
+	if client.Connection == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errSubscribeNotAllowed})
+		return
+	}
+
+	for _, pattern := range args {
+		client.subscribe("psubscribe", pattern)
+	}
+}
+
+// Unsubscribe unsubscribes the client from channels, all channels when none are given
+// UNSUBSCRIBE [channel [channel ...]]
+func Unsubscribe(client *Client, args []string) {
+	unsubscribeFrom(client, "unsubscribe", client.channels, args)
+}
+
+// PUnsubscribe unsubscribes the client from patterns, all patterns when none are given
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func PUnsubscribe(client *Client, args []string) {
+	unsubscribeFrom(client, "punsubscribe", client.patterns, args)
+}
+
+func unsubscribeFrom(client *Client, kind string, subscribed map[string]struct{}, args []string) {
+	if len(args) == 0 {
+		for name := range subscribed {
+			args = append(args, name)
+		}
+		sort.Strings(args)
+	}
+
+	if len(args) == 0 {
+		client.writePubSubReply(kind, "", client.subscriptions())
+		return
+	}
+
+	for _, name := range args {
+		client.unsubscribe(kind, name)
+	}
+}
+
+// Publish sends a message to the subscribers of a channel
+// PUBLISH channel message
+func Publish(client *Client, args []string) {
+	client.WriteInteger(publish(args[0], args[1]))
+}
+
+// PubSub inspects the pub/sub subsystem
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel [channel ...]] | NUMPAT
+func PubSub(client *Client, args []string) {
+	pubsubMux.RLock()
+	defer pubsubMux.RUnlock()
+
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "channels" && len(args) <= 2:
+		names := make([]string, 0)
+		for name := range channels {
+			if len(args) == 1 || util.GlobMatch(args[1], name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		client.WriteStringArray(names)
+	case sub == "numsub":
+		reps := make([]protocol.Reply, 0, 2*(len(args)-1))
+		for _, name := range args[1:] {
+			reps = append(reps, resp2.NewBulkStringReply(false, name), resp2.NewIntegerReply(len(channels[name])))
+		}
+		client.WriteProtocolReply(mapReply(client, reps))
+	case sub == "numpat" && len(args) == 1:
+		client.WriteInteger(len(patterns))
+	default:
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])})
+	}
+}
+
+// Quit replies OK and closes the connection
+func Quit(client *Client, args []string) {
+	client.WriteOK()
+	if client.subscriber != nil {
+		client.subscriber.push(nil, false)
+		return
+	}
+
+	client.flushReply()
+	if client.Connection != nil {
+		client.Connection.Close()
+	}
+}
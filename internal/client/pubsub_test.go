@@ -0,0 +1,178 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kasvith/kache/internal/resp/resp3"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// readFrame waits for the next reply and returns its type along with its value
+func (c *testConn) readFrame() (byte, interface{}) {
+	c.t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(testReplyTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	reply, err := c.parser.Parse()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return reply.Type, replyValue(reply)
+}
+
+func TestSubscribe(t *testing.T) {
+	assert := testifyAssert.New(t)
+	sub := newTestConn(t)
+	pub := newTestConn(t)
+
+	sub.send("subscribe", "news", "sport")
+	assert.Equal([]interface{}{"subscribe", "news", 1}, sub.read())
+	assert.Equal([]interface{}{"subscribe", "sport", 2}, sub.read())
+	sub.send("psubscribe", "new*")
+	assert.Equal([]interface{}{"psubscribe", "new*", 3}, sub.read())
+
+	// a message matching both the channel and the pattern is delivered twice
+	assert.Equal(2, pub.do("publish", "news", "hello"))
+	assert.Equal([]interface{}{"message", "news", "hello"}, sub.read())
+	assert.Equal([]interface{}{"pmessage", "new*", "news", "hello"}, sub.read())
+
+	assert.Equal(1, pub.do("publish", "newer", "pattern"))
+	assert.Equal([]interface{}{"pmessage", "new*", "newer", "pattern"}, sub.read())
+	assert.Equal(0, pub.do("publish", "other", "nobody"))
+
+	// only subscription commands are allowed
+	assert.Contains(string(sub.do("get", "k").(replyError)), "only (P)SUBSCRIBE")
+	assert.Equal([]interface{}{"pong", ""}, sub.do("ping"))
+
+	sub.send("unsubscribe")
+	assert.Equal([]interface{}{"unsubscribe", "news", 2}, sub.read())
+	assert.Equal([]interface{}{"unsubscribe", "sport", 1}, sub.read())
+	assert.Equal([]interface{}{"punsubscribe", "new*", 0}, sub.do("punsubscribe"))
+	assert.Equal([]interface{}{"punsubscribe", nil, 0}, sub.do("punsubscribe"))
+
+	// the connection is usable again once all subscriptions are gone
+	assert.Equal(0, pub.do("publish", "news", "gone"))
+	assert.Equal("PONG", sub.do("ping"))
+}
+
+func TestSubscribeResp3(t *testing.T) {
+	assert := testifyAssert.New(t)
+	sub := newTestConn(t)
+	pub := newTestConn(t)
+	sub.do("hello", "3")
+
+	sub.send("subscribe", "resp3")
+	kind, reply := sub.readFrame()
+	assert.Equal(byte(resp3.Resp3Push), kind)
+	assert.Equal([]interface{}{"subscribe", "resp3", 1}, reply)
+
+	pub.do("publish", "resp3", "pushed")
+	kind, reply = sub.readFrame()
+	assert.Equal(byte(resp3.Resp3Push), kind)
+	assert.Equal([]interface{}{"message", "resp3", "pushed"}, reply)
+
+	// RESP3 connections keep running commands while they are subscribed
+	sub.send("set", "k", "v")
+	kind, reply = sub.readFrame()
+	assert.Equal(byte(resp3.Resp3SimpleString), kind)
+	assert.Equal("OK", reply)
+
+	sub.send("unsubscribe", "resp3")
+	kind, reply = sub.readFrame()
+	assert.Equal(byte(resp3.Resp3Push), kind)
+	assert.Equal([]interface{}{"unsubscribe", "resp3", 0}, reply)
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	first := newTestConn(t)
+	second := newTestConn(t)
+	numpat := c.do("pubsub", "numpat").(int)
+
+	first.send("subscribe", "intro.a", "intro.b")
+	first.read()
+	first.read()
+	second.send("subscribe", "intro.a")
+	second.read()
+	second.send("psubscribe", "intro.*", "other.*")
+	second.read()
+	second.read()
+
+	assert.Equal([]interface{}{"intro.a", "intro.b"}, c.do("pubsub", "channels", "intro.*"))
+	assert.Equal([]interface{}{"intro.a", 2, "intro.b", 1, "intro.c", 0}, c.do("pubsub", "numsub", "intro.a", "intro.b", "intro.c"))
+	assert.Equal(numpat+2, c.do("pubsub", "numpat"))
+
+	// patterns are counted once however many clients subscribe to them
+	first.send("psubscribe", "intro.*")
+	first.read()
+	assert.Equal(numpat+2, c.do("pubsub", "numpat"))
+
+	// subscriptions of disconnected clients are removed
+	second.conn.Close()
+	eventually(t, func() bool {
+		return c.do("pubsub", "numpat") == numpat+1
+	}, "the subscriptions of the closed connection are removed")
+	assert.Equal([]interface{}{"intro.a", 1}, c.do("pubsub", "numsub", "intro.a"))
+
+	assert.IsType(replyError(""), c.do("pubsub", "numpat", "extra"))
+	assert.IsType(replyError(""), c.do("pubsub", "unknown"))
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	assert := testifyAssert.New(t)
+	sub := newTestConn(t)
+	pub := newTestConn(t)
+
+	sub.send("subscribe", "slow")
+	assert.Equal([]interface{}{"subscribe", "slow", 1}, sub.read())
+
+	// the subscriber never reads, so messages pile up until the buffer limit is exceeded
+	message := strings.Repeat("x", 1<<20)
+	published := 0
+	for pub.do("publish", "slow", message) == 1 {
+		published++
+		if published > 4*subscriberBufferLimit/len(message) {
+			t.Fatalf("the subscriber was not disconnected after %d messages", published)
+		}
+	}
+
+	assert.True(published >= subscriberBufferLimit/len(message), "disconnected after "+strconv.Itoa(published)+" messages")
+	eventually(t, func() bool {
+		return pub.do("pubsub", "numsub", "slow").([]interface{})[1] == 0
+	}, "the slow subscriber is removed")
+
+	// other clients are not affected
+	assert.Equal("PONG", pub.do("ping"))
+}
@@ -0,0 +1,486 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/raft"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/sys"
+)
+
+var (
+	errRaftDisabled      = errors.New("This instance has raft support disabled")
+	errRaftInTransaction = errors.New("RAFT is not allowed in transactions")
+)
+
+var (
+	// raftNode replicates commands modifying the key space when raft mode is enabled, nil otherwise
+	raftNode *raft.Node
+
+	// raftConf configures raft mode
+	raftConf raft.Config
+
+	// raftWriteMux is read locked while proposing commands, transactions which watch keys lock it
+	// so no other proposal of this node is in flight while they check their keys
+	raftWriteMux sync.RWMutex
+
+	// raftPeers are the connections used to send requests to other members by address
+	raftPeers    = make(map[string]*raftPeer)
+	raftPeersMux sync.Mutex
+)
+
+// InitRaft enables raft mode, the data set is restored from the snapshot and the log of the node
+func InitRaft(conf raft.Config) error {
+	raftConf = conf.WithDefaults()
+	raftConf.Logf = klogs.Logger.Infof
+
+	fsm := &raftFSM{}
+	fsm.client = &Client{Protocol: RESP2, Writer: bufio.NewWriter(&fsm.reply), Database: databases[0], denyBlocking: true}
+
+	node, err := raft.NewNode(raftConf, fsm, raftTransport{})
+	if err != nil {
+		return err
+	}
+
+	raftNode = node
+	return nil
+}
+
+// StartRaft starts taking part in the raft group
+func StartRaft() {
+	if raftNode != nil {
+		raftNode.Start()
+	}
+}
+
+// raftClock starts an entry with the time it was proposed at, it is not a command
+const raftClock = "now"
+
+// raftFSM applies committed commands to the databases
+// Every member executes the commands itself, so commands which are not deterministic are refused in raft mode
+type raftFSM struct {
+	// client executes the commands, blocking commands reply as if they timed out
+	client *Client
+
+	// reply buffers the reply of the executed commands
+	reply bytes.Buffer
+}
+
+// Apply executes the commands of an entry, it returns the reply of the last command
+func (fsm *raftFSM) Apply(index uint64, data []byte) interface{} {
+	parser := resp2.NewParser(bufio.NewReader(bytes.NewReader(data)))
+
+	var cmds []*protocol.Command
+	for {
+		cmd, err := parser.Parse()
+		if err != nil {
+			break
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	// commands which depend on the time use the clock of the proposing member
+	fsm.client.clock = 0
+	if len(cmds) > 0 && cmds[0].Name == raftClock && len(cmds[0].Args) == 1 {
+		fsm.client.clock, _ = strconv.ParseInt(cmds[0].Args[0], 10, 64)
+		cmds = cmds[1:]
+	}
+
+	for i, cmd := range cmds {
+		// only the reply of the last command is sent to the client
+		if i == len(cmds)-1 {
+			fsm.reply.Reset()
+		}
+
+		Execute(fsm.client, cmd.Name, cmd.Args)
+	}
+
+	reply := append([]byte{}, fsm.reply.Bytes()...)
+	fsm.reply.Reset()
+	return reply
+}
+
+// Snapshot encodes all databases
+func (fsm *raftFSM) Snapshot() ([]byte, error) {
+	keyspaceMux.RLock()
+	defer keyspaceMux.RUnlock()
+
+	var buf bytes.Buffer
+	if err := persistence.WriteSnapshot(&buf, databases); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Restore replaces all databases with a snapshot
+func (fsm *raftFSM) Restore(data []byte) error {
+	keyspaceMux.Lock()
+	defer keyspaceMux.Unlock()
+
+	for _, database := range databases {
+		database.Flush()
+	}
+	touchAllWatched()
+
+	if err := persistence.ReadSnapshot(bytes.NewReader(data), databases); err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		atomic.AddInt64(&dirty, int64(database.Len()))
+	}
+
+	// the append only file must reflect the new data set
+	if aof != nil {
+		if err := RewriteAOF(); err != nil {
+			klogs.Logger.Error("error rewriting append only file after restoring a raft snapshot: ", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// proposeToRaft replicates a command modifying the key space through the raft log and replies with its result
+// once it was applied, it returns false when the command should be executed locally
+func proposeToRaft(client *Client, command *Command, args []string) bool {
+	if raftNode == nil || !command.ModifyKeySpace || client.Connection == nil || command.Name == "multi" {
+		return false
+	}
+
+	// commands are applied to the database which is selected by the client with the clock of this member
+	cmds := [][]string{{raftClock, strconv.FormatInt(sys.NowMillis(), 10)}, {"select", strconv.Itoa(client.DatabaseIndex)}}
+	watching := false
+	if command.Name == "exec" {
+		// transactions which are aborted or were not started are answered locally
+		if !client.Multi || client.MultiError {
+			return false
+		}
+
+		cmds = append(cmds, []string{"multi"})
+		for _, cmd := range client.Commands {
+			cmds = append(cmds, append([]string{cmd.Name}, cmd.Args...))
+		}
+		cmds = append(cmds, []string{"exec"})
+
+		client.Multi = false
+		client.Commands = []*Command{}
+		watching = len(client.watched) > 0
+	} else {
+		cmds = append(cmds, append([]string{command.Name}, args...))
+	}
+
+	var data []byte
+	for _, cmd := range cmds {
+		data = persistence.AppendCommand(data, cmd)
+	}
+
+	if watching {
+		defer client.unwatch()
+
+		raftWriteMux.Lock()
+		defer raftWriteMux.Unlock()
+
+		// once every earlier entry is applied the watched keys show all changes made before the transaction
+		if err := raftNode.Barrier(); err != nil {
+			client.WriteError(raftError(err))
+			return true
+		}
+
+		keyspaceMux.RLock()
+		aborted := client.watchFailed()
+		keyspaceMux.RUnlock()
+
+		if aborted {
+			client.WriteNilArray()
+			return true
+		}
+	} else {
+		raftWriteMux.RLock()
+		defer raftWriteMux.RUnlock()
+	}
+
+	reply, err := raftNode.Propose(data)
+	if err != nil {
+		client.WriteError(raftError(err))
+		return true
+	}
+
+	client.WriteProtocolReply(rawReply(reply.([]byte)))
+	return true
+}
+
+// raftError converts an error of the raft node to the error sent to clients
+func raftError(err error) error {
+	switch err {
+	case raft.ErrNotLeader:
+		if leader := raftNode.Leader(); leader != "" {
+			return protocol.ErrNotLeader{Addr: leader}
+		}
+		return protocol.ErrNoLeader{}
+	case raft.ErrLeadershipLost:
+		return &protocol.ErrGeneric{Err: fmt.Errorf("%s, the command may or may not be applied", err)}
+	}
+
+	return &protocol.ErrGeneric{Err: err}
+}
+
+// notInRaft returns the error of commands which are not allowed in raft mode
+func notInRaft(cmd string) error {
+	return &protocol.ErrGeneric{Err: fmt.Errorf("%s is not allowed in raft mode", cmd)}
+}
+
+// raftTransport sends raft requests to other members as RAFT commands
+type raftTransport struct{}
+
+func (raftTransport) RequestVote(addr string, req *raft.VoteRequest) (*raft.VoteResponse, error) {
+	resp := &raft.VoteResponse{}
+	return resp, raftCall(addr, "vote", req, resp, raftConf.ElectionTimeout)
+}
+
+func (raftTransport) AppendEntries(addr string, req *raft.AppendRequest) (*raft.AppendResponse, error) {
+	resp := &raft.AppendResponse{}
+	return resp, raftCall(addr, "append", req, resp, raftConf.ElectionTimeout)
+}
+
+func (raftTransport) InstallSnapshot(addr string, req *raft.SnapshotRequest) (*raft.SnapshotResponse, error) {
+	// snapshots hold the whole data set
+	resp := &raft.SnapshotResponse{}
+	return resp, raftCall(addr, "installsnapshot", req, resp, 10*raftConf.ElectionTimeout)
+}
+
+// raftPeer is a connection to another member, requests to a member are sent one at a time
+type raftPeer struct {
+	mux    sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// raftCall sends a request to a member and decodes its reply into resp
+func raftCall(addr, op string, req encoding.BinaryMarshaler, resp encoding.BinaryUnmarshaler, timeout time.Duration) error {
+	raftPeersMux.Lock()
+	p, ok := raftPeers[addr]
+	if !ok {
+		p = &raftPeer{}
+		raftPeers[addr] = p
+	}
+	raftPeersMux.Unlock()
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	payload, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	reply, err := p.send(addr, timeout, "raft", op, string(payload))
+	if err != nil {
+		p.close()
+		return err
+	}
+
+	return resp.UnmarshalBinary(reply)
+}
+
+// send sends a command and reads a bulk string reply, caller must hold the lock of the peer
+func (p *raftPeer) send(addr string, timeout time.Duration, args ...string) ([]byte, error) {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		p.conn, p.reader = conn, bufio.NewReader(conn)
+	}
+
+	if err := p.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := p.conn.Write(persistence.AppendCommand(nil, args)); err != nil {
+		return nil, err
+	}
+
+	line, err := readLine(p.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if line[0] != resp2.TypeBulkString {
+		return nil, fmt.Errorf("member %s replied to RAFT %s with %s", addr, strings.ToUpper(args[1]), line)
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("invalid reply length %s", line)
+	}
+
+	buf := make([]byte, size+len(resp2.CRLF))
+	if _, err := io.ReadFull(p.reader, buf); err != nil {
+		return nil, err
+	}
+
+	return buf[:size], nil
+}
+
+func (p *raftPeer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.reader = nil, nil
+	}
+}
+
+// RaftCmd inspects and changes the raft group, members send their requests to each other with it as well
+// RAFT INFO | LEADER | ADDNODE host:port | REMOVENODE host:port | SNAPSHOT |
+// VOTE request | APPEND request | INSTALLSNAPSHOT request
+func RaftCmd(client *Client, args []string) {
+	switch {
+	case raftNode == nil:
+		client.WriteError(&protocol.ErrGeneric{Err: errRaftDisabled})
+		return
+	case client.Connection == nil:
+		// transactions are applied by every member
+		client.WriteError(&protocol.ErrGeneric{Err: errRaftInTransaction})
+		return
+	}
+
+	var err error
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "info" && len(args) == 1:
+		raftInfo(client)
+	case sub == "leader" && len(args) == 1:
+		if leader := raftNode.Leader(); leader != "" {
+			client.WriteBulkString(leader)
+		} else {
+			client.WriteNil()
+		}
+	case sub == "addnode" && len(args) == 2:
+		if err = raftNode.AddMember(args[1]); err == nil {
+			client.WriteOK()
+		}
+	case sub == "removenode" && len(args) == 2:
+		if err = raftNode.RemoveMember(args[1]); err == nil {
+			client.WriteOK()
+		}
+	case sub == "snapshot" && len(args) == 1:
+		if err = raftNode.Snapshot(); err == nil {
+			client.WriteOK()
+		}
+	case sub == "vote" && len(args) == 2:
+		req := &raft.VoteRequest{}
+		if err = req.UnmarshalBinary([]byte(args[1])); err == nil {
+			err = raftReply(client, func() (encoding.BinaryMarshaler, error) { return raftNode.RequestVote(req) })
+		}
+	case sub == "append" && len(args) == 2:
+		req := &raft.AppendRequest{}
+		if err = req.UnmarshalBinary([]byte(args[1])); err == nil {
+			err = raftReply(client, func() (encoding.BinaryMarshaler, error) { return raftNode.AppendEntries(req) })
+		}
+	case sub == "installsnapshot" && len(args) == 2:
+		req := &raft.SnapshotRequest{}
+		if err = req.UnmarshalBinary([]byte(args[1])); err == nil {
+			err = raftReply(client, func() (encoding.BinaryMarshaler, error) { return raftNode.InstallSnapshot(req) })
+		}
+	default:
+		err = fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])
+	}
+
+	if err != nil {
+		client.WriteError(raftError(err))
+	}
+}
+
+// raftReply handles a request of another member and replies with the encoded response
+func raftReply(client *Client, handle func() (encoding.BinaryMarshaler, error)) error {
+	resp, err := handle()
+	if err != nil {
+		return err
+	}
+
+	data, err := resp.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	client.WriteBulkString(string(data))
+	return nil
+}
+
+func raftInfo(client *Client) {
+	status := raftNode.Status()
+
+	var b strings.Builder
+	fields := []infoField{
+		{"raft_role", status.Role},
+		{"raft_term", status.Term},
+		{"raft_leader", status.Leader},
+		{"raft_members", strings.Join(status.Members, ",")},
+		{"raft_commit_index", status.CommitIndex},
+		{"raft_applied_index", status.AppliedIndex},
+		{"raft_last_log_index", status.LastLogIndex},
+		{"raft_snapshot_index", status.SnapshotIndex},
+		{"raft_snapshot_term", status.SnapshotTerm},
+	}
+	for _, field := range fields {
+		fmt.Fprintf(&b, "%s:%v\r\n", field.name, field.value)
+	}
+
+	client.WriteBulkString(b.String())
+}
+
+func raftInfoSection() []infoField {
+	fields := []infoField{
+		{"raft_enabled", boolToInt(raftNode != nil)},
+	}
+
+	if raftNode != nil {
+		status := raftNode.Status()
+		fields = append(fields,
+			infoField{"raft_role", status.Role},
+			infoField{"raft_leader", status.Leader},
+			infoField{"raft_term", status.Term},
+			infoField{"raft_commit_index", status.CommitIndex},
+		)
+	}
+
+	return fields
+}
@@ -60,6 +60,20 @@ func (cr *Registry) Remove(remoteAddr string) {
 	klogs.Logger.Debug("Disconnected:", remoteAddr)
 }
 
+// Get finds a connected client by its ID
+func (cr *Registry) Get(id int64) (*Client, bool) {
+	cr.mux.RLock()
+	defer cr.mux.RUnlock()
+
+	for _, c := range cr.clients {
+		if c.ID == id {
+			return c, true
+		}
+	}
+
+	return nil, false
+}
+
 // Count of the connected clients
 func (cr *Registry) Count() (num int) {
 	cr.mux.RLock()
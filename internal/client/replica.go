@@ -0,0 +1,386 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/replication"
+	"github.com/kasvith/kache/internal/resp/resp2"
+)
+
+const (
+	// linkConnect waits before connecting to the master
+	linkConnect = "connect"
+
+	// linkConnecting is connecting and handshaking with the master
+	linkConnecting = "connecting"
+
+	// linkSync is waiting for the master to start the stream
+	linkSync = "sync"
+
+	// linkConnected applies the stream of the master
+	linkConnected = "connected"
+)
+
+var errLinkStopped = errors.New("replication link stopped")
+
+// applyCommand executes commands of the replication stream
+// It is assigned in init since the command table refers to the replication commands
+var applyCommand func(client *Client, cmd string, args []string)
+
+func init() {
+	applyCommand = Execute
+}
+
+// masterLink replicates the data set of a master
+type masterLink struct {
+	// lastIO is the unix time of the last read from the master
+	lastIO int64
+
+	host string
+	port int
+
+	// fields below are guarded by replMux
+	state     string
+	conn      net.Conn
+	downSince time.Time
+	stopped   bool
+
+	// client applies the stream of the master
+	client *Client
+}
+
+func newMasterLink(host string, port int) *masterLink {
+	return &masterLink{host: host, port: port, state: linkConnect, downSince: time.Now()}
+}
+
+func (l *masterLink) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+// stopLocked closes the link, caller must hold replMux
+func (l *masterLink) stopLocked() {
+	l.stopped = true
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+// run replicates from the master reconnecting after failures until the link is stopped
+func (l *masterLink) run() {
+	for {
+		err := l.session()
+
+		replMux.Lock()
+		if l.stopped {
+			replMux.Unlock()
+			return
+		}
+
+		if l.state == linkConnected {
+			l.downSince = time.Now()
+		}
+		l.state, l.conn, l.client = linkConnect, nil, nil
+		replMux.Unlock()
+
+		klogs.Logger.Warnf("replication link with master %s failed: %s", l.addr(), err)
+		time.Sleep(time.Second)
+	}
+}
+
+// session connects to the master, syncs with it and applies its stream until the connection breaks
+func (l *masterLink) session() error {
+	replMux.Lock()
+	l.state = linkConnecting
+	replMux.Unlock()
+
+	conn, err := net.DialTimeout("tcp", l.addr(), replConf.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	replMux.Lock()
+	if l.stopped {
+		replMux.Unlock()
+		return errLinkStopped
+	}
+	l.conn = conn
+	replMux.Unlock()
+
+	reader := bufio.NewReader(&linkReader{link: l, conn: conn})
+
+	if _, err := l.request(conn, reader, "ping"); err != nil {
+		return err
+	}
+
+	if _, err := l.request(conn, reader, "replconf", "listening-port", strconv.Itoa(replConf.ListeningPort)); err != nil {
+		return err
+	}
+
+	if _, err := l.request(conn, reader, "replconf", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	replMux.Lock()
+	id, offset := replID, replOffset
+	l.state = linkSync
+	replMux.Unlock()
+
+	// the master continues from the first byte we are missing when it shares our history
+	reply, err := l.request(conn, reader, "psync", id, strconv.FormatInt(offset+1, 10))
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) >= 3 && fields[0] == "FULLRESYNC":
+		err = l.fullSync(reader, fields[1:])
+	case len(fields) >= 1 && fields[0] == "CONTINUE":
+		err = l.partialSync(fields[1:])
+	default:
+		err = fmt.Errorf("unexpected reply to PSYNC: %s", reply)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	go l.ack(conn)
+	return l.stream(reader)
+}
+
+// request sends a command to the master and reads a single line reply
+func (l *masterLink) request(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	if _, err := conn.Write(persistence.AppendCommand(nil, args)); err != nil {
+		return "", err
+	}
+
+	line, err := readLine(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if line[0] == resp2.TypeError {
+		return "", fmt.Errorf("master replied to %s with %s", args[0], line[1:])
+	}
+
+	return line[1:], nil
+}
+
+// readLine reads a line without its line ending, empty lines sent by masters as keep alives are skipped
+func readLine(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		if line = strings.TrimRight(line, resp2.CRLF); line != "" {
+			return line, nil
+		}
+	}
+}
+
+// fullSync replaces the data set with the snapshot sent by the master
+// fields are the replication ID, the offset and the database selected in the stream
+func (l *masterLink) fullSync(reader *bufio.Reader, fields []string) error {
+	offset, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid FULLRESYNC offset %s", fields[1])
+	}
+
+	db := 0
+	if len(fields) > 2 {
+		if db, err = strconv.Atoi(fields[2]); err != nil || db < 0 || db >= len(databases) {
+			return fmt.Errorf("invalid FULLRESYNC database %s", fields[2])
+		}
+	}
+
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if line[0] != resp2.TypeBulkString || err != nil || size < 0 {
+		return fmt.Errorf("invalid snapshot length %s", line)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	keyspaceMux.Lock()
+	defer keyspaceMux.Unlock()
+
+	for _, database := range databases {
+		database.Flush()
+	}
+	touchAllWatched()
+
+	if err := persistence.ReadSnapshot(bytes.NewReader(data), databases); err != nil {
+		return err
+	}
+
+	for _, database := range databases {
+		atomic.AddInt64(&dirty, int64(database.Len()))
+	}
+
+	// the append only file must reflect the new data set
+	if aof != nil {
+		if err := RewriteAOF(); err != nil {
+			klogs.Logger.Error("error rewriting append only file after a full resync: ", err.Error())
+		}
+	}
+
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	replID, replID2, secondReplOffset = fields[0], strings.Repeat("0", replication.IDLength), -1
+	replOffset = offset
+	backlog = replication.NewBacklog(replConf.BacklogSize, offset)
+
+	// our replicas hold the old data set
+	disconnectReplicasLocked()
+
+	klogs.Logger.Infof("full resync with master %s finished in %v", l.addr(), time.Since(start))
+	return l.startStreamLocked(db)
+}
+
+// partialSync continues the stream from our offset, fields may hold a new replication ID of the master
+func (l *masterLink) partialSync(fields []string) error {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if len(fields) > 0 && fields[0] != replID {
+		// the master was promoted and continues our history with a new ID
+		replID2, secondReplOffset = replID, replOffset+1
+		replID = fields[0]
+		disconnectReplicasLocked()
+	}
+
+	if backlog == nil {
+		backlog = replication.NewBacklog(replConf.BacklogSize, replOffset)
+	}
+
+	db := streamDB
+	if db < 0 {
+		db = 0
+	}
+
+	klogs.Logger.Infof("partial resync with master %s from offset %d", l.addr(), replOffset)
+	return l.startStreamLocked(db)
+}
+
+// startStreamLocked prepares applying the stream with db selected, caller must hold replMux
+func (l *masterLink) startStreamLocked(db int) error {
+	if l.stopped {
+		return errLinkStopped
+	}
+
+	streamDB = db
+	l.state = linkConnected
+	l.client = &Client{Protocol: RESP2, Writer: bufio.NewWriter(io.Discard), Database: databases[db], DatabaseIndex: db, master: true}
+	return nil
+}
+
+// stream applies the commands sent by the master
+func (l *masterLink) stream(reader *bufio.Reader) error {
+	client := l.client
+	parser := resp2.NewParser(reader)
+
+	for {
+		cmd, err := parser.Parse()
+		if err != nil {
+			return err
+		}
+
+		if cmd == nil || cmd.Name == "" {
+			continue
+		}
+
+		client.pendingStream = persistence.AppendCommand(client.pendingStream, append([]string{cmd.Name}, cmd.Args...))
+		applyCommand(client, cmd.Name, cmd.Args)
+
+		// commands which did not run are forwarded too so offsets stay in sync
+		if !client.Multi && len(client.pendingStream) > 0 {
+			replicate(client, nil)
+		}
+	}
+}
+
+// ack acknowledges the applied offset to the master every second until the connection is replaced
+func (l *masterLink) ack(conn net.Conn) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		replMux.Lock()
+		offset, current := replOffset, l.conn == conn
+		replMux.Unlock()
+
+		if !current {
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(replConf.Timeout))
+		if _, err := conn.Write(persistence.AppendCommand(nil, []string{"replconf", "ack", strconv.FormatInt(offset, 10)})); err != nil {
+			return
+		}
+	}
+}
+
+// linkReader reads from the master, a master which is silent for longer than the timeout breaks the link
+type linkReader struct {
+	link *masterLink
+	conn net.Conn
+}
+
+func (r *linkReader) Read(p []byte) (int, error) {
+	r.conn.SetReadDeadline(time.Now().Add(replConf.Timeout))
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&r.link.lastIO, time.Now().Unix())
+	}
+
+	return n, err
+}
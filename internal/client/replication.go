@@ -0,0 +1,568 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/replication"
+	"github.com/kasvith/kache/internal/resp/resp2"
+)
+
+// replicaOutputLimit is the size of the stream pending for a replica after which it is disconnected
+const replicaOutputLimit = 256 * 1024 * 1024
+
+var (
+	errMasterNotConnected = errors.New("can't SYNC while not connected with my master")
+	errInvalidMasterPort  = errors.New("invalid master port")
+)
+
+var (
+	// replConf configures replication
+	replConf = replication.Config{ReadOnly: true}.WithDefaults()
+
+	// replID identifies the history of the data set, replID2 is the previous history which is still accepted for
+	// partial resyncs up to secondReplOffset after a replica was promoted
+	replID                 = replication.NewID()
+	replID2                = strings.Repeat("0", replication.IDLength)
+	secondReplOffset int64 = -1
+
+	// replOffset is the offset of the replication stream the data set reflects
+	replOffset int64
+
+	// backlog holds the recent replication stream, nil until the first replica connects
+	backlog *replication.Backlog
+
+	// streamDB is the database selected in the replication stream, -1 when the next command must select one
+	streamDB = -1
+
+	// replicas are the connected replicas
+	replicas []*replica
+
+	// syncFull and syncPartialOK count the full and the partial resyncs served to replicas
+	syncFull, syncPartialOK int64
+
+	// link is the connection to our master, nil when running as a master
+	link *masterLink
+
+	// readOnlyReplica is 1 while writes of clients are rejected
+	readOnlyReplica int32
+
+	// replMux guards the replication state, it is taken after the key space lock
+	replMux sync.Mutex
+)
+
+// InitReplication configures replication, this should be called before accepting clients
+func InitReplication(conf replication.Config) {
+	replConf = conf.WithDefaults()
+}
+
+// StartReplication starts pinging replicas and disconnecting the ones which stopped acknowledging
+func StartReplication() {
+	go func() {
+		lastPing := time.Now()
+		for now := range time.Tick(time.Second) {
+			replMux.Lock()
+
+			// replicas of a replica receive the pings of our master
+			if link == nil && len(replicas) > 0 && now.Sub(lastPing) >= replConf.PingPeriod {
+				feedLocked(persistence.AppendCommand(nil, []string{"ping"}))
+				lastPing = now
+			}
+
+			for _, r := range replicas {
+				if now.Sub(r.lastAck) > replConf.Timeout {
+					klogs.Logger.Warnf("replica %s timed out", r.client.RemoteAddr())
+					r.close()
+				}
+			}
+
+			replMux.Unlock()
+		}
+	}()
+}
+
+// replica is a connected replica fed with the replication stream
+type replica struct {
+	client *Client
+
+	// port is the listening port announced by the replica
+	port int
+
+	// ackOffset is the last offset acknowledged by the replica and lastAck is when it was received
+	ackOffset int64
+	lastAck   time.Time
+
+	// online is true once the replica acknowledged the initial sync
+	online bool
+
+	// pending is the part of the stream which is not written to the connection yet
+	pending []byte
+	closed  bool
+	notify  chan struct{}
+	mux     sync.Mutex
+}
+
+func newReplica(client *Client) *replica {
+	return &replica{client: client, port: client.listeningPort, lastAck: time.Now(), notify: make(chan struct{}, 1)}
+}
+
+// ip returns the address of the replica without the port
+func (r *replica) ip() string {
+	host, _, err := net.SplitHostPort(r.client.RemoteAddr().String())
+	if err != nil {
+		return r.client.RemoteAddr().String()
+	}
+
+	return host
+}
+
+// write queues data for the replica, a replica which can not keep up is disconnected
+func (r *replica) write(data []byte) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	if len(r.pending)+len(data) > replicaOutputLimit {
+		klogs.Logger.Warnf("replica %s can not keep up with the replication stream", r.client.RemoteAddr())
+		r.closeLocked()
+		return
+	}
+
+	r.pending = append(r.pending, data...)
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+// writeLoop writes the pending stream to the connection until the replica is closed
+func (r *replica) writeLoop() {
+	for range r.notify {
+		r.mux.Lock()
+		data := r.pending
+		r.pending = nil
+		r.mux.Unlock()
+
+		if _, err := r.client.Connection.Write(data); err != nil {
+			r.close()
+			return
+		}
+	}
+}
+
+func (r *replica) close() {
+	r.mux.Lock()
+	r.closeLocked()
+	r.mux.Unlock()
+}
+
+func (r *replica) closeLocked() {
+	if r.closed {
+		return
+	}
+
+	r.closed = true
+	close(r.notify)
+	r.client.Connection.Close()
+}
+
+// removeReplica stops feeding a disconnected replica
+func removeReplica(r *replica) {
+	replMux.Lock()
+	for i, cur := range replicas {
+		if cur == r {
+			replicas = append(replicas[:i], replicas[i+1:]...)
+			break
+		}
+	}
+	replMux.Unlock()
+
+	r.close()
+}
+
+// disconnectReplicasLocked disconnects all replicas so they resync, caller must hold replMux
+func disconnectReplicasLocked() {
+	for _, r := range replicas {
+		r.close()
+	}
+
+	replicas = nil
+}
+
+// feedLocked appends data to the replication stream, caller must hold replMux
+func feedLocked(data []byte) {
+	if backlog == nil || len(data) == 0 {
+		return
+	}
+
+	backlog.Write(data)
+	replOffset = backlog.Offset()
+	for _, r := range replicas {
+		r.write(data)
+	}
+}
+
+// replicate feeds the replication stream with the changes of an executed command, caller must hold the key space lock
+// The stream received from our master is forwarded as it is, local changes are only replicated by a master
+func replicate(client *Client, entries []persistence.Entry) {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if client.master {
+		// a transaction is forwarded once it is executed
+		if client.Multi {
+			return
+		}
+
+		if link != nil && link.client == client {
+			feedLocked(client.pendingStream)
+			streamDB = client.DatabaseIndex
+		}
+
+		client.pendingStream = nil
+		return
+	}
+
+	if link != nil || backlog == nil {
+		return
+	}
+
+	var buf []byte
+	for _, entry := range entries {
+		if entry.DB != streamDB {
+			buf = persistence.AppendCommand(buf, []string{"select", strconv.Itoa(entry.DB)})
+			streamDB = entry.DB
+		}
+
+		buf = persistence.AppendCommand(buf, entry.Args)
+	}
+
+	feedLocked(buf)
+}
+
+// readOnly reports whether a command is a write which must be rejected because the server is a read only replica
+// MULTI and EXEC only wrap transactions, the commands queued in them are checked on their own
+func readOnly(client *Client, command *Command) bool {
+	if !command.ModifyKeySpace || client.master || atomic.LoadInt32(&readOnlyReplica) == 0 {
+		return false
+	}
+
+	return command.Name != "multi" && command.Name != "exec"
+}
+
+// SetMaster makes the server a replica of the master at host and port
+// It returns false when the server already replicates that master
+func SetMaster(host string, port int) bool {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if link != nil {
+		if link.host == host && link.port == port {
+			return false
+		}
+
+		link.stopLocked()
+	}
+
+	// our replicas resync from the new history, our own history is kept in case the new master continues it
+	disconnectReplicasLocked()
+
+	link = newMasterLink(host, port)
+	if replConf.ReadOnly {
+		atomic.StoreInt32(&readOnlyReplica, 1)
+	}
+
+	go link.run()
+	klogs.Logger.Infof("replicating from master %s", link.addr())
+	return true
+}
+
+// promote turns a replica into a master which continues the history of its former master
+func promote() {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if link == nil {
+		return
+	}
+
+	link.stopLocked()
+	link = nil
+	atomic.StoreInt32(&readOnlyReplica, 0)
+
+	// replicas of the former master can continue with us up to the current offset
+	replID2, secondReplOffset = replID, replOffset+1
+	replID = replication.NewID()
+	streamDB = -1
+
+	// replicas reconnect to learn the new replication ID
+	disconnectReplicasLocked()
+	klogs.Logger.Info("promoted to master")
+}
+
+// ReplicaOf makes the server a replica of another server or turns a replica into a master
+// REPLICAOF host port | NO ONE
+func ReplicaOf(client *Client, args []string) {
+	if raftNode != nil {
+		client.WriteError(notInRaft("REPLICAOF"))
+		return
+	}
+
+	if strings.ToLower(args[0]) == "no" && strings.ToLower(args[1]) == "one" {
+		promote()
+		client.WriteOK()
+		return
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		client.WriteError(&protocol.ErrGeneric{Err: errInvalidMasterPort})
+		return
+	}
+
+	if !SetMaster(args[0], port) {
+		client.WriteSimpleString("OK Already connected to specified master")
+		return
+	}
+
+	client.WriteOK()
+}
+
+// ReplConf configures the replication link of a replica, acknowledgements are not replied
+// REPLCONF listening-port port | capa capability | ack offset
+func ReplConf(client *Client, args []string) {
+	if len(args)%2 != 0 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				client.WriteError(&protocol.ErrCastFailedToInt{Val: args[i+1]})
+				return
+			}
+			client.listeningPort = port
+		case "capa":
+			// all replicas are expected to support partial resyncs
+		case "ack":
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || client.replica == nil {
+				return
+			}
+
+			replMux.Lock()
+			client.replica.ackOffset, client.replica.lastAck, client.replica.online = offset, time.Now(), true
+			replMux.Unlock()
+			return
+		default:
+			client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unrecognized REPLCONF option: %s", opt)})
+			return
+		}
+	}
+
+	client.WriteOK()
+}
+
+// PSync starts streaming to a replica, the replica continues from offset when the backlog covers it
+// Otherwise it receives FULLRESYNC replicationid offset db followed by a snapshot, db is the database selected in the
+// stream at offset
+// PSYNC replicationid offset
+func PSync(client *Client, args []string) {
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	if client.replica != nil {
+		return
+	}
+
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	if link != nil && link.state != linkConnected {
+		client.WriteError(&protocol.ErrGeneric{Err: errMasterNotConnected})
+		return
+	}
+
+	if backlog == nil {
+		backlog = replication.NewBacklog(replConf.BacklogSize, replOffset)
+	}
+
+	var initial []byte
+	if data, ok := continueFrom(args[0], offset); ok {
+		klogs.Logger.Infof("partial resync of replica %s from offset %d", client.RemoteAddr(), offset)
+		initial = append([]byte("+CONTINUE "+replID+resp2.CRLF), data...)
+		atomic.AddInt64(&syncPartialOK, 1)
+	} else {
+		// the key space lock is held so the snapshot reflects the current offset
+		var buf bytes.Buffer
+		if err := persistence.WriteSnapshot(&buf, databases); err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+
+		db := streamDB
+		if db < 0 {
+			db = 0
+		}
+
+		klogs.Logger.Infof("full resync of replica %s", client.RemoteAddr())
+		initial = []byte(fmt.Sprintf("+FULLRESYNC %s %d %d\r\n$%d\r\n", replID, replOffset, db, buf.Len()))
+		initial = append(initial, buf.Bytes()...)
+		atomic.AddInt64(&syncFull, 1)
+	}
+
+	r := newReplica(client)
+	client.replica = r
+	replicas = append(replicas, r)
+
+	r.write(initial)
+	go r.writeLoop()
+}
+
+// continueFrom returns the stream following offset of the history id, caller must hold replMux
+// offset is the first byte the replica is missing
+func continueFrom(id string, offset int64) ([]byte, bool) {
+	if id != replID && (id != replID2 || offset > secondReplOffset) {
+		return nil, false
+	}
+
+	return backlog.Since(offset - 1)
+}
+
+// Role returns the replication role of the server
+func Role(client *Client, args []string) {
+	replMux.Lock()
+
+	var reply []protocol.Reply
+	if link == nil {
+		items := make([]protocol.Reply, 0, len(replicas))
+		for _, r := range replicas {
+			items = append(items, resp2.NewArrayReply(false, []protocol.Reply{
+				resp2.NewBulkStringReply(false, r.ip()),
+				resp2.NewBulkStringReply(false, strconv.Itoa(r.port)),
+				resp2.NewBulkStringReply(false, strconv.FormatInt(r.ackOffset, 10)),
+			}))
+		}
+
+		reply = []protocol.Reply{
+			resp2.NewBulkStringReply(false, "master"),
+			resp2.NewIntegerReply(int(replOffset)),
+			resp2.NewArrayReply(false, items),
+		}
+	} else {
+		reply = []protocol.Reply{
+			resp2.NewBulkStringReply(false, "slave"),
+			resp2.NewBulkStringReply(false, link.host),
+			resp2.NewIntegerReply(link.port),
+			resp2.NewBulkStringReply(false, link.state),
+			resp2.NewIntegerReply(int(replOffset)),
+		}
+	}
+
+	replMux.Unlock()
+	client.WriteProtocolReply(resp2.NewArrayReply(false, reply))
+}
+
+func replicationInfo() []infoField {
+	replMux.Lock()
+	defer replMux.Unlock()
+
+	fields := []infoField{{"role", "master"}}
+	if link != nil {
+		status, lastIO := "down", int64(-1)
+		if link.state == linkConnected {
+			status = "up"
+		}
+		if at := atomic.LoadInt64(&link.lastIO); at > 0 {
+			lastIO = time.Now().Unix() - at
+		}
+
+		fields = []infoField{
+			{"role", "slave"},
+			{"master_host", link.host},
+			{"master_port", link.port},
+			{"master_link_status", status},
+			{"master_last_io_seconds_ago", lastIO},
+			{"master_sync_in_progress", boolToInt(link.state == linkSync)},
+			{"slave_repl_offset", replOffset},
+			{"slave_read_only", atomic.LoadInt32(&readOnlyReplica)},
+		}
+
+		if link.state != linkConnected {
+			fields = append(fields, infoField{"master_link_down_since_seconds", int64(time.Since(link.downSince) / time.Second)})
+		}
+	}
+
+	fields = append(fields, infoField{"connected_slaves", len(replicas)})
+	for i, r := range replicas {
+		state := "send_bulk"
+		if r.online {
+			state = "online"
+		}
+
+		fields = append(fields, infoField{fmt.Sprintf("slave%d", i), fmt.Sprintf("ip=%s,port=%d,state=%s,offset=%d,lag=%d",
+			r.ip(), r.port, state, r.ackOffset, int64(time.Since(r.lastAck)/time.Second))})
+	}
+
+	fields = append(fields,
+		infoField{"master_replid", replID},
+		infoField{"master_replid2", replID2},
+		infoField{"master_repl_offset", replOffset},
+		infoField{"second_repl_offset", secondReplOffset},
+		infoField{"repl_backlog_active", boolToInt(backlog != nil)},
+		infoField{"repl_backlog_size", replConf.BacklogSize},
+	)
+
+	if backlog != nil {
+		fields = append(fields,
+			infoField{"repl_backlog_first_byte_offset", backlog.Start() + 1},
+			infoField{"repl_backlog_histlen", backlog.Len()},
+		)
+	}
+
+	return fields
+}
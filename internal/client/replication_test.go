@@ -0,0 +1,123 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestReplication(t *testing.T) {
+	assert := testifyAssert.New(t)
+	master := newTestConn(t)
+	master.flushAll()
+	replica := dialTest(t, startTestProcess(t))
+	full, partial := mustAtoi(t, master.info("sync_full")), mustAtoi(t, master.info("sync_partial_ok"))
+
+	master.do("set", "before", "sync")
+	master.do("rpush", "list", "a", "b")
+	master.do("select", "3")
+	master.do("set", "other", "db")
+
+	// full sync
+	host, port, _ := net.SplitHostPort(testAddr)
+	assert.Equal("OK", replica.do("replicaof", host, port))
+	eventually(t, func() bool { return replica.info("master_link_status") == "up" }, "the replica is connected")
+	assert.Equal(full+1, mustAtoi(t, master.info("sync_full")))
+	eventually(t, func() bool { return master.info("connected_slaves") == "1" }, "former replicas are removed")
+
+	assert.Equal("sync", replica.do("get", "before"))
+	assert.Equal([]interface{}{"a", "b"}, replica.do("lrange", "list", "0", "-1"))
+
+	// the stream continues in the database selected before the sync
+	master.do("set", "streamed", "3")
+	eventually(t, func() bool {
+		replica.do("select", "3")
+		return replica.do("get", "streamed") == "3"
+	}, "the replica applied the stream")
+	assert.Equal("db", replica.do("get", "other"))
+
+	// writes of clients are rejected
+	assert.IsType(replyError(""), replica.do("set", "k", "v"))
+	assert.Contains(string(replica.do("del", "other").(replyError)), "READONLY")
+	assert.Equal("db", replica.do("get", "other"))
+
+	// offsets match once the replica acknowledged the stream
+	eventually(t, func() bool {
+		offset := master.info("master_repl_offset")
+		return replica.info("slave_repl_offset") == offset && strings.Contains(master.info("slave0"), "offset="+offset)
+	}, "the replica acknowledged the stream")
+
+	role := master.do("role").([]interface{})
+	offset, _ := strconv.Atoi(master.info("master_repl_offset"))
+	assert.Equal("master", role[0])
+	assert.Equal(offset, role[1])
+	assert.Len(role[2], 1)
+	assert.Equal(strconv.Itoa(offset), role[2].([]interface{})[0].([]interface{})[2])
+
+	role = replica.do("role").([]interface{})
+	assert.Equal([]interface{}{"slave", host, mustAtoi(t, port), "connected", offset}, role)
+
+	// partial resync after the connection broke
+	replMux.Lock()
+	for _, r := range replicas {
+		r.close()
+	}
+	replMux.Unlock()
+
+	master.do("set", "while", "disconnected")
+	master.do("select", "0")
+	master.do("rpush", "list", "c")
+
+	eventually(t, func() bool { return mustAtoi(t, master.info("sync_partial_ok")) == partial+1 }, "the replica resynced")
+	eventually(t, func() bool {
+		replica.do("select", "0")
+		return replica.do("llen", "list") == 3
+	}, "the replica applied the missed stream")
+	assert.Equal(full+1, mustAtoi(t, master.info("sync_full")))
+
+	replica.do("select", "3")
+	assert.Equal("disconnected", replica.do("get", "while"))
+	eventually(t, func() bool {
+		return replica.info("slave_repl_offset") == master.info("master_repl_offset")
+	}, "the replica caught up")
+}
+
+// mustAtoi converts s to an int failing the test when it is not a number
+func mustAtoi(t *testing.T, s string) int {
+	t.Helper()
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
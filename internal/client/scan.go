@@ -0,0 +1,142 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// maxScanCount caps COUNT of SCAN family commands, a single call never visits more elements than this
+const maxScanCount = 1 << 20
+
+const (
+	// scanNoValues accepts NOVALUES flag
+	scanNoValues = 1 << iota
+
+	// scanType accepts TYPE option
+	scanType
+)
+
+// scanArgs holds the optional arguments of SCAN family commands
+type scanArgs struct {
+	cursor   uint64
+	match    string
+	count    int
+	noValues bool
+	typ      string
+}
+
+// parseScanArgs parses cursor [MATCH pattern] [COUNT count] arguments
+// opts are the scan* flags for the extra arguments accepted by the command
+func parseScanArgs(args []string, opts int) (*scanArgs, error) {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, &protocol.ErrGeneric{Err: errInvalidCursor}
+	}
+
+	res := &scanArgs{cursor: cursor, match: "*", count: 10}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			if i+1 >= len(args) {
+				return nil, &protocol.ErrSyntax{}
+			}
+			res.match = args[i+1]
+			i++
+		case "count":
+			if i+1 >= len(args) {
+				return nil, &protocol.ErrSyntax{}
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, &protocol.ErrCastFailedToInt{Val: args[i+1]}
+			}
+			if count < 1 {
+				return nil, &protocol.ErrSyntax{}
+			}
+			res.count = min(count, maxScanCount)
+			i++
+		case "novalues":
+			if opts&scanNoValues == 0 {
+				return nil, &protocol.ErrSyntax{}
+			}
+			res.noValues = true
+		case "type":
+			if opts&scanType == 0 || i+1 >= len(args) {
+				return nil, &protocol.ErrSyntax{}
+			}
+			res.typ = strings.ToLower(args[i+1])
+			i++
+		default:
+			return nil, &protocol.ErrSyntax{}
+		}
+	}
+
+	return res, nil
+}
+
+// matches reports whether the given element matches the MATCH pattern
+func (s *scanArgs) matches(str string) bool {
+	return s.match == "*" || util.GlobMatch(s.match, str)
+}
+
+// writeScanReply writes the next cursor and the scanned elements
+func writeScanReply(client *Client, cursor uint64, items []string) {
+	arr := make([]protocol.Reply, len(items))
+	for i := 0; i < len(items); i++ {
+		arr[i] = resp2.NewBulkStringReply(false, items[i])
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+		resp2.NewBulkStringReply(false, strconv.FormatUint(cursor, 10)),
+		resp2.NewArrayReply(false, arr),
+	}))
+}
+
+// Scan iterates over keys of the selected database with a cursor
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func Scan(client *Client, args []string) {
+	scan, err := parseScanArgs(args, scanType)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	keys, cursor := client.Database.Scan(scan.cursor, scan.count, func(key string, node *db.DataNode) bool {
+		return (scan.typ == "" || node.Type.String() == scan.typ) && scan.matches(key)
+	})
+
+	writeScanReply(client, cursor, keys)
+}
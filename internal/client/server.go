@@ -26,12 +26,38 @@
 package client
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/persistence"
 	"github.com/kasvith/kache/internal/protocol"
 	"github.com/kasvith/kache/internal/resp/resp2"
 )
 
+var (
+	errUnblockReason = errors.New("CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+	errProtoVersion  = errors.New("Protocol version is not an integer or out of range")
+	errClientName    = errors.New("Client names cannot contain spaces, newlines or special characters.")
+)
+
+// Version is the version of the server reported to clients, it is set on startup
+var Version = ""
+
 // Ping will return PONG when no argument found or will echo the given argument
+// Subscribed RESP2 clients receive an array as their connection is reserved for messages
 func Ping(client *Client, args []string) {
+	if client.subscriptions() > 0 && client.Protocol == RESP2 {
+		msg := ""
+		if len(args) > 0 {
+			msg = args[0]
+		}
+
+		client.WriteStringArray([]string{"pong", msg})
+		return
+	}
+
 	if len(args) == 0 {
 		client.WriteProtocolReply(resp2.NewSimpleStringReply("PONG"))
 		return
@@ -42,11 +68,21 @@ func Ping(client *Client, args []string) {
 
 // Multi command will put client in multi mode where can execute multiple commands at once
 func Multi(client *Client, args []string) {
+	if client.Multi {
+		client.WriteError(&protocol.ErrGeneric{Err: errNestedMulti})
+		return
+	}
+
 	client.Multi = true
+	client.multiSlot = -1
+
+	// transactions are logged when they are executed
+	client.propagateAs()
 	client.WriteProtocolReply(resp2.NewSimpleStringReply("OK"))
 }
 
 // Exec command will execute a multi transaction
+// Queued commands run while the key space lock is held, so other clients never see a partially applied transaction
 func Exec(client *Client, args []string) {
 	if !client.Multi {
 		client.WriteError(protocol.ErrExecWithoutMulti{})
@@ -57,14 +93,193 @@ func Exec(client *Client, args []string) {
 	if client.MultiError {
 		client.MultiError = false
 		client.Commands = []*Command{}
+		client.unwatch()
 		client.WriteError(protocol.ErrExecAbortTransaction{})
 		return
 	}
 
+	// a watched key which was changed aborts the transaction
+	aborted := client.watchFailed()
+	client.unwatch()
+	if aborted {
+		client.Commands = []*Command{}
+		client.propagateAs()
+		client.WriteNilArray()
+		return
+	}
+
+	// every queued command writes a single element of the reply
+	client.WriteArrayLength(len(client.Commands))
+
+	// blocking commands of a transaction reply as if they timed out
+	deny := client.denyBlocking
+	client.denyBlocking = true
 	for _, cmd := range client.Commands {
-		cmd.Fn(client, cmd.Args)
+		if cmd.ModifyKeySpace {
+			client.execute(cmd, cmd.Args)
+		} else {
+			cmd.Fn(client, cmd.Args)
+			client.trackRead(cmd, cmd.Args)
+		}
 	}
+	client.denyBlocking = deny
 
 	// clear all commands
 	client.Commands = []*Command{}
+
+	// log the changes of the transaction wrapped in MULTI and EXEC so they are replayed atomically
+	client.failed = false
+	entries := client.flushPropagated()
+	if len(entries) == 0 {
+		client.propagateAs()
+		return
+	}
+
+	client.propagated = append([]persistence.Entry{{DB: entries[0].DB, Args: []string{"multi"}}}, entries...)
+	client.propagateAs([]string{"exec"})
+}
+
+// Hello switches the protocol of the connection and replies with the details of the server
+// No users are configured, so AUTH accepts the default user with any password
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func Hello(client *Client, args []string) {
+	proto := client.Protocol
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0])
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: errProtoVersion})
+			return
+		}
+
+		switch ver {
+		case 2:
+			proto = RESP2
+		case 3:
+			proto = RESP3
+		default:
+			client.WriteError(protocol.ErrNoProto{})
+			return
+		}
+	}
+
+	name, setName := "", false
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "auth" && i+2 < len(args):
+			if args[i+1] != "default" {
+				client.WriteError(protocol.ErrWrongPass{})
+				return
+			}
+			i += 2
+		case opt == "setname" && i+1 < len(args):
+			if !validClientName(args[i+1]) {
+				client.WriteError(&protocol.ErrGeneric{Err: errClientName})
+				return
+			}
+			name, setName = args[i+1], true
+			i++
+		default:
+			client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("Syntax error in HELLO option '%s'", args[i])})
+			return
+		}
+	}
+
+	if setName {
+		client.name = name
+	}
+	client.setProtocol(proto)
+
+	mode := "standalone"
+	if clusterState != nil {
+		mode = "cluster"
+	}
+
+	replMux.Lock()
+	role := "master"
+	if link != nil {
+		role = "replica"
+	}
+	replMux.Unlock()
+
+	ver := 2
+	if proto == RESP3 {
+		ver = 3
+	}
+
+	client.WriteProtocolReply(mapReply(client, []protocol.Reply{
+		resp2.NewBulkStringReply(false, "server"), resp2.NewBulkStringReply(false, "kache"),
+		resp2.NewBulkStringReply(false, "version"), resp2.NewBulkStringReply(false, Version),
+		resp2.NewBulkStringReply(false, "proto"), resp2.NewIntegerReply(ver),
+		resp2.NewBulkStringReply(false, "id"), resp2.NewIntegerReply(int(client.ID)),
+		resp2.NewBulkStringReply(false, "mode"), resp2.NewBulkStringReply(false, mode),
+		resp2.NewBulkStringReply(false, "role"), resp2.NewBulkStringReply(false, role),
+		resp2.NewBulkStringReply(false, "modules"), resp2.NewArrayReply(false, nil),
+	}))
+}
+
+// validClientName reports whether name can be used as the name of a client, names are printable without spaces
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ClientCmd manages client connections
+// CLIENT ID | SETNAME name | GETNAME | UNBLOCK id [TIMEOUT | ERROR] | TRACKING ON | OFF [options ...] | CACHING YES | NO
+func ClientCmd(client *Client, args []string) {
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "id" && len(args) == 1:
+		client.WriteInteger(int(client.ID))
+	case sub == "setname" && len(args) == 2:
+		if !validClientName(args[1]) {
+			client.WriteError(&protocol.ErrGeneric{Err: errClientName})
+			return
+		}
+
+		client.name = args[1]
+		client.WriteOK()
+	case sub == "getname" && len(args) == 1:
+		if client.name == "" {
+			client.WriteNil()
+			return
+		}
+
+		client.WriteBulkString(client.name)
+	case sub == "unblock" && (len(args) == 2 || len(args) == 3):
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+
+		withError := false
+		if len(args) == 3 {
+			switch strings.ToLower(args[2]) {
+			case "timeout":
+			case "error":
+				withError = true
+			default:
+				client.WriteError(&protocol.ErrGeneric{Err: errUnblockReason})
+				return
+			}
+		}
+
+		target, found := ConnectedClients.Get(id)
+		if found && unblockClient(target, withError) {
+			client.WriteInteger(1)
+			return
+		}
+
+		client.WriteInteger(0)
+	case sub == "tracking" && len(args) >= 2:
+		clientTracking(client, args[1:])
+	case sub == "caching" && len(args) == 2:
+		clientCaching(client, args[1])
+	default:
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])})
+	}
 }
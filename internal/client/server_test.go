@@ -0,0 +1,129 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kasvith/kache/internal/resp/resp3"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// hello sends HELLO with args and returns the type of the reply with its fields
+func (c *testConn) hello(args ...string) (byte, map[string]interface{}) {
+	c.t.Helper()
+
+	c.send(append([]string{"hello"}, args...)...)
+	typ, reply := c.readFrame()
+	elems, ok := reply.([]interface{})
+	if !ok {
+		c.t.Fatalf("HELLO replied with %v", reply)
+	}
+
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(elems); i += 2 {
+		fields[elems[i].(string)] = elems[i+1]
+	}
+
+	return typ, fields
+}
+
+func TestHello(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+
+	typ, fields := c.hello()
+	assert.Equal(byte(resp3.Resp3Array), typ)
+	assert.Equal("kache", fields["server"])
+	assert.Equal(2, fields["proto"])
+
+	typ, fields = c.hello("3")
+	assert.Equal(byte(resp3.Resp3Map), typ)
+	assert.Equal(3, fields["proto"])
+	assert.Equal("master", fields["role"])
+
+	// the protocol stays the same without a version
+	typ, fields = c.hello()
+	assert.Equal(byte(resp3.Resp3Map), typ)
+	assert.Equal(3, fields["proto"])
+
+	// switching back to RESP2 replies with a flat array and RESP2 types
+	typ, fields = c.hello("2")
+	assert.Equal(byte(resp3.Resp3Array), typ)
+	assert.Equal(2, fields["proto"])
+
+	c.flushAll()
+	c.do("zadd", "z", "1.5", "a")
+	c.send("zrange", "z", "0", "-1", "withscores")
+	typ, reply := c.readFrame()
+	assert.Equal(byte(resp3.Resp3Array), typ)
+	assert.Equal([]interface{}{"a", "1.5"}, reply)
+}
+
+func TestHelloErrors(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+
+	for _, ver := range []string{"1", "4", "-3"} {
+		reply, ok := c.do("hello", ver).(replyError)
+		assert.True(ok)
+		assert.True(strings.HasPrefix(string(reply), "NOPROTO"), "HELLO %s replied with %s", ver, reply)
+	}
+
+	assert.IsType(replyError(""), c.do("hello", "x"))
+	assert.IsType(replyError(""), c.do("hello", "3", "foo"))
+	assert.IsType(replyError(""), c.do("hello", "3", "auth", "default"))
+	assert.IsType(replyError(""), c.do("hello", "3", "setname"))
+
+	// a failed HELLO does not switch the protocol
+	_, fields := c.hello()
+	assert.Equal(2, fields["proto"])
+}
+
+func TestHelloAuthAndSetName(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+
+	reply, ok := c.do("hello", "3", "auth", "someone", "secret").(replyError)
+	assert.True(ok)
+	assert.True(strings.HasPrefix(string(reply), "WRONGPASS"))
+
+	_, fields := c.hello("3", "auth", "default", "secret", "setname", "worker")
+	assert.Equal(3, fields["proto"])
+	assert.Equal("worker", c.do("client", "getname"))
+
+	_, fields = c.hello("2", "setname", "other")
+	assert.Equal(2, fields["proto"])
+	assert.Equal("other", c.do("client", "getname"))
+
+	// an invalid name fails the whole command
+	assert.IsType(replyError(""), c.do("hello", "3", "setname", "bad name"))
+	assert.Equal("other", c.do("client", "getname"))
+	_, fields = c.hello()
+	assert.Equal(2, fields["proto"])
+}
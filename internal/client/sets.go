@@ -0,0 +1,471 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/pkg/types/set"
+)
+
+// getSet finds the set stored at key
+// When create is true a new set will be stored for a missing key
+// A nil set is returned when key is not found and create is false
+func getSet(client *Client, key string, create bool) (*set.Set, error) {
+	var node *db.DataNode
+	if create {
+		node, _ = client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeSet, -1, set.New()))
+	} else {
+		v, found := client.Database.GetNode(key)
+		if !found {
+			return nil, nil
+		}
+		node = v
+	}
+
+	if node.Type != db.TypeSet {
+		return nil, &protocol.ErrWrongType{}
+	}
+
+	return node.Value.(*set.Set), nil
+}
+
+// getSets finds the sets stored at keys, missing keys are treated as empty sets
+func getSets(client *Client, keys []string) ([]set.Set, error) {
+	sets := make([]set.Set, len(keys))
+	for i, key := range keys {
+		s, err := getSet(client, key, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if s == nil {
+			s = set.New()
+		}
+		sets[i] = *s
+	}
+
+	return sets, nil
+}
+
+// removeIfEmptySet will delete the key when the set does not hold any element
+func removeIfEmptySet(client *Client, key string, s *set.Set) {
+	if s.Card() == 0 {
+		client.Database.Del([]string{key})
+	}
+}
+
+// SAdd adds members to the set and returns the number of newly added members
+func SAdd(client *Client, args []string) {
+	s, err := getSet(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.writeChanges(s.Add(args[1:]))
+}
+
+// SRem removes members from the set and returns the number of removed members
+func SRem(client *Client, args []string) {
+	key := args[0]
+	s, err := getSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.writeChanges(0)
+		return
+	}
+
+	removed := s.Delete(args[1:])
+	removeIfEmptySet(client, key, s)
+	client.writeChanges(removed)
+}
+
+// SMembers returns all members of the set
+func SMembers(client *Client, args []string) {
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteSet([]string{})
+		return
+	}
+
+	client.WriteSet(s.Elems())
+}
+
+// SIsMember checks whether a member is in the set
+func SIsMember(client *Client, args []string) {
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(s.Exists(args[1]))
+}
+
+// SMIsMember checks whether each of the given members are in the set
+func SMIsMember(client *Client, args []string) {
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	members := args[1:]
+	res := make([]int, len(members))
+	if s != nil {
+		for i, member := range members {
+			res[i] = s.Exists(member)
+		}
+	}
+
+	client.WriteIntegerArray(res)
+}
+
+// SCard returns the number of members in the set
+func SCard(client *Client, args []string) {
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(s.Card())
+}
+
+// SMove moves a member from source set to destination set
+func SMove(client *Client, args []string) {
+	srcKey, destKey, member := args[0], args[1], args[2]
+
+	src, err := getSet(client, srcKey, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	// check destination type before modifying the source
+	if node, found := client.Database.GetNode(destKey); found && node.Type != db.TypeSet {
+		client.WriteError(&protocol.ErrWrongType{})
+		return
+	}
+
+	if src == nil || src.Exists(member) == 0 {
+		client.WriteInteger(0)
+		return
+	}
+
+	if srcKey == destKey {
+		client.WriteInteger(1)
+		return
+	}
+
+	dest, err := getSet(client, destKey, true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	moved := set.Move(member, src, dest)
+	removeIfEmptySet(client, srcKey, src)
+	client.WriteInteger(moved)
+}
+
+// SDiff returns the members of the difference between the first set and all successive sets
+func SDiff(client *Client, args []string) {
+	sets, err := getSets(client, args)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.WriteSet(sets[0].Diff(sets[1:]))
+}
+
+// SInter returns the members of the intersection of all sets
+func SInter(client *Client, args []string) {
+	sets, err := getSets(client, args)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.WriteSet(set.Intersection(sets))
+}
+
+// SUnion returns the members of the union of all sets
+func SUnion(client *Client, args []string) {
+	sets, err := getSets(client, args)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.WriteSet(set.Union(sets))
+}
+
+// SDiffStore stores the difference of sets in destination
+func SDiffStore(client *Client, args []string) {
+	sets, err := getSets(client, args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	storeSet(client, args[0], sets[0].DiffS(sets[1:]))
+}
+
+// SInterStore stores the intersection of sets in destination
+func SInterStore(client *Client, args []string) {
+	sets, err := getSets(client, args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	storeSet(client, args[0], set.IntersectionS(sets))
+}
+
+// SUnionStore stores the union of sets in destination
+func SUnionStore(client *Client, args []string) {
+	sets, err := getSets(client, args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	storeSet(client, args[0], set.UnionS(sets))
+}
+
+// storeSet overwrites the destination with the given set and replies with its cardinality
+// an empty set will delete the destination
+func storeSet(client *Client, dest string, s *set.Set) {
+	if s.Card() == 0 {
+		client.Database.Del([]string{dest})
+		client.WriteInteger(0)
+		return
+	}
+
+	client.Database.Set(dest, db.NewDataNode(db.TypeSet, -1, s))
+	client.WriteInteger(s.Card())
+}
+
+// SInterCard returns the cardinality of the intersection, LIMIT stops counting at the given limit
+func SInterCard(client *Client, args []string) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[0]})
+		return
+	}
+
+	if numKeys <= 0 || numKeys > len(args)-1 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	limit := 0
+	opts := args[1+numKeys:]
+	if len(opts) > 0 {
+		if len(opts) != 2 || strings.ToLower(opts[0]) != "limit" {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		limit, err = strconv.Atoi(opts[1])
+		if err != nil || limit < 0 {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[1]})
+			return
+		}
+	}
+
+	sets, err := getSets(client, args[1:1+numKeys])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	card := len(set.Intersection(sets))
+	if limit > 0 && card > limit {
+		card = limit
+	}
+
+	client.WriteInteger(card)
+}
+
+// SPop removes and returns random members from the set
+func SPop(client *Client, args []string) {
+	// members would pop different members
+	if raftNode != nil {
+		client.WriteError(notInRaft("SPOP"))
+		return
+	}
+
+	key := args[0]
+	count, hasCount := 1, len(args) > 1
+	if hasCount {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+		count = v
+	}
+
+	s, err := getSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		if hasCount {
+			client.WriteStringArray([]string{})
+		} else {
+			client.WriteNil()
+		}
+		return
+	}
+
+	members := s.Pop(count)
+	removeIfEmptySet(client, key, s)
+
+	// popped members are random so they are logged explicitly
+	if len(members) > 0 {
+		client.propagateAs(append([]string{"srem", key}, members...))
+	} else {
+		client.propagateAs()
+	}
+
+	if !hasCount {
+		client.WriteBulkString(members[0])
+		return
+	}
+
+	client.WriteStringArray(members)
+}
+
+// SRandMember returns random members from the set
+// A positive count returns distinct members while a negative count allows the same member multiple times
+func SRandMember(client *Client, args []string) {
+	count, hasCount := 1, len(args) > 1
+	if hasCount {
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+
+		// like redis, the count must have a positive counterpart
+		if v == math.MinInt {
+			client.WriteError(&protocol.ErrValueOutOfRange{})
+			return
+		}
+		count = v
+	}
+
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		if hasCount {
+			client.WriteStringArray([]string{})
+		} else {
+			client.WriteNil()
+		}
+		return
+	}
+
+	if !hasCount {
+		member, _ := s.RandomMember()
+		client.WriteBulkString(member)
+		return
+	}
+
+	if count < 0 {
+		writeRandomElements(client, -count, 1, func() []string {
+			member, _ := s.RandomMember()
+			return []string{member}
+		})
+		return
+	}
+
+	client.WriteStringArray(s.Random(count))
+}
+
+// SScan iterates over members of the set
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScan(client *Client, args []string) {
+	scan, err := parseScanArgs(args[1:], 0)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	s, err := getSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		writeScanReply(client, 0, []string{})
+		return
+	}
+
+	res := make([]string, 0, min(scan.count, s.Card()))
+	cursor := s.Scan(scan.cursor, scan.count, func(member string) {
+		if scan.matches(member) {
+			res = append(res, member)
+		}
+	})
+
+	writeScanReply(client, cursor, res)
+}
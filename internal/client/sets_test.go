@@ -0,0 +1,117 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// fillSet adds n members named m0, m1... to the set at key
+func fillSet(c *testConn, key string, n int) {
+	c.t.Helper()
+
+	args := []string{"sadd", key}
+	for i := 0; i < n; i++ {
+		args = append(args, "m"+strconv.Itoa(i))
+	}
+
+	if reply := c.do(args...); reply != n {
+		c.t.Fatalf("SADD replied with %v", reply)
+	}
+}
+
+func TestSRandMember(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillSet(c, "s", 3)
+
+	assert.Contains([]interface{}{"m0", "m1", "m2"}, c.do("srandmember", "s"))
+	assert.ElementsMatch([]interface{}{"m0", "m1", "m2"}, c.do("srandmember", "s", "5"))
+	assert.Len(c.do("srandmember", "s", "-5"), 5)
+	assert.Equal(3, c.do("scard", "s"))
+
+	assert.Equal([]interface{}{}, c.do("srandmember", "missing", "-5"))
+}
+
+func TestSRandMemberLargeNegativeCount(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillSet(c, "s", 3)
+
+	assert.IsType(replyError(""), c.do("srandmember", "s", "-9223372036854775808"))
+
+	assert.Len(c.do("srandmember", "s", "-100000"), 100000)
+	assert.Equal("PONG", c.do("ping"))
+}
+
+func TestSPop(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillSet(c, "s", 100)
+
+	popped := make(map[interface{}]bool)
+	for _, member := range c.do("spop", "s", "40").([]interface{}) {
+		popped[member] = true
+	}
+	assert.Len(popped, 40)
+
+	for i := 0; i < 60; i++ {
+		member := c.do("spop", "s")
+		assert.False(popped[member])
+		popped[member] = true
+	}
+
+	assert.Len(popped, 100)
+	assert.Equal(0, c.do("exists", "s"))
+	assert.Nil(c.do("spop", "s"))
+}
+
+func TestSScan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	fillSet(c, "s", 1000)
+
+	members, calls := scanAll(c, 2, "sscan", "s", "count", "20")
+	assert.True(calls > 1)
+	assert.Len(unique(members), 1000)
+
+	members, _ = scanAll(c, 2, "sscan", "s", "match", "m1?")
+	assert.Len(unique(members), 10)
+
+	members, _ = scanAll(c, 2, "sscan", "missing")
+	assert.Empty(members)
+
+	reply := c.do("sscan", "s", "0", "count", "9223372036854775807").([]interface{})
+	assert.Equal("0", reply[0])
+	assert.Len(reply[1], 1000)
+}
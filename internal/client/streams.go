@@ -0,0 +1,1534 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/types/stream"
+)
+
+var (
+	errMaxLenNegative     = errors.New("The MAXLEN argument must be >= 0.")
+	errLimitWithoutApprox = errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+	errInvalidStartID     = errors.New("invalid start ID for the interval")
+	errInvalidEndID       = errors.New("invalid end ID for the interval")
+	errGroupKeyMissing    = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	errNoSuchKey          = errors.New("no such key")
+	errBlockNotInteger    = errors.New("timeout is not an integer or out of range")
+	errReadGroupID        = errors.New("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+	errReadID             = errors.New("The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+	errAutoClaimCount     = errors.New("COUNT must be > 0")
+	errEntriesRead        = errors.New("value for ENTRIESREAD must be positive or -1")
+)
+
+// getStream finds the stream stored at key
+// When create is true a new stream will be stored for a missing key
+// A nil stream is returned when key is not found and create is false
+func getStream(client *Client, key string, create bool) (*stream.Stream, error) {
+	var node *db.DataNode
+	if create {
+		node, _ = client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeStream, -1, stream.New()))
+	} else {
+		v, found := client.Database.GetNode(key)
+		if !found {
+			return nil, nil
+		}
+		node = v
+	}
+
+	if node.Type != db.TypeStream {
+		return nil, &protocol.ErrWrongType{}
+	}
+
+	return node.Value.(*stream.Stream), nil
+}
+
+// streamError converts an error of the stream package to the error sent to clients
+func streamError(err error, key, group string) error {
+	switch err {
+	case stream.ErrNoGroup:
+		return protocol.ErrNoGroup{Key: key, Group: group}
+	case stream.ErrGroupExists:
+		return protocol.ErrBusyGroup{}
+	}
+
+	return &protocol.ErrGeneric{Err: err}
+}
+
+// parseStreamID parses a complete ID, a missing sequence number is set to 0
+func parseStreamID(arg string) (stream.ID, error) {
+	id, err := stream.ParseID(arg, 0)
+	if err != nil {
+		return id, &protocol.ErrGeneric{Err: err}
+	}
+
+	return id, nil
+}
+
+// parseRangeID parses an ID of a range which can be - or + and is exclusive when prefixed with (
+// A missing sequence number includes all entries of the millisecond
+func parseRangeID(arg string, start bool) (stream.ID, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	var id stream.ID
+	var err error
+	switch {
+	case arg == "-" && !exclusive:
+		return stream.MinID, nil
+	case arg == "+" && !exclusive:
+		return stream.MaxID, nil
+	case start:
+		id, err = stream.ParseID(arg, 0)
+	default:
+		id, err = stream.ParseID(arg, math.MaxUint64)
+	}
+
+	if err != nil {
+		return id, &protocol.ErrGeneric{Err: err}
+	}
+
+	if !exclusive {
+		return id, nil
+	}
+
+	var ok bool
+	if start {
+		if id, ok = id.Next(); !ok {
+			return id, &protocol.ErrGeneric{Err: errInvalidStartID}
+		}
+	} else if id, ok = id.Prev(); !ok {
+		return id, &protocol.ErrGeneric{Err: errInvalidEndID}
+	}
+
+	return id, nil
+}
+
+// parseTrim parses MAXLEN | MINID [= | ~] threshold [LIMIT count] starting at args[i]
+// It returns the trim options with the index of the first argument after them
+func parseTrim(args []string, i int) (stream.TrimOptions, int, error) {
+	opts := stream.TrimOptions{ByMinID: strings.ToLower(args[i]) == "minid"}
+	i++
+
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		opts.Approx = args[i] == "~"
+		i++
+	}
+
+	if i >= len(args) {
+		return opts, i, &protocol.ErrSyntax{}
+	}
+
+	if opts.ByMinID {
+		id, err := parseStreamID(args[i])
+		if err != nil {
+			return opts, i, err
+		}
+		opts.MinID = id
+	} else {
+		n, err := strconv.Atoi(args[i])
+		if err != nil {
+			return opts, i, &protocol.ErrCastFailedToInt{Val: args[i]}
+		}
+		if n < 0 {
+			return opts, i, &protocol.ErrGeneric{Err: errMaxLenNegative}
+		}
+		opts.MaxLen = n
+	}
+	i++
+
+	if opts.Approx {
+		opts.Limit = stream.DefaultTrimLimit
+	}
+
+	if i+1 < len(args) && strings.ToLower(args[i]) == "limit" {
+		if !opts.Approx {
+			return opts, i, &protocol.ErrGeneric{Err: errLimitWithoutApprox}
+		}
+
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n < 0 {
+			return opts, i, &protocol.ErrCastFailedToInt{Val: args[i+1]}
+		}
+		opts.Limit = n
+		i += 2
+	}
+
+	return opts, i, nil
+}
+
+// trimCommand is logged instead of a trim, trimming to the resulting length gives the same result everywhere
+func trimCommand(s *stream.Stream) []string {
+	return []string{"maxlen", "=", strconv.Itoa(s.Len())}
+}
+
+// entryReply converts an entry to an array of its ID and fields, fields are null for deleted entries
+func entryReply(entry stream.Entry) protocol.Reply {
+	fields := resp2.NewArrayReply(true, nil)
+	if entry.Fields != nil {
+		reps := make([]protocol.Reply, len(entry.Fields))
+		for i, f := range entry.Fields {
+			reps[i] = resp2.NewBulkStringReply(false, f)
+		}
+		fields = resp2.NewArrayReply(false, reps)
+	}
+
+	return resp2.NewArrayReply(false, []protocol.Reply{resp2.NewBulkStringReply(false, entry.ID.String()), fields})
+}
+
+func entriesReply(entries []stream.Entry) protocol.Reply {
+	reps := make([]protocol.Reply, len(entries))
+	for i, entry := range entries {
+		reps[i] = entryReply(entry)
+	}
+
+	return resp2.NewArrayReply(false, reps)
+}
+
+func idsReply(ids []stream.ID) protocol.Reply {
+	reps := make([]protocol.Reply, len(ids))
+	for i, id := range ids {
+		reps[i] = resp2.NewBulkStringReply(false, id.String())
+	}
+
+	return resp2.NewArrayReply(false, reps)
+}
+
+// XAdd appends an entry to a stream, the stream is created unless NOMKSTREAM is given
+// XADD key [NOMKSTREAM] [MAXLEN | MINID [= | ~] threshold [LIMIT count]] * | id field value [field value ...]
+func XAdd(client *Client, args []string) {
+	key := args[0]
+	noMkStream, trim := false, false
+	var opts stream.TrimOptions
+
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nomkstream":
+			noMkStream = true
+		case "maxlen", "minid":
+			var err error
+			if opts, i, err = parseTrim(args, i); err != nil {
+				client.WriteError(err)
+				return
+			}
+			trim = true
+			i--
+		default:
+			break options
+		}
+	}
+
+	fields := args[min(i+1, len(args)):]
+	if i >= len(args) || len(fields) == 0 || len(fields)%2 != 0 {
+		client.WriteError(&protocol.ErrWrongNumberOfArgs{Cmd: "xadd"})
+		return
+	}
+
+	// the ID is parsed before the stream is created so an invalid ID never creates an empty stream
+	idArg := args[i]
+	var explicit stream.ID
+	auto, autoSeq := idArg == "*", false
+	if !auto {
+		ms := strings.TrimSuffix(idArg, "-*")
+		autoSeq = ms != idArg || !strings.Contains(idArg, "-")
+
+		var err error
+		if explicit, err = parseStreamID(ms); err != nil {
+			client.WriteError(err)
+			return
+		}
+
+		if !autoSeq && explicit == stream.MinID {
+			client.WriteError(&protocol.ErrGeneric{Err: stream.ErrIDZero})
+			return
+		}
+	}
+
+	s, err := getStream(client, key, !noMkStream)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.propagateAs()
+		client.WriteNil()
+		return
+	}
+
+	id := explicit
+	switch {
+	case auto:
+		id, err = s.NextID(uint64(client.now()))
+	case autoSeq:
+		id, err = s.NextSeq(explicit.Ms)
+	}
+
+	if err == nil {
+		err = s.Add(id, fields)
+	}
+
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	cmd := []string{"xadd", key}
+	if noMkStream {
+		cmd = append(cmd, "nomkstream")
+	}
+	if trim {
+		s.Trim(opts)
+		cmd = append(cmd, trimCommand(s)...)
+	}
+	client.propagateAs(append(append(cmd, id.String()), fields...))
+
+	signalKeyReady(client, key)
+	client.WriteBulkString(id.String())
+}
+
+// XTrim removes entries from the head of a stream
+// XTRIM key MAXLEN | MINID [= | ~] threshold [LIMIT count]
+func XTrim(client *Client, args []string) {
+	if strategy := strings.ToLower(args[1]); strategy != "maxlen" && strategy != "minid" {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	opts, i, err := parseTrim(args, 1)
+	if err == nil && i != len(args) {
+		err = &protocol.ErrSyntax{}
+	}
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	s, err := getStream(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	removed := 0
+	if s != nil {
+		removed = s.Trim(opts)
+	}
+
+	if removed == 0 {
+		client.propagateAs()
+	} else {
+		client.propagateAs(append([]string{"xtrim", args[0]}, trimCommand(s)...))
+	}
+
+	client.WriteInteger(removed)
+}
+
+// XDel removes entries from a stream
+// XDEL key id [id ...]
+func XDel(client *Client, args []string) {
+	ids := make([]stream.ID, len(args)-1)
+	for i, arg := range args[1:] {
+		id, err := parseStreamID(arg)
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+		ids[i] = id
+	}
+
+	s, err := getStream(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	removed := 0
+	if s != nil {
+		removed = s.Delete(ids)
+	}
+
+	if removed == 0 {
+		client.propagateAs()
+	}
+
+	client.WriteInteger(removed)
+}
+
+// XLen returns the number of entries of a stream
+// XLEN key
+func XLen(client *Client, args []string) {
+	s, err := getStream(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(s.Len())
+}
+
+// XRange returns the entries of a stream with IDs between start and end
+// XRANGE key start end [COUNT count]
+func XRange(client *Client, args []string) {
+	streamRange(client, args[0], args[1], args[2], args[3:], false)
+}
+
+// XRevRange returns the entries of a stream with IDs between end and start starting from the greatest ID
+// XREVRANGE key end start [COUNT count]
+func XRevRange(client *Client, args []string) {
+	streamRange(client, args[0], args[2], args[1], args[3:], true)
+}
+
+func streamRange(client *Client, key, startArg, endArg string, opts []string, reverse bool) {
+	start, err := parseRangeID(startArg, true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	end, err := parseRangeID(endArg, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	count := 0
+	switch {
+	case len(opts) == 2 && strings.ToLower(opts[0]) == "count":
+		if count, err = strconv.Atoi(opts[1]); err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[1]})
+			return
+		}
+
+		// a count which is not positive returns nothing
+		if count <= 0 {
+			client.WriteStringArray(nil)
+			return
+		}
+	case len(opts) != 0:
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteStringArray(nil)
+		return
+	}
+
+	client.WriteProtocolReply(entriesReply(s.Range(start, end, count, reverse)))
+}
+
+// XSetID sets the last ID of a stream
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func XSetID(client *Client, args []string) {
+	id, err := parseStreamID(args[1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	added := int64(-1)
+	var maxDeleted *stream.ID
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "entriesadded":
+			if added, err = strconv.ParseInt(args[i+1], 10, 64); err != nil || added < 0 {
+				client.WriteError(&protocol.ErrCastFailedToInt{Val: args[i+1]})
+				return
+			}
+		case "maxdeletedid":
+			v, err := parseStreamID(args[i+1])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			maxDeleted = &v
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	s, err := getStream(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errNoSuchKey})
+		return
+	}
+
+	if err := s.SetID(id, added, maxDeleted); err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteOK()
+}
+
+// parseBlockTimeout converts a timeout in milliseconds, 0 blocks forever
+func parseBlockTimeout(arg string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ms > math.MaxInt64/int64(time.Millisecond) {
+		return 0, &protocol.ErrGeneric{Err: errBlockNotInteger}
+	}
+
+	if ms < 0 {
+		return 0, &protocol.ErrGeneric{Err: errTimeoutNegative}
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// streamRead holds the arguments of XREAD and XREADGROUP
+type streamRead struct {
+	group, consumer string
+	count           int
+	block           bool
+	timeout         time.Duration
+	noAck           bool
+	keys            []string
+	ids             []string
+}
+
+// parseStreamRead parses [GROUP group consumer] [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...]
+// id [id ...], GROUP is required when group is true
+func parseStreamRead(cmd string, args []string, group bool) (*streamRead, error) {
+	r := &streamRead{}
+
+	i := 0
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		if opt == "streams" {
+			break
+		}
+
+		switch {
+		case opt == "group" && group && i+2 < len(args):
+			r.group, r.consumer = args[i+1], args[i+2]
+			i += 2
+		case opt == "count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, &protocol.ErrCastFailedToInt{Val: args[i+1]}
+			}
+			r.count = max(n, 0)
+			i++
+		case opt == "block" && i+1 < len(args):
+			timeout, err := parseBlockTimeout(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			r.block, r.timeout = true, timeout
+			i++
+		case opt == "noack" && group:
+			r.noAck = true
+		default:
+			return nil, &protocol.ErrSyntax{}
+		}
+	}
+
+	streams := args[min(i+1, len(args)):]
+	if i >= len(args) || len(streams) == 0 || len(streams)%2 != 0 {
+		return nil, &protocol.ErrGeneric{Err: fmt.Errorf("Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", cmd)}
+	}
+
+	if group && r.group == "" {
+		return nil, &protocol.ErrGeneric{Err: errors.New("Missing GROUP option for XREADGROUP")}
+	}
+
+	r.keys, r.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+	return r, nil
+}
+
+// streamReadKeys returns the keys of XREAD and XREADGROUP which follow STREAMS
+func streamReadKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.ToLower(arg) == "streams" {
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+
+	return nil
+}
+
+// streamReply writes the entries read from streams as an array of key and entries pairs or as a map for RESP3
+func streamReply(client *Client, keys []string, entries []protocol.Reply) {
+	if len(keys) == 0 {
+		client.WriteNilArray()
+		return
+	}
+
+	if client.Protocol == RESP3 {
+		fields := make([]protocol.Reply, 0, 2*len(keys))
+		for i, key := range keys {
+			fields = append(fields, resp2.NewBulkStringReply(false, key), entries[i])
+		}
+		client.WriteProtocolReply(mapReply(client, fields))
+		return
+	}
+
+	reps := make([]protocol.Reply, len(keys))
+	for i, key := range keys {
+		reps[i] = resp2.NewArrayReply(false, []protocol.Reply{resp2.NewBulkStringReply(false, key), entries[i]})
+	}
+	client.WriteProtocolReply(resp2.NewArrayReply(false, reps))
+}
+
+// XRead reads entries with IDs greater than the given ones from streams, blocking until one has new entries
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func XRead(client *Client, args []string) {
+	r, err := parseStreamRead("xread", args, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	// IDs are resolved once so $ only returns entries added after the command
+	after := make([]stream.ID, len(r.keys))
+	for i, key := range r.keys {
+		s, err := getStream(client, key, false)
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+
+		switch r.ids[i] {
+		case "$":
+			if s != nil {
+				after[i] = s.LastID()
+			}
+		case ">":
+			client.WriteError(&protocol.ErrGeneric{Err: errReadID})
+			return
+		default:
+			if after[i], err = parseStreamID(r.ids[i]); err != nil {
+				client.WriteError(err)
+				return
+			}
+		}
+	}
+
+	try := func(client *Client) bool {
+		var keys []string
+		var reps []protocol.Reply
+		for i, key := range r.keys {
+			s, err := getStream(client, key, false)
+			if err != nil || s == nil {
+				continue
+			}
+
+			start, ok := after[i].Next()
+			if !ok {
+				continue
+			}
+
+			if entries := s.Range(start, stream.MaxID, r.count, false); len(entries) > 0 {
+				keys = append(keys, key)
+				reps = append(reps, entriesReply(entries))
+			}
+		}
+
+		if len(keys) == 0 {
+			return false
+		}
+
+		streamReply(client, keys, reps)
+		return true
+	}
+
+	if !r.block {
+		if !try(client) {
+			client.WriteNilArray()
+		}
+		return
+	}
+
+	serveOrBlock(client, r.keys, r.timeout, try, (*Client).WriteNilArray)
+}
+
+// XReadGroup reads entries from streams for a consumer of a group, blocking until new entries are available when
+// only new entries are requested with >
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func XReadGroup(client *Client, args []string) {
+	r, err := parseStreamRead("xreadgroup", args, true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	history := make([]*stream.ID, len(r.keys))
+	for i, key := range r.keys {
+		s, err := getStream(client, key, false)
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+
+		if s == nil || !hasGroup(s, r.group) {
+			client.WriteError(protocol.ErrNoGroup{Key: key, Group: r.group})
+			return
+		}
+
+		switch r.ids[i] {
+		case ">":
+		case "$":
+			client.WriteError(&protocol.ErrGeneric{Err: errReadGroupID})
+			return
+		default:
+			id, err := parseStreamID(r.ids[i])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			history[i] = &id
+		}
+	}
+
+	try := func(client *Client) bool {
+		var keys []string
+		var reps []protocol.Reply
+		var cmds [][]string
+		now := client.now()
+
+		for i, key := range r.keys {
+			s, err := getStream(client, key, false)
+			if err == nil && s == nil {
+				err = stream.ErrNoGroup
+			}
+
+			var created bool
+			if err == nil {
+				created, err = s.CreateConsumer(r.group, r.consumer, now)
+			}
+
+			if err != nil {
+				client.propagateAs(cmds...)
+				client.WriteError(streamError(err, key, r.group))
+				return true
+			}
+
+			if created {
+				cmds = append(cmds, []string{"xgroup", "createconsumer", key, r.group, r.consumer})
+			}
+
+			// the history of the consumer is returned even when it is empty
+			if history[i] != nil {
+				entries, _ := s.ReadGroup(r.group, r.consumer, *history[i], true, r.count, false, now)
+				keys = append(keys, key)
+				reps = append(reps, entriesReply(entries))
+				continue
+			}
+
+			entries, _ := s.ReadGroup(r.group, r.consumer, stream.MinID, false, r.count, r.noAck, now)
+			if len(entries) == 0 {
+				continue
+			}
+
+			keys = append(keys, key)
+			reps = append(reps, entriesReply(entries))
+
+			if !r.noAck {
+				ids := make([]stream.ID, len(entries))
+				for j, entry := range entries {
+					ids[j] = entry.ID
+				}
+				cmds = append(cmds, claimCommands(s, key, r.group, ids)...)
+			}
+
+			if g, ok := groupInfo(s, r.group); ok {
+				cmds = append(cmds, []string{"xgroup", "setid", key, r.group, g.LastID.String(), "entriesread", strconv.FormatInt(g.EntriesRead, 10)})
+			}
+		}
+
+		client.propagateAs(cmds...)
+		if len(keys) == 0 {
+			return false
+		}
+
+		streamReply(client, keys, reps)
+		return true
+	}
+
+	if try(client) {
+		return
+	}
+
+	blocking := r.block
+	for _, id := range history {
+		blocking = blocking && id == nil
+	}
+
+	if !blocking || !client.canBlock() {
+		client.WriteNilArray()
+		return
+	}
+
+	// consumers created while trying are logged even though the client blocks
+	created := client.propagate
+	client.block(r.keys, r.timeout, try, (*Client).WriteNilArray)
+	client.propagateAs(created...)
+}
+
+// hasGroup reports whether a stream has a consumer group
+func hasGroup(s *stream.Stream, name string) bool {
+	_, ok := groupInfo(s, name)
+	return ok
+}
+
+// groupInfo returns the details of a consumer group
+func groupInfo(s *stream.Stream, name string) (stream.GroupInfo, bool) {
+	for _, g := range s.Groups(false) {
+		if g.Name == name {
+			return g, true
+		}
+	}
+
+	return stream.GroupInfo{}, false
+}
+
+// claimCommands returns XCLAIM commands which recreate the pending entries of ids as they are now
+func claimCommands(s *stream.Stream, key, group string, ids []stream.ID) [][]string {
+	var cmds [][]string
+	for _, id := range ids {
+		pending, err := s.PendingRange(group, id, id, 1, "", math.MinInt64, 0)
+		if err != nil || len(pending) == 0 {
+			continue
+		}
+
+		p := pending[0]
+		cmds = append(cmds, []string{"xclaim", key, group, p.Consumer, "0", id.String(),
+			"time", strconv.FormatInt(p.DeliveryTime, 10), "retrycount", strconv.FormatInt(p.DeliveryCount, 10), "force", "justid"})
+	}
+
+	return cmds
+}
+
+// parseEntriesRead parses the value of ENTRIESREAD
+func parseEntriesRead(arg string) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < -1 {
+		return 0, &protocol.ErrGeneric{Err: errEntriesRead}
+	}
+
+	return n, nil
+}
+
+// XGroup manages the consumer groups of a stream
+// XGROUP CREATE key group id | $ [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group id | $ [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func XGroup(client *Client, args []string) {
+	sub := strings.ToLower(args[0])
+	if len(args) < 3 {
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])})
+		return
+	}
+
+	key, group := args[1], args[2]
+	switch {
+	case (sub == "create" || sub == "setid") && len(args) >= 4:
+		xgroupSetID(client, sub == "create", key, group, args[3], args[4:])
+		return
+	case (sub == "destroy" && len(args) == 3) || ((sub == "createconsumer" || sub == "delconsumer") && len(args) == 4):
+	default:
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])})
+		return
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errGroupKeyMissing})
+		return
+	}
+
+	switch sub {
+	case "destroy":
+		if !s.DestroyGroup(group) {
+			client.propagateAs()
+			client.WriteInteger(0)
+			return
+		}
+
+		// consumers blocked on the group are answered with an error
+		signalKeyReady(client, key)
+		client.WriteInteger(1)
+	case "createconsumer":
+		created, err := s.CreateConsumer(group, args[3], client.now())
+		if err != nil {
+			client.WriteError(streamError(err, key, group))
+			return
+		}
+
+		if !created {
+			client.propagateAs()
+			client.WriteInteger(0)
+			return
+		}
+		client.WriteInteger(1)
+	default:
+		pending, err := s.DeleteConsumer(group, args[3])
+		if err != nil {
+			client.WriteError(streamError(err, key, group))
+			return
+		}
+
+		if pending < 0 {
+			client.propagateAs()
+			pending = 0
+		}
+		client.WriteInteger(pending)
+	}
+}
+
+func xgroupSetID(client *Client, create bool, key, group, idArg string, opts []string) {
+	mkStream := false
+	entriesRead := int64(-1)
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToLower(opts[i]); {
+		case opt == "mkstream" && create:
+			mkStream = true
+		case opt == "entriesread" && i+1 < len(opts):
+			n, err := parseEntriesRead(opts[i+1])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			entriesRead = n
+			i++
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	var id stream.ID
+	if idArg != "$" {
+		var err error
+		if id, err = parseStreamID(idArg); err != nil {
+			client.WriteError(err)
+			return
+		}
+	}
+
+	s, err := getStream(client, key, mkStream)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errGroupKeyMissing})
+		return
+	}
+
+	if idArg == "$" {
+		id = s.LastID()
+	}
+
+	if create {
+		err = s.CreateGroup(group, id, entriesRead)
+	} else {
+		err = s.SetGroupID(group, id, entriesRead)
+	}
+
+	if err != nil {
+		client.WriteError(streamError(err, key, group))
+		return
+	}
+
+	cmd := []string{"xgroup", "setid", key, group, id.String(), "entriesread", strconv.FormatInt(entriesRead, 10)}
+	if create {
+		cmd[1] = "create"
+		if mkStream {
+			cmd = append(cmd, "mkstream")
+		}
+	}
+	client.propagateAs(cmd)
+
+	client.WriteOK()
+}
+
+// XAck acknowledges pending entries of a consumer group
+// XACK key group id [id ...]
+func XAck(client *Client, args []string) {
+	ids := make([]stream.ID, len(args)-2)
+	for i, arg := range args[2:] {
+		id, err := parseStreamID(arg)
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+		ids[i] = id
+	}
+
+	s, err := getStream(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	acked := 0
+	if s != nil {
+		// a missing group has nothing to acknowledge
+		acked, _ = s.Ack(args[1], ids)
+	}
+
+	if acked == 0 {
+		client.propagateAs()
+	}
+
+	client.WriteInteger(acked)
+}
+
+// XPending inspects the pending entries of a consumer group
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func XPending(client *Client, args []string) {
+	key, group := args[0], args[1]
+	opts := args[2:]
+
+	minIdle := int64(0)
+	if len(opts) > 0 && strings.ToLower(opts[0]) == "idle" {
+		if len(opts) < 2 {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		n, err := strconv.ParseInt(opts[1], 10, 64)
+		if err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[1]})
+			return
+		}
+		minIdle, opts = n, opts[2:]
+
+		if len(opts) == 0 {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	if len(opts) != 0 && len(opts) != 3 && len(opts) != 4 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	var start, end stream.ID
+	var count int
+	consumer := ""
+	if len(opts) > 0 {
+		var err error
+		if start, err = parseRangeID(opts[0], true); err != nil {
+			client.WriteError(err)
+			return
+		}
+		if end, err = parseRangeID(opts[1], false); err != nil {
+			client.WriteError(err)
+			return
+		}
+		if count, err = strconv.Atoi(opts[2]); err != nil {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[2]})
+			return
+		}
+		if len(opts) == 4 {
+			consumer = opts[3]
+		}
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(protocol.ErrNoGroup{Key: key, Group: group})
+		return
+	}
+
+	if len(opts) > 0 {
+		now := client.now()
+		pending, err := s.PendingRange(group, start, end, count, consumer, minIdle, now)
+		if err != nil {
+			client.WriteError(streamError(err, key, group))
+			return
+		}
+
+		reps := make([]protocol.Reply, len(pending))
+		for i, p := range pending {
+			reps[i] = resp2.NewArrayReply(false, []protocol.Reply{
+				resp2.NewBulkStringReply(false, p.ID.String()),
+				resp2.NewBulkStringReply(false, p.Consumer),
+				resp2.NewIntegerReply(int(now - p.DeliveryTime)),
+				resp2.NewIntegerReply(int(p.DeliveryCount)),
+			})
+		}
+		client.WriteProtocolReply(resp2.NewArrayReply(false, reps))
+		return
+	}
+
+	summary, err := s.Pending(group)
+	if err != nil {
+		client.WriteError(streamError(err, key, group))
+		return
+	}
+
+	if summary.Count == 0 {
+		client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+			resp2.NewIntegerReply(0), resp2.NewBulkStringReply(true, ""), resp2.NewBulkStringReply(true, ""), resp2.NewArrayReply(true, nil),
+		}))
+		return
+	}
+
+	consumers := make([]protocol.Reply, len(summary.Consumers))
+	for i, c := range summary.Consumers {
+		consumers[i] = resp2.NewArrayReply(false, []protocol.Reply{
+			resp2.NewBulkStringReply(false, c.Name), resp2.NewBulkStringReply(false, strconv.Itoa(c.Pending)),
+		})
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+		resp2.NewIntegerReply(summary.Count),
+		resp2.NewBulkStringReply(false, summary.Min.String()),
+		resp2.NewBulkStringReply(false, summary.Max.String()),
+		resp2.NewArrayReply(false, consumers),
+	}))
+}
+
+// XClaim changes the owner of pending entries of a consumer group
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count]
+// [FORCE] [JUSTID] [LASTID lastid]
+func XClaim(client *Client, args []string) {
+	key, group, consumer := args[0], args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[3]})
+		return
+	}
+
+	// IDs are followed by the options
+	var ids []stream.ID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := stream.ParseID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		client.WriteError(&protocol.ErrGeneric{Err: stream.ErrInvalidID})
+		return
+	}
+
+	now := client.now()
+	opts := stream.ClaimOptions{RetryCount: -1}
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(args[i])
+		switch opt {
+		case "force":
+			opts.Force = true
+			continue
+		case "justid":
+			opts.JustID = true
+			continue
+		}
+
+		if i+1 >= len(args) {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		switch opt {
+		case "idle", "time", "retrycount":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				client.WriteError(&protocol.ErrCastFailedToInt{Val: args[i+1]})
+				return
+			}
+
+			switch opt {
+			case "idle":
+				opts.DeliveryTime = now - max(n, 0)
+			case "time":
+				opts.DeliveryTime = n
+			default:
+				opts.RetryCount = max(n, 0)
+			}
+		case "lastid":
+			id, err := parseStreamID(args[i+1])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			opts.LastID = &id
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+		i++
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(protocol.ErrNoGroup{Key: key, Group: group})
+		return
+	}
+
+	created, err := s.CreateConsumer(group, consumer, now)
+	if err != nil {
+		client.WriteError(streamError(err, key, group))
+		return
+	}
+
+	claimed, deleted, _ := s.Claim(group, consumer, max(minIdle, 0), ids, opts, now)
+	propagateClaims(client, s, key, group, consumer, created, claimed, deleted, opts.LastID != nil)
+
+	if opts.JustID {
+		client.WriteProtocolReply(idsReply(entryIDs(claimed)))
+		return
+	}
+	client.WriteProtocolReply(entriesReply(claimed))
+}
+
+// XAutoClaim claims pending entries of a consumer group which are idle for at least min-idle-time
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func XAutoClaim(client *Client, args []string) {
+	key, group, consumer := args[0], args[1], args[2]
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[3]})
+		return
+	}
+
+	start, err := parseRangeID(args[4], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	count, justID := 100, false
+	for i := 5; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "justid":
+			justID = true
+		case opt == "count" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 || n > math.MaxInt32/10 {
+				client.WriteError(&protocol.ErrGeneric{Err: errAutoClaimCount})
+				return
+			}
+			count = n
+			i++
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(protocol.ErrNoGroup{Key: key, Group: group})
+		return
+	}
+
+	now := client.now()
+	created, err := s.CreateConsumer(group, consumer, now)
+	if err != nil {
+		client.WriteError(streamError(err, key, group))
+		return
+	}
+
+	next, claimed, deleted, _ := s.AutoClaim(group, consumer, max(minIdle, 0), start, count, justID, now)
+	propagateClaims(client, s, key, group, consumer, created, claimed, deleted, false)
+
+	entries := entriesReply(claimed)
+	if justID {
+		entries = idsReply(entryIDs(claimed))
+	}
+
+	client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+		resp2.NewBulkStringReply(false, next.String()), entries, idsReply(deleted),
+	}))
+}
+
+func entryIDs(entries []stream.Entry) []stream.ID {
+	ids := make([]stream.ID, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+
+	return ids
+}
+
+// propagateClaims logs the changes of a claim as commands which do not depend on the time they are applied
+func propagateClaims(client *Client, s *stream.Stream, key, group, consumer string, created bool, claimed []stream.Entry, deleted []stream.ID, lastID bool) {
+	var cmds [][]string
+	if created {
+		cmds = append(cmds, []string{"xgroup", "createconsumer", key, group, consumer})
+	}
+
+	cmds = append(cmds, claimCommands(s, key, group, entryIDs(claimed))...)
+
+	if len(deleted) > 0 {
+		cmd := []string{"xack", key, group}
+		for _, id := range deleted {
+			cmd = append(cmd, id.String())
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	if g, ok := groupInfo(s, group); ok && lastID {
+		cmds = append(cmds, []string{"xgroup", "setid", key, group, g.LastID.String(), "entriesread", strconv.FormatInt(g.EntriesRead, 10)})
+	}
+
+	client.propagateAs(cmds...)
+}
+
+// XInfo inspects a stream, its consumer groups or the consumers of a group
+// XINFO STREAM key [FULL [COUNT count]]
+// XINFO GROUPS key
+// XINFO CONSUMERS key group
+func XInfo(client *Client, args []string) {
+	sub := strings.ToLower(args[0])
+	key := args[1]
+
+	switch {
+	case sub == "stream":
+	case sub == "groups" && len(args) == 2:
+	case sub == "consumers" && len(args) == 3:
+	default:
+		client.WriteError(&protocol.ErrGeneric{Err: fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", args[0])})
+		return
+	}
+
+	full, count := false, 10
+	if sub == "stream" {
+		opts := args[2:]
+		if len(opts) > 0 {
+			if strings.ToLower(opts[0]) != "full" || (len(opts) != 1 && len(opts) != 3) || (len(opts) == 3 && strings.ToLower(opts[1]) != "count") {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			full = true
+			if len(opts) == 3 {
+				n, err := strconv.Atoi(opts[2])
+				if err != nil {
+					client.WriteError(&protocol.ErrCastFailedToInt{Val: opts[2]})
+					return
+				}
+				count = max(n, 0)
+			}
+		}
+	}
+
+	s, err := getStream(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if s == nil {
+		client.WriteError(&protocol.ErrGeneric{Err: errNoSuchKey})
+		return
+	}
+
+	now := client.now()
+	switch sub {
+	case "stream":
+		client.WriteProtocolReply(streamInfoReply(client, s, full, count))
+	case "groups":
+		groups := s.Groups(false)
+		reps := make([]protocol.Reply, len(groups))
+		for i, g := range groups {
+			reps[i] = mapReply(client, []protocol.Reply{
+				resp2.NewBulkStringReply(false, "name"), resp2.NewBulkStringReply(false, g.Name),
+				resp2.NewBulkStringReply(false, "consumers"), resp2.NewIntegerReply(len(g.Consumers)),
+				resp2.NewBulkStringReply(false, "pending"), resp2.NewIntegerReply(g.Pending),
+				resp2.NewBulkStringReply(false, "last-delivered-id"), resp2.NewBulkStringReply(false, g.LastID.String()),
+				resp2.NewBulkStringReply(false, "entries-read"), unknownReply(g.EntriesRead),
+				resp2.NewBulkStringReply(false, "lag"), unknownReply(g.Lag),
+			})
+		}
+		client.WriteProtocolReply(resp2.NewArrayReply(false, reps))
+	default:
+		consumers, err := s.Consumers(args[2])
+		if err != nil {
+			client.WriteError(streamError(err, key, args[2]))
+			return
+		}
+
+		reps := make([]protocol.Reply, len(consumers))
+		for i, c := range consumers {
+			inactive := int64(-1)
+			if c.ActiveTime != -1 {
+				inactive = now - c.ActiveTime
+			}
+
+			reps[i] = mapReply(client, []protocol.Reply{
+				resp2.NewBulkStringReply(false, "name"), resp2.NewBulkStringReply(false, c.Name),
+				resp2.NewBulkStringReply(false, "pending"), resp2.NewIntegerReply(c.Pending),
+				resp2.NewBulkStringReply(false, "idle"), resp2.NewIntegerReply(int(now - c.SeenTime)),
+				resp2.NewBulkStringReply(false, "inactive"), resp2.NewIntegerReply(int(inactive)),
+			})
+		}
+		client.WriteProtocolReply(resp2.NewArrayReply(false, reps))
+	}
+}
+
+// unknownReply writes n or null when n is -1
+func unknownReply(n int64) protocol.Reply {
+	if n == -1 {
+		return resp2.NewBulkStringReply(true, "")
+	}
+
+	return resp2.NewIntegerReply(int(n))
+}
+
+// optionalEntryReply writes an entry or null when it is nil
+func optionalEntryReply(entry *stream.Entry) protocol.Reply {
+	if entry == nil {
+		return resp2.NewBulkStringReply(true, "")
+	}
+
+	return entryReply(*entry)
+}
+
+func streamInfoReply(client *Client, s *stream.Stream, full bool, count int) protocol.Reply {
+	info := s.Info()
+	fields := []protocol.Reply{
+		resp2.NewBulkStringReply(false, "length"), resp2.NewIntegerReply(info.Length),
+		resp2.NewBulkStringReply(false, "radix-tree-keys"), resp2.NewIntegerReply(info.Blocks),
+		resp2.NewBulkStringReply(false, "radix-tree-nodes"), resp2.NewIntegerReply(info.Nodes),
+		resp2.NewBulkStringReply(false, "last-generated-id"), resp2.NewBulkStringReply(false, info.LastID.String()),
+		resp2.NewBulkStringReply(false, "max-deleted-entry-id"), resp2.NewBulkStringReply(false, info.MaxDeletedID.String()),
+		resp2.NewBulkStringReply(false, "entries-added"), resp2.NewIntegerReply(int(info.EntriesAdded)),
+		resp2.NewBulkStringReply(false, "recorded-first-entry-id"), resp2.NewBulkStringReply(false, info.FirstID.String()),
+	}
+
+	if !full {
+		fields = append(fields,
+			resp2.NewBulkStringReply(false, "groups"), resp2.NewIntegerReply(info.Groups),
+			resp2.NewBulkStringReply(false, "first-entry"), optionalEntryReply(info.First),
+			resp2.NewBulkStringReply(false, "last-entry"), optionalEntryReply(info.Last),
+		)
+		return mapReply(client, fields)
+	}
+
+	groups := s.Groups(true)
+	groupReps := make([]protocol.Reply, len(groups))
+	for i, g := range groups {
+		pel := make([]protocol.Reply, 0, len(g.PEL))
+		consumerPEL := make(map[string][]protocol.Reply)
+		for _, p := range g.PEL {
+			pel = append(pel, resp2.NewArrayReply(false, []protocol.Reply{
+				resp2.NewBulkStringReply(false, p.ID.String()), resp2.NewBulkStringReply(false, p.Consumer),
+				resp2.NewIntegerReply(int(p.DeliveryTime)), resp2.NewIntegerReply(int(p.DeliveryCount)),
+			}))
+			consumerPEL[p.Consumer] = append(consumerPEL[p.Consumer], resp2.NewArrayReply(false, []protocol.Reply{
+				resp2.NewBulkStringReply(false, p.ID.String()),
+				resp2.NewIntegerReply(int(p.DeliveryTime)), resp2.NewIntegerReply(int(p.DeliveryCount)),
+			}))
+		}
+
+		consumers := make([]protocol.Reply, len(g.Consumers))
+		for j, c := range g.Consumers {
+			consumers[j] = mapReply(client, []protocol.Reply{
+				resp2.NewBulkStringReply(false, "name"), resp2.NewBulkStringReply(false, c.Name),
+				resp2.NewBulkStringReply(false, "seen-time"), resp2.NewIntegerReply(int(c.SeenTime)),
+				resp2.NewBulkStringReply(false, "active-time"), resp2.NewIntegerReply(int(c.ActiveTime)),
+				resp2.NewBulkStringReply(false, "pel-count"), resp2.NewIntegerReply(c.Pending),
+				resp2.NewBulkStringReply(false, "pending"), resp2.NewArrayReply(false, consumerPEL[c.Name]),
+			})
+		}
+
+		groupReps[i] = mapReply(client, []protocol.Reply{
+			resp2.NewBulkStringReply(false, "name"), resp2.NewBulkStringReply(false, g.Name),
+			resp2.NewBulkStringReply(false, "last-delivered-id"), resp2.NewBulkStringReply(false, g.LastID.String()),
+			resp2.NewBulkStringReply(false, "entries-read"), unknownReply(g.EntriesRead),
+			resp2.NewBulkStringReply(false, "lag"), unknownReply(g.Lag),
+			resp2.NewBulkStringReply(false, "pel-count"), resp2.NewIntegerReply(g.Pending),
+			resp2.NewBulkStringReply(false, "pending"), resp2.NewArrayReply(false, pel),
+			resp2.NewBulkStringReply(false, "consumers"), resp2.NewArrayReply(false, consumers),
+		})
+	}
+
+	fields = append(fields,
+		resp2.NewBulkStringReply(false, "entries"), entriesReply(s.Range(stream.MinID, stream.MaxID, count, false)),
+		resp2.NewBulkStringReply(false, "groups"), resp2.NewArrayReply(false, groupReps),
+	)
+	return mapReply(client, fields)
+}
@@ -0,0 +1,257 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// entry builds the reply of a stream entry with a single field
+func entry(id, field, value string) []interface{} {
+	return []interface{}{id, []interface{}{field, value}}
+}
+
+func TestXAddIDs(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Equal("1-1", c.do("xadd", "s", "1-1", "f", "v"))
+
+	// IDs must grow
+	assert.IsType(replyError(""), c.do("xadd", "s", "1-1", "f", "v"))
+	assert.IsType(replyError(""), c.do("xadd", "s", "1-0", "f", "v"))
+	assert.IsType(replyError(""), c.do("xadd", "s", "0-5", "f", "v"))
+
+	// the sequence is generated for a given time
+	assert.Equal("1-2", c.do("xadd", "s", "1-*", "f", "v"))
+	assert.Equal("2-0", c.do("xadd", "s", "2-*", "f", "v"))
+	assert.Equal("3-0", c.do("xadd", "s", "3", "f", "v"))
+	assert.IsType(replyError(""), c.do("xadd", "s", "2-*", "f", "v"))
+
+	id := c.do("xadd", "s", "*", "f", "v").(string)
+	ms, err := strconv.ParseInt(id[:len(id)-2], 10, 64)
+	assert.Nil(err)
+	assert.True(ms > 3)
+
+	// 0-0 is never a valid ID and an invalid ID does not create the stream
+	assert.IsType(replyError(""), c.do("xadd", "fresh", "0-0", "f", "v"))
+	assert.IsType(replyError(""), c.do("xadd", "fresh", "abc", "f", "v"))
+	assert.IsType(replyError(""), c.do("xadd", "fresh", "1-x", "f", "v"))
+	assert.Equal(0, c.do("exists", "fresh"))
+	assert.Equal("0-1", c.do("xadd", "fresh", "0-*", "f", "v"))
+
+	assert.IsType(replyError(""), c.do("xadd", "s", "*", "f"))
+	assert.IsType(replyError(""), c.do("xadd", "s", "*"))
+
+	assert.Nil(c.do("xadd", "missing", "nomkstream", "*", "f", "v"))
+	assert.Equal(0, c.do("exists", "missing"))
+
+	c.do("set", "str", "v")
+	assert.True(isWrongType(c.do("xadd", "str", "*", "f", "v")))
+}
+
+func TestXReadBlock(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("xadd", "s", "1-0", "f", "old")
+
+	reader := newTestConn(t)
+	reader.send("xread", "block", "0", "streams", "s", "$")
+	waitBlocked(t, c, 1)
+
+	assert.Equal("2-0", c.do("xadd", "s", "2-0", "f", "new"))
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{entry("2-0", "f", "new")}},
+	}, reader.read())
+	waitBlocked(t, c, 0)
+
+	// a missing stream is waited for as well
+	reader.send("xread", "count", "1", "block", "0", "streams", "other", "s", "0-0", "2-0")
+	waitBlocked(t, c, 1)
+
+	c.do("xadd", "other", "5-0", "f", "v")
+	assert.Equal([]interface{}{
+		[]interface{}{"other", []interface{}{entry("5-0", "f", "v")}},
+	}, reader.read())
+
+	// available entries are returned without blocking
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{entry("1-0", "f", "old")}},
+	}, reader.do("xread", "count", "1", "block", "0", "streams", "s", "0"))
+
+	start := time.Now()
+	assert.Nil(reader.do("xread", "block", "100", "streams", "s", "$"))
+	assert.True(time.Since(start) >= 100*time.Millisecond)
+
+	assert.IsType(replyError(""), reader.do("xread", "block", "-1", "streams", "s", "$"))
+	assert.IsType(replyError(""), reader.do("xread", "streams", "s", ">"))
+}
+
+func TestXReadGroup(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	for i := 1; i <= 3; i++ {
+		c.do("xadd", "s", strconv.Itoa(i)+"-0", "f", "v"+strconv.Itoa(i))
+	}
+
+	assert.IsType(replyError(""), c.do("xreadgroup", "group", "g", "alice", "streams", "s", ">"))
+	assert.Equal("OK", c.do("xgroup", "create", "s", "g", "0"))
+
+	// new entries are delivered and added to the PEL of the consumer
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{entry("1-0", "f", "v1"), entry("2-0", "f", "v2")}},
+	}, c.do("xreadgroup", "group", "g", "alice", "count", "2", "streams", "s", ">"))
+
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{entry("3-0", "f", "v3")}},
+	}, c.do("xreadgroup", "group", "g", "bob", "streams", "s", ">"))
+
+	assert.Nil(c.do("xreadgroup", "group", "g", "bob", "streams", "s", ">"))
+
+	assert.Equal([]interface{}{3, "1-0", "3-0", []interface{}{
+		[]interface{}{"alice", "2"}, []interface{}{"bob", "1"},
+	}}, c.do("xpending", "s", "g"))
+
+	// the history of a consumer comes from its PEL
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{entry("1-0", "f", "v1"), entry("2-0", "f", "v2")}},
+	}, c.do("xreadgroup", "group", "g", "alice", "streams", "s", "0"))
+
+	// acknowledged entries leave the PEL
+	assert.Equal(1, c.do("xack", "s", "g", "1-0"))
+	assert.Equal(0, c.do("xack", "s", "g", "1-0"))
+	assert.Equal(1, c.do("xack", "s", "g", "2-0", "9-0"))
+
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{}},
+	}, c.do("xreadgroup", "group", "g", "alice", "streams", "s", "0"))
+
+	pending := c.do("xpending", "s", "g", "-", "+", "10").([]interface{})
+	assert.Len(pending, 1)
+	assert.Equal("3-0", pending[0].([]interface{})[0])
+	assert.Equal("bob", pending[0].([]interface{})[1])
+	assert.Equal(1, pending[0].([]interface{})[3])
+
+	// deleted entries are reported as nil when read from the PEL
+	c.do("xdel", "s", "3-0")
+	assert.Equal([]interface{}{
+		[]interface{}{"s", []interface{}{[]interface{}{"3-0", nil}}},
+	}, c.do("xreadgroup", "group", "g", "bob", "streams", "s", "0"))
+
+	assert.Equal(0, c.do("xack", "s", "missing", "3-0"))
+	assert.Equal(0, c.do("xack", "nokey", "g", "3-0"))
+}
+
+func TestXClaim(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("xadd", "s", "1-0", "f", "v1")
+	c.do("xadd", "s", "2-0", "f", "v2")
+	c.do("xgroup", "create", "s", "g", "0")
+	c.do("xreadgroup", "group", "g", "alice", "streams", "s", ">")
+
+	// entries which were delivered recently are not claimed
+	assert.Equal([]interface{}{}, c.do("xclaim", "s", "g", "bob", "3600000", "1-0"))
+
+	assert.Equal([]interface{}{entry("1-0", "f", "v1")}, c.do("xclaim", "s", "g", "bob", "0", "1-0"))
+
+	pending := c.do("xpending", "s", "g", "-", "+", "10", "bob").([]interface{})
+	assert.Len(pending, 1)
+	assert.Equal([]interface{}{"1-0", "bob"}, pending[0].([]interface{})[:2])
+	assert.Equal(2, pending[0].([]interface{})[3])
+
+	// IDLE moves the last delivery to the past, JUSTID does not count a delivery
+	assert.Equal([]interface{}{"2-0"}, c.do("xclaim", "s", "g", "alice", "0", "2-0", "idle", "7200000", "justid"))
+	pending = c.do("xpending", "s", "g", "idle", "3600000", "-", "+", "10").([]interface{})
+	assert.Len(pending, 1)
+	assert.Equal("2-0", pending[0].([]interface{})[0])
+	assert.True(pending[0].([]interface{})[2].(int) >= 7200000)
+	assert.Equal(1, pending[0].([]interface{})[3])
+
+	// XAUTOCLAIM only claims entries idle for long enough
+	assert.Equal([]interface{}{"0-0", []interface{}{entry("2-0", "f", "v2")}, []interface{}{}},
+		c.do("xautoclaim", "s", "g", "bob", "3600000", "0-0"))
+	assert.Equal([]interface{}{"0-0", []interface{}{}, []interface{}{}},
+		c.do("xautoclaim", "s", "g", "alice", "3600000", "0-0"))
+
+	// the cursor continues after the last scanned entry
+	assert.Equal([]interface{}{"2-0", []interface{}{"1-0"}, []interface{}{}},
+		c.do("xautoclaim", "s", "g", "alice", "0", "0-0", "count", "1", "justid"))
+
+	// deleted entries are removed from the PEL
+	c.do("xdel", "s", "2-0")
+	assert.Equal([]interface{}{"0-0", []interface{}{}, []interface{}{"2-0"}},
+		c.do("xautoclaim", "s", "g", "alice", "0", "2-0"))
+	assert.Equal(1, c.do("xpending", "s", "g").([]interface{})[0])
+
+	assert.IsType(replyError(""), c.do("xautoclaim", "s", "g", "alice", "0", "0-0", "count", "0"))
+	assert.IsType(replyError(""), c.do("xclaim", "s", "missing", "alice", "0", "1-0"))
+	assert.IsType(replyError(""), c.do("xclaim", "s", "g", "alice", "x", "1-0"))
+}
+
+func TestXTrim(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	for i := 1; i <= 10; i++ {
+		c.do("xadd", "s", strconv.Itoa(i)+"-0", "f", "v")
+	}
+
+	assert.Equal(5, c.do("xtrim", "s", "maxlen", "5"))
+	assert.Equal(5, c.do("xlen", "s"))
+	assert.Equal(0, c.do("xtrim", "s", "maxlen", "=", "5"))
+
+	// entries with a smaller ID are removed
+	assert.Equal(2, c.do("xtrim", "s", "minid", "8"))
+	assert.Equal([]interface{}{entry("8-0", "f", "v")}, c.do("xrange", "s", "-", "+", "count", "1"))
+	assert.Equal(0, c.do("xtrim", "s", "minid", "1-0"))
+
+	// XADD trims after adding
+	assert.Equal("11-0", c.do("xadd", "s", "maxlen", "2", "11-0", "f", "v"))
+	assert.Equal(2, c.do("xlen", "s"))
+	assert.Equal("12-0", c.do("xadd", "s", "minid", "12", "12-0", "f", "v"))
+	assert.Equal(1, c.do("xlen", "s"))
+
+	assert.Equal(1, c.do("xtrim", "s", "maxlen", "0"))
+	assert.Equal(1, c.do("exists", "s"))
+	assert.Equal(0, c.do("xtrim", "missing", "maxlen", "0"))
+
+	assert.IsType(replyError(""), c.do("xtrim", "s", "maxlen", "-1"))
+	assert.IsType(replyError(""), c.do("xtrim", "s", "maxlen", "1", "limit", "10"))
+	assert.IsType(replyError(""), c.do("xtrim", "s", "minid", "x"))
+	assert.IsType(replyError(""), c.do("xtrim", "s", "size", "1"))
+}
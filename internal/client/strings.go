@@ -26,9 +26,10 @@
 package client
 
 import (
+	"fmt"
 	"strconv"
-
-	"github.com/kasvith/kache/internal/resp/resp2"
+	"strings"
+	"time"
 
 	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/protocol"
@@ -39,10 +40,7 @@ import (
 func Get(client *Client, args []string) {
 	val, err := client.Database.Get(args[0])
 	if err != nil {
-		switch client.Protocol {
-		case RESP2, RESP3:
-			client.WriteProtocolReply(resp2.NewBulkStringReply(true, ""))
-		}
+		client.WriteNil()
 		return
 	}
 
@@ -51,18 +49,306 @@ func Get(client *Client, args []string) {
 		return
 	}
 
-	// TODO handle RESP3 value also
-	client.WriteProtocolReply(resp2.NewBulkStringReply(false, util.ToString(val.Value)))
+	client.WriteBulkString(util.ToString(val.Value))
 }
 
 // Set will create a new string key value pair
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT timestamp | PXAT milliseconds-timestamp | KEEPTTL]
 func Set(client *Client, args []string) {
 	key := args[0]
 	val := args[1]
 
-	client.Database.Set(key, db.NewDataNode(db.TypeString, -1, val))
+	nx, xx, get, keepTTL := false, false, false, false
+	exp := int64(-1)
+	hasExp := false
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "get":
+			get = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if hasExp || i+1 >= len(args) {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			at, err := parseExpireOption("set", opt, args[i+1])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			exp, hasExp = at, true
+			i++
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	if (nx && xx) || (keepTTL && hasExp) {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	var prev *db.DataNode
+	var err error
+	written := false
+
+	client.Database.Compute(key, func(old *db.DataNode) (*db.DataNode, bool) {
+		prev = old
+		if get && old != nil && old.Type != db.TypeString {
+			err = &protocol.ErrWrongType{}
+			return nil, false
+		}
+
+		if (nx && old != nil) || (xx && old == nil) {
+			return nil, false
+		}
+
+		node := db.NewDataNode(db.TypeString, exp, val)
+		if keepTTL && old != nil {
+			node.ExpiresAt = old.ExpiresAt
+		}
+
+		written = true
+		return node, true
+	})
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	switch {
+	case !written:
+		client.propagateAs()
+	case hasExp:
+		// relative expirations are logged as absolute ones
+		client.propagateAs(setCommand(key, val, nx, xx, get, exp))
+	}
+
+	if get {
+		writeStringNode(client, prev)
+		return
+	}
+
+	if !written {
+		client.WriteNil()
+		return
+	}
+
+	client.WriteOK()
+}
+
+// setCommand builds a SET command with an absolute expiration
+func setCommand(key, val string, nx, xx, get bool, exp int64) []string {
+	cmd := []string{"set", key, val}
+	if nx {
+		cmd = append(cmd, "nx")
+	}
+	if xx {
+		cmd = append(cmd, "xx")
+	}
+	if get {
+		cmd = append(cmd, "get")
+	}
+
+	return append(cmd, "pxat", strconv.FormatInt(exp, 10))
+}
+
+// SetNX sets the key only if it does not exist
+func SetNX(client *Client, args []string) {
+	written := 0
+	client.Database.Compute(args[0], func(old *db.DataNode) (*db.DataNode, bool) {
+		if old != nil {
+			return nil, false
+		}
+
+		written = 1
+		return db.NewDataNode(db.TypeString, -1, args[1]), true
+	})
+
+	client.WriteInteger(written)
+}
+
+// SetEX sets the key with an expiration in seconds
+func SetEX(client *Client, args []string) {
+	setWithExpire(client, "setex", "ex", args)
+}
 
-	client.WriteProtocolReply(resp2.NewSimpleStringReply("OK"))
+// PSetEX sets the key with an expiration in milliseconds
+func PSetEX(client *Client, args []string) {
+	setWithExpire(client, "psetex", "px", args)
+}
+
+func setWithExpire(client *Client, cmd, opt string, args []string) {
+	exp, err := parseExpireOption(cmd, opt, args[1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	client.Database.Set(args[0], db.NewDataNode(db.TypeString, exp, args[2]))
+	client.propagateAs(setCommand(args[0], args[2], false, false, false, exp))
+	client.WriteOK()
+}
+
+// GetSet sets the key and returns the old value
+func GetSet(client *Client, args []string) {
+	var prev *db.DataNode
+	var err error
+
+	client.Database.Compute(args[0], func(old *db.DataNode) (*db.DataNode, bool) {
+		if old != nil && old.Type != db.TypeString {
+			err = &protocol.ErrWrongType{}
+			return nil, false
+		}
+
+		prev = old
+		return db.NewDataNode(db.TypeString, -1, args[1]), true
+	})
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	writeStringNode(client, prev)
+}
+
+// GetDel returns the value of the key and deletes it
+func GetDel(client *Client, args []string) {
+	var prev *db.DataNode
+	var err error
+
+	client.Database.Compute(args[0], func(old *db.DataNode) (*db.DataNode, bool) {
+		if old != nil && old.Type != db.TypeString {
+			err = &protocol.ErrWrongType{}
+			return nil, false
+		}
+
+		prev = old
+		return nil, old != nil
+	})
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	writeStringNode(client, prev)
+}
+
+// GetEX returns the value of the key and optionally sets or removes its expiration
+// GETEX key [EX seconds | PX milliseconds | EXAT timestamp | PXAT milliseconds-timestamp | PERSIST]
+func GetEX(client *Client, args []string) {
+	persist := false
+	exp := int64(-1)
+	hasExp := false
+
+	opts := args[1:]
+	switch {
+	case len(opts) == 1 && strings.ToLower(opts[0]) == "persist":
+		persist = true
+	case len(opts) == 2:
+		opt := strings.ToLower(opts[0])
+		if opt != "ex" && opt != "px" && opt != "exat" && opt != "pxat" {
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+
+		at, err := parseExpireOption("getex", opt, opts[1])
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+		exp, hasExp = at, true
+	case len(opts) != 0:
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	var prev *db.DataNode
+	var err error
+
+	client.Database.Compute(args[0], func(old *db.DataNode) (*db.DataNode, bool) {
+		if old != nil && old.Type != db.TypeString {
+			err = &protocol.ErrWrongType{}
+			return nil, false
+		}
+
+		prev = old
+		if old != nil && (persist || hasExp) {
+			old.SetExpiration(exp)
+		}
+
+		return nil, false
+	})
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	switch {
+	case prev == nil:
+		client.propagateAs()
+	case persist:
+		client.propagateAs([]string{"persist", args[0]})
+	case hasExp:
+		client.propagateAs([]string{"pexpireat", args[0], strconv.FormatInt(exp, 10)})
+	default:
+		client.propagateAs()
+	}
+
+	writeStringNode(client, prev)
+}
+
+// writeStringNode writes the value of a string node or nil when node is nil
+func writeStringNode(client *Client, node *db.DataNode) {
+	if node == nil {
+		client.WriteNil()
+		return
+	}
+
+	client.WriteBulkString(util.ToString(node.Value))
+}
+
+// parseExpireOption converts an EX, PX, EXAT or PXAT option to an expiration timestamp
+func parseExpireOption(cmd, opt, val string) (int64, error) {
+	v, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, &protocol.ErrCastFailedToInt{Val: val}
+	}
+
+	if v <= 0 {
+		return 0, &protocol.ErrGeneric{Err: fmt.Errorf("invalid expire time in '%s' command", cmd)}
+	}
+
+	var at int64
+	var ok bool
+	switch opt {
+	case "ex":
+		at, ok = toMillis(v, time.Second, false)
+	case "px":
+		at, ok = toMillis(v, time.Millisecond, false)
+	case "exat":
+		at, ok = toMillis(v, time.Second, true)
+	default:
+		at, ok = toMillis(v, time.Millisecond, true)
+	}
+
+	if !ok {
+		return 0, &protocol.ErrGeneric{Err: fmt.Errorf("invalid expire time in '%s' command", cmd)}
+	}
+
+	return at, nil
 }
 
 // Incr will increment a given string key by 1
@@ -80,34 +366,41 @@ func Decr(client *Client, args []string) {
 }
 
 // accumulateBy will accumulate the value of key by given amount
+// The expiration of the key is preserved
 func accumulateBy(client *Client, key string, v int, incr bool) {
-	val, found := client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeString, -1, strconv.Itoa(v)))
+	var n int
+	var err error
 
-	if !found {
-		client.WriteInteger(v)
-		return
-	}
+	client.Database.Compute(key, func(old *db.DataNode) (*db.DataNode, bool) {
+		if old == nil {
+			n = v
+			return db.NewDataNode(db.TypeString, -1, strconv.Itoa(n)), true
+		}
 
-	if val.Type != db.TypeString {
-		client.WriteProtocolReply(resp2.NewErrorReply(&protocol.ErrWrongType{}))
-		return
-	}
+		if old.Type != db.TypeString {
+			err = &protocol.ErrWrongType{}
+			return nil, false
+		}
 
-	i, err := strconv.Atoi(util.ToString(val.Value))
+		i, convErr := strconv.Atoi(util.ToString(old.Value))
+		if convErr != nil {
+			err = &protocol.ErrCastFailedToInt{Val: old.Value}
+			return nil, false
+		}
+
+		if incr {
+			n = i + v
+		} else {
+			n = i - v
+		}
+
+		return db.NewDataNode(db.TypeString, old.Expiration(), strconv.Itoa(n)), true
+	})
 
 	if err != nil {
-		client.WriteError(&protocol.ErrCastFailedToInt{Val: val.Value})
+		client.WriteError(err)
 		return
 	}
 
-	var n int
-	if incr {
-		n = i + v
-	} else {
-		n = i - v
-	}
-
-	client.Database.Set(key, db.NewDataNode(db.TypeString, -1, strconv.Itoa(n)))
-
 	client.WriteInteger(n)
 }
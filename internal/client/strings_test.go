@@ -0,0 +1,172 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// anyError matches any error reply in table tests
+const anyError = replyError("*")
+
+// assertReply compares a reply with the expected one, anyError matches every error reply
+func assertReply(t *testing.T, expected, actual interface{}, msgAndArgs ...interface{}) {
+	t.Helper()
+
+	if expected == anyError {
+		testifyAssert.IsType(t, replyError(""), actual, msgAndArgs...)
+		return
+	}
+
+	testifyAssert.Equal(t, expected, actual, msgAndArgs...)
+}
+
+func TestSetOptions(t *testing.T) {
+	c := newTestConn(t)
+
+	tests := []struct {
+		name   string
+		setup  [][]string
+		args   []string
+		expect interface{}
+		value  interface{}
+	}{
+		{name: "plain", args: []string{"set", "k", "v"}, expect: "OK", value: "v"},
+		{name: "nx missing", args: []string{"set", "k", "v", "nx"}, expect: "OK", value: "v"},
+		{name: "nx existing", setup: [][]string{{"set", "k", "old"}}, args: []string{"set", "k", "v", "NX"}, expect: nil, value: "old"},
+		{name: "xx missing", args: []string{"set", "k", "v", "xx"}, expect: nil, value: nil},
+		{name: "xx existing", setup: [][]string{{"set", "k", "old"}}, args: []string{"set", "k", "v", "xx"}, expect: "OK", value: "v"},
+		{name: "get missing", args: []string{"set", "k", "v", "get"}, expect: nil, value: "v"},
+		{name: "get existing", setup: [][]string{{"set", "k", "old"}}, args: []string{"set", "k", "v", "get"}, expect: "old", value: "v"},
+		{name: "get wrong type", setup: [][]string{{"lpush", "k", "a"}}, args: []string{"set", "k", "v", "get"}, expect: anyError},
+		{name: "nx get existing", setup: [][]string{{"set", "k", "old"}}, args: []string{"set", "k", "v", "nx", "get"}, expect: "old", value: "old"},
+		{name: "overwrites other types", setup: [][]string{{"lpush", "k", "a"}}, args: []string{"set", "k", "v"}, expect: "OK", value: "v"},
+		{name: "nx and xx", args: []string{"set", "k", "v", "nx", "xx"}, expect: anyError, value: nil},
+		{name: "ex and px", args: []string{"set", "k", "v", "ex", "10", "px", "100"}, expect: anyError, value: nil},
+		{name: "ex twice", args: []string{"set", "k", "v", "ex", "10", "ex", "10"}, expect: anyError, value: nil},
+		{name: "keepttl and ex", args: []string{"set", "k", "v", "keepttl", "ex", "10"}, expect: anyError, value: nil},
+		{name: "pxat and keepttl", args: []string{"set", "k", "v", "pxat", "1", "keepttl"}, expect: anyError, value: nil},
+		{name: "missing expire value", args: []string{"set", "k", "v", "ex"}, expect: anyError, value: nil},
+		{name: "zero expire", args: []string{"set", "k", "v", "ex", "0"}, expect: anyError, value: nil},
+		{name: "negative expire", args: []string{"set", "k", "v", "px", "-1"}, expect: anyError, value: nil},
+		{name: "not an integer", args: []string{"set", "k", "v", "ex", "1.5"}, expect: anyError, value: nil},
+		{name: "overflowing ex", args: []string{"set", "k", "v", "ex", "9223372036854775807"}, expect: anyError, value: nil},
+		{name: "overflowing exat", args: []string{"set", "k", "v", "exat", "9223372036854776"}, expect: anyError, value: nil},
+		{name: "past pxat", args: []string{"set", "k", "v", "pxat", "1"}, expect: "OK", value: nil},
+		{name: "unknown option", args: []string{"set", "k", "v", "forever"}, expect: anyError, value: nil},
+	}
+
+	for _, test := range tests {
+		c.flushAll()
+		for _, cmd := range test.setup {
+			c.do(cmd...)
+		}
+
+		assertReply(t, test.expect, c.do(test.args...), test.name)
+		if test.expect != anyError || test.setup == nil {
+			assertReply(t, test.value, c.do("get", "k"), test.name)
+		}
+	}
+}
+
+func TestSetExpireUnits(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	now := time.Now()
+	tests := []struct {
+		opt string
+		val int64
+	}{
+		{"ex", 100},
+		{"px", 100000},
+		{"exat", now.Unix() + 100},
+		{"pxat", now.UnixNano()/int64(time.Millisecond) + 100000},
+	}
+
+	for _, test := range tests {
+		assert.Equal("OK", c.do("set", "k", "v", test.opt, strconv.FormatInt(test.val, 10)), test.opt)
+
+		ttl := c.do("pttl", "k").(int)
+		assert.InDelta(100000, ttl, 2000, test.opt)
+	}
+
+	// absolute expirations are kept as they are
+	at := strconv.FormatInt(now.Unix()+1000, 10)
+	c.do("set", "k", "v", "exat", at)
+	assert.Equal(at, strconv.Itoa(c.do("expiretime", "k").(int)))
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "k", "v", "ex", "100")
+	assert.Equal("OK", c.do("set", "k", "w", "keepttl"))
+	assert.InDelta(100, c.do("ttl", "k"), 1)
+
+	// a plain SET removes the expiration
+	assert.Equal("OK", c.do("set", "k", "w"))
+	assert.Equal(-1, c.do("ttl", "k"))
+}
+
+func TestSetVariants(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Equal(1, c.do("setnx", "k", "v"))
+	assert.Equal(0, c.do("setnx", "k", "w"))
+	assert.Equal("v", c.do("get", "k"))
+
+	assert.Equal("OK", c.do("setex", "k", "100", "w"))
+	assert.InDelta(100, c.do("ttl", "k"), 1)
+	assert.IsType(replyError(""), c.do("setex", "k", "0", "w"))
+	assert.IsType(replyError(""), c.do("psetex", "k", "-5", "w"))
+
+	assert.Equal("w", c.do("getset", "k", "x"))
+	assert.Equal(-1, c.do("ttl", "k"))
+
+	assert.Equal("x", c.do("getex", "k", "px", "100000"))
+	assert.InDelta(100000, c.do("pttl", "k"), 2000)
+	assert.Equal("x", c.do("getex", "k", "persist"))
+	assert.Equal(-1, c.do("ttl", "k"))
+	assert.IsType(replyError(""), c.do("getex", "k", "persist", "ex", "10"))
+
+	assert.Equal("x", c.do("getdel", "k"))
+	assert.Nil(c.do("getdel", "k"))
+	assert.Equal(0, c.do("exists", "k"))
+
+	c.do("lpush", "l", "a")
+	assert.IsType(replyError(""), c.do("getset", "l", "x"))
+	assert.IsType(replyError(""), c.do("getdel", "l"))
+}
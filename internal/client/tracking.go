@@ -0,0 +1,396 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+)
+
+// trackingChannel is the channel invalidations are sent on to RESP2 clients, redirected clients subscribe to it
+const trackingChannel = "__redis__:invalidate"
+
+var (
+	errTrackingNotAllowed = errors.New("tracking is not allowed in this context")
+	errTrackingResp2      = errors.New("RESP2 clients can only track keys with REDIRECT, use HELLO 3 to receive invalidations as push frames")
+	errRedirectNotFound   = errors.New("The client ID you want redirect to does not exist")
+	errPrefixWithoutBcast = errors.New("PREFIX option requires BCAST mode to be enabled")
+	errOptInAndOptOut     = errors.New("You can't use both OPTIN and OPTOUT")
+	errOptInWithBcast     = errors.New("OPTIN and OPTOUT are not compatible with BCAST")
+	errSwitchBcast        = errors.New("You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode")
+	errSwitchOptIn        = errors.New("You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode")
+	errCachingMode        = errors.New("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	errCachingYes         = errors.New("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode")
+	errCachingNo          = errors.New("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode")
+)
+
+// tracker holds the tracking options of a client, they are not changed once it is registered
+type tracker struct {
+	client *Client
+
+	// redirect receives the invalidations instead of the client when it is set
+	redirect *Client
+
+	bcast, optin, optout, noloop bool
+
+	// prefixes limit the keys broadcast to the client, every key is sent when there are none
+	prefixes []string
+}
+
+var (
+	// trackers holds the clients with tracking enabled by ID
+	trackers = make(map[int64]*tracker)
+
+	// broadcasters holds the trackers in BCAST mode by ID
+	broadcasters = make(map[int64]*tracker)
+
+	// trackedKeys holds the IDs of clients which read a key, keys of all databases share it like a client side cache
+	trackedKeys = make(map[string]map[int64]struct{})
+
+	// trackingMux guards the trackers and the tracked keys, it is taken after the key space lock
+	trackingMux sync.Mutex
+
+	// observeTracking registers the observers which invalidate expired and flushed keys once tracking is enabled
+	observeTracking sync.Once
+)
+
+// matches reports whether a key is broadcast to the tracker
+func (t *tracker) matches(key string) bool {
+	if len(t.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range t.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// invalidate sends keys to the client or the client it redirects to, nil keys invalidate everything
+// Caller must hold trackingMux
+func (t *tracker) invalidate(keys []string) {
+	data := make([]protocol.Reply, len(keys))
+	for i, key := range keys {
+		data[i] = resp2.NewBulkStringReply(false, key)
+	}
+	reply := resp2.NewPushReply("invalidate", trackingChannel, resp2.NewArrayReply(keys == nil, data))
+
+	// RESP2 connections can not tell a push from a reply, e.g. after HELLO 2 they are sent nothing
+	if t.redirect == nil {
+		t.client.subscriber.pushFrame(reply)
+		return
+	}
+
+	// the client redirected to receives invalidations while it is subscribed to the tracking channel
+	pubsubMux.RLock()
+	_, subscribed := channels[trackingChannel][t.redirect]
+	pubsubMux.RUnlock()
+
+	if subscribed {
+		t.redirect.subscriber.pushReply(reply)
+	}
+}
+
+// trackRead remembers the keys read by a command for a client tracking in the default mode
+// Caller must hold the key space lock, so a later change of the keys invalidates them
+func (client *Client) trackRead(command *Command, args []string) {
+	t := client.tracking
+	if t == nil || t.bcast || (t.optin && client.caching != "yes") || (t.optout && client.caching == "no") {
+		return
+	}
+
+	keys := command.Keys(args)
+	if len(keys) == 0 {
+		return
+	}
+
+	trackingMux.Lock()
+	defer trackingMux.Unlock()
+
+	for _, key := range keys {
+		if trackedKeys[key] == nil {
+			trackedKeys[key] = make(map[int64]struct{})
+		}
+		trackedKeys[key][client.ID] = struct{}{}
+	}
+}
+
+// invalidateEntries invalidates the keys changed by entries, clients in NOLOOP mode are not sent the keys they changed
+// Caller must hold the key space lock
+func invalidateEntries(client *Client, entries []persistence.Entry) {
+	if len(entries) == 0 || trackingClients() == 0 {
+		return
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		command, err := GetCommand(strings.ToLower(entry.Args[0]))
+		if err != nil {
+			continue
+		}
+
+		keys = append(keys, command.Keys(entry.Args[1:])...)
+	}
+
+	invalidateKeys(client, keys)
+}
+
+// invalidateKeys sends changed keys to the clients which read them and the clients broadcast to
+// Clients tracking keys in the default mode have to read a key again to receive its next invalidation
+func invalidateKeys(changer *Client, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	trackingMux.Lock()
+	defer trackingMux.Unlock()
+
+	if len(trackers) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		for id := range trackedKeys[key] {
+			if t, ok := trackers[id]; ok && !t.bcast && !(t.noloop && t.client == changer) {
+				t.invalidate([]string{key})
+			}
+		}
+		delete(trackedKeys, key)
+
+		for _, t := range broadcasters {
+			if t.matches(key) && !(t.noloop && t.client == changer) {
+				t.invalidate([]string{key})
+			}
+		}
+	}
+}
+
+// invalidateAll tells every tracking client to drop its cache, e.g. when a database is flushed
+func invalidateAll() {
+	trackingMux.Lock()
+	defer trackingMux.Unlock()
+
+	trackedKeys = make(map[string]map[int64]struct{})
+	for _, t := range trackers {
+		t.invalidate(nil)
+	}
+}
+
+// invalidateEvent invalidates keys which were removed without a command changing them
+func invalidateEvent(event db.Event) {
+	switch event.Kind {
+	case db.EventExpire:
+		invalidateKeys(nil, []string{event.Key})
+	case db.EventFlush:
+		invalidateAll()
+	}
+}
+
+// untrack disables tracking for client, its keys are forgotten once they are invalidated
+func (client *Client) untrack() {
+	if client.tracking == nil {
+		return
+	}
+
+	trackingMux.Lock()
+	delete(trackers, client.ID)
+	delete(broadcasters, client.ID)
+	trackingMux.Unlock()
+
+	client.tracking = nil
+	client.caching = ""
+}
+
+// trackingClients returns the number of clients with tracking enabled
+func trackingClients() int {
+	trackingMux.Lock()
+	defer trackingMux.Unlock()
+
+	return len(trackers)
+}
+
+// clientTracking enables or disables tracking the keys cached by client
+// CLIENT TRACKING ON | OFF [REDIRECT id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(client *Client, args []string) {
+	on := false
+	switch strings.ToLower(args[0]) {
+	case "on":
+		on = true
+	case "off":
+	default:
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	t := &tracker{client: client}
+	for i := 1; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); {
+		case opt == "redirect" && i+1 < len(args):
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				client.WriteError(&protocol.ErrCastFailedToInt{Val: args[i+1]})
+				return
+			}
+
+			target, found := ConnectedClients.Get(id)
+			if !found {
+				client.WriteError(&protocol.ErrGeneric{Err: errRedirectNotFound})
+				return
+			}
+			t.redirect = target
+			i++
+		case opt == "prefix" && i+1 < len(args):
+			t.prefixes = append(t.prefixes, args[i+1])
+			i++
+		case opt == "bcast":
+			t.bcast = true
+		case opt == "optin":
+			t.optin = true
+		case opt == "optout":
+			t.optout = true
+		case opt == "noloop":
+			t.noloop = true
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	if !on {
+		client.untrack()
+		client.WriteOK()
+		return
+	}
+
+	if err := client.track(t); err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteOK()
+}
+
+// track enables tracking with the options of t, prefixes are added to the ones of an enabled tracking
+func (client *Client) track(t *tracker) error {
+	if client.Connection == nil {
+		return errTrackingNotAllowed
+	} else if t.redirect == nil && client.Protocol != RESP3 {
+		return errTrackingResp2
+	} else if len(t.prefixes) > 0 && !t.bcast {
+		return errPrefixWithoutBcast
+	} else if t.optin && t.optout {
+		return errOptInAndOptOut
+	} else if t.bcast && (t.optin || t.optout) {
+		return errOptInWithBcast
+	}
+
+	var prefixes []string
+	if old := client.tracking; old != nil {
+		if old.bcast != t.bcast {
+			return errSwitchBcast
+		} else if old.optin != t.optin || old.optout != t.optout {
+			return errSwitchOptIn
+		}
+		prefixes = append([]string(nil), old.prefixes...)
+	}
+
+	for _, prefix := range t.prefixes {
+		duplicate := false
+		for _, p := range prefixes {
+			if p == prefix {
+				duplicate = true
+			} else if strings.HasPrefix(p, prefix) || strings.HasPrefix(prefix, p) {
+				return fmt.Errorf("Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", prefix, p)
+			}
+		}
+
+		if !duplicate {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	t.prefixes = prefixes
+
+	// invalidations are written by the clients changing keys, the subscriber keeps them apart from replies
+	if t.redirect == nil && client.subscriber == nil {
+		client.subscriber = newSubscriber(client)
+	}
+
+	observeTracking.Do(func() {
+		for _, database := range databases {
+			database.Observe(invalidateEvent)
+		}
+	})
+
+	trackingMux.Lock()
+	trackers[client.ID] = t
+	delete(broadcasters, client.ID)
+	if t.bcast {
+		broadcasters[client.ID] = t
+	}
+	trackingMux.Unlock()
+
+	client.tracking = t
+	return nil
+}
+
+// clientCaching decides whether the keys read by the next command are tracked in the OPTIN or OPTOUT mode
+// CLIENT CACHING YES | NO
+func clientCaching(client *Client, arg string) {
+	t := client.tracking
+	if t == nil || (!t.optin && !t.optout) {
+		client.WriteError(&protocol.ErrGeneric{Err: errCachingMode})
+		return
+	}
+
+	switch caching := strings.ToLower(arg); {
+	case caching == "yes" && t.optin:
+		client.caching = caching
+	case caching == "yes":
+		client.WriteError(&protocol.ErrGeneric{Err: errCachingYes})
+		return
+	case caching == "no" && t.optout:
+		client.caching = caching
+	case caching == "no":
+		client.WriteError(&protocol.ErrGeneric{Err: errCachingNo})
+		return
+	default:
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	client.WriteOK()
+}
@@ -0,0 +1,225 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kasvith/kache/internal/resp/resp3"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// newTrackingConn connects a RESP3 client and enables tracking with opts
+func newTrackingConn(t *testing.T, opts ...string) *testConn {
+	t.Helper()
+
+	c := newTestConn(t)
+	c.do("hello", "3")
+	if reply := c.do(append([]string{"client", "tracking", "on"}, opts...)...); reply != "OK" {
+		t.Fatalf("CLIENT TRACKING replied with %v", reply)
+	}
+
+	return c
+}
+
+// expectInvalidation reads the next frame which must be an invalidation of keys, nil keys invalidate everything
+func expectInvalidation(c *testConn, keys ...interface{}) {
+	c.t.Helper()
+
+	kind, reply := c.readFrame()
+	if kind != resp3.Resp3Push {
+		c.t.Fatalf("expected an invalidation, got %v", reply)
+	}
+
+	var data interface{}
+	if keys != nil {
+		data = keys
+	}
+	testifyAssert.Equal(c.t, []interface{}{"invalidate", data}, reply)
+}
+
+// expectNoInvalidation checks that no invalidation was sent before the reply of a PING
+func expectNoInvalidation(c *testConn) {
+	c.t.Helper()
+
+	c.send("ping")
+	if kind, reply := c.readFrame(); kind != resp3.Resp3SimpleString || reply != "PONG" {
+		c.t.Fatalf("expected PONG, got %v", reply)
+	}
+}
+
+func TestTracking(t *testing.T) {
+	assert := testifyAssert.New(t)
+	other := newTestConn(t)
+	other.flushAll()
+	c := newTrackingConn(t)
+
+	other.do("set", "k", "v")
+	other.do("set", "untracked", "v")
+	assert.Equal("v", c.do("get", "k"))
+
+	other.do("set", "untracked", "w")
+	other.do("set", "k", "w")
+	expectInvalidation(c, "k")
+
+	// the key has to be read again to be invalidated again
+	other.do("set", "k", "x")
+	expectNoInvalidation(c)
+	assert.Equal("x", c.do("get", "k"))
+	other.do("del", "k")
+	expectInvalidation(c, "k")
+
+	// the client changing a key it read is sent the invalidation too
+	c.do("get", "k")
+	c.do("set", "k", "mine")
+	expectInvalidation(c, "k")
+	assert.Equal("OK", c.do("client", "tracking", "off"))
+	other.do("set", "k", "off")
+	expectNoInvalidation(c)
+}
+
+func TestTrackingResp2(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	other := newTestConn(t)
+	other.flushAll()
+
+	// RESP2 has no push type, so invalidations are only sent to a subscribed client
+	assert.IsType(replyError(""), c.do("client", "tracking", "on"))
+
+	sub := newTestConn(t)
+	id := strconv.Itoa(sub.do("client", "id").(int))
+	sub.do("subscribe", trackingChannel)
+	assert.Equal("OK", c.do("client", "tracking", "on", "redirect", id))
+
+	c.do("get", "k")
+	other.do("set", "k", "v")
+	assert.Equal([]interface{}{"message", trackingChannel, []interface{}{"k"}}, sub.read())
+
+	// the replies of the tracking client are not mixed with invalidations
+	assert.Equal("PONG", c.do("ping"))
+	assert.Equal("v", c.do("get", "k"))
+	assert.IsType(replyError(""), c.do("client", "tracking", "on", "redirect", "999999"))
+}
+
+func TestTrackingBcast(t *testing.T) {
+	assert := testifyAssert.New(t)
+	other := newTestConn(t)
+	other.flushAll()
+
+	assert.IsType(replyError(""), newTestConn(t).do("client", "tracking", "on", "prefix", "a"))
+
+	c := newTrackingConn(t, "bcast", "prefix", "a", "prefix", "user:")
+	assert.IsType(replyError(""), c.do("client", "tracking", "on", "bcast", "prefix", "u"))
+	assert.IsType(replyError(""), c.do("client", "tracking", "on"))
+
+	// keys are sent without being read when they match a prefix
+	other.do("set", "a1", "v")
+	expectInvalidation(c, "a1")
+	other.do("set", "user:1", "v")
+	expectInvalidation(c, "user:1")
+	other.do("set", "b1", "v")
+	expectNoInvalidation(c)
+
+	all := newTrackingConn(t, "bcast")
+	other.do("set", "b1", "w")
+	expectInvalidation(all, "b1")
+}
+
+func TestTrackingOptInOptOut(t *testing.T) {
+	assert := testifyAssert.New(t)
+	other := newTestConn(t)
+	other.flushAll()
+	other.do("set", "k", "v")
+
+	assert.IsType(replyError(""), newTrackingConn(t).do("client", "caching", "yes"))
+	assert.IsType(replyError(""), newTestConn(t).do("client", "tracking", "on", "optin", "optout"))
+
+	optin := newTrackingConn(t, "optin")
+	assert.IsType(replyError(""), optin.do("client", "caching", "no"))
+	optin.do("get", "k")
+	other.do("set", "k", "w")
+	expectNoInvalidation(optin)
+
+	// CLIENT CACHING YES only applies to the next command
+	assert.Equal("OK", optin.do("client", "caching", "yes"))
+	optin.do("get", "k")
+	optin.do("get", "other")
+	other.do("set", "other", "v")
+	other.do("set", "k", "x")
+	expectInvalidation(optin, "k")
+
+	optout := newTrackingConn(t, "optout")
+	assert.IsType(replyError(""), optout.do("client", "caching", "yes"))
+	assert.Equal("OK", optout.do("client", "caching", "no"))
+	optout.do("get", "k")
+	optout.do("get", "other")
+	other.do("set", "k", "y")
+	other.do("set", "other", "w")
+	expectInvalidation(optout, "other")
+}
+
+func TestTrackingNoLoop(t *testing.T) {
+	other := newTestConn(t)
+	other.flushAll()
+	c := newTrackingConn(t, "noloop")
+
+	c.do("get", "k")
+	c.do("set", "k", "mine")
+	expectNoInvalidation(c)
+
+	c.do("get", "k")
+	other.do("set", "k", "theirs")
+	expectInvalidation(c, "k")
+
+	bcast := newTrackingConn(t, "bcast", "noloop")
+	bcast.do("set", "k", "mine")
+	expectNoInvalidation(bcast)
+	other.do("set", "k", "theirs")
+	expectInvalidation(bcast, "k")
+}
+
+func TestTrackingExpireAndFlush(t *testing.T) {
+	other := newTestConn(t)
+	other.flushAll()
+	c := newTrackingConn(t)
+
+	other.do("set", "e", "v", "px", "20")
+	c.do("get", "e")
+	time.Sleep(50 * time.Millisecond)
+
+	// the key is removed once it is accessed
+	other.do("get", "e")
+	expectInvalidation(c, "e")
+
+	other.do("set", "k", "v")
+	c.do("get", "k")
+	other.do("flushdb")
+	expectInvalidation(c)
+}
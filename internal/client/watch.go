@@ -0,0 +1,209 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/protocol"
+)
+
+var (
+	errWatchInMulti = errors.New("WATCH inside MULTI is not allowed")
+	errNestedMulti  = errors.New("MULTI calls can not be nested")
+	errDiscard      = errors.New("DISCARD without MULTI")
+)
+
+var (
+	// watchedKeys holds the clients watching a key
+	watchedKeys = make(map[blockKey]map[*Client]struct{})
+
+	// watchMux guards the watched keys and the watch state of clients, it is taken after the key space lock
+	watchMux sync.Mutex
+)
+
+// watch adds keys of the selected database to the keys watched by client
+// existed records whether a key existed, a key which expires before EXEC aborts the transaction
+func (client *Client) watch(keys []string) {
+	watchMux.Lock()
+	defer watchMux.Unlock()
+
+	if client.watched == nil {
+		client.watched = make(map[blockKey]bool)
+	}
+
+	for _, key := range keys {
+		k := blockKey{db: client.DatabaseIndex, key: key}
+		if _, ok := client.watched[k]; ok {
+			continue
+		}
+
+		client.watched[k] = client.Database.Exists(key) == 1
+		if watchedKeys[k] == nil {
+			watchedKeys[k] = make(map[*Client]struct{})
+		}
+		watchedKeys[k][client] = struct{}{}
+	}
+}
+
+// unwatch forgets all keys watched by client
+func (client *Client) unwatch() {
+	watchMux.Lock()
+	defer watchMux.Unlock()
+
+	for k := range client.watched {
+		delete(watchedKeys[k], client)
+		if len(watchedKeys[k]) == 0 {
+			delete(watchedKeys, k)
+		}
+	}
+
+	client.watched = nil
+	client.watchDirty = false
+}
+
+// watchFailed reports whether a key watched by client was changed, deleted or expired since it was watched
+// Caller must hold the key space lock
+func (client *Client) watchFailed() bool {
+	watchMux.Lock()
+	defer watchMux.Unlock()
+
+	if client.watchDirty {
+		return true
+	}
+
+	for k, existed := range client.watched {
+		if existed && databases[k.db].Exists(k.key) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// touchWatched flags the transactions of clients watching the keys changed by entries
+// Caller must hold the key space lock
+func touchWatched(entries []persistence.Entry) {
+	watchMux.Lock()
+	defer watchMux.Unlock()
+
+	if len(watchedKeys) == 0 {
+		return
+	}
+
+	for _, entry := range entries {
+		switch name := strings.ToLower(entry.Args[0]); name {
+		case "flushall":
+			touchDBLocked(-1)
+		case "flushdb":
+			touchDBLocked(entry.DB)
+		case "swapdb":
+			for _, arg := range entry.Args[1:] {
+				if idx, err := strconv.Atoi(arg); err == nil {
+					touchDBLocked(idx)
+				}
+			}
+		default:
+			command, err := GetCommand(name)
+			if err != nil {
+				continue
+			}
+
+			for _, key := range command.Keys(entry.Args[1:]) {
+				touchKeyLocked(blockKey{db: entry.DB, key: key})
+			}
+
+			// MOVE creates the key in another database
+			if name == "move" && len(entry.Args) == 3 {
+				if idx, err := strconv.Atoi(entry.Args[2]); err == nil {
+					touchKeyLocked(blockKey{db: idx, key: entry.Args[1]})
+				}
+			}
+		}
+	}
+}
+
+// touchAllWatched flags the transactions of all clients watching keys, e.g. when the data set is replaced
+func touchAllWatched() {
+	watchMux.Lock()
+	touchDBLocked(-1)
+	watchMux.Unlock()
+}
+
+func touchKeyLocked(k blockKey) {
+	for client := range watchedKeys[k] {
+		client.watchDirty = true
+	}
+}
+
+// touchDBLocked flags clients watching keys of a database, -1 flags all databases
+func touchDBLocked(idx int) {
+	for k, clients := range watchedKeys {
+		if idx != -1 && k.db != idx {
+			continue
+		}
+
+		for client := range clients {
+			client.watchDirty = true
+		}
+	}
+}
+
+// Watch marks keys to be checked by EXEC, the transaction is aborted when one of them changes before it
+// WATCH key [key ...]
+func Watch(client *Client, args []string) {
+	if client.Multi {
+		client.WriteError(&protocol.ErrGeneric{Err: errWatchInMulti})
+		return
+	}
+
+	client.watch(args)
+	client.WriteOK()
+}
+
+// Unwatch forgets all watched keys
+func Unwatch(client *Client, args []string) {
+	client.unwatch()
+	client.WriteOK()
+}
+
+// Discard drops the queued commands of a transaction and forgets all watched keys
+func Discard(client *Client, args []string) {
+	if !client.Multi {
+		client.WriteError(&protocol.ErrGeneric{Err: errDiscard})
+		return
+	}
+
+	client.Multi = false
+	client.MultiError = false
+	client.Commands = []*Command{}
+	client.unwatch()
+	client.WriteOK()
+}
@@ -0,0 +1,188 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// execWatched watches keys, calls modify and then runs a transaction setting the key result
+// It returns the reply of EXEC, which is nil when the transaction was aborted
+func execWatched(t *testing.T, c *testConn, watch []string, modify func()) interface{} {
+	t.Helper()
+
+	c.do(append([]string{"watch"}, watch...)...)
+	modify()
+	c.do("multi")
+	c.do("set", "result", "done")
+	return c.do("exec")
+}
+
+func TestWatch(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	other := newTestConn(t)
+	c.flushAll()
+
+	// unchanged keys let the transaction run
+	c.do("set", "k", "v")
+	assert.Equal([]interface{}{"OK"}, execWatched(t, c, []string{"k", "missing"}, func() {
+		other.do("get", "k")
+	}))
+
+	// a key modified by another client aborts it
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		other.do("set", "k", "changed")
+	}))
+
+	// a watched key which is created aborts it
+	assert.Nil(execWatched(t, c, []string{"missing"}, func() {
+		other.do("rpush", "missing", "a")
+	}))
+
+	// a deleted key aborts it
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		other.do("del", "k")
+	}))
+
+	// a key which expired before EXEC aborts it even though nobody accessed it
+	c.do("set", "k", "v", "px", "20")
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		time.Sleep(50 * time.Millisecond)
+	}))
+
+	// keys are watched in the database they were watched in
+	c.do("set", "k", "v")
+	assert.Equal([]interface{}{"OK"}, execWatched(t, c, []string{"k"}, func() {
+		other.do("select", "1")
+		other.do("set", "k", "other db")
+		other.do("select", "0")
+	}))
+
+	// EXEC forgets watched keys
+	c.do("watch", "k")
+	c.do("multi")
+	c.do("exec")
+	other.do("set", "k", "after exec")
+	c.do("multi")
+	c.do("set", "result", "done")
+	assert.Equal([]interface{}{"OK"}, c.do("exec"))
+}
+
+func TestWatchDatabases(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	other := newTestConn(t)
+	c.flushAll()
+
+	c.do("set", "k", "v")
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		other.do("flushdb")
+	}))
+
+	// flushing another database does not touch the key
+	c.do("set", "k", "v")
+	assert.Equal([]interface{}{"OK"}, execWatched(t, c, []string{"k"}, func() {
+		other.do("select", "1")
+		other.do("flushdb")
+		other.do("select", "0")
+	}))
+
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		other.do("flushall")
+	}))
+
+	// swapping the database replaces the watched key
+	c.do("set", "k", "v")
+	assert.Nil(execWatched(t, c, []string{"k"}, func() {
+		other.do("swapdb", "0", "2")
+	}))
+
+	c.do("set", "k", "v")
+	assert.Equal([]interface{}{"OK"}, execWatched(t, c, []string{"k"}, func() {
+		other.do("swapdb", "1", "2")
+	}))
+
+	// MOVE creates the key in the target database
+	c.do("select", "3")
+	assert.Nil(execWatched(t, c, []string{"moved"}, func() {
+		other.do("set", "moved", "v")
+		other.do("move", "moved", "3")
+	}))
+}
+
+func TestUnwatch(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	other := newTestConn(t)
+	c.flushAll()
+	c.do("set", "k", "v")
+
+	assert.Equal("OK", c.do("watch", "k"))
+	assert.Equal("OK", c.do("unwatch"))
+	other.do("set", "k", "changed")
+	c.do("multi")
+	c.do("get", "k")
+	assert.Equal([]interface{}{"changed"}, c.do("exec"))
+
+	// DISCARD forgets watched keys too
+	c.do("watch", "k")
+	c.do("multi")
+	c.do("set", "k", "discarded")
+	assert.Equal("OK", c.do("discard"))
+	other.do("set", "k", "again")
+	c.do("multi")
+	c.do("get", "k")
+	assert.Equal([]interface{}{"again"}, c.do("exec"))
+
+	assert.IsType(replyError(""), c.do("discard"))
+}
+
+func TestMultiErrors(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Equal("OK", c.do("multi"))
+	assert.Contains(string(c.do("multi").(replyError)), "nested")
+	assert.Contains(string(c.do("watch", "k").(replyError)), "inside MULTI")
+
+	// the transaction is still open after the errors
+	assert.Equal("QUEUED", c.do("set", "k", "v"))
+	assert.Equal([]interface{}{"OK"}, c.do("exec"))
+
+	// a command which can not be queued aborts the transaction
+	c.do("multi")
+	assert.IsType(replyError(""), c.do("set", "k"))
+	c.do("set", "k", "aborted")
+	assert.Contains(string(c.do("exec").(replyError)), "EXECABORT")
+	assert.Equal("v", c.do("get", "k"))
+
+	assert.IsType(replyError(""), c.do("exec"))
+}
@@ -0,0 +1,821 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/internal/resp/resp3"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+var (
+	errZAddNXAndXX   = errors.New("XX and NX options at the same time are not compatible")
+	errZAddGTLTAndNX = errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	errZAddIncrPairs = errors.New("INCR option supports a single increment-element pair")
+	errWeightNotNum  = errors.New("weight value is not a float")
+	errLimitNoScore  = errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	errWithScoresLex = errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+)
+
+// getZSet finds the sorted set stored at key
+// When create is true a new sorted set will be stored for a missing key
+// A nil sorted set is returned when key is not found and create is false
+func getZSet(client *Client, key string, create bool) (*zset.ZSet, error) {
+	var node *db.DataNode
+	if create {
+		node, _ = client.Database.GetIfNotSet(key, db.NewDataNode(db.TypeZSet, -1, zset.New()))
+	} else {
+		v, found := client.Database.GetNode(key)
+		if !found {
+			return nil, nil
+		}
+		node = v
+	}
+
+	if node.Type != db.TypeZSet {
+		return nil, &protocol.ErrWrongType{}
+	}
+
+	return node.Value.(*zset.ZSet), nil
+}
+
+// removeIfEmptyZSet will delete the key when the sorted set does not hold any element
+func removeIfEmptyZSet(client *Client, key string, z *zset.ZSet) {
+	if z.Len() == 0 {
+		client.Database.Del([]string{key})
+	}
+}
+
+// parseScore parses a score which can be -inf or +inf but never NaN
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, &protocol.ErrGeneric{Err: errNotFloat}
+	}
+
+	return f, nil
+}
+
+// formatScore converts a score to its string representation
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// scoreReply builds the reply of a score, RESP3 clients receive a double
+func scoreReply(client *Client, score float64) protocol.Reply {
+	if client.Protocol == RESP3 {
+		return resp3.NewDoubleReply(score)
+	}
+
+	return resp2.NewBulkStringReply(false, formatScore(score))
+}
+
+// writeElements writes elements of a sorted set, scores are included after each member when withScores is true
+// RESP3 clients receive members with scores as pairs
+func writeElements(client *Client, elems []zset.Element, withScores bool) {
+	if !withScores {
+		res := make([]string, len(elems))
+		for i, e := range elems {
+			res[i] = e.Member
+		}
+
+		client.WriteStringArray(res)
+		return
+	}
+
+	reps := make([]protocol.Reply, 0, len(elems)*2)
+	for _, e := range elems {
+		reps = append(reps, resp2.NewBulkStringReply(false, e.Member), scoreReply(client, e.Score))
+	}
+
+	client.WriteProtocolReply(pairsReply(client, reps))
+}
+
+// ZAdd adds members with scores to the sorted set
+func ZAdd(client *Client, args []string) {
+	key := args[0]
+	flags, ch := 0, false
+
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			flags |= zset.FlagNX
+		case "xx":
+			flags |= zset.FlagXX
+		case "gt":
+			flags |= zset.FlagGT
+		case "lt":
+			flags |= zset.FlagLT
+		case "ch":
+			ch = true
+		case "incr":
+			flags |= zset.FlagIncr
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	if flags&zset.FlagNX != 0 && flags&zset.FlagXX != 0 {
+		client.WriteError(&protocol.ErrGeneric{Err: errZAddNXAndXX})
+		return
+	}
+
+	if (flags&zset.FlagNX != 0 && flags&(zset.FlagGT|zset.FlagLT) != 0) ||
+		(flags&zset.FlagGT != 0 && flags&zset.FlagLT != 0) {
+		client.WriteError(&protocol.ErrGeneric{Err: errZAddGTLTAndNX})
+		return
+	}
+
+	incr := flags&zset.FlagIncr != 0
+	if incr && len(pairs) > 2 {
+		client.WriteError(&protocol.ErrGeneric{Err: errZAddIncrPairs})
+		return
+	}
+
+	// validate all scores before touching the sorted set
+	scores := make([]float64, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+		scores[j/2] = score
+	}
+
+	// XX never creates the key
+	var z *zset.ZSet
+	var err error
+	if flags&zset.FlagXX != 0 {
+		z, err = getZSet(client, key, false)
+	} else {
+		z, err = getZSet(client, key, true)
+	}
+
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		if incr {
+			client.WriteNil()
+		} else {
+			client.WriteInteger(0)
+		}
+		return
+	}
+
+	changed := 0
+	for j, score := range scores {
+		newScore, res, err := z.Add(score, pairs[j*2+1], flags)
+		if err != nil {
+			removeIfEmptyZSet(client, key, z)
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+
+		if res == zset.Added || (ch && res == zset.Updated) {
+			changed++
+		}
+
+		if incr {
+			removeIfEmptyZSet(client, key, z)
+			if res == zset.Nop {
+				client.WriteNil()
+				return
+			}
+			client.WriteDouble(newScore)
+			return
+		}
+	}
+
+	removeIfEmptyZSet(client, key, z)
+	client.WriteInteger(changed)
+}
+
+// ZIncrBy increments the score of a member
+func ZIncrBy(client *Client, args []string) {
+	incr, err := parseScore(args[1])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	z, err := getZSet(client, args[0], true)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	score, _, err := z.Add(incr, args[2], zset.FlagIncr)
+	if err != nil {
+		removeIfEmptyZSet(client, args[0], z)
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	client.WriteDouble(score)
+}
+
+// ZCard returns the number of members in the sorted set
+func ZCard(client *Client, args []string) {
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(z.Len())
+}
+
+// ZScore returns the score of a member
+func ZScore(client *Client, args []string) {
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteNil()
+		return
+	}
+
+	if score, found := z.Score(args[1]); found {
+		client.WriteDouble(score)
+		return
+	}
+
+	client.WriteNil()
+}
+
+// ZRank returns the rank of a member ordered from the lowest score
+func ZRank(client *Client, args []string) {
+	zrank(client, args, false)
+}
+
+// ZRevRank returns the rank of a member ordered from the highest score
+func ZRevRank(client *Client, args []string) {
+	zrank(client, args, true)
+}
+
+func zrank(client *Client, args []string, reverse bool) {
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteNil()
+		return
+	}
+
+	if rank, found := z.Rank(args[1], reverse); found {
+		client.WriteInteger(rank)
+		return
+	}
+
+	client.WriteNil()
+}
+
+// ZRem removes members from the sorted set
+func ZRem(client *Client, args []string) {
+	key := args[0]
+	z, err := getZSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.writeChanges(0)
+		return
+	}
+
+	removed := z.Remove(args[1:])
+	removeIfEmptyZSet(client, key, z)
+	client.writeChanges(removed)
+}
+
+// ZRange returns a range of members by rank, score or lexicographical order
+func ZRange(client *Client, args []string) {
+	key := args[0]
+	byScore, byLex, rev, withScores, hasLimit := false, false, false, false, false
+	offset, count := 0, -1
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "byscore":
+			byScore = true
+		case "bylex":
+			byLex = true
+		case "rev":
+			rev = true
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			limit, err := parseInts(args[i+1 : i+3])
+			if err != nil {
+				client.WriteError(err)
+				return
+			}
+			offset, count, hasLimit = limit[0], limit[1], true
+			i += 2
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	if byScore && byLex {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	if hasLimit && !byScore && !byLex {
+		client.WriteError(&protocol.ErrGeneric{Err: errLimitNoScore})
+		return
+	}
+
+	if withScores && byLex {
+		client.WriteError(&protocol.ErrGeneric{Err: errWithScoresLex})
+		return
+	}
+
+	// reversed ranges are given as max, min
+	minArg, maxArg := args[1], args[2]
+	if rev && (byScore || byLex) {
+		minArg, maxArg = maxArg, minArg
+	}
+
+	var elems func(z *zset.ZSet) []zset.Element
+	switch {
+	case byScore:
+		min, err := zset.ParseScoreBound(minArg)
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+		max, err := zset.ParseScoreBound(maxArg)
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+
+		elems = func(z *zset.ZSet) []zset.Element {
+			return z.RangeByScore(min, max, rev, offset, count)
+		}
+	case byLex:
+		min, err := zset.ParseLexBound(minArg)
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+		max, err := zset.ParseLexBound(maxArg)
+		if err != nil {
+			client.WriteError(&protocol.ErrGeneric{Err: err})
+			return
+		}
+
+		elems = func(z *zset.ZSet) []zset.Element {
+			return z.RangeByLex(min, max, rev, offset, count)
+		}
+	default:
+		idx, err := parseInts(args[1:3])
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+
+		elems = func(z *zset.ZSet) []zset.Element {
+			return z.Range(idx[0], idx[1], rev)
+		}
+	}
+
+	z, err := getZSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil || offset < 0 {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	writeElements(client, elems(z), withScores)
+}
+
+// ZCount returns the number of members within the score range
+func ZCount(client *Client, args []string) {
+	min, err := zset.ParseScoreBound(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	max, err := zset.ParseScoreBound(args[2])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(z.Count(min, max))
+}
+
+// ZLexCount returns the number of members within the lexicographical range
+func ZLexCount(client *Client, args []string) {
+	min, err := zset.ParseLexBound(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	max, err := zset.ParseLexBound(args[2])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	client.WriteInteger(z.LexCount(min, max))
+}
+
+// ZRemRangeByRank removes members within the rank range
+func ZRemRangeByRank(client *Client, args []string) {
+	idx, err := parseInts(args[1:])
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	zremrange(client, args[0], func(z *zset.ZSet) int {
+		return z.RemoveRange(idx[0], idx[1])
+	})
+}
+
+// ZRemRangeByScore removes members within the score range
+func ZRemRangeByScore(client *Client, args []string) {
+	min, err := zset.ParseScoreBound(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	max, err := zset.ParseScoreBound(args[2])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	zremrange(client, args[0], func(z *zset.ZSet) int {
+		return z.RemoveRangeByScore(min, max)
+	})
+}
+
+// ZRemRangeByLex removes members within the lexicographical range
+func ZRemRangeByLex(client *Client, args []string) {
+	min, err := zset.ParseLexBound(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	max, err := zset.ParseLexBound(args[2])
+	if err != nil {
+		client.WriteError(&protocol.ErrGeneric{Err: err})
+		return
+	}
+
+	zremrange(client, args[0], func(z *zset.ZSet) int {
+		return z.RemoveRangeByLex(min, max)
+	})
+}
+
+func zremrange(client *Client, key string, remove func(z *zset.ZSet) int) {
+	z, err := getZSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteInteger(0)
+		return
+	}
+
+	removed := remove(z)
+	removeIfEmptyZSet(client, key, z)
+	client.WriteInteger(removed)
+}
+
+// ZPopMin removes and returns members with the lowest scores
+func ZPopMin(client *Client, args []string) {
+	zpop(client, args, false)
+}
+
+// ZPopMax removes and returns members with the highest scores
+func ZPopMax(client *Client, args []string) {
+	zpop(client, args, true)
+}
+
+func zpop(client *Client, args []string, max bool) {
+	key := args[0]
+	count, hasCount := 1, len(args) > 1
+	if hasCount {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+			return
+		}
+		count = v
+	}
+
+	z, err := getZSet(client, key, false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		client.WriteStringArray([]string{})
+		return
+	}
+
+	var elems []zset.Element
+	if max {
+		elems = z.PopMax(count)
+	} else {
+		elems = z.PopMin(count)
+	}
+
+	removeIfEmptyZSet(client, key, z)
+
+	// like redis, a single element popped without a count is not nested in RESP3
+	if !hasCount && len(elems) == 1 {
+		client.WriteProtocolReply(resp2.NewArrayReply(false, []protocol.Reply{
+			resp2.NewBulkStringReply(false, elems[0].Member), scoreReply(client, elems[0].Score),
+		}))
+		return
+	}
+
+	writeElements(client, elems, true)
+}
+
+// ZUnionStore stores the union of sorted sets in destination
+func ZUnionStore(client *Client, args []string) {
+	zstore(client, args, false)
+}
+
+// ZInterStore stores the intersection of sorted sets in destination
+func ZInterStore(client *Client, args []string) {
+	zstore(client, args, true)
+}
+
+// zstore parses destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+// and stores the union or intersection of given keys, plain sets are treated as sorted sets with score 1
+func zstore(client *Client, args []string, inter bool) {
+	dest := args[0]
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil {
+		client.WriteError(&protocol.ErrCastFailedToInt{Val: args[1]})
+		return
+	}
+
+	if numKeys <= 0 || numKeys > len(args)-2 {
+		client.WriteError(&protocol.ErrSyntax{})
+		return
+	}
+
+	keys := args[2 : 2+numKeys]
+	weights := make([]float64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "sum"
+
+	opts := args[2+numKeys:]
+	for i := 0; i < len(opts); i++ {
+		switch strings.ToLower(opts[i]) {
+		case "weights":
+			if i+numKeys >= len(opts) {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			for j := 0; j < numKeys; j++ {
+				w, err := strconv.ParseFloat(opts[i+1+j], 64)
+				if err != nil || math.IsNaN(w) {
+					client.WriteError(&protocol.ErrGeneric{Err: errWeightNotNum})
+					return
+				}
+				weights[j] = w
+			}
+			i += numKeys
+		case "aggregate":
+			if i+1 >= len(opts) {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+
+			aggregate = strings.ToLower(opts[i+1])
+			if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+				client.WriteError(&protocol.ErrSyntax{})
+				return
+			}
+			i++
+		default:
+			client.WriteError(&protocol.ErrSyntax{})
+			return
+		}
+	}
+
+	inputs := make([][]zset.Element, numKeys)
+	for i, key := range keys {
+		elems, err := zsetElements(client, key)
+		if err != nil {
+			client.WriteError(err)
+			return
+		}
+		inputs[i] = elems
+	}
+
+	scores := make(map[string]float64)
+	seen := make(map[string]int)
+	for i, elems := range inputs {
+		for _, e := range elems {
+			score := e.Score * weights[i]
+			if math.IsNaN(score) {
+				score = 0
+			}
+
+			cur, found := scores[e.Member]
+			seen[e.Member]++
+			if !found {
+				scores[e.Member] = score
+				continue
+			}
+
+			switch aggregate {
+			case "sum":
+				cur += score
+				if math.IsNaN(cur) {
+					cur = 0
+				}
+			case "min":
+				cur = math.Min(cur, score)
+			case "max":
+				cur = math.Max(cur, score)
+			}
+			scores[e.Member] = cur
+		}
+	}
+
+	res := zset.New()
+	for member, score := range scores {
+		if inter && seen[member] != numKeys {
+			continue
+		}
+		res.Add(score, member, 0)
+	}
+
+	if res.Len() == 0 {
+		client.Database.Del([]string{dest})
+		client.WriteInteger(0)
+		return
+	}
+
+	client.Database.Set(dest, db.NewDataNode(db.TypeZSet, -1, res))
+	client.WriteInteger(res.Len())
+}
+
+// zsetElements returns elements of a sorted set or a set stored at key, set members have a score of 1
+func zsetElements(client *Client, key string) ([]zset.Element, error) {
+	node, found := client.Database.GetNode(key)
+	if !found {
+		return []zset.Element{}, nil
+	}
+
+	switch node.Type {
+	case db.TypeZSet:
+		return node.Value.(*zset.ZSet).Elements(), nil
+	case db.TypeSet:
+		members := node.Value.(*set.Set).Elems()
+		elems := make([]zset.Element, len(members))
+		for i, m := range members {
+			elems[i] = zset.Element{Member: m, Score: 1}
+		}
+		return elems, nil
+	}
+
+	return nil, &protocol.ErrWrongType{}
+}
+
+// ZScan iterates over members and scores of the sorted set
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func ZScan(client *Client, args []string) {
+	scan, err := parseScanArgs(args[1:], 0)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	z, err := getZSet(client, args[0], false)
+	if err != nil {
+		client.WriteError(err)
+		return
+	}
+
+	if z == nil {
+		writeScanReply(client, 0, []string{})
+		return
+	}
+
+	res := make([]string, 0, min(scan.count, z.Len())*2)
+	cursor := z.Scan(scan.cursor, scan.count, func(elem zset.Element) {
+		if scan.matches(elem.Member) {
+			res = append(res, elem.Member, formatScore(elem.Score))
+		}
+	})
+
+	writeScanReply(client, cursor, res)
+}
@@ -0,0 +1,305 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package client
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestZScan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	args := []string{"zadd", "z"}
+	for i := 0; i < 1000; i++ {
+		args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	c.do(args...)
+
+	elems, calls := scanAll(c, 2, "zscan", "z", "count", "20")
+	assert.True(calls > 1)
+
+	scores := make(map[interface{}]interface{})
+	for i := 0; i < len(elems); i += 2 {
+		scores[elems[i]] = elems[i+1]
+	}
+	assert.Len(scores, 1000)
+	assert.Equal("42", scores["m42"])
+
+	elems, _ = scanAll(c, 2, "zscan", "z", "match", "m1?")
+	assert.Len(elems, 20)
+
+	reply := c.do("zscan", "z", "0", "count", "9223372036854775807").([]interface{})
+	assert.Equal("0", reply[0])
+	assert.Len(reply[1], 2000)
+}
+
+func TestZRangeResp3(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("zadd", "z", "1", "a", "2.5", "b", "inf", "c")
+
+	assert.Equal([]interface{}{"a", "1", "b", "2.5"}, c.do("zrange", "z", "0", "1", "withscores"))
+	assert.Equal([]interface{}{"c", "inf"}, c.do("zpopmax", "z"))
+	c.do("zadd", "z", "inf", "c")
+
+	c.hello("3")
+
+	// members with scores are returned as pairs holding a double
+	assert.Equal([]interface{}{
+		[]interface{}{"a", float64(1)}, []interface{}{"b", 2.5},
+	}, c.do("zrange", "z", "0", "1", "withscores"))
+	assert.Equal([]interface{}{
+		[]interface{}{"b", 2.5}, []interface{}{"a", float64(1)},
+	}, c.do("zrange", "z", "3", "1", "byscore", "rev", "withscores"))
+	assert.Equal([]interface{}{"a", "b", "c"}, c.do("zrange", "z", "0", "-1"))
+
+	// a single popped member is not nested unless a count is given
+	assert.Equal([]interface{}{"a", float64(1)}, c.do("zpopmin", "z"))
+	assert.Equal([]interface{}{[]interface{}{"b", 2.5}}, c.do("zpopmin", "z", "1"))
+
+	reply := c.do("zpopmax", "z", "5").([]interface{})
+	assert.Len(reply, 1)
+	assert.Equal("c", reply[0].([]interface{})[0])
+	assert.True(math.IsInf(reply[0].([]interface{})[1].(float64), 1))
+
+	assert.Equal([]interface{}{}, c.do("zpopmin", "z"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "0", "-1", "withscores"))
+}
+
+func TestZAddFlags(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+
+	assert.Equal(2, c.do("zadd", "z", "1", "a", "2", "b"))
+
+	// NX only adds new members and XX only updates existing ones
+	assert.Equal(1, c.do("zadd", "z", "nx", "5", "a", "3", "c"))
+	assert.Equal("1", c.do("zscore", "z", "a"))
+	assert.Equal(0, c.do("zadd", "z", "xx", "5", "a", "4", "d"))
+	assert.Equal("5", c.do("zscore", "z", "a"))
+	assert.Nil(c.do("zscore", "z", "d"))
+
+	// CH counts updated members too
+	assert.Equal(1, c.do("zadd", "z", "xx", "ch", "6", "a", "4", "d"))
+	assert.Equal(2, c.do("zadd", "z", "ch", "6", "a", "7", "b", "8", "e"))
+
+	// GT and LT only update scores in their direction but still add members
+	assert.Equal(1, c.do("zadd", "z", "gt", "ch", "1", "a", "9", "b"))
+	assert.Equal([]interface{}{"6", "9"}, []interface{}{c.do("zscore", "z", "a"), c.do("zscore", "z", "b")})
+	assert.Equal(1, c.do("zadd", "z", "gt", "1", "f"))
+	assert.Equal(1, c.do("zadd", "z", "lt", "ch", "10", "a", "0", "b"))
+	assert.Equal([]interface{}{"6", "0"}, []interface{}{c.do("zscore", "z", "a"), c.do("zscore", "z", "b")})
+
+	// INCR replies with the new score or nil when the member was not touched
+	assert.Equal("8", c.do("zadd", "z", "incr", "2", "a"))
+	assert.Nil(c.do("zadd", "z", "incr", "nx", "1", "a"))
+	assert.Nil(c.do("zadd", "z", "incr", "xx", "1", "nope"))
+	assert.Nil(c.do("zadd", "z", "incr", "gt", "-1", "a"))
+	assert.Equal("7", c.do("zadd", "z", "incr", "lt", "-1", "a"))
+	assert.Equal("1", c.do("zadd", "z", "incr", "1", "new"))
+
+	// XX never creates the key
+	assert.Equal(0, c.do("zadd", "missing", "xx", "1", "a"))
+	assert.Nil(c.do("zadd", "missing", "xx", "incr", "1", "a"))
+	assert.Equal(0, c.do("exists", "missing"))
+
+	assert.Equal(6, c.do("zcard", "z"))
+}
+
+func TestZAddInvalid(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("zadd", "z", "1", "a")
+
+	for _, args := range [][]string{
+		{"nx", "xx", "1", "a"},
+		{"nx", "gt", "1", "a"},
+		{"nx", "lt", "1", "a"},
+		{"gt", "lt", "1", "a"},
+		{"incr", "1", "a", "2", "b"},
+		{"1", "a", "2"},
+		{"x", "a"},
+		{"nan", "a"},
+		{"1", "b", "x", "c"},
+	} {
+		assert.IsType(replyError(""), c.do(append([]string{"zadd", "z"}, args...)...), "ZADD %v", args)
+	}
+
+	// a failed command changes nothing
+	assert.Equal([]interface{}{"a", "1"}, c.do("zrange", "z", "0", "-1", "withscores"))
+	assert.Equal(0, c.do("exists", "other"))
+
+	// an increment resulting in NaN is rejected
+	c.do("zadd", "z", "inf", "i")
+	assert.IsType(replyError(""), c.do("zadd", "z", "incr", "-inf", "i"))
+	assert.Equal("inf", c.do("zscore", "z", "i"))
+
+	c.do("set", "s", "v")
+	assert.True(isWrongType(c.do("zadd", "s", "1", "a")))
+}
+
+func TestZRange(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e")
+
+	// by rank
+	assert.Equal([]interface{}{"a", "b", "c", "d", "e"}, c.do("zrange", "z", "0", "-1"))
+	assert.Equal([]interface{}{"d", "e"}, c.do("zrange", "z", "-2", "-1"))
+	assert.Equal([]interface{}{"d", "c"}, c.do("zrange", "z", "1", "2", "rev"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "3", "1"))
+	assert.Equal([]interface{}{"a", "1", "b", "2"}, c.do("zrange", "z", "0", "1", "withscores"))
+
+	// by score with inclusive and exclusive bounds
+	assert.Equal([]interface{}{"b", "c", "d"}, c.do("zrange", "z", "2", "4", "byscore"))
+	assert.Equal([]interface{}{"c", "d"}, c.do("zrange", "z", "(2", "4", "byscore"))
+	assert.Equal([]interface{}{"b", "c"}, c.do("zrange", "z", "2", "(4", "byscore"))
+	assert.Equal([]interface{}{"c"}, c.do("zrange", "z", "(2", "(4", "byscore"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "(3", "(3", "byscore"))
+	assert.Equal([]interface{}{"a", "b", "c", "d", "e"}, c.do("zrange", "z", "-inf", "+inf", "byscore"))
+	assert.Equal([]interface{}{"d", "4", "e", "5"}, c.do("zrange", "z", "(3", "+inf", "byscore", "withscores"))
+
+	// reversed ranges are given as max, min
+	assert.Equal([]interface{}{"d", "c", "b"}, c.do("zrange", "z", "4", "2", "byscore", "rev"))
+	assert.Equal([]interface{}{"c", "b"}, c.do("zrange", "z", "(4", "2", "byscore", "rev"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "2", "4", "byscore", "rev"))
+
+	assert.Equal([]interface{}{"b", "c"}, c.do("zrange", "z", "-inf", "+inf", "byscore", "limit", "1", "2"))
+	assert.Equal([]interface{}{"d", "e"}, c.do("zrange", "z", "-inf", "+inf", "byscore", "limit", "3", "-1"))
+	assert.Equal([]interface{}{"e", "d"}, c.do("zrange", "z", "+inf", "-inf", "byscore", "rev", "limit", "0", "2"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "-inf", "+inf", "byscore", "limit", "-1", "2"))
+	assert.Equal([]interface{}{}, c.do("zrange", "z", "-inf", "+inf", "byscore", "limit", "10", "2"))
+
+	// by lex among members with the same score
+	c.do("zadd", "l", "0", "a", "0", "b", "0", "c", "0", "d")
+	assert.Equal([]interface{}{"b", "c"}, c.do("zrange", "l", "[b", "[c", "bylex"))
+	assert.Equal([]interface{}{"c", "d"}, c.do("zrange", "l", "(b", "[d", "bylex"))
+	assert.Equal([]interface{}{"a", "b"}, c.do("zrange", "l", "-", "(c", "bylex"))
+	assert.Equal([]interface{}{"a", "b", "c", "d"}, c.do("zrange", "l", "-", "+", "bylex"))
+	assert.Equal([]interface{}{"d", "c", "b", "a"}, c.do("zrange", "l", "+", "-", "bylex", "rev"))
+	assert.Equal([]interface{}{"b", "a"}, c.do("zrange", "l", "(c", "-", "bylex", "rev"))
+	assert.Equal([]interface{}{"b"}, c.do("zrange", "l", "-", "+", "bylex", "limit", "1", "1"))
+
+	assert.Equal([]interface{}{}, c.do("zrange", "missing", "0", "-1"))
+
+	for _, args := range [][]string{
+		{"0", "-1", "byscore", "bylex"},
+		{"0", "-1", "limit", "0", "1"},
+		{"-", "+", "bylex", "withscores"},
+		{"x", "5", "byscore"},
+		{"(", "5", "byscore"},
+		{"b", "[c", "bylex"},
+		{"0", "x"},
+		{"0", "-1", "limit", "0"},
+		{"0", "-1", "foo"},
+	} {
+		assert.IsType(replyError(""), c.do(append([]string{"zrange", "z"}, args...)...), "ZRANGE %v", args)
+	}
+}
+
+// zrangeAll returns the members of a sorted set with their scores
+func zrangeAll(c *testConn, key string) interface{} {
+	return c.do("zrange", key, "0", "-1", "withscores")
+}
+
+func TestZStore(t *testing.T) {
+	assert := testifyAssert.New(t)
+	c := newTestConn(t)
+	c.flushAll()
+	c.do("zadd", "z1", "1", "a", "2", "b", "3", "c")
+	c.do("zadd", "z2", "10", "b", "20", "c", "30", "d")
+	c.do("sadd", "s", "c", "e")
+
+	assert.Equal(4, c.do("zunionstore", "dest", "2", "z1", "z2"))
+	assert.Equal([]interface{}{"a", "1", "b", "12", "c", "23", "d", "30"}, zrangeAll(c, "dest"))
+
+	assert.Equal(4, c.do("zunionstore", "dest", "2", "z1", "z2", "weights", "2", "0.5"))
+	assert.Equal([]interface{}{"a", "2", "b", "9", "d", "15", "c", "16"}, zrangeAll(c, "dest"))
+
+	assert.Equal(4, c.do("zunionstore", "dest", "2", "z1", "z2", "aggregate", "min"))
+	assert.Equal([]interface{}{"a", "1", "b", "2", "c", "3", "d", "30"}, zrangeAll(c, "dest"))
+
+	assert.Equal(4, c.do("zunionstore", "dest", "2", "z1", "z2", "weights", "1", "-1", "aggregate", "max"))
+	assert.Equal([]interface{}{"d", "-30", "a", "1", "b", "2", "c", "3"}, zrangeAll(c, "dest"))
+
+	assert.Equal(2, c.do("zinterstore", "dest", "2", "z1", "z2"))
+	assert.Equal([]interface{}{"b", "12", "c", "23"}, zrangeAll(c, "dest"))
+
+	assert.Equal(2, c.do("zinterstore", "dest", "2", "z1", "z2", "weights", "1", "-1", "aggregate", "max"))
+	assert.Equal([]interface{}{"b", "2", "c", "3"}, zrangeAll(c, "dest"))
+
+	assert.Equal(2, c.do("zinterstore", "dest", "2", "z1", "z2", "weights", "3", "1", "aggregate", "min"))
+	assert.Equal([]interface{}{"b", "6", "c", "9"}, zrangeAll(c, "dest"))
+
+	// members of plain sets have a score of 1
+	assert.Equal(1, c.do("zinterstore", "dest", "2", "z1", "s"))
+	assert.Equal([]interface{}{"c", "4"}, zrangeAll(c, "dest"))
+	assert.Equal(4, c.do("zunionstore", "dest", "2", "z1", "s", "weights", "1", "5"))
+	assert.Equal([]interface{}{"a", "1", "b", "2", "e", "5", "c", "8"}, zrangeAll(c, "dest"))
+
+	// an empty result removes the destination
+	assert.Equal(0, c.do("zinterstore", "dest", "2", "z1", "missing"))
+	assert.Equal(0, c.do("exists", "dest"))
+	c.do("set", "dest", "v")
+	assert.Equal(0, c.do("zunionstore", "dest", "1", "missing"))
+	assert.Equal(0, c.do("exists", "dest"))
+
+	// the destination can be one of the inputs
+	assert.Equal(3, c.do("zunionstore", "z1", "1", "z1", "weights", "2"))
+	assert.Equal([]interface{}{"a", "2", "b", "4", "c", "6"}, zrangeAll(c, "z1"))
+
+	// infinite scores multiplied by a weight of 0 are 0
+	c.do("zadd", "inf", "inf", "x")
+	assert.Equal(1, c.do("zunionstore", "dest", "1", "inf", "weights", "0"))
+	assert.Equal([]interface{}{"x", "0"}, zrangeAll(c, "dest"))
+
+	c.do("set", "str", "v")
+	assert.True(isWrongType(c.do("zunionstore", "dest", "2", "z1", "str")))
+
+	for _, args := range [][]string{
+		{"0", "z1"},
+		{"3", "z1", "z2"},
+		{"x", "z1"},
+		{"2", "z1", "z2", "weights", "1"},
+		{"2", "z1", "z2", "weights", "1", "x"},
+		{"2", "z1", "z2", "aggregate", "avg"},
+		{"2", "z1", "z2", "aggregate"},
+		{"2", "z1", "z2", "foo"},
+	} {
+		assert.IsType(replyError(""), c.do(append([]string{"zunionstore", "dest"}, args...)...), "ZUNIONSTORE %v", args)
+		assert.IsType(replyError(""), c.do(append([]string{"zinterstore", "dest"}, args...)...), "ZINTERSTORE %v", args)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultConfigFile is the default name of the file cluster state is persisted to
+	DefaultConfigFile = "nodes.conf"
+
+	// DefaultNodeTimeout is the default time after which an unreachable node is flagged as failing
+	DefaultNodeTimeout = 15 * time.Second
+
+	// GossipPeriod is the interval other nodes are polled for their view of the cluster
+	GossipPeriod = time.Second
+
+	// IDLength is the length of a node ID
+	IDLength = 40
+)
+
+var (
+	// ErrInvalidSlot is returned when a slot is not a number in the range of slots
+	ErrInvalidSlot = errors.New("invalid or out of range slot")
+
+	// ErrForgetMyself is returned when a node is asked to forget itself
+	ErrForgetMyself = errors.New("I tried hard but I can't forget myself")
+
+	// ErrInvalidConfig is returned when a cluster config file can not be parsed
+	ErrInvalidConfig = errors.New("invalid cluster config")
+)
+
+// Config configures cluster mode
+type Config struct {
+	// ConfigFile is the file cluster state is persisted to
+	ConfigFile string
+
+	// Host is the host announced to clients and other nodes
+	Host string
+
+	// Port is the port announced to clients and other nodes
+	Port int
+
+	// NodeTimeout is the time after which an unreachable node is flagged as failing
+	NodeTimeout time.Duration
+}
+
+// WithDefaults fills unset values with defaults
+func (conf Config) WithDefaults() Config {
+	if conf.ConfigFile == "" {
+		conf.ConfigFile = DefaultConfigFile
+	}
+
+	if conf.NodeTimeout <= 0 {
+		conf.NodeTimeout = DefaultNodeTimeout
+	}
+
+	return conf
+}
+
+// NewID returns a random node ID
+func NewID() string {
+	b := make([]byte, IDLength/2)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// SlotRange is an inclusive range of slots
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// Node is a snapshot of a node as known by this node
+type Node struct {
+	ID   string
+	Host string
+	Port int
+
+	// Myself is set for this node
+	Myself bool
+
+	// Handshake is set for nodes which were met but did not reply yet, their ID is temporary
+	Handshake bool
+
+	// Fail is set for nodes which were not reachable within the node timeout
+	Fail bool
+
+	// Connected is set when the last poll of the node succeeded
+	Connected bool
+
+	// Epoch is the config epoch of the node, slot claims of higher epochs win
+	Epoch uint64
+
+	// PongRecv is the unix time in milliseconds when the node replied the last time
+	PongRecv int64
+
+	// Slots are the slots served by the node
+	Slots []SlotRange
+
+	// Migrating and Importing map slots to the IDs of the nodes they are moved to and from, only set for myself
+	Migrating map[int]string
+	Importing map[int]string
+}
+
+// Addr returns the address of the node
+func (n Node) Addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// SlotCount returns the number of slots served by the node
+func (n Node) SlotCount() int {
+	count := 0
+	for _, r := range n.Slots {
+		count += r.End - r.Start + 1
+	}
+
+	return count
+}
@@ -0,0 +1,319 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatNodes formats nodes as CLUSTER NODES does, one node per line
+// <id> <host:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func FormatNodes(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		flags := "master"
+		switch {
+		case n.Myself:
+			flags = "myself,master"
+		case n.Handshake:
+			flags = "handshake"
+		case n.Fail:
+			flags = "master,fail?"
+		}
+
+		link := "disconnected"
+		if n.Connected {
+			link = "connected"
+		}
+
+		fmt.Fprintf(&b, "%s %s@%d %s - 0 %d %d %s", n.ID, n.Addr(), n.Port, flags, n.PongRecv, n.Epoch, link)
+		for _, r := range n.Slots {
+			if r.Start == r.End {
+				fmt.Fprintf(&b, " %d", r.Start)
+			} else {
+				fmt.Fprintf(&b, " %d-%d", r.Start, r.End)
+			}
+		}
+
+		for _, slot := range sortedSlots(n.Migrating) {
+			fmt.Fprintf(&b, " [%d->-%s]", slot, n.Migrating[slot])
+		}
+		for _, slot := range sortedSlots(n.Importing) {
+			fmt.Fprintf(&b, " [%d-<-%s]", slot, n.Importing[slot])
+		}
+
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func sortedSlots(m map[int]string) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+
+	return slots
+}
+
+// ParseNodes parses nodes in the format of CLUSTER NODES
+func ParseNodes(text string) ([]Node, error) {
+	var nodes []Node
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		n, err := parseNode(line)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+func parseNode(line string) (Node, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return Node{}, ErrInvalidConfig
+	}
+
+	var n Node
+	var err error
+	n.ID = fields[0]
+
+	hostPort := fields[1]
+	if i := strings.IndexByte(hostPort, '@'); i != -1 {
+		hostPort = hostPort[:i]
+	}
+
+	var port string
+	if n.Host, port, err = net.SplitHostPort(hostPort); err != nil {
+		return Node{}, ErrInvalidConfig
+	}
+	if n.Port, err = strconv.Atoi(port); err != nil {
+		return Node{}, ErrInvalidConfig
+	}
+
+	for _, flag := range strings.Split(fields[2], ",") {
+		switch flag {
+		case "myself":
+			n.Myself = true
+		case "handshake":
+			n.Handshake = true
+		case "fail", "fail?":
+			n.Fail = true
+		}
+	}
+
+	if n.PongRecv, err = strconv.ParseInt(fields[5], 10, 64); err != nil {
+		return Node{}, ErrInvalidConfig
+	}
+	if n.Epoch, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+		return Node{}, ErrInvalidConfig
+	}
+	n.Connected = fields[7] == "connected"
+
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") {
+			if err := parseMovingSlot(&n, field); err != nil {
+				return Node{}, err
+			}
+			continue
+		}
+
+		r, err := parseSlotRange(field)
+		if err != nil {
+			return Node{}, err
+		}
+		n.Slots = append(n.Slots, r)
+	}
+
+	return n, nil
+}
+
+// parseSlotRange parses a slot or a range of slots like 0-5460
+func parseSlotRange(s string) (SlotRange, error) {
+	start, end := s, s
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		start, end = s[:i], s[i+1:]
+	}
+
+	first, err := ParseSlot(start)
+	if err != nil {
+		return SlotRange{}, err
+	}
+
+	last, err := ParseSlot(end)
+	if err != nil || last < first {
+		return SlotRange{}, ErrInvalidSlot
+	}
+
+	return SlotRange{Start: first, End: last}, nil
+}
+
+// parseMovingSlot parses a migrating slot like [5->-id] or an importing slot like [5-<-id]
+func parseMovingSlot(n *Node, s string) error {
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+
+	states := map[string]*map[int]string{"->-": &n.Migrating, "-<-": &n.Importing}
+	for sep, m := range states {
+		i := strings.Index(s, sep)
+		if i == -1 {
+			continue
+		}
+
+		slot, err := ParseSlot(s[:i])
+		if err != nil {
+			return err
+		}
+
+		if *m == nil {
+			*m = make(map[int]string)
+		}
+		(*m)[slot] = s[i+len(sep):]
+		return nil
+	}
+
+	return ErrInvalidConfig
+}
+
+// WriteConfig persists the state, nodes in handshake are not written
+func (s *State) WriteConfig(w io.Writer) error {
+	nodes := s.Nodes()
+	known := nodes[:0]
+	for _, n := range nodes {
+		if !n.Handshake {
+			known = append(known, n)
+		}
+	}
+
+	if _, err := io.WriteString(w, FormatNodes(known)); err != nil {
+		return err
+	}
+
+	s.mux.RLock()
+	epoch := s.currentEpoch
+	s.mux.RUnlock()
+
+	_, err := fmt.Fprintf(w, "vars currentEpoch %d lastVoteEpoch 0\n", epoch)
+	return err
+}
+
+// ReadConfig restores a state written by WriteConfig
+// The address of this node is taken from host and port since it may have changed since the state was written
+func ReadConfig(r io.Reader, host string, port int, timeout time.Duration) (*State, error) {
+	var nodes []Node
+	var epoch uint64
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if fields := strings.Fields(line); fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					v, err := strconv.ParseUint(fields[i+1], 10, 64)
+					if err != nil {
+						return nil, ErrInvalidConfig
+					}
+					epoch = v
+				}
+			}
+			continue
+		}
+
+		n, err := parseNode(line)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var s *State
+	for _, n := range nodes {
+		if n.Myself {
+			s = NewState(n.ID, host, port, timeout)
+			s.myself.epoch = n.Epoch
+		}
+	}
+
+	if s == nil {
+		return nil, ErrInvalidConfig
+	}
+	s.currentEpoch = epoch
+
+	for _, n := range nodes {
+		if !n.Myself && !n.Handshake {
+			s.nodes[n.ID] = &node{id: n.ID, host: n.Host, port: n.Port, epoch: n.Epoch, pongRecv: n.PongRecv}
+		}
+	}
+
+	for _, n := range nodes {
+		owner := s.nodes[n.ID]
+		if owner == nil {
+			continue
+		}
+
+		for _, r := range n.Slots {
+			for slot := r.Start; slot <= r.End; slot++ {
+				s.owners[slot] = owner
+			}
+		}
+
+		for slot, id := range n.Migrating {
+			if target, ok := s.nodes[id]; ok {
+				s.migrating[slot] = target
+			}
+		}
+
+		for slot, id := range n.Importing {
+			if source, ok := s.nodes[id]; ok {
+				s.importing[slot] = source
+			}
+		}
+	}
+
+	return s, nil
+}
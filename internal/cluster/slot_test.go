@@ -0,0 +1,74 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cluster
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestCrc16(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal(uint16(0x31C3), crc16("123456789"))
+	assert.Equal(uint16(0), crc16(""))
+}
+
+func TestKeySlot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal(12182, KeySlot("foo"))
+	assert.Equal(5061, KeySlot("bar"))
+	assert.Equal(866, KeySlot("hello"))
+	assert.Equal(0, KeySlot(""))
+}
+
+func TestKeySlot_HashTags(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal(KeySlot("user1000"), KeySlot("{user1000}.following"))
+	assert.Equal(KeySlot("{user1000}.following"), KeySlot("{user1000}.followers"))
+	assert.Equal(KeySlot("bar"), KeySlot("foo{bar}{zap}"))
+
+	// empty or unclosed tags hash the whole key
+	assert.Equal(int(crc16("foo{}{bar}")&(Slots-1)), KeySlot("foo{}{bar}"))
+	assert.Equal(int(crc16("foo{bar")&(Slots-1)), KeySlot("foo{bar"))
+	assert.Equal(KeySlot("{bar"), KeySlot("foo{{bar}}zap"))
+}
+
+func TestParseSlot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	slot, err := ParseSlot("16383")
+	assert.Nil(err)
+	assert.Equal(16383, slot)
+
+	for _, s := range []string{"16384", "-1", "a"} {
+		_, err = ParseSlot(s)
+		assert.Equal(ErrInvalidSlot, err)
+	}
+}
@@ -0,0 +1,547 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/sys"
+)
+
+// node is a member of the cluster
+type node struct {
+	id        string
+	host      string
+	port      int
+	handshake bool
+	connected bool
+	epoch     uint64
+	pongRecv  int64
+}
+
+// State is the view of the cluster held by this node
+// Nodes learn about each other by polling the nodes they know for their view, a node only claims its own slots
+// and a claim wins over the current owner when it has a higher config epoch
+type State struct {
+	myself       *node
+	nodes        map[string]*node
+	owners       [Slots]*node
+	migrating    map[int]*node
+	importing    map[int]*node
+	currentEpoch uint64
+	timeout      time.Duration
+
+	mux sync.RWMutex
+}
+
+// SlotInfo describes how a slot is served
+type SlotInfo struct {
+	// Owner is the address of the node serving the slot, it is empty when the slot is not assigned
+	Owner string
+
+	// Mine is set when this node serves the slot
+	Mine bool
+
+	// MigratingTo is the address of the node the slot is moved to by this node
+	MigratingTo string
+
+	// Importing is set when this node imports the slot
+	Importing bool
+}
+
+// Info holds the counters reported by CLUSTER INFO
+type Info struct {
+	SlotsAssigned int
+	SlotsPFail    int
+	KnownNodes    int
+	Size          int
+	CurrentEpoch  uint64
+	MyEpoch       uint64
+}
+
+// NewState creates a cluster with this node as the only member
+func NewState(id, host string, port int, timeout time.Duration) *State {
+	myself := &node{id: id, host: host, port: port, connected: true}
+	return &State{
+		myself:    myself,
+		nodes:     map[string]*node{id: myself},
+		migrating: make(map[int]*node),
+		importing: make(map[int]*node),
+		timeout:   timeout,
+	}
+}
+
+// ID returns the ID of this node
+func (s *State) ID() string {
+	return s.myself.id
+}
+
+// snapshotLocked converts a node to a snapshot, slots are the slot ranges of all nodes
+func (s *State) snapshotLocked(n *node, slots map[*node][]SlotRange) Node {
+	snap := Node{
+		ID:        n.id,
+		Host:      n.host,
+		Port:      n.port,
+		Myself:    n == s.myself,
+		Handshake: n.handshake,
+		Connected: n.connected,
+		Epoch:     n.epoch,
+		PongRecv:  n.pongRecv,
+		Slots:     slots[n],
+	}
+
+	if n != s.myself {
+		snap.Fail = !n.connected && sys.NowMillis()-n.pongRecv > s.timeout.Milliseconds()
+		return snap
+	}
+
+	snap.Migrating = make(map[int]string, len(s.migrating))
+	for slot, target := range s.migrating {
+		snap.Migrating[slot] = target.id
+	}
+	snap.Importing = make(map[int]string, len(s.importing))
+	for slot, source := range s.importing {
+		snap.Importing[slot] = source.id
+	}
+
+	return snap
+}
+
+// slotRangesLocked groups the assigned slots by their owners
+func (s *State) slotRangesLocked() map[*node][]SlotRange {
+	ranges := make(map[*node][]SlotRange)
+	for slot := 0; slot < Slots; slot++ {
+		owner := s.owners[slot]
+		if owner == nil {
+			continue
+		}
+
+		if r := ranges[owner]; len(r) > 0 && r[len(r)-1].End == slot-1 {
+			r[len(r)-1].End = slot
+			continue
+		}
+		ranges[owner] = append(ranges[owner], SlotRange{Start: slot, End: slot})
+	}
+
+	return ranges
+}
+
+// Myself returns this node
+func (s *State) Myself() Node {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.snapshotLocked(s.myself, s.slotRangesLocked())
+}
+
+// Nodes returns all known nodes, this node comes first and the others are ordered by their IDs
+func (s *State) Nodes() []Node {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	ranges := s.slotRangesLocked()
+	nodes := make([]Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, s.snapshotLocked(n, ranges))
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Myself != nodes[j].Myself {
+			return nodes[i].Myself
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	return nodes
+}
+
+// Slot returns how a slot is served
+func (s *State) Slot(slot int) SlotInfo {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	var info SlotInfo
+	if owner := s.owners[slot]; owner != nil {
+		info.Owner = addr(owner)
+		info.Mine = owner == s.myself
+	}
+
+	if target, ok := s.migrating[slot]; ok {
+		info.MigratingTo = addr(target)
+	}
+	_, info.Importing = s.importing[slot]
+
+	return info
+}
+
+// Info returns the counters of the cluster
+func (s *State) Info() Info {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	info := Info{KnownNodes: len(s.nodes), CurrentEpoch: s.currentEpoch, MyEpoch: s.myself.epoch}
+	serving := make(map[*node]struct{})
+	now := sys.NowMillis()
+	for _, owner := range s.owners {
+		if owner == nil {
+			continue
+		}
+
+		info.SlotsAssigned++
+		serving[owner] = struct{}{}
+		if owner != s.myself && !owner.connected && now-owner.pongRecv > s.timeout.Milliseconds() {
+			info.SlotsPFail++
+		}
+	}
+	info.Size = len(serving)
+
+	return info
+}
+
+func addr(n *node) string {
+	return Node{Host: n.host, Port: n.port}.Addr()
+}
+
+// checkSlots validates that slots are not given twice
+func checkSlots(slots []int) error {
+	seen := make(map[int]struct{}, len(slots))
+	for _, slot := range slots {
+		if _, ok := seen[slot]; ok {
+			return fmt.Errorf("slot %d specified multiple times", slot)
+		}
+		seen[slot] = struct{}{}
+	}
+
+	return nil
+}
+
+// AddSlots assigns unassigned slots to this node
+func (s *State) AddSlots(slots []int) error {
+	if err := checkSlots(slots); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, slot := range slots {
+		if s.owners[slot] != nil {
+			return fmt.Errorf("slot %d is already busy", slot)
+		}
+	}
+
+	for _, slot := range slots {
+		s.owners[slot] = s.myself
+		delete(s.importing, slot)
+	}
+
+	return nil
+}
+
+// DelSlots unassigns slots, other nodes keep them assigned until they learn that the owner stopped claiming them
+func (s *State) DelSlots(slots []int) error {
+	if err := checkSlots(slots); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, slot := range slots {
+		if s.owners[slot] == nil {
+			return fmt.Errorf("slot %d is already unassigned", slot)
+		}
+	}
+
+	for _, slot := range slots {
+		s.owners[slot] = nil
+		delete(s.migrating, slot)
+		delete(s.importing, slot)
+	}
+
+	return nil
+}
+
+// knownLocked finds a node by its ID
+func (s *State) knownLocked(id string) (*node, error) {
+	n, ok := s.nodes[id]
+	if !ok || n.handshake {
+		return nil, fmt.Errorf("I don't know about node %s", id)
+	}
+
+	return n, nil
+}
+
+// SetSlotMigrating starts moving a slot served by this node to another node
+func (s *State) SetSlotMigrating(slot int, id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.owners[slot] != s.myself {
+		return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+	}
+
+	target, err := s.knownLocked(id)
+	if err != nil {
+		return err
+	}
+
+	if target == s.myself {
+		return fmt.Errorf("can't migrate hash slot %d to myself", slot)
+	}
+
+	s.migrating[slot] = target
+	return nil
+}
+
+// SetSlotImporting starts importing a slot served by another node
+func (s *State) SetSlotImporting(slot int, id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.owners[slot] == s.myself {
+		return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+	}
+
+	source, err := s.knownLocked(id)
+	if err != nil {
+		return err
+	}
+
+	if source == s.myself {
+		return fmt.Errorf("can't import hash slot %d from myself", slot)
+	}
+
+	s.importing[slot] = source
+	return nil
+}
+
+// SetSlotStable stops migrating or importing a slot
+func (s *State) SetSlotStable(slot int) {
+	s.mux.Lock()
+	delete(s.migrating, slot)
+	delete(s.importing, slot)
+	s.mux.Unlock()
+}
+
+// SetSlotNode assigns a slot to a node, this ends moving the slot
+// Taking over an imported slot bumps the config epoch of this node so the claim wins on the other nodes
+func (s *State) SetSlotNode(slot int, id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	n, err := s.knownLocked(id)
+	if err != nil {
+		return err
+	}
+
+	if n != s.myself {
+		delete(s.migrating, slot)
+	} else if _, ok := s.importing[slot]; ok {
+		delete(s.importing, slot)
+		s.currentEpoch++
+		s.myself.epoch = s.currentEpoch
+	}
+
+	s.owners[slot] = n
+	return nil
+}
+
+// Meet adds a node by its address, it stays in handshake until it replies with its ID
+func (s *State) Meet(host string, port int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, n := range s.nodes {
+		if n.host == host && n.port == port {
+			return
+		}
+	}
+
+	id := NewID()
+	s.nodes[id] = &node{id: id, host: host, port: port, handshake: true, pongRecv: sys.NowMillis()}
+}
+
+// Forget removes a node and unassigns its slots
+func (s *State) Forget(id string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		return fmt.Errorf("unknown node %s", id)
+	}
+
+	if n == s.myself {
+		return ErrForgetMyself
+	}
+
+	s.removeLocked(n)
+	return nil
+}
+
+// removeLocked removes a node and every reference to it
+func (s *State) removeLocked(n *node) {
+	delete(s.nodes, n.id)
+	for slot, owner := range s.owners {
+		if owner == n {
+			s.owners[slot] = nil
+		}
+	}
+
+	for slot, target := range s.migrating {
+		if target == n {
+			delete(s.migrating, slot)
+		}
+	}
+
+	for slot, source := range s.importing {
+		if source == n {
+			delete(s.importing, slot)
+		}
+	}
+}
+
+// LinkDown records a failed poll of a node, nodes stuck in handshake are dropped after the node timeout
+func (s *State) LinkDown(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok || n == s.myself {
+		return
+	}
+
+	n.connected = false
+	if n.handshake && sys.NowMillis()-n.pongRecv > s.timeout.Milliseconds() {
+		s.removeLocked(n)
+	}
+}
+
+// Merge applies the view of the cluster polled from the node with the given ID
+// The polled node is the entry flagged as myself in view, its slot claims replace the ones known for it unless
+// another node claims a slot with a higher config epoch. Other nodes in view are added when they are unknown.
+// It returns true when the state was changed and should be persisted
+func (s *State) Merge(id string, view []Node) bool {
+	var peer *Node
+	for i := range view {
+		if view[i].Myself {
+			peer = &view[i]
+			break
+		}
+	}
+
+	if peer == nil {
+		return false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	polled, ok := s.nodes[id]
+	if !ok {
+		return false
+	}
+
+	changed := false
+	if polled.id != peer.ID {
+		// a node in handshake replied with its real ID
+		s.removeLocked(polled)
+		changed = true
+
+		if peer.ID == s.myself.id {
+			return changed
+		}
+
+		if n, ok := s.nodes[peer.ID]; ok {
+			n.host, n.port = polled.host, polled.port
+			polled = n
+		} else {
+			polled = &node{id: peer.ID, host: polled.host, port: polled.port}
+			s.nodes[peer.ID] = polled
+		}
+	}
+
+	polled.connected = true
+	polled.pongRecv = sys.NowMillis()
+	if polled.epoch != peer.Epoch {
+		polled.epoch = peer.Epoch
+		changed = true
+	}
+
+	if peer.Epoch > s.currentEpoch {
+		s.currentEpoch = peer.Epoch
+		changed = true
+	}
+
+	var claimed [Slots]bool
+	for _, r := range peer.Slots {
+		for slot := r.Start; slot <= r.End && slot < Slots; slot++ {
+			claimed[slot] = true
+		}
+	}
+
+	for slot, owner := range s.owners {
+		switch {
+		case claimed[slot] && owner != polled && (owner == nil || owner.epoch < polled.epoch):
+			s.owners[slot] = polled
+			if owner == s.myself {
+				delete(s.migrating, slot)
+			}
+			delete(s.importing, slot)
+			changed = true
+		case !claimed[slot] && owner == polled:
+			s.owners[slot] = nil
+			changed = true
+		}
+	}
+
+	for _, other := range view {
+		if other.Myself || other.Handshake || other.ID == s.myself.id {
+			continue
+		}
+
+		if _, ok := s.nodes[other.ID]; ok {
+			continue
+		}
+
+		// drop a handshake of the same node, it is known by its ID now
+		for _, n := range s.nodes {
+			if n.handshake && n.host == other.Host && n.port == other.Port {
+				s.removeLocked(n)
+				break
+			}
+		}
+
+		s.nodes[other.ID] = &node{id: other.ID, host: other.Host, port: other.Port, epoch: other.Epoch, pongRecv: sys.NowMillis()}
+		changed = true
+	}
+
+	return changed
+}
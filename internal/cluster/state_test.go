@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestState_AddSlots(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s := NewState(NewID(), "127.0.0.1", 7000, time.Second)
+	assert.Nil(s.AddSlots([]int{0, 1, 2, 5}))
+	assert.Equal([]SlotRange{{0, 2}, {5, 5}}, s.Myself().Slots)
+	assert.True(s.Slot(1).Mine)
+	assert.Equal("127.0.0.1:7000", s.Slot(1).Owner)
+	assert.Equal("", s.Slot(3).Owner)
+
+	assert.NotNil(s.AddSlots([]int{3, 2}))
+	assert.NotNil(s.AddSlots([]int{4, 4}))
+	assert.Equal("", s.Slot(3).Owner)
+
+	assert.NotNil(s.DelSlots([]int{3}))
+	assert.Nil(s.DelSlots([]int{0, 5}))
+	assert.Equal([]SlotRange{{1, 2}}, s.Myself().Slots)
+	assert.Equal(2, s.Info().SlotsAssigned)
+}
+
+func TestState_Merge(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s := NewState(NewID(), "127.0.0.1", 7000, time.Second)
+	assert.Nil(s.AddSlots([]int{0, 1}))
+
+	s.Meet("127.0.0.1", 7001)
+	var handshake string
+	for _, n := range s.Nodes() {
+		if n.Handshake {
+			handshake = n.ID
+		}
+	}
+	assert.NotEmpty(handshake)
+
+	peer := NewID()
+	third := NewID()
+	view := []Node{
+		{ID: peer, Host: "127.0.0.1", Port: 7001, Myself: true, Slots: []SlotRange{{1, 3}}},
+		{ID: third, Host: "127.0.0.1", Port: 7002},
+	}
+
+	// equal epochs do not take over slots
+	assert.True(s.Merge(handshake, view))
+	assert.True(s.Slot(1).Mine)
+	assert.Equal("127.0.0.1:7001", s.Slot(2).Owner)
+	assert.Len(s.Nodes(), 3)
+
+	// a higher epoch wins
+	view[0].Epoch = 1
+	assert.True(s.Merge(peer, view))
+	assert.Equal("127.0.0.1:7001", s.Slot(1).Owner)
+	assert.Equal([]SlotRange{{0, 0}}, s.Myself().Slots)
+	assert.Equal(uint64(1), s.Info().CurrentEpoch)
+
+	// slots which are not claimed anymore are released
+	view[0].Slots = []SlotRange{{1, 2}}
+	assert.True(s.Merge(peer, view))
+	assert.Equal("", s.Slot(3).Owner)
+	assert.False(s.Merge(peer, view))
+}
+
+func TestState_SetSlot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s := NewState(NewID(), "127.0.0.1", 7000, time.Second)
+	peer := NewID()
+	s.Meet("127.0.0.1", 7001)
+	handshake := s.Nodes()[1].ID
+	s.Merge(handshake, []Node{{ID: peer, Host: "127.0.0.1", Port: 7001, Myself: true, Slots: []SlotRange{{10, 10}}}})
+
+	assert.NotNil(s.SetSlotMigrating(10, peer))
+	assert.NotNil(s.SetSlotImporting(10, NewID()))
+	assert.Nil(s.SetSlotImporting(10, peer))
+	assert.True(s.Slot(10).Importing)
+
+	assert.Nil(s.SetSlotNode(10, s.ID()))
+	assert.True(s.Slot(10).Mine)
+	assert.False(s.Slot(10).Importing)
+	assert.Equal(uint64(1), s.Myself().Epoch)
+
+	assert.Nil(s.SetSlotMigrating(10, peer))
+	assert.Equal("127.0.0.1:7001", s.Slot(10).MigratingTo)
+	s.SetSlotStable(10)
+	assert.Equal("", s.Slot(10).MigratingTo)
+
+	assert.Equal(ErrForgetMyself, s.Forget(s.ID()))
+	assert.Nil(s.Forget(peer))
+	assert.Len(s.Nodes(), 1)
+}
+
+func TestState_Config(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s := NewState(NewID(), "127.0.0.1", 7000, time.Second)
+	peer := NewID()
+	s.Meet("127.0.0.1", 7001)
+	s.Merge(s.Nodes()[1].ID, []Node{{ID: peer, Host: "127.0.0.1", Port: 7001, Myself: true, Epoch: 3, Slots: []SlotRange{{100, 200}}}})
+	assert.Nil(s.AddSlots([]int{0, 1, 2, 50}))
+	assert.Nil(s.SetSlotMigrating(50, peer))
+	assert.Nil(s.SetSlotImporting(150, peer))
+
+	nodes, err := ParseNodes(FormatNodes(s.Nodes()))
+	assert.Nil(err)
+	assert.Equal(s.Nodes()[0].Slots, nodes[0].Slots)
+	assert.Equal(map[int]string{50: peer}, nodes[0].Migrating)
+	assert.Equal(map[int]string{150: peer}, nodes[0].Importing)
+	assert.Equal(uint64(3), nodes[1].Epoch)
+
+	var buf bytes.Buffer
+	assert.Nil(s.WriteConfig(&buf))
+
+	restored, err := ReadConfig(&buf, "127.0.0.1", 7100, time.Second)
+	assert.Nil(err)
+	assert.Equal(s.ID(), restored.ID())
+	assert.Equal(7100, restored.Myself().Port)
+	assert.Equal(s.Info().CurrentEpoch, restored.Info().CurrentEpoch)
+	assert.Equal("127.0.0.1:7001", restored.Slot(150).Owner)
+	assert.True(restored.Slot(150).Importing)
+	assert.Equal("127.0.0.1:7001", restored.Slot(50).MigratingTo)
+
+	_, err = ReadConfig(bytes.NewBufferString("vars currentEpoch 0 lastVoteEpoch 0\n"), "127.0.0.1", 7000, time.Second)
+	assert.Equal(ErrInvalidConfig, err)
+}
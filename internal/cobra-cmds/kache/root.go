@@ -33,9 +33,15 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/kasvith/kache/internal/client"
+	"github.com/kasvith/kache/internal/cluster"
 	cobracmds "github.com/kasvith/kache/internal/cobra-cmds"
 	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/raft"
+	"github.com/kasvith/kache/internal/replication"
 	"github.com/kasvith/kache/internal/srv"
 )
 
@@ -66,17 +72,50 @@ func init() {
 	RootCmd.Flags().IntP("port", "p", 7088, "port for running application")
 	RootCmd.Flags().IntP("maxClients", "", 10000, "max connections can be handled")
 	RootCmd.Flags().IntP("maxTimeout", "", 120, "max timeout for clients(in seconds)")
+	RootCmd.Flags().String("importRdb", "", "import a Redis RDB file on startup instead of loading persisted data")
+	RootCmd.Flags().String("replicaof", "", `replicate from a master given as "<host> <port>"`)
 
 	// Bind the flags to config
 	viper.BindPFlag("port", RootCmd.Flags().Lookup("port"))
 	viper.BindPFlag("host", RootCmd.Flags().Lookup("host"))
 	viper.BindPFlag("maxClients", RootCmd.Flags().Lookup("maxClients"))
 	viper.BindPFlag("maxTimeout", RootCmd.Flags().Lookup("maxTimeout"))
+	viper.BindPFlag("importRdb", RootCmd.Flags().Lookup("importRdb"))
+	viper.BindPFlag("replicaof", RootCmd.Flags().Lookup("replicaof"))
 	viper.BindPFlag("verbose", RootCmd.PersistentFlags().Lookup("verbose"))
 	viper.BindPFlag("logging", RootCmd.PersistentFlags().Lookup("logging"))
 	viper.BindPFlag("logfile", RootCmd.PersistentFlags().Lookup("logfile"))
 	viper.BindPFlag("logtype", RootCmd.PersistentFlags().Lookup("logtype"))
 	viper.BindPFlag("debug", RootCmd.PersistentFlags().Lookup("debug"))
+
+	// Defaults for values only available in config
+	viper.SetDefault("databases", config.DefaultDatabases)
+	viper.SetDefault("dir", ".")
+	viper.SetDefault("dbfilename", "dump.kache")
+	viper.SetDefault("save", []string{"3600 1", "300 100", "60 10000"})
+	viper.SetDefault("appendonly", false)
+	viper.SetDefault("appendfilename", "appendonly.aof")
+	viper.SetDefault("appendfsync", persistence.FsyncEverySec)
+	viper.SetDefault("aofLoadTruncated", true)
+	viper.SetDefault("replicaReadOnly", true)
+	viper.SetDefault("replBacklogSize", replication.DefaultBacklogSize)
+	viper.SetDefault("replTimeout", int(replication.DefaultTimeout/time.Second))
+	viper.SetDefault("replPingReplicaPeriod", int(replication.DefaultPingPeriod/time.Second))
+	viper.SetDefault("clusterEnabled", false)
+	viper.SetDefault("clusterConfigFile", cluster.DefaultConfigFile)
+	viper.SetDefault("clusterNodeTimeout", int(cluster.DefaultNodeTimeout/time.Millisecond))
+	viper.SetDefault("raftEnabled", false)
+	viper.SetDefault("raftDir", "raft")
+	viper.SetDefault("raftPeers", []string{})
+	viper.SetDefault("raftBootstrap", false)
+	viper.SetDefault("raftElectionTimeout", int(raft.DefaultElectionTimeout/time.Millisecond))
+	viper.SetDefault("raftHeartbeatInterval", int(raft.DefaultHeartbeatInterval/time.Millisecond))
+	viper.SetDefault("raftSnapshotThreshold", raft.DefaultSnapshotThreshold)
+	viper.SetDefault("changeFeedSize", db.DefaultEventRingSize)
+	viper.SetDefault("hz", db.DefaultHz)
+	viper.SetDefault("activeExpireKeysPerLoop", db.DefaultActiveExpireKeysPerLoop)
+	viper.SetDefault("activeExpireStalePercent", db.DefaultActiveExpireStalePercent)
+	viper.SetDefault("activeExpireTimePercent", db.DefaultActiveExpireTimePercent)
 }
 
 func initConfig() {
@@ -114,6 +153,7 @@ func initConfig() {
 func Execute() {
 	// Commands
 	RootCmd.AddCommand(cobracmds.VersionCmd)
+	RootCmd.AddCommand(cobracmds.RdbCmd)
 
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -137,6 +177,7 @@ func runApp(cmd *cobra.Command, args []string) {
 	fmt.Println()
 
 	klogs.InitLoggers(appConfig)
+	client.Version = cobracmds.AppVersion
 	srv.Start(appConfig)
 }
 
@@ -0,0 +1,137 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package cobracmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/persistence/rdb"
+)
+
+var (
+	rdbSnapshot  string
+	rdbDatabases int
+)
+
+// RdbCmd groups commands which convert between Redis RDB files and kache snapshots
+var RdbCmd = &cobra.Command{
+	Use:   "rdb",
+	Short: "Convert between Redis RDB files and kache snapshots",
+	Long:  `Import Redis RDB files as kache snapshots and export kache snapshots as RDB files which Redis can load`,
+}
+
+var rdbImportCmd = &cobra.Command{
+	Use:   "import <rdb file>",
+	Short: "Convert a Redis RDB file to a kache snapshot",
+	Long:  `Convert a Redis RDB file to a kache snapshot which is loaded on startup`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dbs := newDatabases(rdbDatabases)
+		if err := readFile(args[0], func(r io.Reader) error { return rdb.Read(r, dbs) }); err != nil {
+			klogs.PrintErrorAndExit(err, 1)
+		}
+
+		err := persistence.WriteFileAtomic(rdbSnapshot, func(w io.Writer) error {
+			return persistence.WriteSnapshot(w, dbs)
+		})
+		if err != nil {
+			klogs.PrintErrorAndExit(err, 1)
+		}
+
+		fmt.Printf("Imported %d keys from %s to %s\n", countKeys(dbs), args[0], rdbSnapshot)
+	},
+}
+
+var rdbExportCmd = &cobra.Command{
+	Use:   "export <rdb file>",
+	Short: "Convert a kache snapshot to a Redis RDB file",
+	Long:  `Convert a kache snapshot to a Redis RDB file which can be loaded by Redis 5.0 and later`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dbs := newDatabases(rdbDatabases)
+		if err := persistence.LoadSnapshotFile(rdbSnapshot, dbs); err != nil {
+			klogs.PrintErrorAndExit(err, 1)
+		}
+
+		skipped := 0
+		err := persistence.WriteFileAtomic(args[0], func(w io.Writer) (err error) {
+			skipped, err = rdb.Write(w, dbs)
+			return err
+		})
+		if err != nil {
+			klogs.PrintErrorAndExit(err, 1)
+		}
+
+		if skipped > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %d stream keys, streams can not be exported to RDB files\n", skipped)
+		}
+
+		fmt.Printf("Exported %d keys from %s to %s\n", countKeys(dbs)-skipped, rdbSnapshot, args[0])
+	},
+}
+
+func init() {
+	RdbCmd.PersistentFlags().StringVar(&rdbSnapshot, "snapshot", "dump.kache", "kache snapshot file")
+	RdbCmd.PersistentFlags().IntVar(&rdbDatabases, "databases", config.DefaultDatabases, "number of databases")
+
+	RdbCmd.AddCommand(rdbImportCmd, rdbExportCmd)
+}
+
+func newDatabases(n int) []*db.DB {
+	dbs := make([]*db.DB, n)
+	for i := range dbs {
+		dbs[i] = db.NewIndexedDB(i)
+	}
+
+	return dbs
+}
+
+func countKeys(dbs []*db.DB) int {
+	keys := 0
+	for _, database := range dbs {
+		keys += database.Len()
+	}
+
+	return keys
+}
+
+func readFile(path string, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return fn(f)
+}
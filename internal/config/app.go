@@ -27,6 +27,9 @@ package config
 // DefaultMaxMultiBulkLength default max multi bulk length
 const DefaultMaxMultiBulkLength = 512 * 1024 * 1024
 
+// DefaultDatabases is the default number of databases
+const DefaultDatabases = 16
+
 // AppConfig is application configuration struct
 type AppConfig struct {
 	Port               int
@@ -39,6 +42,51 @@ type AppConfig struct {
 	Debug              bool
 	MaxMultiBulkLength int // in bytes
 	LogType            string
+	Databases          int
+
+	// snapshots
+	Dir        string
+	DBFilename string
+	Save       []string
+
+	// append only file
+	AppendOnly       bool
+	AppendFilename   string
+	AppendFsync      string
+	AOFLoadTruncated bool
+
+	// ImportRDB is a Redis RDB file imported on startup
+	ImportRDB string
+
+	// replication
+	ReplicaOf             string
+	ReplicaReadOnly       bool
+	ReplBacklogSize       int
+	ReplTimeout           int // in seconds
+	ReplPingReplicaPeriod int // in seconds
+
+	// cluster
+	ClusterEnabled     bool
+	ClusterConfigFile  string
+	ClusterNodeTimeout int // in milliseconds
+
+	// raft
+	RaftEnabled           bool
+	RaftDir               string
+	RaftPeers             []string
+	RaftBootstrap         bool
+	RaftElectionTimeout   int // in milliseconds
+	RaftHeartbeatInterval int // in milliseconds
+	RaftSnapshotThreshold int
+
+	// change feed, a negative size disables it
+	ChangeFeedSize int
+
+	// active expiration
+	Hz                       int
+	ActiveExpireKeysPerLoop  int
+	ActiveExpireStalePercent int
+	ActiveExpireTimePercent  int
 }
 
 // AppConf is the globle application config
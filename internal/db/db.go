@@ -28,14 +28,46 @@ package db
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
+
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/index"
+	"github.com/kasvith/kache/pkg/util"
 )
 
 // DB holds a thread safe struct for store data
 type DB struct {
 	file map[string]*DataNode
-	mux  sync.RWMutex
+
+	// expires indexes keys which have an expiration, active expire cycles walk it with expireCursor
+	// the index is resized incrementally, so setting an expiration on a large db does not stall it
+	expires      *index.Index
+	expireCursor uint64
+
+	// index allows iterating over keys with a cursor
+	index *index.Index
+
+	// expired is the number of keys removed due to expiration
+	expired int64
+
+	// number is the index of the db reported in events
+	number int
+
+	// observers receive the events of the db, they are guarded by mux
+	observers []*observer
+
+	// reported holds the keys which had an event since the last TouchUnreported, nil while nobody observes the db
+	reported map[string]struct{}
+
+	mux sync.RWMutex
 }
 
+// keysBatchSize is the number of keys visited at once by Keys
+const keysBatchSize = 1000
+
+// pairMux serializes operations which lock two databases at once to avoid lock ordering issues
+var pairMux sync.Mutex
+
 // KeyNotFoundError has the key which was not able to found in a DB
 type KeyNotFoundError struct {
 	key string
@@ -47,7 +79,73 @@ func (e *KeyNotFoundError) Error() string {
 
 // NewDB returns a new *DB
 func NewDB() *DB {
-	return &DB{file: make(map[string]*DataNode)}
+	return NewIndexedDB(0)
+}
+
+// NewIndexedDB returns a new *DB which reports number as its db in events
+func NewIndexedDB(number int) *DB {
+	return &DB{file: make(map[string]*DataNode), expires: index.New(), index: index.New(), number: number}
+}
+
+// setLocked stores a node and updates the expiry index, caller must hold the write lock
+func (db *DB) setLocked(key string, node *DataNode) {
+	old, ok := db.file[key]
+	if ok && old.IsExpired() {
+		db.expireLocked(key)
+		ok = false
+	}
+
+	if !ok {
+		db.index.Add(key)
+	}
+
+	db.file[key] = node
+	db.trackExpireLocked(key)
+
+	if ok {
+		db.emitLocked(EventOverwrite, key, node.Type)
+	} else {
+		db.emitLocked(EventSet, key, node.Type)
+	}
+}
+
+// removeLocked removes a key from the db and the expiry index, caller must hold the write lock
+func (db *DB) removeLocked(key string) (*DataNode, bool) {
+	node, ok := db.file[key]
+	if ok {
+		db.index.Remove(key)
+	}
+
+	delete(db.file, key)
+	db.expires.Remove(key)
+	return node, ok
+}
+
+// deleteLocked deletes a key and removes it from the expiry index, caller must hold the write lock
+func (db *DB) deleteLocked(key string) {
+	if node, ok := db.removeLocked(key); ok {
+		db.emitLocked(EventDel, key, node.Type)
+	}
+}
+
+// expireLocked deletes an expired key and counts it, caller must hold the write lock
+func (db *DB) expireLocked(key string) {
+	if node, ok := db.removeLocked(key); ok {
+		db.emitLocked(EventExpire, key, node.Type)
+	}
+	atomic.AddInt64(&db.expired, 1)
+}
+
+// trackExpireLocked syncs the expiry index with the current expiration of a key, caller must hold the write lock
+func (db *DB) trackExpireLocked(key string) {
+	if node, ok := db.file[key]; ok && node.Expiration() != -1 {
+		if !db.expires.Contains(key) {
+			db.expires.Add(key)
+		}
+		return
+	}
+
+	db.expires.Remove(key)
 }
 
 // GetNode will clear the key if its expired
@@ -58,7 +156,10 @@ func (db *DB) GetNode(key string) (*DataNode, bool) {
 		db.mux.RUnlock()
 		if v.IsExpired() {
 			db.mux.Lock()
-			delete(db.file, key)
+			// make sure the key was not replaced meanwhile
+			if cur, ok := db.file[key]; ok && cur == v {
+				db.expireLocked(key)
+			}
 			db.mux.Unlock()
 
 			return nil, false
@@ -83,21 +184,49 @@ func (db *DB) Get(key string) (*DataNode, error) {
 // Set the value of a key
 func (db *DB) Set(key string, val *DataNode) {
 	db.mux.Lock()
-	db.file[key] = val
+	db.setLocked(key, val)
 	db.mux.Unlock()
 }
 
-// GetIfNotSet will try to GetNode the key if not will set it to a given value
-func (db *DB) GetIfNotSet(key string, val *DataNode) (value *DataNode, found bool) {
-	if v, found := db.GetNode(key); found {
-		return v, true
+// Compute atomically replaces the value of a key with the result of fn
+// fn receives the current node or nil when key does not exist, when write is false the key is left untouched,
+// otherwise the returned node is stored or the key is deleted when the returned node is nil
+func (db *DB) Compute(key string, fn func(old *DataNode) (node *DataNode, write bool)) {
+	db.mux.Lock()
+
+	old, ok := db.file[key]
+	if ok && old.IsExpired() {
+		db.expireLocked(key)
+		old = nil
+	}
+
+	if node, write := fn(old); write {
+		if node == nil {
+			db.deleteLocked(key)
+		} else {
+			db.setLocked(key, node)
+		}
+	} else {
+		// fn is allowed to change the expiration of the current node
+		db.trackExpireLocked(key)
 	}
 
-	db.mux.Lock()
-	db.file[key] = val
 	db.mux.Unlock()
+}
+
+// GetIfNotSet will try to GetNode the key if not will set it to a given value
+func (db *DB) GetIfNotSet(key string, val *DataNode) (value *DataNode, found bool) {
+	db.Compute(key, func(old *DataNode) (*DataNode, bool) {
+		if old != nil {
+			value, found = old, true
+			return nil, false
+		}
 
-	return val, false
+		value = val
+		return val, true
+	})
+
+	return
 }
 
 // Del will delete keys
@@ -107,10 +236,13 @@ func (db *DB) Del(keys []string) int {
 	for _, k := range keys {
 		if v, ok := db.file[k]; ok {
 			// dont count already deleted keys aka expired
-			if !v.IsExpired() {
-				del++
+			if v.IsExpired() {
+				db.expireLocked(k)
+				continue
 			}
-			delete(db.file, k)
+
+			del++
+			db.deleteLocked(k)
 		}
 	}
 	db.mux.Unlock()
@@ -127,31 +259,239 @@ func (db *DB) Exists(key string) int {
 	return 0
 }
 
-// Keys returns all keys of the db
-func (db *DB) Keys() []string {
+// Keys returns all keys of the db matching a glob pattern
+// Keys are collected in batches so the db is not locked during the whole iteration
+func (db *DB) Keys(pattern string) []string {
+	seen := make(map[string]struct{})
+	keys := make([]string, 0)
+
+	var batch []string
+	cursor := uint64(0)
+	for {
+		batch, cursor = db.Scan(cursor, keysBatchSize, nil)
+		for _, key := range batch {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			if pattern == "*" || util.GlobMatch(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+
+		if cursor == 0 {
+			return keys
+		}
+	}
+}
+
+// ForEach calls fn for every key which is not expired until fn returns false
+// The db is read locked during the iteration so fn must not modify it
+func (db *DB) ForEach(fn func(key string, node *DataNode) bool) {
 	db.mux.RLock()
+	defer db.mux.RUnlock()
 
-	keys := make([]string, 0)
-	for key, val := range db.file {
-		if !val.IsExpired() {
-			keys = append(keys, key)
+	for key, node := range db.file {
+		if node.IsExpired() {
+			continue
 		}
 
+		if !fn(key, node) {
+			return
+		}
 	}
+}
 
+// Scan iterates over keys starting from cursor until at least count keys were visited
+// filter can be used to skip keys, expired keys are always skipped
+// It returns the keys with the next cursor, a returned cursor of 0 means the iteration is completed
+// Every key which was present during the whole iteration is returned at least once
+func (db *DB) Scan(cursor uint64, count int, filter func(key string, node *DataNode) bool) ([]string, uint64) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	keys := make([]string, 0, min(count, len(db.file)))
+	cursor = db.index.Scan(cursor, count, func(key string) {
+		node := db.file[key]
+		if node.IsExpired() || (filter != nil && !filter(key, node)) {
+			return
+		}
+
+		keys = append(keys, key)
+	})
+
+	return keys, cursor
+}
+
+// Len returns the number of keys in the db including expired keys which are not removed yet
+func (db *DB) Len() int {
+	db.mux.RLock()
+	n := len(db.file)
 	db.mux.RUnlock()
-	return keys
+	return n
+}
+
+// RandomKey returns a random key of the db, found is false when db is empty
+func (db *DB) RandomKey() (key string, found bool) {
+	db.mux.Lock()
+	// map iteration order is randomized, expired keys seen meanwhile are removed
+	for k, v := range db.file {
+		if v.IsExpired() {
+			db.expireLocked(k)
+			continue
+		}
+
+		key, found = k, true
+		break
+	}
+	db.mux.Unlock()
+
+	return
 }
 
-// SetExpire time for a key
-func (db *DB) SetExpire(key string, ttl int64) bool {
-	if ttl < 0 && ttl != -1 {
+// Flush removes all keys of the db
+func (db *DB) Flush() {
+	db.mux.Lock()
+	flushed := len(db.file) > 0
+	db.file = make(map[string]*DataNode)
+	db.expires, db.expireCursor = index.New(), 0
+	db.index = index.New()
+	if flushed {
+		db.emitLocked(EventFlush, "", 0)
+	}
+	db.mux.Unlock()
+}
+
+// Swap exchanges all keys of the db with other db
+func (db *DB) Swap(other *DB) {
+	if db == other {
+		return
+	}
+
+	pairMux.Lock()
+	db.mux.Lock()
+	other.mux.Lock()
+
+	db.file, other.file = other.file, db.file
+	db.expires, other.expires = other.expires, db.expires
+	db.expireCursor, other.expireCursor = 0, 0
+	db.index, other.index = other.index, db.index
+
+	// both dbs have other keys now
+	if len(db.file) > 0 || len(other.file) > 0 {
+		db.emitLocked(EventFlush, "", 0)
+		other.emitLocked(EventFlush, "", 0)
+	}
+
+	other.mux.Unlock()
+	db.mux.Unlock()
+	pairMux.Unlock()
+}
+
+// Move moves a key to dst db with its expiration
+// It returns false when the key does not exist or dst already has the key
+func (db *DB) Move(key string, dst *DB) bool {
+	if db == dst {
 		return false
 	}
 
+	pairMux.Lock()
+	db.mux.Lock()
+	dst.mux.Lock()
+
+	moved := false
+	node, ok := db.file[key]
+	if ok && node.IsExpired() {
+		db.expireLocked(key)
+		ok = false
+	}
+
+	if ok {
+		if cur, exists := dst.file[key]; exists && cur.IsExpired() {
+			dst.expireLocked(key)
+		}
+
+		if _, exists := dst.file[key]; !exists {
+			db.deleteLocked(key)
+			dst.setLocked(key, node)
+			moved = true
+		}
+	}
+
+	dst.mux.Unlock()
+	db.mux.Unlock()
+	pairMux.Unlock()
+
+	return moved
+}
+
+const (
+	// ExpireNX sets expiration only when the key has no expiration
+	ExpireNX = 1 << iota
+
+	// ExpireXX sets expiration only when the key has an expiration
+	ExpireXX
+
+	// ExpireGT sets expiration only when the new expiration is greater than the current one
+	ExpireGT
+
+	// ExpireLT sets expiration only when the new expiration is less than the current one
+	ExpireLT
+)
+
+// SetExpire sets the expiration of a key to an unix timestamp in milliseconds honoring the Expire* flags
+// A key without an expiration is considered to have an infinite TTL when comparing with GT and LT
+// An expiration in the past deletes the key. It returns true when the expiration was applied
+func (db *DB) SetExpire(key string, at int64, flags int) bool {
+	applied := false
+	db.Compute(key, func(old *DataNode) (*DataNode, bool) {
+		if old == nil {
+			return nil, false
+		}
+
+		cur := old.Expiration()
+		switch {
+		case flags&ExpireNX != 0 && cur != -1,
+			flags&ExpireXX != 0 && cur == -1,
+			flags&ExpireGT != 0 && (cur == -1 || at <= cur),
+			flags&ExpireLT != 0 && cur != -1 && at >= cur:
+			return nil, false
+		}
+
+		applied = true
+		if at <= sys.NowMillis() {
+			return nil, true
+		}
+
+		old.SetExpiration(at)
+		return nil, false
+	})
+
+	return applied
+}
+
+// Persist removes the expiration of a key, it returns true when the key had an expiration
+func (db *DB) Persist(key string) bool {
+	persisted := false
+	db.Compute(key, func(old *DataNode) (*DataNode, bool) {
+		if old != nil && old.Expiration() != -1 {
+			old.SetExpiration(-1)
+			persisted = true
+		}
+
+		return nil, false
+	})
+
+	return persisted
+}
+
+// Expiration returns the expiration of a key as an unix timestamp in milliseconds
+// It returns -1 when key does not expire and found is false when key does not exist
+func (db *DB) Expiration(key string) (at int64, found bool) {
 	if v, ok := db.GetNode(key); ok {
-		v.SetExpiration(ttl)
+		return v.Expiration(), true
 	}
 
-	return false
+	return 0, false
 }
@@ -0,0 +1,185 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"testing"
+
+	"github.com/kasvith/kache/internal/sys"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestDB_SetExpire(t *testing.T) {
+	assert := testifyAssert.New(t)
+	now := sys.NowMillis()
+	soon, later := now+10000, now+20000
+
+	tests := []struct {
+		name    string
+		current int64
+		at      int64
+		flags   int
+		applied bool
+		expect  int64
+	}{
+		{"no flags", -1, soon, 0, true, soon},
+		{"replace", later, soon, 0, true, soon},
+		{"nx without expiration", -1, soon, ExpireNX, true, soon},
+		{"nx with expiration", later, soon, ExpireNX, false, later},
+		{"xx without expiration", -1, soon, ExpireXX, false, -1},
+		{"xx with expiration", later, soon, ExpireXX, true, soon},
+		{"gt without expiration", -1, soon, ExpireGT, false, -1},
+		{"gt greater", soon, later, ExpireGT, true, later},
+		{"gt equal", soon, soon, ExpireGT, false, soon},
+		{"gt less", later, soon, ExpireGT, false, later},
+		{"lt without expiration", -1, soon, ExpireLT, true, soon},
+		{"lt less", later, soon, ExpireLT, true, soon},
+		{"lt equal", soon, soon, ExpireLT, false, soon},
+		{"lt greater", soon, later, ExpireLT, false, soon},
+		{"xx gt", soon, later, ExpireXX | ExpireGT, true, later},
+		{"xx lt without expiration", -1, soon, ExpireXX | ExpireLT, false, -1},
+	}
+
+	for _, test := range tests {
+		db := NewDB()
+		db.Set("k", NewDataNode(TypeString, test.current, "v"))
+
+		assert.Equal(test.applied, db.SetExpire("k", test.at, test.flags), test.name)
+
+		at, found := db.Expiration("k")
+		assert.True(found, test.name)
+		assert.Equal(test.expect, at, test.name)
+		assert.Equal(test.expect != -1, db.ExpiresCount() == 1, test.name)
+	}
+}
+
+func TestDB_SetExpirePast(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	assert.False(db.SetExpire("missing", sys.NowMillis()+1000, 0))
+
+	// an expiration in the past deletes the key
+	db.Set("k", NewDataNode(TypeString, -1, "v"))
+	assert.True(db.SetExpire("k", sys.NowMillis()-1, 0))
+	assert.Equal(0, db.Exists("k"))
+	assert.Equal(0, db.Len())
+}
+
+func TestDB_Persist(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("k", NewDataNode(TypeString, sys.NowMillis()+1000, "v"))
+	assert.Equal(1, db.ExpiresCount())
+
+	assert.True(db.Persist("k"))
+	assert.False(db.Persist("k"))
+	assert.False(db.Persist("missing"))
+	assert.Equal(0, db.ExpiresCount())
+
+	at, found := db.Expiration("k")
+	assert.True(found)
+	assert.Equal(int64(-1), at)
+}
+
+func TestDB_Flush(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+
+	db.Set("a", NewDataNode(TypeString, -1, "v"))
+	db.Set("b", NewDataNode(TypeString, sys.NowMillis()+1000, "v"))
+	db.Flush()
+
+	assert.Equal(0, db.Len())
+	assert.Equal(0, db.ExpiresCount())
+	assert.Equal(0, db.Exists("a"))
+
+	keys, cursor := db.Scan(0, 10, nil)
+	assert.Empty(keys)
+	assert.Equal(uint64(0), cursor)
+
+	// the db is usable after a flush
+	db.Set("a", NewDataNode(TypeString, -1, "v"))
+	assert.Equal(1, db.Len())
+}
+
+func TestDB_Swap(t *testing.T) {
+	assert := testifyAssert.New(t)
+	first, second := NewIndexedDB(0), NewIndexedDB(1)
+
+	first.Set("a", NewDataNode(TypeString, sys.NowMillis()+1000, "first"))
+	second.Set("b", NewDataNode(TypeString, -1, "second"))
+	second.Set("c", NewDataNode(TypeString, -1, "second"))
+
+	first.Swap(second)
+
+	assert.Equal(2, first.Len())
+	assert.Equal(0, first.ExpiresCount())
+	assert.Equal(1, first.Exists("b"))
+	assert.Equal(0, first.Exists("a"))
+	assert.ElementsMatch([]string{"b", "c"}, first.Keys("*"))
+
+	assert.Equal(1, second.Len())
+	assert.Equal(1, second.ExpiresCount())
+	assert.ElementsMatch([]string{"a"}, second.Keys("*"))
+
+	// swapping a db with itself does nothing
+	first.Swap(first)
+	assert.Equal(2, first.Len())
+}
+
+func TestDB_Move(t *testing.T) {
+	assert := testifyAssert.New(t)
+	src, dst := NewIndexedDB(0), NewIndexedDB(1)
+	at := sys.NowMillis() + 10000
+
+	src.Set("a", NewDataNode(TypeString, at, "v"))
+	src.Set("b", NewDataNode(TypeString, -1, "src"))
+	dst.Set("b", NewDataNode(TypeString, -1, "dst"))
+
+	// the expiration moves with the key
+	assert.True(src.Move("a", dst))
+	assert.Equal(0, src.Exists("a"))
+	assert.Equal(0, src.ExpiresCount())
+	exp, found := dst.Expiration("a")
+	assert.True(found)
+	assert.Equal(at, exp)
+	assert.Equal(1, dst.ExpiresCount())
+
+	// keys are not overwritten
+	assert.False(src.Move("b", dst))
+	node, _ := dst.Get("b")
+	assert.Equal("dst", node.Value)
+	assert.Equal(1, src.Exists("b"))
+
+	src.Set("expired", NewDataNode(TypeString, sys.NowMillis()-1, "v"))
+	assert.False(src.Move("expired", dst))
+	assert.False(src.Move("missing", dst))
+	assert.False(src.Move("b", src))
+	assert.Equal(0, dst.Exists("expired"))
+}
@@ -0,0 +1,276 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"sync"
+
+	"github.com/kasvith/kache/internal/sys"
+)
+
+// DefaultEventRingSize is the default number of events kept by an EventRing
+const DefaultEventRingSize = 4096
+
+// EventKind is the kind of a change of the key space
+type EventKind int
+
+const (
+	// EventSet is emitted when a key is created
+	EventSet = EventKind(iota + 1)
+
+	// EventOverwrite is emitted when the value of an existing key is replaced
+	EventOverwrite
+
+	// EventModify is emitted by Touch and TouchUnreported when a value was changed in place, e.g. an element was
+	// pushed to a list
+	EventModify
+
+	// EventDel is emitted when a key is deleted
+	EventDel
+
+	// EventExpire is emitted when an expired key is removed, both lazily and actively
+	EventExpire
+
+	// EventFlush is emitted when all keys of a db are removed or swapped with another db, the key is empty
+	EventFlush
+)
+
+// eventKinds are the names of the event kinds
+var eventKinds = map[EventKind]string{
+	EventSet:       "set",
+	EventOverwrite: "overwrite",
+	EventModify:    "modify",
+	EventDel:       "del",
+	EventExpire:    "expire",
+	EventFlush:     "flush",
+}
+
+// String returns the name of the kind
+func (k EventKind) String() string {
+	if name, ok := eventKinds[k]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// ParseEventKind finds an event kind by its name
+func ParseEventKind(name string) (EventKind, bool) {
+	for k, n := range eventKinds {
+		if n == name {
+			return k, true
+		}
+	}
+
+	return 0, false
+}
+
+// Event describes a change of a key
+type Event struct {
+	// Seq is the sequence number given by an EventRing, 0 until the event is recorded
+	Seq uint64
+
+	Kind EventKind
+
+	// DB is the index of the db given to NewIndexedDB
+	DB int
+
+	Key string
+
+	// Type is the type of the new value, or the removed value for deletions, 0 for EventFlush
+	Type DataType
+
+	// Time is the unix timestamp in milliseconds of the change
+	Time int64
+}
+
+// Observer receives the events of a db
+// It is called while the db is locked, so it must return quickly and must not access the db
+type Observer func(event Event)
+
+// observer wraps an Observer so it can be removed again
+type observer struct {
+	fn Observer
+}
+
+// Observe registers fn to receive every change of the db, the returned function removes it again
+func (db *DB) Observe(fn Observer) (cancel func()) {
+	return db.observe(fn, false)
+}
+
+// ObserveExisting registers fn like Observe after sending it EventSet for every key the db holds
+// Keys which expired but were not removed yet are included, so fn receives their EventExpire later
+func (db *DB) ObserveExisting(fn Observer) (cancel func()) {
+	return db.observe(fn, true)
+}
+
+func (db *DB) observe(fn Observer, existing bool) (cancel func()) {
+	o := &observer{fn: fn}
+
+	db.mux.Lock()
+	if existing {
+		now := sys.NowMillis()
+		for key, node := range db.file {
+			fn(Event{Kind: EventSet, DB: db.number, Key: key, Type: node.Type, Time: now})
+		}
+	}
+	db.observers = append(db.observers, o)
+	if db.reported == nil {
+		db.reported = make(map[string]struct{})
+	}
+	db.mux.Unlock()
+
+	return func() {
+		db.mux.Lock()
+		defer db.mux.Unlock()
+
+		for i, cur := range db.observers {
+			if cur == o {
+				db.observers = append(db.observers[:i:i], db.observers[i+1:]...)
+				if len(db.observers) == 0 {
+					db.reported = nil
+				}
+				return
+			}
+		}
+	}
+}
+
+// emitLocked sends an event to the observers, caller must hold the write lock
+func (db *DB) emitLocked(kind EventKind, key string, t DataType) {
+	if len(db.observers) == 0 {
+		return
+	}
+
+	db.reported[key] = struct{}{}
+	event := Event{Kind: kind, DB: db.number, Key: key, Type: t, Time: sys.NowMillis()}
+	for _, o := range db.observers {
+		o.fn(event)
+	}
+}
+
+// Touch reports a value which was changed in place without replacing its node, nothing is emitted when the key
+// does not exist
+func (db *DB) Touch(key string) {
+	db.mux.Lock()
+	if node, ok := db.file[key]; ok && !node.IsExpired() {
+		db.emitLocked(EventModify, key, node.Type)
+	}
+	db.mux.Unlock()
+}
+
+// TouchUnreported reports keys like Touch unless they had an event since the previous call, so a value which was
+// changed in place gets EventModify while keys which were set or removed are not reported twice
+// Callers which change values in place pass the keys they wrote, e.g. once a command completed
+func (db *DB) TouchUnreported(keys []string) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if len(db.observers) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		if _, ok := db.reported[key]; ok {
+			continue
+		}
+
+		if node, ok := db.file[key]; ok && !node.IsExpired() {
+			db.emitLocked(EventModify, key, node.Type)
+		}
+	}
+
+	clear(db.reported)
+}
+
+// EventRing keeps the latest events of one or more dbs and numbers them in the order they were recorded
+type EventRing struct {
+	events []Event
+
+	// next is the sequence number of the next event, sequences start from 1
+	next uint64
+
+	mux sync.Mutex
+}
+
+// NewEventRing creates an EventRing keeping up to size events, DefaultEventRingSize is used when size is not positive
+func NewEventRing(size int) *EventRing {
+	if size <= 0 {
+		size = DefaultEventRingSize
+	}
+
+	return &EventRing{events: make([]Event, size), next: 1}
+}
+
+// Record stores an event replacing the oldest one when the ring is full, it can be registered as an Observer
+func (r *EventRing) Record(event Event) {
+	r.mux.Lock()
+	event.Seq = r.next
+	r.events[r.next%uint64(len(r.events))] = event
+	r.next++
+	r.mux.Unlock()
+}
+
+// Seq returns the sequence number of the latest event, 0 when nothing was recorded
+func (r *EventRing) Seq() uint64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.next - 1
+}
+
+// Since returns up to count events recorded after the event with sequence number seq which are accepted by filter
+// A count which is not positive returns all of them and a nil filter accepts every event
+// last is the sequence number to continue from, it is the latest sequence number when no more events are available,
+// lost is the number of events after seq which were replaced before they could be read
+func (r *EventRing) Since(seq uint64, count int, filter func(Event) bool) (events []Event, last uint64, lost uint64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	latest := r.next - 1
+	first := uint64(1)
+	if latest > uint64(len(r.events)) {
+		first = latest - uint64(len(r.events)) + 1
+	}
+
+	if seq+1 < first {
+		lost = first - seq - 1
+		seq = first - 1
+	}
+
+	for last = seq; last < latest; last++ {
+		if count > 0 && len(events) == count {
+			return events, last, lost
+		}
+
+		event := r.events[(last+1)%uint64(len(r.events))]
+		if filter == nil || filter(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events, latest, lost
+}
@@ -0,0 +1,153 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// eventKeys returns the keys of events
+func eventKeys(events []Event) []string {
+	keys := make([]string, len(events))
+	for i, event := range events {
+		keys[i] = event.Key
+	}
+
+	return keys
+}
+
+func TestEventRing_Since(t *testing.T) {
+	assert := testifyAssert.New(t)
+	r := NewEventRing(4)
+
+	events, last, lost := r.Since(0, 0, nil)
+	assert.Empty(events)
+	assert.Equal(uint64(0), last)
+	assert.Equal(uint64(0), lost)
+
+	for i := 1; i <= 3; i++ {
+		r.Record(Event{Kind: EventSet, Key: "k" + strconv.Itoa(i)})
+	}
+
+	events, last, lost = r.Since(0, 0, nil)
+	assert.Equal([]string{"k1", "k2", "k3"}, eventKeys(events))
+	assert.Equal(uint64(1), events[0].Seq)
+	assert.Equal(uint64(3), last)
+	assert.Equal(uint64(0), lost)
+
+	// count stops early and last continues after the returned events
+	events, last, _ = r.Since(0, 2, nil)
+	assert.Equal([]string{"k1", "k2"}, eventKeys(events))
+	assert.Equal(uint64(2), last)
+	events, last, _ = r.Since(last, 2, nil)
+	assert.Equal([]string{"k3"}, eventKeys(events))
+	assert.Equal(uint64(3), last)
+
+	// the oldest events are replaced once the ring wraps around
+	for i := 4; i <= 10; i++ {
+		r.Record(Event{Kind: EventDel, Key: "k" + strconv.Itoa(i)})
+	}
+	assert.Equal(uint64(10), r.Seq())
+
+	events, last, lost = r.Since(3, 0, nil)
+	assert.Equal([]string{"k7", "k8", "k9", "k10"}, eventKeys(events))
+	assert.Equal(uint64(10), last)
+	assert.Equal(uint64(3), lost)
+
+	events, _, lost = r.Since(6, 0, nil)
+	assert.Len(events, 4)
+	assert.Equal(uint64(0), lost)
+
+	// filtered events are skipped but last still moves past them
+	events, last, _ = r.Since(6, 1, func(event Event) bool { return event.Key == "k9" })
+	assert.Equal([]string{"k9"}, eventKeys(events))
+	assert.Equal(uint64(9), last)
+
+	events, last, lost = r.Since(10, 0, nil)
+	assert.Empty(events)
+	assert.Equal(uint64(10), last)
+	assert.Equal(uint64(0), lost)
+}
+
+func TestDB_Observe(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewIndexedDB(2)
+
+	var first, second []Event
+	cancel := db.Observe(func(event Event) { first = append(first, event) })
+	db.Observe(func(event Event) { second = append(second, event) })
+
+	db.Set("k", NewDataNode(TypeString, -1, "v"))
+	db.Set("k", NewDataNode(TypeString, -1, "w"))
+	db.Del([]string{"k"})
+	assert.Len(first, 3)
+	assert.Equal(EventSet, first[0].Kind)
+	assert.Equal(EventOverwrite, first[1].Kind)
+	assert.Equal(EventDel, first[2].Kind)
+	assert.Equal(2, first[0].DB)
+
+	// a cancelled observer receives nothing while others still do
+	cancel()
+	cancel()
+	db.Set("k", NewDataNode(TypeString, -1, "v"))
+	assert.Len(first, 3)
+	assert.Len(second, 4)
+}
+
+func TestDB_TouchUnreported(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+	db.Set("changed", NewDataNode(TypeString, -1, "v"))
+
+	// nothing is recorded without observers
+	db.Set("set", NewDataNode(TypeString, -1, "v"))
+	db.TouchUnreported([]string{"set"})
+
+	var events []Event
+	cancel := db.Observe(func(event Event) { events = append(events, event) })
+
+	db.Set("set", NewDataNode(TypeString, -1, "w"))
+	db.TouchUnreported([]string{"set", "changed", "changed", "missing"})
+	assert.Len(events, 2)
+	assert.Equal(EventOverwrite, events[0].Kind)
+	assert.Equal(EventModify, events[1].Kind)
+	assert.Equal("changed", events[1].Key)
+	assert.Equal(TypeString, events[1].Type)
+
+	// keys reported before the previous call are reported again
+	events = nil
+	db.TouchUnreported([]string{"set"})
+	assert.Len(events, 1)
+	assert.Equal(EventModify, events[0].Kind)
+
+	cancel()
+	events = nil
+	db.TouchUnreported([]string{"set"})
+	assert.Empty(events)
+}
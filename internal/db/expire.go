@@ -0,0 +1,204 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kasvith/kache/internal/sys"
+)
+
+const (
+	// DefaultHz is the default number of active expire cycles per second
+	DefaultHz = 10
+
+	// DefaultActiveExpireKeysPerLoop is the default number of keys sampled in a single loop of a cycle
+	DefaultActiveExpireKeysPerLoop = 20
+
+	// DefaultActiveExpireStalePercent is the default percentage of expired keys in a sample which starts another loop
+	DefaultActiveExpireStalePercent = 10
+
+	// DefaultActiveExpireTimePercent is the default percentage of a cycle period a cycle is allowed to run
+	DefaultActiveExpireTimePercent = 25
+)
+
+// ExpireConfig configures the active expire cycle
+type ExpireConfig struct {
+	// Hz is the number of cycles per second
+	Hz int
+
+	// KeysPerLoop is the number of keys with an expiration sampled in a single loop
+	KeysPerLoop int
+
+	// StalePercent is the percentage of expired keys in a sample which makes the cycle run another loop
+	StalePercent int
+
+	// TimePercent is the percentage of the cycle period a cycle can spend before giving up
+	TimePercent int
+}
+
+// withDefaults fills unset values with defaults
+func (conf ExpireConfig) withDefaults() ExpireConfig {
+	if conf.Hz <= 0 {
+		conf.Hz = DefaultHz
+	}
+
+	if conf.KeysPerLoop <= 0 {
+		conf.KeysPerLoop = DefaultActiveExpireKeysPerLoop
+	}
+
+	if conf.StalePercent <= 0 {
+		conf.StalePercent = DefaultActiveExpireStalePercent
+	}
+
+	if conf.TimePercent <= 0 || conf.TimePercent > 100 {
+		conf.TimePercent = DefaultActiveExpireTimePercent
+	}
+
+	return conf
+}
+
+// ExpiredKeys returns the number of keys removed due to expiration, both lazily and actively
+func (db *DB) ExpiredKeys() int64 {
+	return atomic.LoadInt64(&db.expired)
+}
+
+// ExpiresCount returns the number of keys which have an expiration
+func (db *DB) ExpiresCount() int {
+	db.mux.RLock()
+	count := db.expires.Len()
+	db.mux.RUnlock()
+	return count
+}
+
+// ActiveExpireCycle samples keys with an expiration and removes the expired ones
+// Samples continue where the previous one ended so every key with an expiration is checked eventually
+// Sampling continues while more than stalePercent of a sample was expired until the deadline is reached
+// It returns the number of removed keys
+func (db *DB) ActiveExpireCycle(keysPerLoop, stalePercent int, deadline time.Time) int {
+	expired := 0
+
+	for {
+		db.mux.Lock()
+
+		var sample []string
+		db.expireCursor = db.expires.Scan(db.expireCursor, keysPerLoop, func(key string) {
+			sample = append(sample, key)
+		})
+
+		removed := 0
+		now := sys.NowMillis()
+		for _, key := range sample {
+			if node, ok := db.file[key]; !ok || node.Expiration() <= now {
+				db.expireLocked(key)
+				removed++
+			}
+		}
+
+		db.mux.Unlock()
+
+		expired += removed
+		if len(sample) == 0 || removed*100 <= len(sample)*stalePercent || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	return expired
+}
+
+// ActiveExpirer periodically runs active expire cycles on a set of databases
+type ActiveExpirer struct {
+	conf ExpireConfig
+	dbs  []*DB
+
+	// cycles is the number of completed cycles
+	cycles int64
+
+	// timedOut is the number of cycles which reached their time limit
+	timedOut int64
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewActiveExpirer creates an ActiveExpirer for given databases, zero config values fall back to defaults
+func NewActiveExpirer(conf ExpireConfig, dbs ...*DB) *ActiveExpirer {
+	return &ActiveExpirer{conf: conf.withDefaults(), dbs: dbs, stop: make(chan struct{})}
+}
+
+// Start runs the expire cycles in background until Stop is called
+func (e *ActiveExpirer) Start() {
+	period := time.Second / time.Duration(e.conf.Hz)
+	ticker := time.NewTicker(period)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.stop:
+				return
+			case <-ticker.C:
+				e.cycle(period * time.Duration(e.conf.TimePercent) / 100)
+			}
+		}
+	}()
+}
+
+// cycle runs a single expire cycle over all databases within the time limit
+func (e *ActiveExpirer) cycle(limit time.Duration) {
+	deadline := time.Now().Add(limit)
+
+	for _, db := range e.dbs {
+		if time.Now().After(deadline) {
+			atomic.AddInt64(&e.timedOut, 1)
+			break
+		}
+
+		db.ActiveExpireCycle(e.conf.KeysPerLoop, e.conf.StalePercent, deadline)
+	}
+
+	atomic.AddInt64(&e.cycles, 1)
+}
+
+// Stop stops running expire cycles
+func (e *ActiveExpirer) Stop() {
+	e.once.Do(func() {
+		close(e.stop)
+	})
+}
+
+// Cycles returns the number of completed expire cycles
+func (e *ActiveExpirer) Cycles() int64 {
+	return atomic.LoadInt64(&e.cycles)
+}
+
+// TimedOutCycles returns the number of expire cycles which hit the time limit
+func (e *ActiveExpirer) TimedOutCycles() int64 {
+	return atomic.LoadInt64(&e.timedOut)
+}
@@ -0,0 +1,156 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kasvith/kache/internal/sys"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// newExpiringDB creates a db with expired, live and persistent keys
+func newExpiringDB(expired, live, persistent int) *DB {
+	db := NewDB()
+	now := sys.NowMillis()
+
+	for i := 0; i < expired; i++ {
+		db.Set("expired"+strconv.Itoa(i), NewDataNode(TypeString, now-1, "v"))
+	}
+
+	for i := 0; i < live; i++ {
+		db.Set("live"+strconv.Itoa(i), NewDataNode(TypeString, now+60000, "v"))
+	}
+
+	for i := 0; i < persistent; i++ {
+		db.Set("persistent"+strconv.Itoa(i), NewDataNode(TypeString, -1, "v"))
+	}
+
+	return db
+}
+
+func TestDB_ActiveExpireCycle(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := newExpiringDB(500, 100, 100)
+
+	assert.Equal(700, db.Len())
+	assert.Equal(600, db.ExpiresCount())
+
+	// sampling continues while the samples are mostly expired
+	removed := db.ActiveExpireCycle(20, 10, time.Now().Add(time.Minute))
+
+	assert.True(removed >= 450, "only %d keys were removed", removed)
+	assert.Equal(int64(removed), db.ExpiredKeys())
+	assert.Equal(700-removed, db.Len())
+	assert.Equal(600-removed, db.ExpiresCount())
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(1, db.Exists("live"+strconv.Itoa(i)))
+		assert.Equal(1, db.Exists("persistent"+strconv.Itoa(i)))
+	}
+}
+
+func TestDB_ActiveExpireCycleDeadline(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := newExpiringDB(500, 0, 0)
+
+	// a passed deadline stops the cycle after a single loop
+	// a sample covers whole buckets so it may hold a few more keys
+	removed := db.ActiveExpireCycle(20, 10, time.Now().Add(-time.Second))
+	assert.True(removed >= 20 && removed < 40, "%d keys were removed", removed)
+	assert.Equal(500-removed, db.Len())
+}
+
+func TestDB_ActiveExpireCycleLiveKeys(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := newExpiringDB(0, 100, 100)
+
+	assert.Equal(0, db.ActiveExpireCycle(20, 10, time.Now().Add(time.Minute)))
+	assert.Equal(200, db.Len())
+	assert.Equal(int64(0), db.ExpiredKeys())
+}
+
+func TestDB_ActiveExpireCycleResizing(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := newExpiringDB(0, 100, 0)
+	now := sys.NowMillis()
+
+	// expired keys are added and removed between loops so the expires index grows and shrinks during the sampling
+	removed := 0
+	for i := 0; i < 4000; i++ {
+		db.Set("expired"+strconv.Itoa(i), NewDataNode(TypeString, now-1, "v"))
+		if i%4 == 0 {
+			removed += db.ActiveExpireCycle(5, 100, time.Now().Add(time.Minute))
+		}
+	}
+
+	// every key of the index is sampled eventually
+	for i := 0; i < 10000 && db.Len() > 100; i++ {
+		removed += db.ActiveExpireCycle(5, 100, time.Now().Add(time.Minute))
+	}
+
+	assert.Equal(4000, removed)
+	assert.Equal(100, db.ExpiresCount())
+	for i := 0; i < 100; i++ {
+		assert.Equal(1, db.Exists("live"+strconv.Itoa(i)))
+	}
+}
+
+func TestActiveExpirer(t *testing.T) {
+	assert := testifyAssert.New(t)
+	first, second := newExpiringDB(100, 10, 0), newExpiringDB(100, 0, 10)
+
+	expirer := NewActiveExpirer(ExpireConfig{Hz: 100}, first, second)
+	expirer.Start()
+	defer expirer.Stop()
+
+	// expired keys are removed without being accessed
+	deadline := time.Now().Add(5 * time.Second)
+	for (first.Len() > 10 || second.Len() > 10) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(10, first.Len())
+	assert.Equal(10, second.Len())
+	assert.Equal(int64(100), first.ExpiredKeys())
+	assert.Equal(int64(100), second.ExpiredKeys())
+	assert.True(expirer.Cycles() > 0)
+}
+
+func TestExpireConfig_Defaults(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	conf := ExpireConfig{TimePercent: 200}.withDefaults()
+	assert.Equal(ExpireConfig{
+		Hz:           DefaultHz,
+		KeysPerLoop:  DefaultActiveExpireKeysPerLoop,
+		StalePercent: DefaultActiveExpireStalePercent,
+		TimePercent:  DefaultActiveExpireTimePercent,
+	}, conf)
+}
@@ -0,0 +1,86 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"maps"
+	"sync/atomic"
+)
+
+var (
+	// generation is incremented by every Fork, nodes created before the last fork can be shared with a fork
+	generation uint64
+
+	// forks is the number of forks which were not released yet
+	forks int64
+)
+
+// Fork returns read only copies of dbs sharing their nodes, the copies only support ForEach and Len
+// Nodes are copied on write, callers modifying a node in place must call Unshare first so forks keep the old value
+// release must be called once the forks are not used anymore
+// The caller must make sure dbs are not modified during Fork
+func Fork(dbs []*DB) (copies []*DB, release func()) {
+	atomic.AddUint64(&generation, 1)
+	atomic.AddInt64(&forks, 1)
+
+	copies = make([]*DB, len(dbs))
+	for i, database := range dbs {
+		database.mux.RLock()
+		copies[i] = &DB{file: maps.Clone(database.file), number: database.number}
+		database.mux.RUnlock()
+	}
+
+	released := int32(0)
+	return copies, func() {
+		if atomic.CompareAndSwapInt32(&released, 0, 1) {
+			atomic.AddInt64(&forks, -1)
+		}
+	}
+}
+
+// Unshare replaces the node of key with a copy made by clone when it is shared with a fork
+// Commands call it before modifying the value or the expiration of a node in place
+func (db *DB) Unshare(key string, clone func(node *DataNode) (*DataNode, error)) error {
+	if atomic.LoadInt64(&forks) == 0 {
+		return nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	node, ok := db.file[key]
+	if !ok || node.gen >= atomic.LoadUint64(&generation) {
+		return nil
+	}
+
+	copied, err := clone(node)
+	if err != nil {
+		return err
+	}
+
+	db.file[key] = copied
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package db
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+// cloneString copies a node holding a string
+func cloneString(node *DataNode) (*DataNode, error) {
+	return NewDataNode(node.Type, node.Expiration(), node.Value), nil
+}
+
+func TestFork(t *testing.T) {
+	assert := testifyAssert.New(t)
+	db := NewDB()
+	db.Set("a", NewDataNode(TypeString, -1, "1"))
+	db.Set("b", NewDataNode(TypeString, -1, "2"))
+	shared, _ := db.GetNode("a")
+
+	forks, release := Fork([]*DB{db})
+	fork := forks[0]
+	assert.Equal(2, fork.Len())
+
+	// the fork is not affected by changes of the db
+	db.Set("c", NewDataNode(TypeString, -1, "3"))
+	db.Del([]string{"b"})
+	assert.Equal(2, fork.Len())
+
+	assert.NoError(db.Unshare("a", cloneString))
+	node, _ := db.GetNode("a")
+	assert.True(shared != node)
+	node.Value = "changed"
+
+	values := make(map[string]interface{})
+	fork.ForEach(func(key string, node *DataNode) bool {
+		values[key] = node.Value
+		return true
+	})
+	assert.Equal(map[string]interface{}{"a": "1", "b": "2"}, values)
+
+	// nodes created after the fork and copies are not shared
+	created, _ := db.GetNode("c")
+	assert.NoError(db.Unshare("c", cloneString))
+	assert.NoError(db.Unshare("a", cloneString))
+	assert.NoError(db.Unshare("missing", cloneString))
+	current, _ := db.GetNode("c")
+	assert.True(created == current)
+	current, _ = db.GetNode("a")
+	assert.True(node == current)
+
+	// nothing is copied once the fork is released
+	db.Set("d", NewDataNode(TypeString, -1, "4"))
+	forks, releaseOther := Fork([]*DB{db})
+	release()
+	releaseOther()
+	releaseOther()
+	assert.Equal(3, forks[0].Len())
+
+	created, _ = db.GetNode("d")
+	assert.NoError(db.Unshare("d", cloneString))
+	current, _ = db.GetNode("d")
+	assert.True(created == current)
+}
@@ -26,7 +26,9 @@ package db
 
 import (
 	"sync"
-	"time"
+	"sync/atomic"
+
+	"github.com/kasvith/kache/internal/sys"
 )
 
 // DataType is a enum type which holds the type of data
@@ -44,32 +46,61 @@ const (
 
 	// TypeSet set type
 	TypeSet
+
+	// TypeZSet sorted set type
+	TypeZSet
+
+	// TypeStream stream type
+	TypeStream
 )
 
+// String returns the name of the type
+func (t DataType) String() string {
+	switch t {
+	case TypeString:
+		return "string"
+	case TypeList:
+		return "list"
+	case TypeHashMap:
+		return "hash"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	}
+
+	return "none"
+}
+
 // DataNode holds data node which used to store in db
 type DataNode struct {
 	// Type of the data
 	Type DataType
 
-	// ExpiresAt for the data(unix timestamp)
+	// ExpiresAt for the data(unix timestamp in milliseconds), -1 when data never expires
 	ExpiresAt int64
 
 	// Value of the data
 	Value interface{}
 
+	// gen is the fork generation the node was created in, older nodes can be shared with forks
+	gen uint64
+
 	// rw mux
 	mux sync.RWMutex
 }
 
 // NewDataNode creates a new *DataNode
 func NewDataNode(t DataType, exp int64, val interface{}) *DataNode {
-	return &DataNode{Type: t, ExpiresAt: exp, Value: val}
+	return &DataNode{Type: t, ExpiresAt: exp, Value: val, gen: atomic.LoadUint64(&generation)}
 }
 
 // IsExpired will be true when node expired
 func (node *DataNode) IsExpired() bool {
 	node.mux.RLock()
-	expired := node.ExpiresAt != -1 && node.ExpiresAt < time.Now().Unix()
+	expired := node.ExpiresAt != -1 && node.ExpiresAt <= sys.NowMillis()
 	node.mux.RUnlock()
 	return expired
 }
@@ -80,3 +111,11 @@ func (node *DataNode) SetExpiration(ttl int64) {
 	node.ExpiresAt = ttl
 	node.mux.Unlock()
 }
+
+// Expiration returns the expiration of the node, -1 when node never expires
+func (node *DataNode) Expiration() int64 {
+	node.mux.RLock()
+	exp := node.ExpiresAt
+	node.mux.RUnlock()
+	return exp
+}
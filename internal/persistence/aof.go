@@ -0,0 +1,487 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/stream"
+	"github.com/kasvith/kache/pkg/types/zset"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+const (
+	// FsyncAlways syncs the AOF after every write
+	FsyncAlways = "always"
+
+	// FsyncEverySec syncs the AOF once per second
+	FsyncEverySec = "everysec"
+
+	// FsyncNo leaves syncing to the operating system
+	FsyncNo = "no"
+)
+
+// rewriteBatchSize is the maximum number of elements written in a single command by a rewrite
+const rewriteBatchSize = 64
+
+var (
+	// ErrCorruptedAOF is returned when the AOF contains invalid data which is not a truncated tail
+	ErrCorruptedAOF = errors.New("corrupted append only file")
+
+	// ErrTruncatedAOF is returned when the AOF ends with an incomplete command and repairing is not allowed
+	ErrTruncatedAOF = errors.New("append only file ends with an incomplete command")
+
+	// ErrRewriteInProgress is returned when a rewrite is started while another one is running
+	ErrRewriteInProgress = errors.New("background append only file rewriting already in progress")
+)
+
+// ValidFsyncPolicy reports whether policy is one of the Fsync* policies
+func ValidFsyncPolicy(policy string) bool {
+	return policy == FsyncAlways || policy == FsyncEverySec || policy == FsyncNo
+}
+
+// AppendCommand appends a command in RESP format to buf
+func AppendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, resp2.TypeArray)
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, resp2.CRLF...)
+	for _, arg := range args {
+		buf = append(buf, resp2.TypeBulkString)
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, resp2.CRLF...)
+		buf = append(buf, arg...)
+		buf = append(buf, resp2.CRLF...)
+	}
+
+	return buf
+}
+
+// Entry is a command logged to the AOF
+type Entry struct {
+	// DB is the index of the database the command was executed on
+	DB int
+
+	// Args of the command including its name
+	Args []string
+}
+
+// AOF is an append only log of commands which modified the key space
+type AOF struct {
+	path   string
+	policy string
+
+	file *os.File
+	w    *bufio.Writer
+
+	// selected is the database the following commands apply to, -1 when unknown
+	selected int
+
+	// size is the current size of the file
+	size int64
+
+	// rewriting is true while a rewrite collects commands in rewriteBuf
+	rewriting  bool
+	rewriteBuf []byte
+
+	// syncErr is the last error of a background sync
+	syncErr error
+
+	stop chan struct{}
+	mux  sync.Mutex
+}
+
+// OpenAOF opens the AOF at path for appending with the given fsync policy
+func OpenAOF(path, policy string) (*AOF, error) {
+	if !ValidFsyncPolicy(policy) {
+		return nil, fmt.Errorf("invalid appendfsync policy %q", policy)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	aof := &AOF{path: path, policy: policy, file: file, w: bufio.NewWriter(file), selected: -1, size: info.Size(), stop: make(chan struct{})}
+	if policy == FsyncEverySec {
+		go aof.syncEverySec()
+	}
+
+	return aof, nil
+}
+
+// syncEverySec flushes and syncs the file once per second
+func (aof *AOF) syncEverySec() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-aof.stop:
+			return
+		case <-ticker.C:
+			aof.mux.Lock()
+			if err := aof.w.Flush(); err == nil {
+				aof.syncErr = aof.file.Sync()
+			} else {
+				aof.syncErr = err
+			}
+			aof.mux.Unlock()
+		}
+	}
+}
+
+// Append logs executed commands, databases are selected as needed
+func (aof *AOF) Append(entries ...Entry) error {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	var buf []byte
+	for _, entry := range entries {
+		if entry.DB != aof.selected {
+			buf = AppendCommand(buf, []string{"select", strconv.Itoa(entry.DB)})
+			aof.selected = entry.DB
+		}
+
+		buf = AppendCommand(buf, entry.Args)
+	}
+
+	if aof.rewriting {
+		aof.rewriteBuf = append(aof.rewriteBuf, buf...)
+	}
+
+	n, err := aof.w.Write(buf)
+	aof.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	switch aof.policy {
+	case FsyncAlways:
+		if err := aof.w.Flush(); err != nil {
+			return err
+		}
+		return aof.file.Sync()
+	case FsyncNo:
+		return aof.w.Flush()
+	}
+
+	// everysec flushes in background, but a background failure is reported to the writers
+	return aof.syncErr
+}
+
+// Size returns the current size of the file
+func (aof *AOF) Size() int64 {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+	return aof.size
+}
+
+// Close flushes, syncs and closes the file
+func (aof *AOF) Close() error {
+	close(aof.stop)
+
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	if err := aof.w.Flush(); err != nil {
+		return err
+	}
+
+	if err := aof.file.Sync(); err != nil {
+		return err
+	}
+
+	return aof.file.Close()
+}
+
+// StartRewrite starts collecting commands appended from now on so they can be added to the rewritten file
+// The caller must make sure no commands are appended between taking the base of the rewrite and calling StartRewrite
+func (aof *AOF) StartRewrite() error {
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	if aof.rewriting {
+		return ErrRewriteInProgress
+	}
+
+	aof.rewriting = true
+	aof.rewriteBuf = nil
+
+	// the next command selects its database again since the base might end with another one
+	aof.selected = -1
+	return nil
+}
+
+// AbortRewrite stops collecting commands for a rewrite
+func (aof *AOF) AbortRewrite() {
+	aof.mux.Lock()
+	aof.rewriting, aof.rewriteBuf = false, nil
+	aof.mux.Unlock()
+}
+
+// FinishRewrite replaces the file with base followed by the commands appended since StartRewrite
+func (aof *AOF) FinishRewrite(base []byte) error {
+	// the base is written without holding the lock so appends are not blocked meanwhile
+	tmp, err := os.CreateTemp(filepath.Dir(aof.path), "temp-rewrite-*-"+filepath.Base(aof.path))
+	if err != nil {
+		aof.AbortRewrite()
+		return err
+	}
+
+	if _, err := tmp.Write(base); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		aof.AbortRewrite()
+		return err
+	}
+
+	aof.mux.Lock()
+	defer aof.mux.Unlock()
+
+	err = aof.switchLocked(tmp, int64(len(base)))
+	aof.rewriting, aof.rewriteBuf = false, nil
+	return err
+}
+
+// switchLocked appends the collected commands to tmp and replaces the file with it, caller must hold the lock
+func (aof *AOF) switchLocked(tmp *os.File, size int64) error {
+	_, err := tmp.Write(aof.rewriteBuf)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = aof.w.Flush()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), aof.path)
+	}
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	aof.file.Close()
+	aof.file, aof.w = tmp, bufio.NewWriter(tmp)
+	aof.size = size + int64(len(aof.rewriteBuf))
+	return nil
+}
+
+// RewriteCommands generates the shortest list of commands which recreate all keys of dbs
+// The caller must make sure dbs are not modified meanwhile
+func RewriteCommands(dbs []*db.DB) []byte {
+	var buf []byte
+	for i, database := range dbs {
+		selected := false
+		database.ForEach(func(key string, node *db.DataNode) bool {
+			if !selected {
+				buf = AppendCommand(buf, []string{"select", strconv.Itoa(i)})
+				selected = true
+			}
+
+			buf = appendValueCommands(buf, key, node)
+			if exp := node.Expiration(); exp != -1 {
+				buf = AppendCommand(buf, []string{"pexpireat", key, strconv.FormatInt(exp, 10)})
+			}
+			return true
+		})
+	}
+
+	return buf
+}
+
+// appendValueCommands appends commands which create the value of a node, big collections are split in batches
+func appendValueCommands(buf []byte, key string, node *db.DataNode) []byte {
+	var cmd string
+	var items []string
+
+	switch node.Type {
+	case db.TypeString:
+		return AppendCommand(buf, []string{"set", key, util.ToString(node.Value)})
+	case db.TypeList:
+		cmd, items = "rpush", node.Value.(*list.TList).Range(0, -1)
+	case db.TypeHashMap:
+		cmd, items = "hset", node.Value.(*hashmap.HashMap).Fields()
+	case db.TypeSet:
+		cmd, items = "sadd", node.Value.(*set.Set).Elems()
+	case db.TypeZSet:
+		cmd = "zadd"
+		for _, elem := range node.Value.(*zset.ZSet).Elements() {
+			items = append(items, strconv.FormatFloat(elem.Score, 'g', 17, 64), elem.Member)
+		}
+	case db.TypeStream:
+		return appendStreamCommands(buf, key, node.Value.(*stream.Stream))
+	default:
+		return buf
+	}
+
+	// hashes and sorted sets are written as pairs
+	step := rewriteBatchSize
+	if cmd == "hset" || cmd == "zadd" {
+		step *= 2
+	}
+
+	for i := 0; i < len(items); i += step {
+		end := i + step
+		if end > len(items) {
+			end = len(items)
+		}
+
+		buf = AppendCommand(buf, append([]string{cmd, key}, items[i:end]...))
+	}
+
+	return buf
+}
+
+// appendStreamCommands appends commands recreating a stream with its consumer groups and pending entries
+func appendStreamCommands(buf []byte, key string, s *stream.Stream) []byte {
+	info := s.Info()
+	for _, entry := range s.Range(stream.MinID, stream.MaxID, 0, false) {
+		buf = AppendCommand(buf, append([]string{"xadd", key, entry.ID.String()}, entry.Fields...))
+	}
+
+	// an empty stream is created by adding an entry which is trimmed right away
+	if info.Length == 0 {
+		id := info.LastID
+		if id == stream.MinID {
+			id.Seq = 1
+		}
+		buf = AppendCommand(buf, []string{"xadd", key, "maxlen", "0", id.String(), "x", "y"})
+	}
+
+	buf = AppendCommand(buf, []string{"xsetid", key, info.LastID.String(),
+		"entriesadded", strconv.FormatUint(info.EntriesAdded, 10), "maxdeletedid", info.MaxDeletedID.String()})
+
+	for _, g := range s.Groups(true) {
+		buf = AppendCommand(buf, []string{"xgroup", "create", key, g.Name, g.LastID.String(), "entriesread", strconv.FormatInt(g.EntriesRead, 10)})
+		for _, c := range g.Consumers {
+			buf = AppendCommand(buf, []string{"xgroup", "createconsumer", key, g.Name, c.Name})
+		}
+
+		for _, p := range g.PEL {
+			buf = AppendCommand(buf, []string{"xclaim", key, g.Name, p.Consumer, "0", p.ID.String(),
+				"time", strconv.FormatInt(p.DeliveryTime, 10), "retrycount", strconv.FormatInt(p.DeliveryCount, 10), "force", "justid"})
+		}
+	}
+
+	return buf
+}
+
+// countingReader counts the bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ReplayAOF calls fn for every command logged in the AOF at path
+// A file ending with an incomplete command or an unfinished MULTI is truncated to the last complete command when
+// repair is true, otherwise ErrTruncatedAOF is returned. truncated reports whether the file was repaired
+func ReplayAOF(path string, repair bool, fn func(cmd *protocol.Command)) (truncated bool, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	counter := &countingReader{r: file}
+	reader := bufio.NewReader(counter)
+	parser := resp2.NewParser(reader)
+
+	// valid is the offset after the last complete command, multi is the offset of an unfinished MULTI
+	valid, multi := int64(0), int64(-1)
+	for {
+		start := valid
+		cmd, err := parser.Parse()
+		if err == io.EOF && counter.n-int64(reader.Buffered()) == valid {
+			break
+		}
+
+		if err != nil {
+			// anything else than an incomplete command at the end of the file is a corruption
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				return false, ErrCorruptedAOF
+			}
+
+			truncated = true
+			break
+		}
+
+		valid = counter.n - int64(reader.Buffered())
+		if cmd == nil || cmd.Name == "" {
+			continue
+		}
+
+		switch cmd.Name {
+		case "multi":
+			multi = start
+		case "exec":
+			multi = -1
+		}
+
+		fn(cmd)
+	}
+
+	if multi != -1 {
+		truncated, valid = true, multi
+	}
+
+	if !truncated {
+		return false, nil
+	}
+
+	if !repair {
+		return false, ErrTruncatedAOF
+	}
+
+	return true, file.Truncate(valid)
+}
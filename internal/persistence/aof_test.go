@@ -0,0 +1,144 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/list"
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func writeAOF(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	testifyAssert.Nil(t, os.WriteFile(path, []byte(data), 0644))
+	return path
+}
+
+func replay(path string, repair bool) ([]string, bool, error) {
+	var cmds []string
+	truncated, err := ReplayAOF(path, repair, func(cmd *protocol.Command) {
+		cmds = append(cmds, strings.Join(append([]string{cmd.Name}, cmd.Args...), " "))
+	})
+	return cmds, truncated, err
+}
+
+func TestAppendCommand(t *testing.T) {
+	testifyAssert.Equal(t, "*3\r\n$3\r\nset\r\n$3\r\nkey\r\n$0\r\n\r\n", string(AppendCommand(nil, []string{"set", "key", ""})))
+}
+
+func TestAOF_AppendAndReplay(t *testing.T) {
+	assert := testifyAssert.New(t)
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	for _, policy := range []string{FsyncAlways, FsyncEverySec, FsyncNo} {
+		os.Remove(path)
+
+		aof, err := OpenAOF(path, policy)
+		assert.Nil(err)
+		assert.Nil(aof.Append(Entry{DB: 0, Args: []string{"set", "a", "1"}}, Entry{DB: 0, Args: []string{"incr", "a"}}))
+		assert.Nil(aof.Append(Entry{DB: 2, Args: []string{"del", "a"}}))
+		assert.Nil(aof.Close())
+
+		cmds, truncated, err := replay(path, false)
+		assert.Nil(err)
+		assert.False(truncated)
+		assert.Equal([]string{"select 0", "set a 1", "incr a", "select 2", "del a"}, cmds)
+	}
+
+	_, err := OpenAOF(path, "sometimes")
+	assert.NotNil(err)
+}
+
+func TestReplayAOF_TruncatedTail(t *testing.T) {
+	assert := testifyAssert.New(t)
+	complete := string(AppendCommand(nil, []string{"set", "a", "1"}))
+
+	for _, tail := range []string{"*3\r\n$3\r\nset\r\n$1\r\nb", "*3", "*3\r\n$3\r\nse"} {
+		path := writeAOF(t, complete+tail)
+
+		_, _, err := replay(path, false)
+		assert.Equal(ErrTruncatedAOF, err)
+
+		cmds, truncated, err := replay(path, true)
+		assert.Nil(err)
+		assert.True(truncated)
+		assert.Equal([]string{"set a 1"}, cmds)
+
+		data, _ := os.ReadFile(path)
+		assert.Equal(complete, string(data))
+	}
+}
+
+func TestReplayAOF_UnfinishedMulti(t *testing.T) {
+	assert := testifyAssert.New(t)
+	complete := string(AppendCommand(nil, []string{"set", "a", "1"}))
+	multi := string(AppendCommand(AppendCommand(nil, []string{"multi"}), []string{"set", "b", "2"}))
+
+	path := writeAOF(t, complete+multi)
+	_, truncated, err := replay(path, true)
+	assert.Nil(err)
+	assert.True(truncated)
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(complete, string(data))
+}
+
+func TestReplayAOF_Corrupted(t *testing.T) {
+	path := writeAOF(t, "*1\r\n$4\r\nping\r\ngarbage\r\n*1\r\n$4\r\nping\r\n")
+	_, _, err := replay(path, true)
+	testifyAssert.Equal(t, ErrCorruptedAOF, err)
+}
+
+func TestRewriteCommands(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := []*db.DB{db.NewDB(), db.NewDB()}
+
+	l := list.New()
+	elems := make([]string, rewriteBatchSize+1)
+	for i := range elems {
+		elems[i] = "x"
+	}
+	l.TPush(elems)
+	dbs[1].Set("list", db.NewDataNode(db.TypeList, -1, l))
+	exp := sys.NowMillis() + 60000
+	dbs[1].Set("str", db.NewDataNode(db.TypeString, exp, "v"))
+	dbs[1].Set("expired", db.NewDataNode(db.TypeString, 1000, "v"))
+
+	cmds, _, err := replay(writeAOF(t, string(RewriteCommands(dbs))), false)
+	assert.Nil(err)
+	assert.Equal("select 1", cmds[0])
+	assert.Len(cmds, 5)
+	assert.Contains(cmds, "set str v")
+	assert.Contains(cmds, "pexpireat str "+strconv.FormatInt(exp, 10))
+}
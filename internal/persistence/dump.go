@@ -0,0 +1,134 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+
+	"github.com/kasvith/kache/internal/db"
+)
+
+// DumpVersion is the current version of the DUMP payload format
+const DumpVersion = 2
+
+// ErrInvalidDump is returned when a DUMP payload is malformed or its version or checksum do not match
+var ErrInvalidDump = errors.New("DUMP payload version or checksum are wrong")
+
+// Dump serializes a single node as DUMP does
+// The payload holds the expiration of the node when it has one and the value written by WriteValue,
+// followed by the format version and a checksum of everything before it
+func Dump(node *db.DataNode) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+
+	if exp := node.Expiration(); exp != -1 {
+		if err := enc.writeByte(opExpireMs); err != nil {
+			return nil, err
+		}
+		if err := enc.writeUint64(uint64(exp)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := enc.WriteValue(node); err != nil {
+		return nil, err
+	}
+
+	if err := enc.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var trailer [10]byte
+	binary.LittleEndian.PutUint16(trailer[:2], DumpVersion)
+	buf.Write(trailer[:2])
+	binary.LittleEndian.PutUint64(trailer[2:], crc64.Checksum(buf.Bytes(), crcTable))
+	buf.Write(trailer[2:])
+
+	return buf.Bytes(), nil
+}
+
+// Restore deserializes a payload created by Dump
+// The returned node keeps the expiration given to Dump, -1 when it had none
+func Restore(payload []byte) (*db.DataNode, error) {
+	if len(payload) < 10 {
+		return nil, ErrInvalidDump
+	}
+
+	body, trailer := payload[:len(payload)-8], payload[len(payload)-8:]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(trailer) {
+		return nil, ErrInvalidDump
+	}
+
+	body, version := body[:len(body)-2], binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version == 0 || version > DumpVersion {
+		return nil, ErrInvalidDump
+	}
+
+	dec := NewDecoder(bytes.NewReader(body))
+	t, err := dec.readByte()
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+
+	exp := int64(-1)
+	if t == opExpireMs {
+		at, err := dec.readUint64()
+		if err != nil {
+			return nil, ErrInvalidDump
+		}
+		exp = int64(at)
+
+		if t, err = dec.readByte(); err != nil {
+			return nil, ErrInvalidDump
+		}
+	}
+
+	dataType, value, err := dec.readPayload(t)
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+
+	// the value should take the whole payload
+	if _, err := dec.r.ReadByte(); err != io.EOF {
+		return nil, ErrInvalidDump
+	}
+
+	return db.NewDataNode(dataType, exp, value), nil
+}
+
+// Clone returns a copy of a node with its value and expiration which shares nothing with it
+func Clone(node *db.DataNode) (*db.DataNode, error) {
+	payload, err := Dump(node)
+	if err != nil {
+		return nil, err
+	}
+
+	return Restore(payload)
+}
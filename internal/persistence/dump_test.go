@@ -0,0 +1,103 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"testing"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestDump_RoundTrip(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	l := list.New()
+	l.TPush([]string{"a", "b", "c"})
+
+	m := hashmap.New()
+	m.Set("f1", "v1")
+
+	z := zset.New()
+	z.Add(1.5, "one", 0)
+	z.Add(-2, "two", 0)
+
+	exp := sys.NowMillis() + 60000
+	nodes := []*db.DataNode{
+		db.NewDataNode(db.TypeString, exp, "value"),
+		db.NewDataNode(db.TypeList, -1, l),
+		db.NewDataNode(db.TypeHashMap, -1, m),
+		db.NewDataNode(db.TypeSet, -1, set.NewFromSlice([]string{"x", "y"})),
+		db.NewDataNode(db.TypeZSet, exp, z),
+	}
+
+	for _, node := range nodes {
+		payload, err := Dump(node)
+		assert.Nil(err)
+
+		restored, err := Restore(payload)
+		assert.Nil(err)
+		assert.Equal(node.Type, restored.Type)
+		assert.Equal(node.Expiration(), restored.Expiration())
+	}
+
+	payload, _ := Dump(nodes[1])
+	restored, _ := Restore(payload)
+	assert.Equal([]string{"a", "b", "c"}, restored.Value.(*list.TList).Range(0, -1))
+
+	payload, _ = Dump(nodes[4])
+	restored, _ = Restore(payload)
+	assert.Equal(z.Elements(), restored.Value.(*zset.ZSet).Elements())
+}
+
+func TestDump_Invalid(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	payload, err := Dump(db.NewDataNode(db.TypeString, -1, "value"))
+	assert.Nil(err)
+
+	corrupted := append([]byte{}, payload...)
+	corrupted[1] ^= 0xFF
+	_, err = Restore(corrupted)
+	assert.Equal(ErrInvalidDump, err)
+
+	_, err = Restore(payload[:5])
+	assert.Equal(ErrInvalidDump, err)
+
+	// a newer version is rejected even with a valid checksum
+	newer := append([]byte{}, payload[:len(payload)-8]...)
+	binary.LittleEndian.PutUint16(newer[len(newer)-2:], DumpVersion+1)
+	newer = binary.LittleEndian.AppendUint64(newer, crc64.Checksum(newer, crcTable))
+	_, err = Restore(newer)
+	assert.Equal(ErrInvalidDump, err)
+}
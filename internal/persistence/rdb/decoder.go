@@ -0,0 +1,588 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+)
+
+// decoder reads an RDB file and computes its checksum
+type decoder struct {
+	r   *bufio.Reader
+	crc uint64
+	buf [8]byte
+}
+
+// unexpected converts an EOF in the middle of a file to ErrInvalidRDB
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidRDB
+	}
+
+	return err
+}
+
+func (d *decoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return unexpected(err)
+	}
+
+	d.crc = crc64Update(d.crc, p)
+	return nil
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if err := d.readFull(d.buf[:1]); err != nil {
+		return 0, err
+	}
+
+	return d.buf[0], nil
+}
+
+// readLength reads a length, encoded is true when the length describes a special string encoding
+func (d *decoder) readLength() (n uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case len6Bit:
+		return uint64(b & 0x3F), false, nil
+	case len14Bit:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case lenEnc:
+		return uint64(b & 0x3F), true, nil
+	}
+
+	switch b {
+	case len32Bit:
+		if err := d.readFull(d.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(d.buf[:4])), false, nil
+	case len64Bit:
+		if err := d.readFull(d.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(d.buf[:8]), false, nil
+	}
+
+	return 0, false, ErrInvalidRDB
+}
+
+// readLen reads a plain length
+func (d *decoder) readLen() (int, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return 0, err
+	}
+
+	if encoded || n > math.MaxInt32 {
+		return 0, ErrInvalidRDB
+	}
+
+	return int(n), nil
+}
+
+// readBytes reads n bytes without trusting n for allocations since it might come from a corrupted file
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	const chunk = 64 * 1024
+
+	b := make([]byte, 0, min(n, chunk))
+	for len(b) < n {
+		l := min(n-len(b), chunk)
+		b = append(b, make([]byte, l)...)
+		if err := d.readFull(b[len(b)-l:]); err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
+
+// readString reads a string in any of its encodings
+func (d *decoder) readString() (string, error) {
+	b, err := d.readStringBytes()
+	return string(b), err
+}
+
+func (d *decoder) readStringBytes() ([]byte, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	if !encoded {
+		if n > math.MaxInt32 {
+			return nil, ErrInvalidRDB
+		}
+		return d.readBytes(int(n))
+	}
+
+	switch n {
+	case encInt8, encInt16, encInt32:
+		size := 1 << n
+		if err := d.readFull(d.buf[:size]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.FormatInt(littleEndianInt(d.buf[:size]), 10)), nil
+	case encLZF:
+		clen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+
+		ulen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+
+		compressed, err := d.readBytes(clen)
+		if err != nil {
+			return nil, err
+		}
+
+		return lzfDecompress(compressed, ulen)
+	}
+
+	return nil, ErrInvalidRDB
+}
+
+// readStrings reads a length followed by that many strings
+func (d *decoder) readStrings(perItem int) ([]string, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0)
+	for i := 0; i < n*perItem; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// readScore reads a score of the old sorted set encoding which is stored as a string
+func (d *decoder) readScore() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	b := make([]byte, n)
+	if err := d.readFull(b); err != nil {
+		return 0, err
+	}
+
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, ErrInvalidRDB
+	}
+
+	return f, nil
+}
+
+// readBinaryScore reads a score stored as a little endian double
+func (d *decoder) readBinaryScore() (float64, error) {
+	if err := d.readFull(d.buf[:8]); err != nil {
+		return 0, err
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:8])), nil
+}
+
+// readEncoded reads a string holding a ziplist, listpack or intset and parses it
+func (d *decoder) readEncoded(parse func([]byte) ([]string, error)) ([]string, error) {
+	b, err := d.readStringBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(b)
+}
+
+// readValue reads a value of type t and converts it to a kache data type
+func (d *decoder) readValue(t byte) (db.DataType, interface{}, error) {
+	switch t {
+	case typeString:
+		s, err := d.readString()
+		return db.TypeString, s, err
+	case typeList:
+		elems, err := d.readStrings(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newList(elems)
+	case typeListZiplist:
+		elems, err := d.readEncoded(parseZiplist)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newList(elems)
+	case typeListQuicklist, typeListQuicklist2:
+		elems, err := d.readQuicklist(t == typeListQuicklist2)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newList(elems)
+	case typeSet:
+		members, err := d.readStrings(1)
+		if err != nil {
+			return 0, nil, err
+		}
+		return db.TypeSet, set.NewFromSlice(members), nil
+	case typeSetIntset, typeSetListpack:
+		parse := parseIntset
+		if t == typeSetListpack {
+			parse = parseListpack
+		}
+
+		members, err := d.readEncoded(parse)
+		if err != nil {
+			return 0, nil, err
+		}
+		return db.TypeSet, set.NewFromSlice(members), nil
+	case typeHash:
+		fields, err := d.readStrings(2)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newHash(fields)
+	case typeHashZipmap, typeHashZiplist, typeHashListpack:
+		parse := parseZiplist
+		switch t {
+		case typeHashZipmap:
+			parse = parseZipmap
+		case typeHashListpack:
+			parse = parseListpack
+		}
+
+		fields, err := d.readEncoded(parse)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newHash(fields)
+	case typeZSet, typeZSet2:
+		return d.readZSet(t == typeZSet2)
+	case typeZSetZiplist, typeZSetListpack:
+		parse := parseZiplist
+		if t == typeZSetListpack {
+			parse = parseListpack
+		}
+
+		pairs, err := d.readEncoded(parse)
+		if err != nil {
+			return 0, nil, err
+		}
+		return newZSetFromPairs(pairs)
+	}
+
+	return 0, nil, &ErrUnsupportedType{Type: t}
+}
+
+// readQuicklist reads the nodes of a quicklist, version 2 nodes are either plain elements or listpacks
+func (d *decoder) readQuicklist(v2 bool) ([]string, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	elems := make([]string, 0)
+	for i := 0; i < n; i++ {
+		container := quicklistNodePacked
+		if v2 {
+			if container, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		}
+
+		b, err := d.readStringBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []string
+		switch {
+		case container == quicklistNodePlain:
+			entries = []string{string(b)}
+		case container != quicklistNodePacked:
+			return nil, ErrInvalidRDB
+		case v2:
+			entries, err = parseListpack(b)
+		default:
+			entries, err = parseZiplist(b)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, entries...)
+	}
+
+	return elems, nil
+}
+
+// readZSet reads a sorted set with string or binary scores
+func (d *decoder) readZSet(binaryScores bool) (db.DataType, interface{}, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	z := zset.New()
+	for i := 0; i < n; i++ {
+		member, err := d.readString()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var score float64
+		if binaryScores {
+			score, err = d.readBinaryScore()
+		} else {
+			score, err = d.readScore()
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if _, _, err := z.Add(score, member, 0); err != nil {
+			return 0, nil, ErrInvalidRDB
+		}
+	}
+
+	return db.TypeZSet, z, nil
+}
+
+func newList(elems []string) (db.DataType, interface{}, error) {
+	l := list.New()
+	if len(elems) > 0 {
+		if err := l.TPush(elems); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return db.TypeList, l, nil
+}
+
+func newHash(fields []string) (db.DataType, interface{}, error) {
+	if len(fields)%2 != 0 {
+		return 0, nil, ErrInvalidRDB
+	}
+
+	m := hashmap.New()
+	for i := 0; i < len(fields); i += 2 {
+		m.Set(fields[i], fields[i+1])
+	}
+
+	return db.TypeHashMap, m, nil
+}
+
+// newZSetFromPairs creates a sorted set from members and scores one after another
+func newZSetFromPairs(pairs []string) (db.DataType, interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return 0, nil, ErrInvalidRDB
+	}
+
+	z := zset.New()
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return 0, nil, ErrInvalidRDB
+		}
+
+		if _, _, err := z.Add(score, pairs[i], 0); err != nil {
+			return 0, nil, ErrInvalidRDB
+		}
+	}
+
+	return db.TypeZSet, z, nil
+}
+
+// Read loads an RDB file into dbs, keys which are already expired are skipped
+func Read(r io.Reader, dbs []*db.DB) error {
+	d := &decoder{r: bufio.NewReader(r)}
+
+	header := make([]byte, len(Magic)+4)
+	if err := d.readFull(header); err != nil {
+		return err
+	}
+
+	if string(header[:len(Magic)]) != Magic {
+		return ErrInvalidRDB
+	}
+
+	version, err := strconv.Atoi(string(header[len(Magic):]))
+	if err != nil {
+		return ErrInvalidRDB
+	}
+
+	if version < 1 || version > MaxVersion {
+		return &ErrUnsupportedVersion{Version: version}
+	}
+
+	var database *db.DB
+	exp := int64(-1)
+	now := sys.NowMillis()
+
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			return d.verifyChecksum(version)
+		case opSelectDB:
+			idx, err := d.readLen()
+			if err != nil {
+				return err
+			}
+
+			if idx >= len(dbs) {
+				return fmt.Errorf("RDB file contains db %d but only %d databases are configured", idx, len(dbs))
+			}
+			database = dbs[idx]
+		case opResizeDB:
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+		case opAux:
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+		case opExpireTimeMs:
+			if err := d.readFull(d.buf[:8]); err != nil {
+				return err
+			}
+			exp = int64(binary.LittleEndian.Uint64(d.buf[:8]))
+		case opExpireTime:
+			if err := d.readFull(d.buf[:4]); err != nil {
+				return err
+			}
+			exp = int64(binary.LittleEndian.Uint32(d.buf[:4])) * 1000
+		case opFreq:
+			if _, err := d.readByte(); err != nil {
+				return err
+			}
+		case opIdle:
+			if _, err := d.readLen(); err != nil {
+				return err
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := d.readLen(); err != nil {
+					return err
+				}
+			}
+		case opFunction2:
+			// functions are not supported, their code is skipped
+			if _, err := d.readString(); err != nil {
+				return err
+			}
+		case opModuleAux, opFunctionPre:
+			return &ErrUnsupportedType{Type: op}
+		default:
+			if database == nil {
+				// files written by old versions might not select a database
+				database = dbs[0]
+			}
+
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+
+			t, val, err := d.readValue(op)
+			if err != nil {
+				return err
+			}
+
+			if exp == -1 || exp > now {
+				database.Set(key, db.NewDataNode(t, exp, val))
+			}
+			exp = -1
+		}
+	}
+}
+
+// verifyChecksum compares the checksum of the read data with the trailing checksum, files before version 5 have none
+func (d *decoder) verifyChecksum(version int) error {
+	if version < 5 {
+		return nil
+	}
+
+	sum := d.crc
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return unexpected(err)
+	}
+
+	// a zero checksum means checksums were disabled when the file was written
+	expected := binary.LittleEndian.Uint64(d.buf[:8])
+	if expected != 0 && expected != sum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
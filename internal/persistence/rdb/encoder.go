@@ -0,0 +1,242 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/zset"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+// encoder writes an RDB file and computes its checksum
+type encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	buf [9]byte
+
+	// skipped counts keys which have no RDB counterpart
+	skipped int
+}
+
+func (e *encoder) write(p []byte) error {
+	e.crc = crc64Update(e.crc, p)
+	_, err := e.w.Write(p)
+	return err
+}
+
+func (e *encoder) writeByte(b byte) error {
+	e.buf[0] = b
+	return e.write(e.buf[:1])
+}
+
+func (e *encoder) writeLength(n uint64) error {
+	switch {
+	case n < 1<<6:
+		return e.writeByte(byte(n))
+	case n < 1<<14:
+		e.buf[0], e.buf[1] = byte(n>>8)|len14Bit<<6, byte(n)
+		return e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(e.buf[1:5], uint32(n))
+		return e.write(e.buf[:5])
+	}
+
+	e.buf[0] = len64Bit
+	binary.BigEndian.PutUint64(e.buf[1:9], n)
+	return e.write(e.buf[:9])
+}
+
+func (e *encoder) writeString(s string) error {
+	if err := e.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+
+	return e.write([]byte(s))
+}
+
+func (e *encoder) writeStrings(strs []string, count int) error {
+	if err := e.writeLength(uint64(count)); err != nil {
+		return err
+	}
+
+	for _, s := range strs {
+		if err := e.writeString(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *encoder) writeAux(key, val string) error {
+	if err := e.writeByte(opAux); err != nil {
+		return err
+	}
+
+	if err := e.writeString(key); err != nil {
+		return err
+	}
+
+	return e.writeString(val)
+}
+
+// writeValue writes the type, the key and the value of a node using the plain encodings every Redis version can load
+func (e *encoder) writeValue(key string, node *db.DataNode) error {
+	var err error
+	write := func(t byte, fn func() error) error {
+		if err = e.writeByte(t); err != nil {
+			return err
+		}
+		if err = e.writeString(key); err != nil {
+			return err
+		}
+		return fn()
+	}
+
+	switch node.Type {
+	case db.TypeString:
+		return write(typeString, func() error {
+			return e.writeString(util.ToString(node.Value))
+		})
+	case db.TypeList:
+		elems := node.Value.(*list.TList).Range(0, -1)
+		return write(typeList, func() error {
+			return e.writeStrings(elems, len(elems))
+		})
+	case db.TypeSet:
+		members := node.Value.(*set.Set).Elems()
+		return write(typeSet, func() error {
+			return e.writeStrings(members, len(members))
+		})
+	case db.TypeHashMap:
+		fields := node.Value.(*hashmap.HashMap).Fields()
+		return write(typeHash, func() error {
+			return e.writeStrings(fields, len(fields)/2)
+		})
+	case db.TypeZSet:
+		elems := node.Value.(*zset.ZSet).Elements()
+		return write(typeZSet2, func() error {
+			if err := e.writeLength(uint64(len(elems))); err != nil {
+				return err
+			}
+
+			for _, elem := range elems {
+				if err := e.writeString(elem.Member); err != nil {
+					return err
+				}
+
+				binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(elem.Score))
+				if err := e.write(e.buf[:8]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	return fmt.Errorf("unknown data type %d", node.Type)
+}
+
+// writeDB writes all keys of a database, empty databases are skipped
+func (e *encoder) writeDB(index int, database *db.DB) error {
+	var err error
+	selected := false
+
+	database.ForEach(func(key string, node *db.DataNode) bool {
+		if !selected {
+			if err = e.writeByte(opSelectDB); err != nil {
+				return false
+			}
+			if err = e.writeLength(uint64(index)); err != nil {
+				return false
+			}
+			selected = true
+		}
+
+		// the stream encoding is not supported, the decoder rejects it as well
+		if node.Type == db.TypeStream {
+			e.skipped++
+			return true
+		}
+
+		if exp := node.Expiration(); exp != -1 {
+			if err = e.writeByte(opExpireTimeMs); err != nil {
+				return false
+			}
+
+			binary.LittleEndian.PutUint64(e.buf[:8], uint64(exp))
+			if err = e.write(e.buf[:8]); err != nil {
+				return false
+			}
+		}
+
+		err = e.writeValue(key, node)
+		return err == nil
+	})
+
+	return err
+}
+
+// Write writes all databases to w as an RDB file
+// Streams are not written, skipped is the number of keys which were left out
+func Write(w io.Writer, dbs []*db.DB) (skipped int, err error) {
+	e := &encoder{w: bufio.NewWriter(w)}
+
+	if err := e.write([]byte(fmt.Sprintf("%s%04d", Magic, Version))); err != nil {
+		return 0, err
+	}
+
+	if err := e.writeAux("redis-bits", "64"); err != nil {
+		return 0, err
+	}
+
+	for i, database := range dbs {
+		if err := e.writeDB(i, database); err != nil {
+			return e.skipped, err
+		}
+	}
+
+	if err := e.writeByte(opEOF); err != nil {
+		return e.skipped, err
+	}
+
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return e.skipped, err
+	}
+
+	return e.skipped, e.w.Flush()
+}
@@ -0,0 +1,308 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package rdb
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// parseZiplist returns the entries of a ziplist as strings
+func parseZiplist(b []byte) ([]string, error) {
+	// zlbytes, zltail and zllen
+	if len(b) < 11 {
+		return nil, ErrInvalidRDB
+	}
+
+	entries := make([]string, 0, binary.LittleEndian.Uint16(b[8:10]))
+	for pos := 10; ; {
+		if pos >= len(b) {
+			return nil, ErrInvalidRDB
+		}
+
+		if b[pos] == 0xFF {
+			return entries, nil
+		}
+
+		// skip prevlen
+		if b[pos] < 0xFE {
+			pos++
+		} else {
+			pos += 5
+		}
+
+		if pos >= len(b) {
+			return nil, ErrInvalidRDB
+		}
+
+		entry, n, err := ziplistEntry(b[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+		pos += n
+	}
+}
+
+// ziplistEntry decodes the encoding and the data of a ziplist entry and returns the consumed bytes
+func ziplistEntry(b []byte) (string, int, error) {
+	enc := b[0]
+
+	var strLen, header int
+	switch enc >> 6 {
+	case 0:
+		strLen, header = int(enc&0x3F), 1
+	case 1:
+		if len(b) < 2 {
+			return "", 0, ErrInvalidRDB
+		}
+		strLen, header = int(enc&0x3F)<<8|int(b[1]), 2
+	case 2:
+		if len(b) < 5 {
+			return "", 0, ErrInvalidRDB
+		}
+		strLen, header = int(binary.BigEndian.Uint32(b[1:5])), 5
+	default:
+		return ziplistInt(b)
+	}
+
+	if header+strLen > len(b) || strLen < 0 {
+		return "", 0, ErrInvalidRDB
+	}
+
+	return string(b[header : header+strLen]), header + strLen, nil
+}
+
+// ziplistInt decodes an integer entry of a ziplist
+func ziplistInt(b []byte) (string, int, error) {
+	enc := b[0]
+
+	var size int
+	switch enc {
+	case 0xC0:
+		size = 2
+	case 0xD0:
+		size = 4
+	case 0xE0:
+		size = 8
+	case 0xF0:
+		size = 3
+	case 0xFE:
+		size = 1
+	default:
+		// 4 bit immediate values from 0 to 12
+		if enc >= 0xF1 && enc <= 0xFD {
+			return strconv.Itoa(int(enc&0x0F) - 1), 1, nil
+		}
+		return "", 0, ErrInvalidRDB
+	}
+
+	if len(b) < 1+size {
+		return "", 0, ErrInvalidRDB
+	}
+
+	return strconv.FormatInt(littleEndianInt(b[1:1+size]), 10), 1 + size, nil
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes
+func littleEndianInt(b []byte) int64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	// sign extend
+	shift := uint(64 - 8*len(b))
+	return int64(v<<shift) >> shift
+}
+
+// parseListpack returns the entries of a listpack as strings
+func parseListpack(b []byte) ([]string, error) {
+	// total bytes and number of elements
+	if len(b) < 7 {
+		return nil, ErrInvalidRDB
+	}
+
+	entries := make([]string, 0)
+	for pos := 6; ; {
+		if pos >= len(b) {
+			return nil, ErrInvalidRDB
+		}
+
+		if b[pos] == 0xFF {
+			return entries, nil
+		}
+
+		entry, n, err := listpackEntry(b[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+		pos += n + listpackBacklenSize(n)
+	}
+}
+
+// listpackEntry decodes the encoding and the data of a listpack entry and returns the consumed bytes without backlen
+func listpackEntry(b []byte) (string, int, error) {
+	enc := b[0]
+
+	var strLen, header int
+	switch {
+	case enc&0x80 == 0:
+		return strconv.Itoa(int(enc & 0x7F)), 1, nil
+	case enc&0xC0 == 0x80:
+		strLen, header = int(enc&0x3F), 1
+	case enc&0xE0 == 0xC0:
+		if len(b) < 2 {
+			return "", 0, ErrInvalidRDB
+		}
+
+		// 13 bit signed integer
+		v := int(enc&0x1F)<<8 | int(b[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return strconv.Itoa(v), 2, nil
+	case enc&0xF0 == 0xE0:
+		if len(b) < 2 {
+			return "", 0, ErrInvalidRDB
+		}
+		strLen, header = int(enc&0x0F)<<8|int(b[1]), 2
+	case enc == 0xF0:
+		if len(b) < 5 {
+			return "", 0, ErrInvalidRDB
+		}
+		strLen, header = int(binary.LittleEndian.Uint32(b[1:5])), 5
+	case enc >= 0xF1 && enc <= 0xF4:
+		size := [...]int{2, 3, 4, 8}[enc-0xF1]
+		if len(b) < 1+size {
+			return "", 0, ErrInvalidRDB
+		}
+		return strconv.FormatInt(littleEndianInt(b[1:1+size]), 10), 1 + size, nil
+	default:
+		return "", 0, ErrInvalidRDB
+	}
+
+	if header+strLen > len(b) || strLen < 0 {
+		return "", 0, ErrInvalidRDB
+	}
+
+	return string(b[header : header+strLen]), header + strLen, nil
+}
+
+// listpackBacklenSize returns the size of the backlen of an entry with n bytes
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+
+	return 5
+}
+
+// parseIntset returns the members of an intset as strings
+func parseIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, ErrInvalidRDB
+	}
+
+	size := int(binary.LittleEndian.Uint32(b[0:4]))
+	count := int(binary.LittleEndian.Uint32(b[4:8]))
+	if (size != 2 && size != 4 && size != 8) || count < 0 || 8+count*size > len(b) {
+		return nil, ErrInvalidRDB
+	}
+
+	members := make([]string, count)
+	for i := 0; i < count; i++ {
+		members[i] = strconv.FormatInt(littleEndianInt(b[8+i*size:8+(i+1)*size]), 10)
+	}
+
+	return members, nil
+}
+
+// parseZipmap returns the keys and values of a zipmap one after another
+func parseZipmap(b []byte) ([]string, error) {
+	entries := make([]string, 0)
+
+	// skip zmlen
+	pos := 1
+	readLen := func() (int, bool) {
+		if pos >= len(b) {
+			return 0, false
+		}
+
+		switch l := b[pos]; {
+		case l < 254:
+			pos++
+			return int(l), true
+		case l == 254 && pos+5 <= len(b):
+			n := int(binary.LittleEndian.Uint32(b[pos+1 : pos+5]))
+			pos += 5
+			return n, true
+		}
+
+		return 0, false
+	}
+
+	for {
+		if pos >= len(b) {
+			return nil, ErrInvalidRDB
+		}
+
+		if b[pos] == 0xFF {
+			return entries, nil
+		}
+
+		keyLen, ok := readLen()
+		if !ok || pos+keyLen > len(b) {
+			return nil, ErrInvalidRDB
+		}
+		entries = append(entries, string(b[pos:pos+keyLen]))
+		pos += keyLen
+
+		valLen, ok := readLen()
+		if !ok || pos >= len(b) {
+			return nil, ErrInvalidRDB
+		}
+
+		// free bytes after the value
+		free := int(b[pos])
+		pos++
+		if pos+valLen+free > len(b) {
+			return nil, ErrInvalidRDB
+		}
+		entries = append(entries, string(b[pos:pos+valLen]))
+		pos += valLen + free
+	}
+}
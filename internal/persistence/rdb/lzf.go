@@ -0,0 +1,81 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package rdb
+
+// lzfDecompress decompresses LZF compressed data to a buffer of length n
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// literal run of ctrl+1 bytes
+		if ctrl < 1<<5 {
+			l := ctrl + 1
+			if i+l > len(in) || len(out)+l > n {
+				return nil, ErrInvalidRDB
+			}
+
+			out = append(out, in[i:i+l]...)
+			i += l
+			continue
+		}
+
+		// back reference
+		l := ctrl >> 5
+		if l == 7 {
+			if i >= len(in) {
+				return nil, ErrInvalidRDB
+			}
+			l += int(in[i])
+			i++
+		}
+		l += 2
+
+		if i >= len(in) {
+			return nil, ErrInvalidRDB
+		}
+
+		ref := len(out) - ((ctrl & 0x1F) << 8) - int(in[i]) - 1
+		i++
+
+		if ref < 0 || len(out)+l > n {
+			return nil, ErrInvalidRDB
+		}
+
+		// references can overlap with the bytes being written
+		for j := 0; j < l; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != n {
+		return nil, ErrInvalidRDB
+	}
+
+	return out, nil
+}
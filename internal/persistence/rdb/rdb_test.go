@@ -0,0 +1,271 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/stream"
+	"github.com/kasvith/kache/pkg/types/zset"
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func newDBs(n int) []*db.DB {
+	dbs := make([]*db.DB, n)
+	for i := range dbs {
+		dbs[i] = db.NewDB()
+	}
+	return dbs
+}
+
+// rdbString encodes a plain string
+func rdbString(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func ziplist(entries ...[]byte) []byte {
+	b := make([]byte, 10)
+	binary.LittleEndian.PutUint16(b[8:], uint16(len(entries)))
+	for _, entry := range entries {
+		b = append(b, 0)
+		b = append(b, entry...)
+	}
+	return append(b, 0xFF)
+}
+
+func listpack(entries ...[]byte) []byte {
+	b := make([]byte, 6)
+	binary.LittleEndian.PutUint16(b[4:], uint16(len(entries)))
+	for _, entry := range entries {
+		b = append(b, entry...)
+		b = append(b, byte(len(entry)))
+	}
+	return append(b, 0xFF)
+}
+
+func TestCRC64(t *testing.T) {
+	testifyAssert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64Update(0, []byte("123456789")))
+}
+
+func TestLZFDecompress(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	out, err := lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x00}, 10)
+	assert.Nil(err)
+	assert.Equal("aaaaaaaaaa", string(out))
+
+	out, err = lzfDecompress([]byte{0x02, 'a', 'b', 'c', 0x20, 0x02}, 6)
+	assert.Nil(err)
+	assert.Equal("abcabc", string(out))
+
+	_, err = lzfDecompress([]byte{0x00, 'a', 0xE0, 0x00, 0x05}, 10)
+	assert.Equal(ErrInvalidRDB, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := newDBs(3)
+
+	exp := sys.NowMillis() + 60000
+	dbs[0].Set("str", db.NewDataNode(db.TypeString, exp, "value"))
+
+	l := list.New()
+	l.TPush([]string{"a", "b", "c"})
+	dbs[0].Set("list", db.NewDataNode(db.TypeList, -1, l))
+
+	m := hashmap.New()
+	m.Set("f", "v")
+	dbs[2].Set("hash", db.NewDataNode(db.TypeHashMap, -1, m))
+	dbs[2].Set("set", db.NewDataNode(db.TypeSet, -1, set.NewFromSlice([]string{"x", "y"})))
+
+	z := zset.New()
+	z.Add(1.5, "one", 0)
+	dbs[2].Set("zset", db.NewDataNode(db.TypeZSet, -1, z))
+
+	// streams are left out
+	s := stream.New()
+	s.Add(stream.ID{Ms: 1}, []string{"f", "v"})
+	dbs[1].Set("stream", db.NewDataNode(db.TypeStream, -1, s))
+
+	var buf bytes.Buffer
+	skipped, err := Write(&buf, dbs)
+	assert.Nil(err)
+	assert.Equal(1, skipped)
+	assert.Equal("REDIS0009", string(buf.Bytes()[:9]))
+
+	loaded := newDBs(3)
+	assert.Nil(Read(bytes.NewReader(buf.Bytes()), loaded))
+
+	node, err := loaded[0].Get("str")
+	assert.Nil(err)
+	assert.Equal("value", node.Value)
+	assert.Equal(exp, node.Expiration())
+
+	node, _ = loaded[0].Get("list")
+	assert.Equal([]string{"a", "b", "c"}, node.Value.(*list.TList).Range(0, -1))
+
+	node, _ = loaded[2].Get("hash")
+	assert.Equal("v", node.Value.(*hashmap.HashMap).Get("f"))
+
+	node, _ = loaded[2].Get("set")
+	assert.ElementsMatch([]string{"x", "y"}, node.Value.(*set.Set).Elems())
+
+	assert.Equal(0, loaded[1].Len())
+
+	node, _ = loaded[2].Get("zset")
+	assert.Equal([]zset.Element{{Member: "one", Score: 1.5}}, node.Value.(*zset.ZSet).Elements())
+
+	// corrupting the content breaks the checksum
+	data := buf.Bytes()
+	data[len(data)-12] ^= 0xFF
+	assert.Equal(ErrChecksumMismatch, Read(bytes.NewReader(data), newDBs(3)))
+}
+
+func TestRead_Encodings(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	b := []byte("REDIS0011")
+	b = append(b, opAux)
+	b = append(b, rdbString("redis-ver")...)
+	b = append(b, rdbString("7.2.0")...)
+	b = append(b, opSelectDB, 0, opResizeDB, 8, 1)
+
+	// integer encoded strings
+	b = append(b, typeString)
+	b = append(b, rdbString("int8")...)
+	b = append(b, 0xC0|encInt8, 0xF6)
+	b = append(b, typeString)
+	b = append(b, rdbString("int32")...)
+	b = append(b, 0xC0|encInt32, 0x40, 0xE2, 0x01, 0x00)
+
+	// lzf compressed string with an expiration in seconds far in the future
+	b = append(b, opExpireTime, 0xFF, 0xFF, 0xFF, 0x7F)
+	b = append(b, typeString)
+	b = append(b, rdbString("lzf")...)
+	b = append(b, 0xC0|encLZF, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00)
+
+	// list as a ziplist with a string, an immediate and an int16
+	zl := ziplist([]byte{0x02, 'a', 'b'}, []byte{0xF6}, []byte{0xC0, 0xE8, 0x03})
+	b = append(b, typeListZiplist)
+	b = append(b, rdbString("ziplist")...)
+	b = append(b, rdbString(string(zl))...)
+
+	// list as a quicklist with a plain node and a listpack node
+	lp := listpack([]byte{0x82, 'c', 'd'}, []byte{0x07}, []byte{0xDF, 0xFB}, []byte{0xF1, 0xE8, 0x03})
+	b = append(b, typeListQuicklist2)
+	b = append(b, rdbString("quicklist")...)
+	b = append(b, 2, quicklistNodePlain)
+	b = append(b, rdbString("plain")...)
+	b = append(b, quicklistNodePacked)
+	b = append(b, rdbString(string(lp))...)
+
+	// set as an intset of int16
+	is := []byte{2, 0, 0, 0, 3, 0, 0, 0, 1, 0, 2, 0, 0xFD, 0xFF}
+	b = append(b, typeSetIntset)
+	b = append(b, rdbString("intset")...)
+	b = append(b, rdbString(string(is))...)
+
+	// hash as a listpack
+	hlp := listpack([]byte{0x81, 'f'}, []byte{0x81, 'v'})
+	b = append(b, typeHashListpack)
+	b = append(b, rdbString("hash")...)
+	b = append(b, rdbString(string(hlp))...)
+
+	// hash as a zipmap
+	zm := []byte{1, 1, 'k', 1, 0, 'v', 0xFF}
+	b = append(b, typeHashZipmap)
+	b = append(b, rdbString("zipmap")...)
+	b = append(b, rdbString(string(zm))...)
+
+	// sorted set as a listpack with an integer and a string score
+	zlp := listpack([]byte{0x81, 'm'}, []byte{0x02}, []byte{0x81, 'n'}, []byte{0x83, '1', '.', '5'})
+	b = append(b, typeZSetListpack)
+	b = append(b, rdbString("zset")...)
+	b = append(b, rdbString(string(zlp))...)
+
+	// sorted set with string scores
+	b = append(b, typeZSet)
+	b = append(b, rdbString("oldzset")...)
+	b = append(b, 2)
+	b = append(b, rdbString("a")...)
+	b = append(b, rdbString("2.5")...)
+	b = append(b, rdbString("b")...)
+	b = append(b, 254)
+
+	// expired keys are skipped
+	b = append(b, opExpireTimeMs, 1, 0, 0, 0, 0, 0, 0, 0)
+	b = append(b, typeString)
+	b = append(b, rdbString("expired")...)
+	b = append(b, rdbString("v")...)
+
+	// disabled checksum
+	b = append(b, opEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	dbs := newDBs(1)
+	assert.Nil(Read(bytes.NewReader(b), dbs))
+
+	get := func(key string) interface{} {
+		node, err := dbs[0].Get(key)
+		assert.Nil(err, key)
+		if node == nil {
+			return nil
+		}
+		return node.Value
+	}
+
+	assert.Equal("-10", get("int8"))
+	assert.Equal("123456", get("int32"))
+	assert.Equal("aaaaaaaaaa", get("lzf"))
+	assert.Equal([]string{"ab", "5", "1000"}, get("ziplist").(*list.TList).Range(0, -1))
+	assert.Equal([]string{"plain", "cd", "7", "-5", "1000"}, get("quicklist").(*list.TList).Range(0, -1))
+	assert.ElementsMatch([]string{"1", "2", "-3"}, get("intset").(*set.Set).Elems())
+	assert.Equal("v", get("hash").(*hashmap.HashMap).Get("f"))
+	assert.Equal("v", get("zipmap").(*hashmap.HashMap).Get("k"))
+	assert.Equal([]zset.Element{{Member: "n", Score: 1.5}, {Member: "m", Score: 2}}, get("zset").(*zset.ZSet).Elements())
+
+	score, _ := get("oldzset").(*zset.ZSet).Score("b")
+	assert.True(score > 1e308)
+	assert.Equal(0, dbs[0].Exists("expired"))
+}
+
+func TestRead_Invalid(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal(ErrInvalidRDB, Read(bytes.NewReader([]byte("KACHE0001")), newDBs(1)))
+	assert.Equal(&ErrUnsupportedVersion{Version: 99}, Read(bytes.NewReader([]byte("REDIS0099")), newDBs(1)))
+	assert.Equal(ErrInvalidRDB, Read(bytes.NewReader([]byte("REDIS0009\xFE\x00\x00\x03ke")), newDBs(1)))
+
+	stream := append([]byte("REDIS0011\xFE\x00"), typeStreamListpacks)
+	stream = append(stream, rdbString("s")...)
+	assert.Equal(&ErrUnsupportedType{Type: typeStreamListpacks}, Read(bytes.NewReader(stream), newDBs(1)))
+}
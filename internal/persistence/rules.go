@@ -0,0 +1,69 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SaveRule triggers a snapshot when at least Changes modifications happened within Seconds since the last save
+type SaveRule struct {
+	Seconds int
+	Changes int
+}
+
+// Matches reports whether the rule triggers a snapshot
+func (rule SaveRule) Matches(changes int64, sinceLastSave time.Duration) bool {
+	return changes >= int64(rule.Changes) && sinceLastSave >= time.Duration(rule.Seconds)*time.Second
+}
+
+// ParseSaveRules parses rules in "<seconds> <changes>" format
+func ParseSaveRules(rules []string) ([]SaveRule, error) {
+	res := make([]SaveRule, 0, len(rules))
+	for _, rule := range rules {
+		fields := strings.Fields(rule)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid save rule %q, expected \"<seconds> <changes>\"", rule)
+		}
+
+		seconds, err := strconv.Atoi(fields[0])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid seconds in save rule %q", rule)
+		}
+
+		changes, err := strconv.Atoi(fields[1])
+		if err != nil || changes < 1 {
+			return nil, fmt.Errorf("invalid changes in save rule %q", rule)
+		}
+
+		res = append(res, SaveRule{Seconds: seconds, Changes: changes})
+	}
+
+	return res, nil
+}
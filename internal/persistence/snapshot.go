@@ -0,0 +1,811 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package persistence implements the snapshot format of kache
+package persistence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/stream"
+	"github.com/kasvith/kache/pkg/types/zset"
+	"github.com/kasvith/kache/pkg/util"
+)
+
+// Magic is written at the beginning of every snapshot
+const Magic = "KACHE"
+
+// Version is the current version of the snapshot format
+const Version = 2
+
+// opcodes of the snapshot format
+const (
+	opSelectDB = 0xFE
+	opExpireMs = 0xFC
+	opEOF      = 0xFF
+)
+
+// value types of the snapshot format, these are independent from db.DataType so the format stays stable
+const (
+	valueString = 0
+	valueList   = 1
+	valueHash   = 2
+	valueSet    = 3
+	valueZSet   = 4
+	valueStream = 5
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var (
+	// ErrInvalidSnapshot is returned when a snapshot is malformed
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+	// ErrChecksumMismatch is returned when the checksum of a snapshot does not match its content
+	ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+)
+
+// ErrUnsupportedVersion is returned when a snapshot was written by a newer format version
+type ErrUnsupportedVersion struct {
+	Version uint16
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("unsupported snapshot version %d", e.Version)
+}
+
+// Encoder writes values in the snapshot format
+type Encoder struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	buf [binary.MaxVarintLen64]byte
+}
+
+// NewEncoder creates an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	crc := crc64.New(crcTable)
+	return &Encoder{w: bufio.NewWriter(io.MultiWriter(w, crc)), crc: crc}
+}
+
+func (e *Encoder) writeByte(b byte) error {
+	return e.w.WriteByte(b)
+}
+
+func (e *Encoder) writeLength(n uint64) error {
+	l := binary.PutUvarint(e.buf[:], n)
+	_, err := e.w.Write(e.buf[:l])
+	return err
+}
+
+func (e *Encoder) writeString(s string) error {
+	if err := e.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+
+	_, err := e.w.WriteString(s)
+	return err
+}
+
+func (e *Encoder) writeUint64(n uint64) error {
+	binary.BigEndian.PutUint64(e.buf[:8], n)
+	_, err := e.w.Write(e.buf[:8])
+	return err
+}
+
+func (e *Encoder) writeStrings(strs []string) error {
+	if err := e.writeLength(uint64(len(strs))); err != nil {
+		return err
+	}
+
+	for _, s := range strs {
+		if err := e.writeString(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// valueType maps a db.DataType to the value type of the snapshot format
+func valueType(t db.DataType) (byte, error) {
+	switch t {
+	case db.TypeString:
+		return valueString, nil
+	case db.TypeList:
+		return valueList, nil
+	case db.TypeHashMap:
+		return valueHash, nil
+	case db.TypeSet:
+		return valueSet, nil
+	case db.TypeZSet:
+		return valueZSet, nil
+	case db.TypeStream:
+		return valueStream, nil
+	}
+
+	return 0, fmt.Errorf("unknown data type %d", t)
+}
+
+// WriteValue writes the type and the value of a node
+func (e *Encoder) WriteValue(node *db.DataNode) error {
+	t, err := valueType(node.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := e.writeByte(t); err != nil {
+		return err
+	}
+
+	return e.writePayload(node)
+}
+
+// writePayload writes the value of a node without its type
+func (e *Encoder) writePayload(node *db.DataNode) error {
+	switch node.Type {
+	case db.TypeString:
+		return e.writeString(util.ToString(node.Value))
+	case db.TypeList:
+		return e.writeStrings(node.Value.(*list.TList).Range(0, -1))
+	case db.TypeHashMap:
+		return e.writeStrings(node.Value.(*hashmap.HashMap).Fields())
+	case db.TypeSet:
+		return e.writeStrings(node.Value.(*set.Set).Elems())
+	case db.TypeStream:
+		return e.writeStream(node.Value.(*stream.Stream))
+	}
+
+	elems := node.Value.(*zset.ZSet).Elements()
+	if err := e.writeLength(uint64(len(elems))); err != nil {
+		return err
+	}
+
+	for _, elem := range elems {
+		if err := e.writeString(elem.Member); err != nil {
+			return err
+		}
+		if err := e.writeUint64(math.Float64bits(elem.Score)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteHeader writes the magic and the format version
+func (e *Encoder) WriteHeader() error {
+	if _, err := e.w.WriteString(Magic); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint16(e.buf[:2], Version)
+	_, err := e.w.Write(e.buf[:2])
+	return err
+}
+
+// WriteDB writes all keys of a database with given index, empty databases are skipped
+func (e *Encoder) WriteDB(index int, database *db.DB) error {
+	var err error
+	selected := false
+
+	database.ForEach(func(key string, node *db.DataNode) bool {
+		if !selected {
+			if err = e.writeByte(opSelectDB); err != nil {
+				return false
+			}
+			if err = e.writeLength(uint64(index)); err != nil {
+				return false
+			}
+			selected = true
+		}
+
+		if exp := node.Expiration(); exp != -1 {
+			if err = e.writeByte(opExpireMs); err != nil {
+				return false
+			}
+			if err = e.writeUint64(uint64(exp)); err != nil {
+				return false
+			}
+		}
+
+		// entries start with the value type followed by the key and the value
+		var t byte
+		if t, err = valueType(node.Type); err != nil {
+			return false
+		}
+		if err = e.writeByte(t); err != nil {
+			return false
+		}
+		if err = e.writeString(key); err != nil {
+			return false
+		}
+
+		err = e.writePayload(node)
+		return err == nil
+	})
+
+	return err
+}
+
+// Close writes the end of the snapshot with its checksum and flushes the buffered data
+func (e *Encoder) Close() error {
+	if err := e.writeByte(opEOF); err != nil {
+		return err
+	}
+
+	// the checksum covers everything written before
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc.Sum64())
+	if _, err := e.w.Write(e.buf[:8]); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+// WriteSnapshot writes all databases to w
+func WriteSnapshot(w io.Writer, dbs []*db.DB) error {
+	enc := NewEncoder(w)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+
+	for i, database := range dbs {
+		if err := enc.WriteDB(i, database); err != nil {
+			return err
+		}
+	}
+
+	return enc.Close()
+}
+
+// checksumReader computes the checksum of the bytes consumed from a buffered reader
+type checksumReader struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.crc.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// Decoder reads values in the snapshot format
+type Decoder struct {
+	r   *checksumReader
+	buf [8]byte
+}
+
+// NewDecoder creates a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: &checksumReader{r: bufio.NewReader(r), crc: crc64.New(crcTable)}}
+}
+
+// unexpected converts an EOF in the middle of a snapshot to ErrInvalidSnapshot
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrInvalidSnapshot
+	}
+
+	return err
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	return b, unexpected(err)
+}
+
+func (d *Decoder) readLength() (uint64, error) {
+	n, err := binary.ReadUvarint(d.r)
+	return n, unexpected(err)
+}
+
+func (d *Decoder) readString() (string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if n > math.MaxInt64 {
+		return "", ErrInvalidSnapshot
+	}
+
+	// the length is not trusted for allocations since it might come from a corrupted snapshot
+	var sb strings.Builder
+	if _, err := io.CopyN(&sb, d.r, int64(n)); err != nil {
+		return "", unexpected(err)
+	}
+
+	return sb.String(), nil
+}
+
+func (d *Decoder) readUint64() (uint64, error) {
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		return 0, unexpected(err)
+	}
+
+	return binary.BigEndian.Uint64(d.buf[:8]), nil
+}
+
+func (d *Decoder) readStrings() ([]string, error) {
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0)
+	for i := uint64(0); i < n; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// ReadValue reads a value written by WriteValue
+func (d *Decoder) ReadValue() (db.DataType, interface{}, error) {
+	t, err := d.readByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return d.readPayload(t)
+}
+
+// readPayload reads a value of type t
+func (d *Decoder) readPayload(t byte) (db.DataType, interface{}, error) {
+	switch t {
+	case valueString:
+		s, err := d.readString()
+		return db.TypeString, s, err
+	case valueList:
+		elems, err := d.readStrings()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		l := list.New()
+		if len(elems) > 0 {
+			if err := l.TPush(elems); err != nil {
+				return 0, nil, err
+			}
+		}
+		return db.TypeList, l, nil
+	case valueHash:
+		fields, err := d.readStrings()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if len(fields)%2 != 0 {
+			return 0, nil, ErrInvalidSnapshot
+		}
+
+		m := hashmap.New()
+		for i := 0; i < len(fields); i += 2 {
+			m.Set(fields[i], fields[i+1])
+		}
+		return db.TypeHashMap, m, nil
+	case valueSet:
+		elems, err := d.readStrings()
+		if err != nil {
+			return 0, nil, err
+		}
+		return db.TypeSet, set.NewFromSlice(elems), nil
+	case valueZSet:
+		n, err := d.readLength()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		z := zset.New()
+		for i := uint64(0); i < n; i++ {
+			member, err := d.readString()
+			if err != nil {
+				return 0, nil, err
+			}
+
+			bits, err := d.readUint64()
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if _, _, err := z.Add(math.Float64frombits(bits), member, 0); err != nil {
+				return 0, nil, ErrInvalidSnapshot
+			}
+		}
+		return db.TypeZSet, z, nil
+	case valueStream:
+		s, err := d.readStream()
+		return db.TypeStream, s, err
+	}
+
+	return 0, nil, ErrInvalidSnapshot
+}
+
+// ReadHeader reads and validates the magic and the format version
+func (d *Decoder) ReadHeader() error {
+	header := make([]byte, len(Magic)+2)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return unexpected(err)
+	}
+
+	if string(header[:len(Magic)]) != Magic {
+		return ErrInvalidSnapshot
+	}
+
+	if version := binary.BigEndian.Uint16(header[len(Magic):]); version > Version {
+		return &ErrUnsupportedVersion{Version: version}
+	}
+
+	return nil
+}
+
+// ReadSnapshot loads a snapshot written by WriteSnapshot into dbs, keys which are already expired are skipped
+func ReadSnapshot(r io.Reader, dbs []*db.DB) error {
+	d := NewDecoder(r)
+	if err := d.ReadHeader(); err != nil {
+		return err
+	}
+
+	var database *db.DB
+	exp := int64(-1)
+	now := sys.NowMillis()
+
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			return d.verifyChecksum()
+		case opSelectDB:
+			idx, err := d.readLength()
+			if err != nil {
+				return err
+			}
+
+			if idx >= uint64(len(dbs)) {
+				return fmt.Errorf("snapshot contains db %d but only %d databases are configured", idx, len(dbs))
+			}
+			database = dbs[idx]
+			continue
+		case opExpireMs:
+			at, err := d.readUint64()
+			if err != nil {
+				return err
+			}
+			exp = int64(at)
+			continue
+		}
+
+		if database == nil {
+			return ErrInvalidSnapshot
+		}
+
+		// anything else starts an entry with the value type
+		key, err := d.readString()
+		if err != nil {
+			return err
+		}
+
+		t, val, err := d.readPayload(op)
+		if err != nil {
+			return err
+		}
+
+		if exp == -1 || exp > now {
+			database.Set(key, db.NewDataNode(t, exp, val))
+		}
+		exp = -1
+	}
+}
+
+// verifyChecksum compares the checksum of the read data with the trailing checksum
+func (d *Decoder) verifyChecksum() error {
+	sum := d.r.crc.Sum64()
+	if _, err := io.ReadFull(d.r.r, d.buf[:8]); err != nil {
+		return unexpected(err)
+	}
+
+	if binary.LittleEndian.Uint64(d.buf[:8]) != sum {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+func (e *Encoder) writeID(id stream.ID) error {
+	if err := e.writeLength(id.Ms); err != nil {
+		return err
+	}
+
+	return e.writeLength(id.Seq)
+}
+
+// writeStream writes the entries of a stream followed by its metadata and its consumer groups
+func (e *Encoder) writeStream(s *stream.Stream) error {
+	entries := s.Range(stream.MinID, stream.MaxID, 0, false)
+	if err := e.writeLength(uint64(len(entries))); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := e.writeID(entry.ID); err != nil {
+			return err
+		}
+		if err := e.writeStrings(entry.Fields); err != nil {
+			return err
+		}
+	}
+
+	info := s.Info()
+	if err := e.writeID(info.LastID); err != nil {
+		return err
+	}
+	if err := e.writeLength(info.EntriesAdded); err != nil {
+		return err
+	}
+	if err := e.writeID(info.MaxDeletedID); err != nil {
+		return err
+	}
+
+	groups := s.Groups(true)
+	if err := e.writeLength(uint64(len(groups))); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		if err := e.writeGroup(g); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeGroup writes a consumer group with its consumers and pending entries, signed values are written as uint64
+func (e *Encoder) writeGroup(g stream.GroupInfo) error {
+	if err := e.writeString(g.Name); err != nil {
+		return err
+	}
+	if err := e.writeID(g.LastID); err != nil {
+		return err
+	}
+	if err := e.writeUint64(uint64(g.EntriesRead)); err != nil {
+		return err
+	}
+
+	if err := e.writeLength(uint64(len(g.Consumers))); err != nil {
+		return err
+	}
+	for _, c := range g.Consumers {
+		if err := e.writeString(c.Name); err != nil {
+			return err
+		}
+		if err := e.writeUint64(uint64(c.SeenTime)); err != nil {
+			return err
+		}
+		if err := e.writeUint64(uint64(c.ActiveTime)); err != nil {
+			return err
+		}
+	}
+
+	if err := e.writeLength(uint64(len(g.PEL))); err != nil {
+		return err
+	}
+	for _, p := range g.PEL {
+		if err := e.writeID(p.ID); err != nil {
+			return err
+		}
+		if err := e.writeString(p.Consumer); err != nil {
+			return err
+		}
+		if err := e.writeUint64(uint64(p.DeliveryTime)); err != nil {
+			return err
+		}
+		if err := e.writeUint64(uint64(p.DeliveryCount)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Decoder) readID() (stream.ID, error) {
+	ms, err := d.readLength()
+	if err != nil {
+		return stream.ID{}, err
+	}
+
+	seq, err := d.readLength()
+	return stream.ID{Ms: ms, Seq: seq}, err
+}
+
+// readStream reads a stream written by writeStream
+func (d *Decoder) readStream() (*stream.Stream, error) {
+	s := stream.New()
+
+	n, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		id, err := d.readID()
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := d.readStrings()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(fields) == 0 || len(fields)%2 != 0 || s.Add(id, fields) != nil {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+
+	lastID, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	maxDeleted, err := d.readID()
+	if err != nil {
+		return nil, err
+	}
+
+	if added > math.MaxInt64 || s.SetID(lastID, int64(added), &maxDeleted) != nil {
+		return nil, ErrInvalidSnapshot
+	}
+
+	groups, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < groups; i++ {
+		g, err := d.readGroup()
+		if err != nil {
+			return nil, err
+		}
+
+		if s.RestoreGroup(g) != nil {
+			return nil, ErrInvalidSnapshot
+		}
+	}
+
+	return s, nil
+}
+
+// readGroup reads a consumer group written by writeGroup
+func (d *Decoder) readGroup() (stream.GroupInfo, error) {
+	var g stream.GroupInfo
+	var err error
+
+	if g.Name, err = d.readString(); err != nil {
+		return g, err
+	}
+	if g.LastID, err = d.readID(); err != nil {
+		return g, err
+	}
+
+	read, err := d.readUint64()
+	if err != nil {
+		return g, err
+	}
+	g.EntriesRead = int64(read)
+
+	n, err := d.readLength()
+	if err != nil {
+		return g, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var c stream.ConsumerInfo
+		if c.Name, err = d.readString(); err != nil {
+			return g, err
+		}
+
+		seen, err := d.readUint64()
+		if err != nil {
+			return g, err
+		}
+
+		active, err := d.readUint64()
+		if err != nil {
+			return g, err
+		}
+
+		c.SeenTime, c.ActiveTime = int64(seen), int64(active)
+		g.Consumers = append(g.Consumers, c)
+	}
+
+	if n, err = d.readLength(); err != nil {
+		return g, err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		var p stream.PendingEntry
+		if p.ID, err = d.readID(); err != nil {
+			return g, err
+		}
+		if p.Consumer, err = d.readString(); err != nil {
+			return g, err
+		}
+
+		at, err := d.readUint64()
+		if err != nil {
+			return g, err
+		}
+
+		count, err := d.readUint64()
+		if err != nil {
+			return g, err
+		}
+
+		p.DeliveryTime, p.DeliveryCount = int64(at), int64(count)
+		g.PEL = append(g.PEL, p)
+	}
+
+	return g, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package persistence
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kasvith/kache/internal/db"
+	"github.com/kasvith/kache/internal/sys"
+	"github.com/kasvith/kache/pkg/types/hashmap"
+	"github.com/kasvith/kache/pkg/types/list"
+	"github.com/kasvith/kache/pkg/types/set"
+	"github.com/kasvith/kache/pkg/types/stream"
+	"github.com/kasvith/kache/pkg/types/zset"
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func newDBs(n int) []*db.DB {
+	dbs := make([]*db.DB, n)
+	for i := range dbs {
+		dbs[i] = db.NewDB()
+	}
+	return dbs
+}
+
+func encode(t *testing.T, dbs []*db.DB) []byte {
+	var buf bytes.Buffer
+	testifyAssert.Nil(t, WriteSnapshot(&buf, dbs))
+	return buf.Bytes()
+}
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := newDBs(2)
+
+	exp := sys.NowMillis() + 60000
+	dbs[0].Set("str", db.NewDataNode(db.TypeString, exp, "value"))
+
+	l := list.New()
+	l.TPush([]string{"a", "b", "c"})
+	dbs[0].Set("list", db.NewDataNode(db.TypeList, -1, l))
+
+	m := hashmap.New()
+	m.Set("f1", "v1")
+	m.Set("f2", "v2")
+	dbs[1].Set("hash", db.NewDataNode(db.TypeHashMap, -1, m))
+
+	dbs[1].Set("set", db.NewDataNode(db.TypeSet, -1, set.NewFromSlice([]string{"x", "y"})))
+
+	z := zset.New()
+	z.Add(1.5, "one", 0)
+	z.Add(-2, "two", 0)
+	dbs[1].Set("zset", db.NewDataNode(db.TypeZSet, -1, z))
+
+	// expired keys are not saved
+	dbs[1].Set("expired", db.NewDataNode(db.TypeString, sys.NowMillis()-1, "gone"))
+
+	loaded := newDBs(2)
+	assert.Nil(ReadSnapshot(bytes.NewReader(encode(t, dbs)), loaded))
+
+	node, err := loaded[0].Get("str")
+	assert.Nil(err)
+	assert.Equal("value", node.Value)
+	assert.Equal(exp, node.Expiration())
+
+	node, err = loaded[0].Get("list")
+	assert.Nil(err)
+	assert.Equal([]string{"a", "b", "c"}, node.Value.(*list.TList).Range(0, -1))
+
+	node, err = loaded[1].Get("hash")
+	assert.Nil(err)
+	assert.Equal("v2", node.Value.(*hashmap.HashMap).Get("f2"))
+	assert.Equal(2, node.Value.(*hashmap.HashMap).Len())
+
+	node, err = loaded[1].Get("set")
+	assert.Nil(err)
+	assert.ElementsMatch([]string{"x", "y"}, node.Value.(*set.Set).Elems())
+
+	node, err = loaded[1].Get("zset")
+	assert.Nil(err)
+	assert.Equal([]zset.Element{{Member: "two", Score: -2}, {Member: "one", Score: 1.5}}, node.Value.(*zset.ZSet).Elements())
+
+	assert.Equal(0, loaded[1].Exists("expired"))
+	assert.Equal(2, loaded[0].Len())
+	assert.Equal(3, loaded[1].Len())
+}
+
+func TestSnapshot_Stream(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := newDBs(1)
+
+	s := stream.New()
+	s.Add(stream.ID{Ms: 1}, []string{"a", "1"})
+	s.Add(stream.ID{Ms: 2}, []string{"a", "2", "b", "3"})
+	s.Add(stream.ID{Ms: 3}, []string{"a", "4"})
+	s.Delete([]stream.ID{{Ms: 3}})
+	s.CreateGroup("g", stream.MinID, -1)
+	s.ReadGroup("g", "alice", stream.MinID, false, 1, false, 100)
+	s.CreateConsumer("g", "bob", 200)
+	dbs[0].Set("stream", db.NewDataNode(db.TypeStream, -1, s))
+
+	loaded := newDBs(1)
+	assert.Nil(ReadSnapshot(bytes.NewReader(encode(t, dbs)), loaded))
+
+	node, err := loaded[0].Get("stream")
+	assert.Nil(err)
+	assert.Equal(db.TypeStream, node.Type)
+
+	restored := node.Value.(*stream.Stream)
+	assert.Equal(s.Range(stream.MinID, stream.MaxID, 0, false), restored.Range(stream.MinID, stream.MaxID, 0, false))
+	assert.Equal(s.Info(), restored.Info())
+	assert.Equal(s.Groups(true), restored.Groups(true))
+}
+
+func TestSnapshot_Empty(t *testing.T) {
+	assert := testifyAssert.New(t)
+	loaded := newDBs(1)
+	assert.Nil(ReadSnapshot(bytes.NewReader(encode(t, newDBs(4))), loaded))
+	assert.Equal(0, loaded[0].Len())
+}
+
+func TestSnapshot_Corrupted(t *testing.T) {
+	assert := testifyAssert.New(t)
+	dbs := newDBs(1)
+	dbs[0].Set("key", db.NewDataNode(db.TypeString, -1, "value"))
+	data := encode(t, dbs)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-12] ^= 0xFF
+	assert.Equal(ErrChecksumMismatch, ReadSnapshot(bytes.NewReader(corrupted), newDBs(1)))
+
+	assert.Equal(ErrInvalidSnapshot, ReadSnapshot(bytes.NewReader(data[:len(data)-4]), newDBs(1)))
+	assert.Equal(ErrInvalidSnapshot, ReadSnapshot(bytes.NewReader(data[:len(data)-10]), newDBs(1)))
+	assert.Equal(ErrInvalidSnapshot, ReadSnapshot(bytes.NewReader([]byte("REDIS0009")), newDBs(1)))
+
+	newer := append([]byte{}, data...)
+	newer[len(Magic)+1] = Version + 1
+	assert.Equal(&ErrUnsupportedVersion{Version: Version + 1}, ReadSnapshot(bytes.NewReader(newer), newDBs(1)))
+}
+
+func TestSnapshot_TooFewDatabases(t *testing.T) {
+	dbs := newDBs(2)
+	dbs[1].Set("key", db.NewDataNode(db.TypeString, -1, "value"))
+	testifyAssert.NotNil(t, ReadSnapshot(bytes.NewReader(encode(t, dbs)), newDBs(1)))
+}
+
+func TestParseSaveRules(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	rules, err := ParseSaveRules([]string{"3600 1", " 60   10000 "})
+	assert.Nil(err)
+	assert.Equal([]SaveRule{{Seconds: 3600, Changes: 1}, {Seconds: 60, Changes: 10000}}, rules)
+
+	for _, rule := range []string{"", "60", "60 a", "0 1", "60 0", "1 2 3"} {
+		_, err := ParseSaveRules([]string{rule})
+		assert.NotNil(err, rule)
+	}
+}
@@ -276,3 +276,271 @@ func (ErrExecWithoutMulti) Recoverable() bool {
 func (ErrExecWithoutMulti) Error() string {
 	return "ERR EXEC without MULTI"
 }
+
+// ErrSyntax is used when command arguments are malformed
+type ErrSyntax struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrSyntax) Recoverable() bool {
+	return true
+}
+
+func (ErrSyntax) Error() string {
+	return fmt.Sprintf("%s: syntax error", PrefixErr)
+}
+
+// ErrNoSuchKey is used when a command requires an existing key
+type ErrNoSuchKey struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrNoSuchKey) Recoverable() bool {
+	return true
+}
+
+func (ErrNoSuchKey) Error() string {
+	return fmt.Sprintf("%s: no such key", PrefixErr)
+}
+
+// ErrIndexOutOfRange is used when an index based command points outside of a collection
+type ErrIndexOutOfRange struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrIndexOutOfRange) Recoverable() bool {
+	return true
+}
+
+func (ErrIndexOutOfRange) Error() string {
+	return fmt.Sprintf("%s: index out of range", PrefixErr)
+}
+
+// ErrReadOnly is used when a write is sent to a read only replica
+type ErrReadOnly struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrReadOnly) Recoverable() bool {
+	return true
+}
+
+func (ErrReadOnly) Error() string {
+	return "READONLY You can't write against a read only replica."
+}
+
+// ErrUnblocked is used when a blocked client is unblocked with an error
+type ErrUnblocked struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrUnblocked) Recoverable() bool {
+	return true
+}
+
+func (ErrUnblocked) Error() string {
+	return "UNBLOCKED client unblocked via CLIENT UNBLOCK"
+}
+
+// ErrMoved is used when a key belongs to a slot served by another cluster node
+type ErrMoved struct {
+	Slot int
+	Addr string
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrMoved) Recoverable() bool {
+	return true
+}
+
+func (e ErrMoved) Error() string {
+	return fmt.Sprintf("MOVED %d %s", e.Slot, e.Addr)
+}
+
+// ErrAsk is used when a key of a slot being migrated should be asked from the node importing the slot
+type ErrAsk struct {
+	Slot int
+	Addr string
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrAsk) Recoverable() bool {
+	return true
+}
+
+func (e ErrAsk) Error() string {
+	return fmt.Sprintf("ASK %d %s", e.Slot, e.Addr)
+}
+
+// ErrCrossSlot is used when keys of a command belong to different slots
+type ErrCrossSlot struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrCrossSlot) Recoverable() bool {
+	return true
+}
+
+func (ErrCrossSlot) Error() string {
+	return "CROSSSLOT Keys in request don't hash to the same slot"
+}
+
+// ErrClusterDown is used when a key belongs to a slot which is not served by any cluster node
+type ErrClusterDown struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrClusterDown) Recoverable() bool {
+	return true
+}
+
+func (ErrClusterDown) Error() string {
+	return "CLUSTERDOWN Hash slot not served"
+}
+
+// ErrTryAgain is used when only some keys of a multi key command are found while their slot is moved
+type ErrTryAgain struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrTryAgain) Recoverable() bool {
+	return true
+}
+
+func (ErrTryAgain) Error() string {
+	return "TRYAGAIN Multiple keys request during rehashing of slot"
+}
+
+// ErrBusyKey is used when a key to be created already exists
+type ErrBusyKey struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrBusyKey) Recoverable() bool {
+	return true
+}
+
+func (ErrBusyKey) Error() string {
+	return "BUSYKEY Target key name already exists."
+}
+
+// ErrIO is used when another instance could not be reached or did not reply in time
+type ErrIO struct {
+	Op string
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrIO) Recoverable() bool {
+	return true
+}
+
+func (e ErrIO) Error() string {
+	return fmt.Sprintf("IOERR error or timeout %s target instance", e.Op)
+}
+
+// ErrNotLeader is used when a write is sent to a raft node which is not the leader
+type ErrNotLeader struct {
+	Addr string
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrNotLeader) Recoverable() bool {
+	return true
+}
+
+func (e ErrNotLeader) Error() string {
+	return fmt.Sprintf("NOTLEADER %s", e.Addr)
+}
+
+// ErrNoLeader is used when a write is sent to a raft node while no leader is elected
+type ErrNoLeader struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrNoLeader) Recoverable() bool {
+	return true
+}
+
+func (ErrNoLeader) Error() string {
+	return "NOLEADER No leader is elected, try again later"
+}
+
+// ErrNoGroup is used when a stream or one of its consumer groups does not exist
+type ErrNoGroup struct {
+	Key   string
+	Group string
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrNoGroup) Recoverable() bool {
+	return true
+}
+
+func (e ErrNoGroup) Error() string {
+	return fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", e.Key, e.Group)
+}
+
+// ErrBusyGroup is used when a consumer group is created with the name of an existing one
+type ErrBusyGroup struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrBusyGroup) Recoverable() bool {
+	return true
+}
+
+func (ErrBusyGroup) Error() string {
+	return "BUSYGROUP Consumer Group name already exists"
+}
+
+// ErrNoProto is used when a client asks for a protocol version which is not supported
+type ErrNoProto struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrNoProto) Recoverable() bool {
+	return true
+}
+
+func (ErrNoProto) Error() string {
+	return "NOPROTO unsupported protocol version"
+}
+
+// ErrWrongPass is used when a client authenticates with an unknown user or a wrong password
+type ErrWrongPass struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrWrongPass) Recoverable() bool {
+	return true
+}
+
+func (ErrWrongPass) Error() string {
+	return "WRONGPASS invalid username-password pair or user is disabled."
+}
+
+// ErrUnbalancedQuotes is used when a quoted argument of an inline command is not closed
+type ErrUnbalancedQuotes struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrUnbalancedQuotes) Recoverable() bool {
+	return true
+}
+
+func (ErrUnbalancedQuotes) Error() string {
+	return fmt.Sprintf("%s: Protocol error: unbalanced quotes in request", PrefixErr)
+}
+
+// ErrInlineTooBig is used when an inline command is longer than the maximum inline length
+type ErrInlineTooBig struct {
+}
+
+// Recoverable whether error is recoverable or not
+func (ErrInlineTooBig) Recoverable() bool {
+	return true
+}
+
+func (ErrInlineTooBig) Error() string {
+	return fmt.Sprintf("%s: Protocol error: too big inline request", PrefixErr)
+}
@@ -0,0 +1,159 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"encoding/binary"
+)
+
+// Messages implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler so transports can send them as bytes
+
+func appendBool(buf []byte, b bool) []byte {
+	if b {
+		return append(buf, 1)
+	}
+
+	return append(buf, 0)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+	return append(buf, s...)
+}
+
+// MarshalBinary encodes the request
+func (r *VoteRequest) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, r.Term)
+	buf = appendString(buf, r.Candidate)
+	buf = binary.LittleEndian.AppendUint64(buf, r.LastLogIndex)
+	return binary.LittleEndian.AppendUint64(buf, r.LastLogTerm), nil
+}
+
+// UnmarshalBinary decodes a request encoded by MarshalBinary
+func (r *VoteRequest) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = VoteRequest{Term: d.uint64(), Candidate: d.string(), LastLogIndex: d.uint64(), LastLogTerm: d.uint64()}
+	return d.err
+}
+
+// MarshalBinary encodes the response
+func (r *VoteResponse) MarshalBinary() ([]byte, error) {
+	return appendBool(binary.LittleEndian.AppendUint64(nil, r.Term), r.Granted), nil
+}
+
+// UnmarshalBinary decodes a response encoded by MarshalBinary
+func (r *VoteResponse) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = VoteResponse{Term: d.uint64(), Granted: d.byte() == 1}
+	return d.err
+}
+
+// MarshalBinary encodes the request
+func (r *AppendRequest) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, r.Term)
+	buf = appendString(buf, r.Leader)
+	buf = binary.LittleEndian.AppendUint64(buf, r.PrevLogIndex)
+	buf = binary.LittleEndian.AppendUint64(buf, r.PrevLogTerm)
+	buf = binary.LittleEndian.AppendUint64(buf, r.LeaderCommit)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(r.Entries)))
+	for _, entry := range r.Entries {
+		buf = appendEntry(buf, entry)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a request encoded by MarshalBinary
+func (r *AppendRequest) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = AppendRequest{Term: d.uint64(), Leader: d.string(), PrevLogIndex: d.uint64(), PrevLogTerm: d.uint64(), LeaderCommit: d.uint64()}
+
+	n := d.uint32()
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		header := d.bytes(entryHeaderSize)
+		if header == nil {
+			break
+		}
+
+		payload := d.bytes(uint64(binary.LittleEndian.Uint32(header)))
+		if payload == nil {
+			break
+		}
+
+		entry, err := decodeEntry(header, payload)
+		if err != nil {
+			return err
+		}
+		r.Entries = append(r.Entries, entry)
+	}
+
+	return d.err
+}
+
+// MarshalBinary encodes the response
+func (r *AppendResponse) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, r.Term)
+	buf = appendBool(buf, r.Success)
+	return binary.LittleEndian.AppendUint64(buf, r.LastIndex), nil
+}
+
+// UnmarshalBinary decodes a response encoded by MarshalBinary
+func (r *AppendResponse) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = AppendResponse{Term: d.uint64(), Success: d.byte() == 1, LastIndex: d.uint64()}
+	return d.err
+}
+
+// MarshalBinary encodes the request
+func (r *SnapshotRequest) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint64(nil, r.Term)
+	buf = appendString(buf, r.Leader)
+	buf = binary.LittleEndian.AppendUint64(buf, r.LastIndex)
+	buf = binary.LittleEndian.AppendUint64(buf, r.LastTerm)
+	buf = appendStrings(buf, r.Members)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(r.Data)))
+	return append(buf, r.Data...), nil
+}
+
+// UnmarshalBinary decodes a request encoded by MarshalBinary
+func (r *SnapshotRequest) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = SnapshotRequest{Term: d.uint64(), Leader: d.string(), LastIndex: d.uint64(), LastTerm: d.uint64(), Members: d.strings()}
+	r.Data = d.bytes(d.uint64())
+	return d.err
+}
+
+// MarshalBinary encodes the response
+func (r *SnapshotResponse) MarshalBinary() ([]byte, error) {
+	return binary.LittleEndian.AppendUint64(nil, r.Term), nil
+}
+
+// UnmarshalBinary decodes a response encoded by MarshalBinary
+func (r *SnapshotResponse) UnmarshalBinary(data []byte) error {
+	d := decoder{buf: data}
+	*r = SnapshotResponse{Term: d.uint64()}
+	return d.err
+}
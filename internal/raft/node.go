@@ -0,0 +1,1166 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// result is the outcome of a proposal
+type result struct {
+	value interface{}
+	err   error
+}
+
+// waiter waits for an entry proposed in term to be applied
+type waiter struct {
+	term uint64
+	ch   chan result
+}
+
+// replicator sends entries and heartbeats to a member while the node is leader
+type replicator struct {
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+// Node is a member of a raft group
+// Entries proposed to the leader are replicated to the members and applied to the state machine of every member
+// once a majority of the members persisted them
+type Node struct {
+	conf      Config
+	sm        StateMachine
+	transport Transport
+	storage   *storage
+
+	// snapshotMux serializes writing snapshots with compacting the log
+	snapshotMux sync.Mutex
+
+	// mux guards the fields below
+	mux sync.Mutex
+
+	role     Role
+	term     uint64
+	votedFor string
+	leader   string
+
+	// log holds the entries after the snapshot, log[i] has the index snapIndex+1+i
+	log       []Entry
+	snapIndex uint64
+	snapTerm  uint64
+
+	// snapMembers are the members as of the snapshot
+	snapMembers []string
+
+	// members are the members of the latest config entry in the log, which may not be committed yet
+	members     []string
+	configIndex uint64
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// electionDeadline is when a follower starts an election unless it hears from a leader
+	electionDeadline time.Time
+
+	// lastContact is when a leader was heard the last time
+	lastContact time.Time
+
+	// leader state
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastAck     map[string]time.Time
+	replicators map[string]*replicator
+	waiters     map[uint64]*waiter
+
+	applyCh chan struct{}
+
+	// snapshotCh asks the applier to compact the log
+	snapshotCh chan chan error
+
+	stopCh  chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewNode restores a node from the state persisted in the directory of conf
+// A node without persisted state starts a group with the configured peers or joins an existing group
+func NewNode(conf Config, sm StateMachine, transport Transport) (*Node, error) {
+	conf = conf.WithDefaults()
+
+	s, err := openStorage(conf.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		conf:       conf,
+		sm:         sm,
+		transport:  transport,
+		storage:    s,
+		applyCh:    make(chan struct{}, 1),
+		snapshotCh: make(chan chan error),
+		stopCh:     make(chan struct{}),
+		waiters:    make(map[uint64]*waiter),
+	}
+
+	if err := n.load(); err != nil {
+		s.close()
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// load restores the vote, the snapshot and the log
+func (n *Node) load() error {
+	var err error
+	if n.term, n.votedFor, err = n.storage.loadState(); err != nil {
+		return err
+	}
+
+	snap, err := n.storage.loadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	if snap != nil {
+		n.snapIndex, n.snapTerm, n.snapMembers = snap.Index, snap.Term, snap.Members
+		n.commitIndex = snap.Index
+	}
+
+	if n.log, err = n.storage.loadLog(); err != nil {
+		return err
+	}
+
+	// entries which were compacted after the log file was written are dropped
+	if len(n.log) > 0 && n.log[0].Index <= n.snapIndex {
+		skip := n.snapIndex - n.log[0].Index + 1
+		if skip >= uint64(len(n.log)) {
+			n.log = nil
+		} else {
+			n.log = n.log[skip:]
+		}
+
+		if err := n.storage.rewriteLog(n.log); err != nil {
+			return err
+		}
+	}
+
+	if len(n.log) > 0 && n.log[0].Index != n.snapIndex+1 {
+		return ErrCorruptLog
+	}
+
+	if snap == nil && len(n.log) == 0 && n.term == 0 {
+		if err := n.bootstrap(); err != nil {
+			return err
+		}
+	}
+
+	n.updateMembersLocked()
+	return nil
+}
+
+// bootstrap writes the initial members as the first entry, every node bootstrapped with the same peers has the same entry
+func (n *Node) bootstrap() error {
+	var members []string
+	switch {
+	case len(n.conf.Peers) > 0:
+		members = append(members, n.conf.Peers...)
+		if !contains(members, n.conf.ID) {
+			members = append(members, n.conf.ID)
+		}
+	case n.conf.Bootstrap:
+		members = []string{n.conf.ID}
+	default:
+		return nil
+	}
+
+	sort.Strings(members)
+	entry := Entry{Index: 1, Term: 1, Type: EntryConfig, Data: appendStrings(nil, members)}
+	if err := n.storage.appendEntries([]Entry{entry}); err != nil {
+		return err
+	}
+
+	n.log = []Entry{entry}
+	n.term = 1
+	return n.storage.saveState(n.term, n.votedFor)
+}
+
+// Start starts taking part in elections and applying committed entries
+func (n *Node) Start() {
+	n.mux.Lock()
+	n.resetElectionTimerLocked()
+	n.mux.Unlock()
+
+	n.wg.Add(2)
+	go n.tick()
+	go n.applier()
+
+	// entries of the snapshot are applied by restoring it
+	n.notifyApplier()
+}
+
+// Stop stops the node, pending proposals fail with ErrStopped
+func (n *Node) Stop() {
+	n.mux.Lock()
+	if n.stopped {
+		n.mux.Unlock()
+		return
+	}
+
+	n.stopped = true
+	close(n.stopCh)
+	n.stopReplicatorsLocked()
+	n.failWaitersLocked(ErrStopped)
+	n.mux.Unlock()
+
+	n.wg.Wait()
+	n.storage.close()
+}
+
+func (n *Node) logf(format string, args ...interface{}) {
+	if n.conf.Logf != nil {
+		n.conf.Logf(format, args...)
+	}
+}
+
+// ID returns the address of the node
+func (n *Node) ID() string {
+	return n.conf.ID
+}
+
+// Leader returns the address of the current leader, empty when it is not known
+func (n *Node) Leader() string {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.leader
+}
+
+// IsLeader reports whether the node is the leader
+func (n *Node) IsLeader() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return n.role == Leader
+}
+
+// Status returns a snapshot of the state of the node
+func (n *Node) Status() Status {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	return Status{
+		ID:            n.conf.ID,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		Members:       append([]string{}, n.members...),
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		LastLogIndex:  n.lastIndexLocked(),
+		SnapshotIndex: n.snapIndex,
+		SnapshotTerm:  n.snapTerm,
+	}
+}
+
+// Propose replicates data and waits until it was applied, it returns the result of the state machine
+func (n *Node) Propose(data []byte) (interface{}, error) {
+	return n.propose(EntryCommand, data, nil)
+}
+
+// Barrier waits until all entries which were committed before it are applied
+func (n *Node) Barrier() error {
+	_, err := n.propose(EntryNoop, nil, nil)
+	return err
+}
+
+// AddMember adds a node to the group, the node should be started without peers so it waits to be contacted
+func (n *Node) AddMember(id string) error {
+	_, err := n.propose(EntryConfig, nil, func(members []string) ([]string, error) {
+		if contains(members, id) {
+			return nil, ErrMemberExists
+		}
+
+		return append(append([]string{}, members...), id), nil
+	})
+
+	return err
+}
+
+// RemoveMember removes a node from the group, a leader removing itself steps down once the change is committed
+func (n *Node) RemoveMember(id string) error {
+	_, err := n.propose(EntryConfig, nil, func(members []string) ([]string, error) {
+		if !contains(members, id) {
+			return nil, ErrUnknownMember
+		}
+
+		var updated []string
+		for _, m := range members {
+			if m != id {
+				updated = append(updated, m)
+			}
+		}
+
+		return updated, nil
+	})
+
+	return err
+}
+
+// propose appends an entry to the log of the leader and waits until it is applied
+// Config entries are built by change from the current members, only one of them can be in progress at a time
+func (n *Node) propose(t EntryType, data []byte, change func(members []string) ([]string, error)) (interface{}, error) {
+	n.mux.Lock()
+
+	switch {
+	case n.stopped:
+		n.mux.Unlock()
+		return nil, ErrStopped
+	case n.role != Leader:
+		n.mux.Unlock()
+		return nil, ErrNotLeader
+	}
+
+	if t == EntryConfig {
+		// a leader changes members only after committing an entry of its term
+		if n.configIndex > n.commitIndex || n.termAtLocked(n.commitIndex) != n.term {
+			n.mux.Unlock()
+			return nil, ErrConfigChangePending
+		}
+
+		members, err := change(n.members)
+		if err != nil {
+			n.mux.Unlock()
+			return nil, err
+		}
+
+		sort.Strings(members)
+		data = appendStrings(nil, members)
+	}
+
+	entry, err := n.appendLocked(t, data)
+	if err != nil {
+		n.mux.Unlock()
+		return nil, err
+	}
+
+	w := &waiter{term: n.term, ch: make(chan result, 1)}
+	n.waiters[entry.Index] = w
+	n.advanceCommitLocked()
+	n.triggerReplicatorsLocked()
+	n.mux.Unlock()
+
+	r := <-w.ch
+	return r.value, r.err
+}
+
+// appendLocked appends a new entry of the current term to the log of the leader
+func (n *Node) appendLocked(t EntryType, data []byte) (Entry, error) {
+	entry := Entry{Index: n.lastIndexLocked() + 1, Term: n.term, Type: t, Data: data}
+	if err := n.storage.appendEntries([]Entry{entry}); err != nil {
+		return Entry{}, err
+	}
+
+	n.log = append(n.log, entry)
+	if t == EntryConfig {
+		n.updateMembersLocked()
+		n.startReplicatorsLocked()
+	}
+
+	return entry, nil
+}
+
+func (n *Node) lastIndexLocked() uint64 {
+	return n.snapIndex + uint64(len(n.log))
+}
+
+func (n *Node) lastTermLocked() uint64 {
+	return n.termAtLocked(n.lastIndexLocked())
+}
+
+// termAtLocked returns the term of the entry at index, 0 when the entry is compacted or does not exist
+func (n *Node) termAtLocked(index uint64) uint64 {
+	switch {
+	case index == n.snapIndex:
+		return n.snapTerm
+	case index < n.snapIndex || index > n.lastIndexLocked():
+		return 0
+	}
+
+	return n.log[index-n.snapIndex-1].Term
+}
+
+// updateMembersLocked sets the members from the latest config entry of the log or the snapshot
+func (n *Node) updateMembersLocked() {
+	n.members, n.configIndex = n.snapMembers, 0
+	for i := len(n.log) - 1; i >= 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			d := decoder{buf: n.log[i].Data}
+			n.members, n.configIndex = d.strings(), n.log[i].Index
+			return
+		}
+	}
+}
+
+// membersAtLocked returns the members as of the entry at index
+func (n *Node) membersAtLocked(index uint64) []string {
+	for i := int(index - n.snapIndex - 1); i >= 0; i-- {
+		if n.log[i].Type == EntryConfig {
+			d := decoder{buf: n.log[i].Data}
+			return d.strings()
+		}
+	}
+
+	return n.snapMembers
+}
+
+func (n *Node) isMemberLocked() bool {
+	return contains(n.members, n.conf.ID)
+}
+
+func (n *Node) quorumLocked() int {
+	return len(n.members)/2 + 1
+}
+
+func (n *Node) resetElectionTimerLocked() {
+	timeout := n.conf.ElectionTimeout + time.Duration(rand.Int63n(int64(n.conf.ElectionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// setTermLocked moves to a newer term and persists it
+func (n *Node) setTermLocked(term uint64) error {
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		return n.storage.saveState(n.term, n.votedFor)
+	}
+
+	return nil
+}
+
+// becomeFollowerLocked steps down to a follower of term
+func (n *Node) becomeFollowerLocked(term uint64, leader string) error {
+	if n.role == Leader {
+		n.logf("raft: stepping down from leader in term %d", n.term)
+		n.stopReplicatorsLocked()
+		n.failWaitersLocked(ErrLeadershipLost)
+	}
+
+	n.role, n.leader = Follower, leader
+	n.resetElectionTimerLocked()
+	return n.setTermLocked(term)
+}
+
+// tick starts elections when the leader is not heard within the election timeout
+// and makes a leader which can not reach a majority step down
+func (n *Node) tick() {
+	defer n.wg.Done()
+
+	interval := n.conf.HeartbeatInterval
+	if interval > n.conf.ElectionTimeout/10 {
+		interval = n.conf.ElectionTimeout / 10
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		n.mux.Lock()
+		switch {
+		case n.role == Leader:
+			n.checkQuorumLocked()
+		case time.Now().After(n.electionDeadline) && n.isMemberLocked():
+			n.startElectionLocked()
+		}
+		n.mux.Unlock()
+	}
+}
+
+// checkQuorumLocked makes the leader step down when a majority did not reply within the election timeout
+func (n *Node) checkQuorumLocked() {
+	reachable := 0
+	for _, m := range n.members {
+		if m == n.conf.ID || time.Since(n.lastAck[m]) < n.conf.ElectionTimeout {
+			reachable++
+		}
+	}
+
+	if reachable < n.quorumLocked() {
+		n.logf("raft: leader lost contact with a majority of members")
+		n.becomeFollowerLocked(n.term, "")
+	}
+}
+
+// startElectionLocked starts a new term and asks the members for votes
+func (n *Node) startElectionLocked() {
+	n.resetElectionTimerLocked()
+	if err := n.storage.saveState(n.term+1, n.conf.ID); err != nil {
+		n.logf("raft: error persisting vote: %s", err)
+		return
+	}
+
+	n.term++
+	n.role, n.votedFor, n.leader = Candidate, n.conf.ID, ""
+	n.logf("raft: starting election for term %d", n.term)
+
+	votes := 1
+	if votes >= n.quorumLocked() {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	req := &VoteRequest{Term: n.term, Candidate: n.conf.ID, LastLogIndex: n.lastIndexLocked(), LastLogTerm: n.lastTermLocked()}
+	for _, m := range n.members {
+		if m == n.conf.ID {
+			continue
+		}
+
+		go func(addr string) {
+			resp, err := n.transport.RequestVote(addr, req)
+			if err != nil {
+				return
+			}
+
+			n.mux.Lock()
+			defer n.mux.Unlock()
+
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				return
+			}
+
+			if n.role != Candidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+
+			votes++
+			if votes == n.quorumLocked() {
+				n.becomeLeaderLocked()
+			}
+		}(m)
+	}
+}
+
+// becomeLeaderLocked starts replicating to the members, a no-op entry commits the entries of previous terms
+func (n *Node) becomeLeaderLocked() {
+	n.logf("raft: elected leader in term %d", n.term)
+	n.role, n.leader = Leader, n.conf.ID
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastAck = make(map[string]time.Time)
+	n.replicators = make(map[string]*replicator)
+
+	if _, err := n.appendLocked(EntryNoop, nil); err != nil {
+		n.logf("raft: error appending to log: %s", err)
+		n.becomeFollowerLocked(n.term, "")
+		return
+	}
+
+	n.startReplicatorsLocked()
+	n.advanceCommitLocked()
+}
+
+// startReplicatorsLocked starts replicating to members which have no replicator and stops replicating to removed ones
+func (n *Node) startReplicatorsLocked() {
+	if n.role != Leader {
+		return
+	}
+
+	for addr, r := range n.replicators {
+		if !contains(n.members, addr) {
+			close(r.stop)
+			delete(n.replicators, addr)
+		}
+	}
+
+	for _, m := range n.members {
+		if _, ok := n.replicators[m]; ok || m == n.conf.ID {
+			continue
+		}
+
+		r := &replicator{trigger: make(chan struct{}, 1), stop: make(chan struct{})}
+		n.replicators[m] = r
+		n.nextIndex[m] = n.lastIndexLocked() + 1
+		n.matchIndex[m] = 0
+		n.lastAck[m] = time.Now()
+
+		n.wg.Add(1)
+		go n.replicate(m, r, n.term)
+	}
+}
+
+func (n *Node) stopReplicatorsLocked() {
+	for addr, r := range n.replicators {
+		close(r.stop)
+		delete(n.replicators, addr)
+	}
+}
+
+func (n *Node) triggerReplicatorsLocked() {
+	for _, r := range n.replicators {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *Node) failWaitersLocked(err error) {
+	for index, w := range n.waiters {
+		w.ch <- result{err: err}
+		delete(n.waiters, index)
+	}
+}
+
+// replicate sends entries to a member when they are appended and heartbeats otherwise while the node leads term
+func (n *Node) replicate(addr string, r *replicator, term uint64) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.conf.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		// keep sending while the member is behind
+		for n.sendTo(addr, term) {
+			select {
+			case <-r.stop:
+				return
+			default:
+			}
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-r.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendTo sends the next entries or the snapshot to a member, it returns true when more should be sent right away
+func (n *Node) sendTo(addr string, term uint64) bool {
+	n.mux.Lock()
+	if n.role != Leader || n.term != term {
+		n.mux.Unlock()
+		return false
+	}
+
+	next := n.nextIndex[addr]
+	if next <= n.snapIndex {
+		n.mux.Unlock()
+		return n.sendSnapshotTo(addr, term)
+	}
+
+	last := n.lastIndexLocked()
+	if last-next+1 > maxAppendEntries {
+		last = next + maxAppendEntries - 1
+	}
+
+	req := &AppendRequest{
+		Term:         term,
+		Leader:       n.conf.ID,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAtLocked(next - 1),
+		LeaderCommit: n.commitIndex,
+	}
+	if last >= next {
+		req.Entries = append([]Entry{}, n.log[next-n.snapIndex-1:last-n.snapIndex]...)
+	}
+	n.mux.Unlock()
+
+	resp, err := n.transport.AppendEntries(addr, req)
+	if err != nil {
+		return false
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return false
+	}
+
+	if n.role != Leader || n.term != term {
+		return false
+	}
+
+	n.lastAck[addr] = time.Now()
+	if !resp.Success {
+		// continue from the hint of the member, at least one entry before the last try
+		next := req.PrevLogIndex
+		if resp.LastIndex+1 < next {
+			next = resp.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+
+		n.nextIndex[addr] = next
+		return true
+	}
+
+	if match := req.PrevLogIndex + uint64(len(req.Entries)); match > n.matchIndex[addr] {
+		n.matchIndex[addr] = match
+		n.nextIndex[addr] = match + 1
+		n.advanceCommitLocked()
+	}
+
+	return n.nextIndex[addr] <= n.lastIndexLocked()
+}
+
+// sendSnapshotTo sends the latest snapshot to a member which needs compacted entries
+func (n *Node) sendSnapshotTo(addr string, term uint64) bool {
+	snap, err := n.storage.loadSnapshot()
+	if err != nil || snap == nil {
+		n.logf("raft: error loading snapshot for %s: %v", addr, err)
+		return false
+	}
+
+	req := &SnapshotRequest{Term: term, Leader: n.conf.ID, LastIndex: snap.Index, LastTerm: snap.Term, Members: snap.Members, Data: snap.Data}
+	resp, err := n.transport.InstallSnapshot(addr, req)
+	if err != nil {
+		return false
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		return false
+	}
+
+	if n.role != Leader || n.term != term {
+		return false
+	}
+
+	n.lastAck[addr] = time.Now()
+	if snap.Index > n.matchIndex[addr] {
+		n.matchIndex[addr] = snap.Index
+		n.nextIndex[addr] = snap.Index + 1
+		n.advanceCommitLocked()
+	}
+
+	return n.nextIndex[addr] <= n.lastIndexLocked()
+}
+
+// advanceCommitLocked commits the latest entry of the current term which is persisted by a majority
+func (n *Node) advanceCommitLocked() {
+	for index := n.lastIndexLocked(); index > n.commitIndex && n.termAtLocked(index) == n.term; index-- {
+		count := 0
+		for _, m := range n.members {
+			if m == n.conf.ID || n.matchIndex[m] >= index {
+				count++
+			}
+		}
+
+		if count >= n.quorumLocked() {
+			n.commitIndex = index
+			n.notifyApplier()
+			return
+		}
+	}
+}
+
+func (n *Node) notifyApplier() {
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// RequestVote handles a vote request of a candidate
+func (n *Node) RequestVote(req *VoteRequest) (*VoteResponse, error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	resp := &VoteResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+
+	// removed members which did not learn about their removal would disrupt the group with elections
+	if req.Term > n.term && (n.role == Leader || (n.leader != "" && time.Since(n.lastContact) < n.conf.ElectionTimeout)) {
+		return resp, nil
+	}
+
+	if req.Term > n.term {
+		if err := n.becomeFollowerLocked(req.Term, ""); err != nil {
+			return nil, err
+		}
+		resp.Term = n.term
+	}
+
+	upToDate := req.LastLogTerm > n.lastTermLocked() || (req.LastLogTerm == n.lastTermLocked() && req.LastLogIndex >= n.lastIndexLocked())
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		if err := n.storage.saveState(n.term, req.Candidate); err != nil {
+			return nil, err
+		}
+
+		n.votedFor = req.Candidate
+		n.resetElectionTimerLocked()
+		resp.Granted = true
+	}
+
+	return resp, nil
+}
+
+// AppendEntries handles entries or a heartbeat sent by the leader
+func (n *Node) AppendEntries(req *AppendRequest) (*AppendResponse, error) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	resp := &AppendResponse{Term: n.term, LastIndex: n.lastIndexLocked()}
+	if req.Term < n.term {
+		return resp, nil
+	}
+
+	if err := n.heardFromLeaderLocked(req.Term, req.Leader); err != nil {
+		return nil, err
+	}
+	resp.Term = n.term
+
+	if req.PrevLogIndex > n.lastIndexLocked() {
+		return resp, nil
+	}
+
+	// entries of the snapshot are committed and match the leader
+	entries := req.Entries
+	prev := req.PrevLogIndex
+	if prev < n.snapIndex {
+		skip := n.snapIndex - prev
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		entries, prev = entries[skip:], prev+skip
+	} else if n.termAtLocked(prev) != req.PrevLogTerm {
+		// skip the whole conflicting term
+		conflict := n.termAtLocked(prev)
+		hint := prev - 1
+		for hint > n.snapIndex && n.termAtLocked(hint) == conflict {
+			hint--
+		}
+		resp.LastIndex = hint
+		return resp, nil
+	}
+
+	truncated := false
+	var appended []Entry
+	for i, entry := range entries {
+		if entry.Index <= n.lastIndexLocked() {
+			if n.termAtLocked(entry.Index) == entry.Term {
+				continue
+			}
+
+			// committed entries never conflict
+			n.log = n.log[:entry.Index-n.snapIndex-1]
+			truncated = true
+		}
+
+		appended = entries[i:]
+		n.log = append(n.log, appended...)
+		break
+	}
+
+	if truncated {
+		if err := n.storage.rewriteLog(n.log); err != nil {
+			return nil, err
+		}
+	} else if len(appended) > 0 {
+		if err := n.storage.appendEntries(appended); err != nil {
+			return nil, err
+		}
+	}
+
+	if truncated || len(appended) > 0 {
+		n.updateMembersLocked()
+	}
+
+	last := prev + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = req.LeaderCommit
+		if n.commitIndex > last {
+			n.commitIndex = last
+		}
+		n.notifyApplier()
+	}
+
+	resp.Success, resp.LastIndex = true, last
+	return resp, nil
+}
+
+// InstallSnapshot handles a snapshot sent by the leader to replace a log which is too far behind
+func (n *Node) InstallSnapshot(req *SnapshotRequest) (*SnapshotResponse, error) {
+	n.snapshotMux.Lock()
+	defer n.snapshotMux.Unlock()
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	resp := &SnapshotResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp, nil
+	}
+
+	if err := n.heardFromLeaderLocked(req.Term, req.Leader); err != nil {
+		return nil, err
+	}
+	resp.Term = n.term
+
+	if req.LastIndex <= n.snapIndex || req.LastIndex <= n.lastApplied {
+		return resp, nil
+	}
+
+	snap := &snapshot{Index: req.LastIndex, Term: req.LastTerm, Members: req.Members, Data: req.Data}
+	if err := n.storage.saveSnapshot(snap); err != nil {
+		return nil, err
+	}
+
+	// entries following the snapshot are kept when the log matches it
+	var log []Entry
+	if req.LastIndex < n.lastIndexLocked() && n.termAtLocked(req.LastIndex) == req.LastTerm {
+		log = append(log, n.log[req.LastIndex-n.snapIndex:]...)
+	}
+
+	if err := n.storage.rewriteLog(log); err != nil {
+		return nil, err
+	}
+
+	n.log, n.snapIndex, n.snapTerm, n.snapMembers = log, snap.Index, snap.Term, snap.Members
+	n.updateMembersLocked()
+	if n.commitIndex < snap.Index {
+		n.commitIndex = snap.Index
+	}
+
+	// the applier restores the snapshot since it is ahead of the applied entries
+	n.notifyApplier()
+	return resp, nil
+}
+
+// heardFromLeaderLocked follows the leader of term
+func (n *Node) heardFromLeaderLocked(term uint64, leader string) error {
+	if term > n.term || n.role != Follower {
+		if err := n.becomeFollowerLocked(term, leader); err != nil {
+			return err
+		}
+	}
+
+	n.leader = leader
+	n.lastContact = time.Now()
+	n.resetElectionTimerLocked()
+	return nil
+}
+
+// applier applies committed entries to the state machine and compacts the log
+func (n *Node) applier() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case done := <-n.snapshotCh:
+			done <- n.compact()
+			continue
+		case <-n.applyCh:
+		}
+
+		for n.applyCommitted() {
+		}
+
+		n.maybeSnapshot()
+	}
+}
+
+// applyCommitted applies a batch of committed entries, it returns true when there may be more to apply
+func (n *Node) applyCommitted() bool {
+	n.mux.Lock()
+	if n.stopped {
+		n.mux.Unlock()
+		return false
+	}
+
+	if n.lastApplied < n.snapIndex {
+		n.mux.Unlock()
+		return n.restoreSnapshot()
+	}
+
+	if n.lastApplied >= n.commitIndex {
+		n.mux.Unlock()
+		return false
+	}
+
+	last := n.commitIndex
+	if last-n.lastApplied > maxAppendEntries {
+		last = n.lastApplied + maxAppendEntries
+	}
+	entries := append([]Entry{}, n.log[n.lastApplied-n.snapIndex:last-n.snapIndex]...)
+	n.mux.Unlock()
+
+	for _, entry := range entries {
+		var value interface{}
+		if entry.Type == EntryCommand {
+			value = n.sm.Apply(entry.Index, entry.Data)
+		}
+
+		n.mux.Lock()
+		if entry.Index > n.lastApplied {
+			n.lastApplied = entry.Index
+		}
+
+		if w, ok := n.waiters[entry.Index]; ok {
+			if w.term == entry.Term {
+				w.ch <- result{value: value}
+			} else {
+				w.ch <- result{err: ErrLeadershipLost}
+			}
+			delete(n.waiters, entry.Index)
+		}
+
+		// a leader which was removed steps down once its removal is committed
+		if entry.Type == EntryConfig && entry.Index == n.configIndex && n.role == Leader && !n.isMemberLocked() {
+			n.becomeFollowerLocked(n.term, "")
+		}
+		n.mux.Unlock()
+	}
+
+	return true
+}
+
+// restoreSnapshot replaces the state machine with the latest snapshot
+func (n *Node) restoreSnapshot() bool {
+	snap, err := n.storage.loadSnapshot()
+	if err == nil && snap == nil {
+		err = ErrCorruptLog
+	}
+
+	if err == nil {
+		err = n.sm.Restore(snap.Data)
+	}
+
+	if err != nil {
+		n.logf("raft: error restoring snapshot: %s", err)
+		return false
+	}
+
+	n.mux.Lock()
+	if snap.Index > n.lastApplied {
+		n.lastApplied = snap.Index
+	}
+
+	for index, w := range n.waiters {
+		if index <= snap.Index {
+			w.ch <- result{err: ErrLeadershipLost}
+			delete(n.waiters, index)
+		}
+	}
+	n.mux.Unlock()
+
+	return true
+}
+
+// maybeSnapshot compacts the log when enough entries were applied since the last snapshot
+func (n *Node) maybeSnapshot() {
+	n.mux.Lock()
+	due := n.lastApplied >= n.snapIndex+n.conf.SnapshotThreshold
+	n.mux.Unlock()
+
+	if due {
+		if err := n.compact(); err != nil {
+			n.logf("raft: error compacting log: %s", err)
+		}
+	}
+}
+
+// Snapshot compacts the log up to the last applied entry, it must not be called from the state machine
+func (n *Node) Snapshot() error {
+	done := make(chan error, 1)
+	select {
+	case n.snapshotCh <- done:
+		return <-done
+	case <-n.stopCh:
+		return ErrStopped
+	}
+}
+
+// compact snapshots the state machine and drops the entries included in the snapshot from the log
+// It runs on the applier so the state machine is not changed meanwhile
+func (n *Node) compact() error {
+	n.snapshotMux.Lock()
+	defer n.snapshotMux.Unlock()
+
+	n.mux.Lock()
+	index := n.lastApplied
+	if index <= n.snapIndex {
+		n.mux.Unlock()
+		return nil
+	}
+	snap := &snapshot{Index: index, Term: n.termAtLocked(index), Members: n.membersAtLocked(index)}
+	n.mux.Unlock()
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		return err
+	}
+	snap.Data = data
+
+	if err := n.storage.saveSnapshot(snap); err != nil {
+		return err
+	}
+
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	log := append([]Entry{}, n.log[index-n.snapIndex:]...)
+	if err := n.storage.rewriteLog(log); err != nil {
+		return err
+	}
+
+	n.log, n.snapIndex, n.snapTerm, n.snapMembers = log, snap.Index, snap.Term, snap.Members
+	n.updateMembersLocked()
+	return nil
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+
+	return false
+}
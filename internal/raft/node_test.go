@@ -0,0 +1,388 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+var errUnreachable = errors.New("unreachable")
+
+// network connects nodes of a test group in memory
+type network struct {
+	mux   sync.Mutex
+	nodes map[string]*Node
+	down  map[string]bool
+}
+
+func newNetwork() *network {
+	return &network{nodes: make(map[string]*Node), down: make(map[string]bool)}
+}
+
+func (net *network) node(from, to string) (*Node, error) {
+	net.mux.Lock()
+	defer net.mux.Unlock()
+
+	n, ok := net.nodes[to]
+	if !ok || net.down[from] || net.down[to] {
+		return nil, errUnreachable
+	}
+
+	return n, nil
+}
+
+func (net *network) setDown(id string, down bool) {
+	net.mux.Lock()
+	net.down[id] = down
+	net.mux.Unlock()
+}
+
+// transport sends requests of a node through the network, messages are encoded to cover the codec
+type transport struct {
+	net  *network
+	from string
+}
+
+func (t *transport) RequestVote(addr string, req *VoteRequest) (*VoteResponse, error) {
+	n, err := t.net.node(t.from, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := req.MarshalBinary()
+	decoded := &VoteRequest{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return n.RequestVote(decoded)
+}
+
+func (t *transport) AppendEntries(addr string, req *AppendRequest) (*AppendResponse, error) {
+	n, err := t.net.node(t.from, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := req.MarshalBinary()
+	decoded := &AppendRequest{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return n.AppendEntries(decoded)
+}
+
+func (t *transport) InstallSnapshot(addr string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	n, err := t.net.node(t.from, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := req.MarshalBinary()
+	decoded := &SnapshotRequest{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return n.InstallSnapshot(decoded)
+}
+
+// list is a state machine appending entries to a list
+type list struct {
+	mux    sync.Mutex
+	values []string
+}
+
+func (l *list) Apply(index uint64, data []byte) interface{} {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.values = append(l.values, string(data))
+	return len(l.values)
+}
+
+func (l *list) Snapshot() ([]byte, error) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return []byte(strings.Join(l.values, ",")), nil
+}
+
+func (l *list) Restore(data []byte) error {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	l.values = nil
+	if len(data) > 0 {
+		l.values = strings.Split(string(data), ",")
+	}
+
+	return nil
+}
+
+func (l *list) get() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	return append([]string{}, l.values...)
+}
+
+func testConfig(t *testing.T, id string) Config {
+	return Config{
+		ID:                id,
+		Dir:               t.TempDir(),
+		ElectionTimeout:   150 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+	}
+}
+
+func startNode(t *testing.T, net *network, conf Config) (*Node, *list) {
+	sm := &list{}
+	n, err := NewNode(conf, sm, &transport{net: net, from: conf.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	net.mux.Lock()
+	net.nodes[conf.ID] = n
+	net.mux.Unlock()
+
+	n.Start()
+	t.Cleanup(n.Stop)
+	return n, sm
+}
+
+// startGroup starts size nodes knowing each other as peers
+func startGroup(t *testing.T, size int, threshold uint64) (*network, []*Node, []*list) {
+	net := newNetwork()
+	var peers []string
+	for i := 0; i < size; i++ {
+		peers = append(peers, fmt.Sprintf("node%d", i))
+	}
+
+	var nodes []*Node
+	var sms []*list
+	for _, id := range peers {
+		conf := testConfig(t, id)
+		conf.Peers = peers
+		conf.SnapshotThreshold = threshold
+
+		n, sm := startNode(t, net, conf)
+		nodes, sms = append(nodes, n), append(sms, sm)
+	}
+
+	return net, nodes, sms
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitLeader waits until one of the nodes which are up is leader
+func waitLeader(t *testing.T, net *network, nodes []*Node) *Node {
+	var leader *Node
+	waitFor(t, "a leader", func() bool {
+		for _, n := range nodes {
+			net.mux.Lock()
+			down := net.down[n.ID()]
+			net.mux.Unlock()
+
+			if !down && n.IsLeader() {
+				leader = n
+				return true
+			}
+		}
+		return false
+	})
+
+	return leader
+}
+
+func TestNode_SingleNode(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	conf := testConfig(t, "node0")
+	conf.Bootstrap = true
+	n, sm := startNode(t, newNetwork(), conf)
+
+	waitFor(t, "leader", n.IsLeader)
+	res, err := n.Propose([]byte("a"))
+	assert.Nil(err)
+	assert.Equal(1, res)
+	assert.Equal([]string{"a"}, sm.get())
+	assert.Equal([]string{"node0"}, n.Status().Members)
+}
+
+func TestNode_Replicate(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	net, nodes, sms := startGroup(t, 3, 0)
+	leader := waitLeader(t, net, nodes)
+
+	for _, v := range []string{"a", "b", "c"} {
+		_, err := leader.Propose([]byte(v))
+		assert.Nil(err)
+	}
+
+	for i, n := range nodes {
+		if n != leader {
+			_, err := n.Propose([]byte("x"))
+			assert.Equal(ErrNotLeader, err)
+			assert.Equal(leader.ID(), n.Leader())
+		}
+
+		sm := sms[i]
+		waitFor(t, "replication", func() bool { return len(sm.get()) == 3 })
+		assert.Equal([]string{"a", "b", "c"}, sm.get())
+	}
+}
+
+func TestNode_Failover(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	net, nodes, sms := startGroup(t, 3, 0)
+	old := waitLeader(t, net, nodes)
+	_, err := old.Propose([]byte("a"))
+	assert.Nil(err)
+
+	// the isolated leader can not commit and steps down
+	net.setDown(old.ID(), true)
+	_, err = old.Propose([]byte("lost"))
+	assert.Equal(ErrLeadershipLost, err)
+
+	leader := waitLeader(t, net, nodes)
+	assert.NotEqual(old, leader)
+	_, err = leader.Propose([]byte("b"))
+	assert.Nil(err)
+
+	// the old leader drops its uncommitted entry when it rejoins
+	net.setDown(old.ID(), false)
+	for _, sm := range sms {
+		sm := sm
+		waitFor(t, "catch up", func() bool { return len(sm.get()) == 2 })
+		assert.Equal([]string{"a", "b"}, sm.get())
+	}
+}
+
+func TestNode_InstallSnapshot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	net, nodes, sms := startGroup(t, 3, 5)
+	leader := waitLeader(t, net, nodes)
+
+	lagging := 0
+	for nodes[lagging] == leader {
+		lagging++
+	}
+	net.setDown(nodes[lagging].ID(), true)
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		v := fmt.Sprintf("v%d", i)
+		want = append(want, v)
+		_, err := leader.Propose([]byte(v))
+		assert.Nil(err)
+	}
+
+	waitFor(t, "compaction", func() bool { return leader.Status().SnapshotIndex > 5 })
+
+	net.setDown(nodes[lagging].ID(), false)
+	sm := sms[lagging]
+	waitFor(t, "snapshot install", func() bool { return len(sm.get()) == len(want) })
+	assert.Equal(want, sm.get())
+	assert.True(nodes[lagging].Status().SnapshotIndex > 0)
+}
+
+func TestNode_Membership(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	net := newNetwork()
+	conf := testConfig(t, "node0")
+	conf.Bootstrap = true
+	first, _ := startNode(t, net, conf)
+	waitFor(t, "leader", first.IsLeader)
+
+	_, err := first.Propose([]byte("a"))
+	assert.Nil(err)
+
+	// a node without peers waits to be added
+	second, sm := startNode(t, net, testConfig(t, "node1"))
+	assert.Empty(second.Status().Members)
+
+	assert.Nil(first.AddMember("node1"))
+	assert.Equal(ErrMemberExists, first.AddMember("node1"))
+	assert.Equal([]string{"node0", "node1"}, first.Status().Members)
+
+	_, err = first.Propose([]byte("b"))
+	assert.Nil(err)
+	waitFor(t, "replication", func() bool { return len(sm.get()) == 2 })
+	assert.Equal([]string{"node0", "node1"}, second.Status().Members)
+
+	// the leader removing itself hands over to the remaining member
+	assert.Nil(first.RemoveMember("node0"))
+	assert.False(first.IsLeader())
+	waitFor(t, "new leader", second.IsLeader)
+	assert.Equal([]string{"node1"}, second.Status().Members)
+	assert.Equal(ErrUnknownMember, second.RemoveMember("node0"))
+}
+
+func TestNode_Restart(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	net := newNetwork()
+	conf := testConfig(t, "node0")
+	conf.Bootstrap = true
+	conf.SnapshotThreshold = 3
+	n, _ := startNode(t, net, conf)
+	waitFor(t, "leader", n.IsLeader)
+
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		_, err := n.Propose([]byte(v))
+		assert.Nil(err)
+	}
+	waitFor(t, "compaction", func() bool { return n.Status().SnapshotIndex > 0 })
+	n.Stop()
+
+	// the snapshot is restored and the remaining entries are applied again once committed
+	restarted, sm := startNode(t, net, conf)
+	waitFor(t, "leader", restarted.IsLeader)
+	assert.Nil(restarted.Barrier())
+	assert.Equal([]string{"a", "b", "c", "d", "e"}, sm.get())
+}
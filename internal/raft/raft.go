@@ -0,0 +1,245 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultElectionTimeout is the default minimum time a follower waits for a leader before starting an election
+	DefaultElectionTimeout = time.Second
+
+	// DefaultHeartbeatInterval is the default interval the leader contacts followers when there is nothing to replicate
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+
+	// DefaultSnapshotThreshold is the default number of applied entries after which the log is compacted
+	DefaultSnapshotThreshold = 10000
+
+	// maxAppendEntries is the maximum number of entries sent in a single AppendEntries request
+	maxAppendEntries = 512
+
+	// maxEntrySize is the maximum size of an entry, larger sizes read from the log file are treated as corruption
+	maxEntrySize = 512 << 20
+)
+
+var (
+	// ErrNotLeader is returned when a proposal is made to a node which is not the leader
+	ErrNotLeader = errors.New("node is not the leader")
+
+	// ErrLeadershipLost is returned when the leader stepped down before a proposal was applied, the outcome is unknown
+	ErrLeadershipLost = errors.New("leadership lost while applying the entry")
+
+	// ErrStopped is returned when the node was stopped
+	ErrStopped = errors.New("raft node stopped")
+
+	// ErrConfigChangePending is returned when a membership change is requested before the previous one is committed
+	ErrConfigChangePending = errors.New("a membership change is already in progress")
+
+	// ErrMemberExists is returned when adding a node which is already a member
+	ErrMemberExists = errors.New("node is already a member")
+
+	// ErrUnknownMember is returned when removing a node which is not a member
+	ErrUnknownMember = errors.New("node is not a member")
+
+	// ErrCorruptLog is returned when the persisted log can not be read
+	ErrCorruptLog = errors.New("corrupt raft log")
+)
+
+// Config configures a raft node
+type Config struct {
+	// ID is the address other members reach the node at, it identifies the node in the group
+	ID string
+
+	// Dir is the directory the log, the vote and snapshots are persisted to
+	Dir string
+
+	// Peers are the initial members of the group, used only when the node has no persisted state
+	Peers []string
+
+	// Bootstrap starts a new group with the node as its only member when there are no Peers
+	// Otherwise a node without persisted state waits to be added by the leader of an existing group
+	Bootstrap bool
+
+	// ElectionTimeout is the minimum time a follower waits for a leader before starting an election
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is the interval the leader contacts followers when there is nothing to replicate
+	HeartbeatInterval time.Duration
+
+	// SnapshotThreshold is the number of applied entries after which the log is compacted
+	SnapshotThreshold uint64
+
+	// Logf logs events like elections and failures to persist state, nil disables logging
+	Logf func(format string, args ...interface{})
+}
+
+// WithDefaults fills unset values with defaults
+func (conf Config) WithDefaults() Config {
+	if conf.ElectionTimeout <= 0 {
+		conf.ElectionTimeout = DefaultElectionTimeout
+	}
+
+	if conf.HeartbeatInterval <= 0 {
+		conf.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if conf.SnapshotThreshold == 0 {
+		conf.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+
+	return conf
+}
+
+// StateMachine is the replicated state entries are applied to
+// Apply, Snapshot and Restore are never called concurrently
+type StateMachine interface {
+	// Apply applies the data of a committed entry, the result is returned to the proposer on the leader
+	Apply(index uint64, data []byte) interface{}
+
+	// Snapshot returns the state including all applied entries
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the state with a snapshot
+	Restore(data []byte) error
+}
+
+// Transport sends requests to other members
+type Transport interface {
+	RequestVote(addr string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(addr string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(addr string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// EntryType is the type of a log entry
+type EntryType uint8
+
+const (
+	// EntryCommand holds data for the state machine
+	EntryCommand EntryType = iota
+
+	// EntryNoop is appended by a new leader to commit entries of previous terms
+	EntryNoop
+
+	// EntryConfig holds the members of the group, it takes effect once it is appended
+	EntryConfig
+)
+
+// Entry is an entry of the replicated log
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// VoteRequest is sent by candidates to gather votes
+type VoteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// VoteResponse is the reply to a VoteRequest
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendRequest is sent by the leader to replicate entries and as a heartbeat
+type AppendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendResponse is the reply to an AppendRequest
+type AppendResponse struct {
+	Term    uint64
+	Success bool
+
+	// LastIndex is the last index matching the leader when Success is set, otherwise a hint where to continue
+	LastIndex uint64
+}
+
+// SnapshotRequest is sent by the leader to followers which need entries that were compacted
+type SnapshotRequest struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64
+	LastTerm  uint64
+	Members   []string
+	Data      []byte
+}
+
+// SnapshotResponse is the reply to a SnapshotRequest
+type SnapshotResponse struct {
+	Term uint64
+}
+
+// Role is the role of a node in its term
+type Role int
+
+const (
+	// Follower replicates the log of the leader
+	Follower Role = iota
+
+	// Candidate is gathering votes to become leader
+	Candidate
+
+	// Leader accepts proposals and replicates them
+	Leader
+)
+
+func (r Role) String() string {
+	switch r {
+	case Leader:
+		return "leader"
+	case Candidate:
+		return "candidate"
+	default:
+		return "follower"
+	}
+}
+
+// Status is a snapshot of the state of a node
+type Status struct {
+	ID            string
+	Role          Role
+	Term          uint64
+	Leader        string
+	Members       []string
+	CommitIndex   uint64
+	AppliedIndex  uint64
+	LastLogIndex  uint64
+	SnapshotIndex uint64
+	SnapshotTerm  uint64
+}
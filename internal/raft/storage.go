@@ -0,0 +1,378 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kasvith/kache/internal/persistence"
+)
+
+const (
+	stateFile    = "raft-state"
+	logFile      = "raft-log"
+	snapshotFile = "raft-snapshot"
+
+	// entryHeaderSize is the length and the checksum preceding every entry in the log file
+	entryHeaderSize = 8
+)
+
+// snapshotMagic starts every snapshot file
+var snapshotMagic = []byte("KRSNAP01")
+
+// snapshot is the compacted prefix of the log
+type snapshot struct {
+	Index   uint64
+	Term    uint64
+	Members []string
+	Data    []byte
+}
+
+// storage persists the vote, the log and the latest snapshot of a node
+// Entries are appended to the log file, which is rewritten when its prefix is compacted or its suffix conflicts
+type storage struct {
+	dir string
+	log *os.File
+}
+
+func openStorage(dir string) (*storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storage{dir: dir, log: f}, nil
+}
+
+func (s *storage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// loadState returns the current term and the vote given in it
+func (s *storage) loadState() (term uint64, votedFor string, err error) {
+	data, err := os.ReadFile(s.path(stateFile))
+	switch {
+	case os.IsNotExist(err):
+		return 0, "", nil
+	case err != nil:
+		return 0, "", err
+	case len(data) < 8:
+		return 0, "", ErrCorruptLog
+	}
+
+	return binary.LittleEndian.Uint64(data), string(data[8:]), nil
+}
+
+// saveState persists the current term and the vote given in it
+func (s *storage) saveState(term uint64, votedFor string) error {
+	return persistence.WriteFileAtomic(s.path(stateFile), func(w io.Writer) error {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], term)
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+
+		_, err := io.WriteString(w, votedFor)
+		return err
+	})
+}
+
+// loadLog reads the entries of the log file
+// An entry which was partially written when the node crashed is dropped together with anything after it
+func (s *storage) loadLog() ([]Entry, error) {
+	if _, err := s.log.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	var valid int64
+	reader := bufio.NewReader(s.log)
+	for {
+		entry, n, err := readEntry(reader)
+		if err != nil {
+			break
+		}
+
+		if len(entries) > 0 && entry.Index != entries[len(entries)-1].Index+1 {
+			return nil, ErrCorruptLog
+		}
+
+		entries = append(entries, entry)
+		valid += n
+	}
+
+	if err := s.log.Truncate(valid); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.log.Seek(valid, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// appendEntries appends entries to the log file and syncs it
+func (s *storage) appendEntries(entries []Entry) error {
+	var buf []byte
+	for _, entry := range entries {
+		buf = appendEntry(buf, entry)
+	}
+
+	if _, err := s.log.Write(buf); err != nil {
+		return err
+	}
+
+	return s.log.Sync()
+}
+
+// rewriteLog replaces the log file with entries
+func (s *storage) rewriteLog(entries []Entry) error {
+	err := persistence.WriteFileAtomic(s.path(logFile), func(w io.Writer) error {
+		for _, entry := range entries {
+			if _, err := w.Write(appendEntry(nil, entry)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path(logFile), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.log.Close()
+	s.log = f
+	return nil
+}
+
+// loadSnapshot reads the latest snapshot, it returns nil when there is none
+func (s *storage) loadSnapshot() (*snapshot, error) {
+	data, err := os.ReadFile(s.path(snapshotFile))
+	switch {
+	case os.IsNotExist(err):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return decodeSnapshot(data)
+}
+
+// saveSnapshot replaces the latest snapshot
+func (s *storage) saveSnapshot(snap *snapshot) error {
+	return persistence.WriteFileAtomic(s.path(snapshotFile), func(w io.Writer) error {
+		_, err := w.Write(encodeSnapshot(snap))
+		return err
+	})
+}
+
+func (s *storage) close() error {
+	return s.log.Close()
+}
+
+// appendEntry encodes entry as its length, a checksum and the index, term, type and data of the entry
+func appendEntry(buf []byte, entry Entry) []byte {
+	payload := make([]byte, 17, 17+len(entry.Data))
+	binary.LittleEndian.PutUint64(payload, entry.Index)
+	binary.LittleEndian.PutUint64(payload[8:], entry.Term)
+	payload[16] = byte(entry.Type)
+	payload = append(payload, entry.Data...)
+
+	var header [entryHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// readEntry decodes an entry written by appendEntry and returns the number of bytes read
+func readEntry(r io.Reader) (Entry, int64, error) {
+	var header [entryHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Entry{}, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[:])
+	if size > maxEntrySize {
+		return Entry{}, 0, ErrCorruptLog
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Entry{}, 0, err
+	}
+
+	entry, err := decodeEntry(header[:], payload)
+	return entry, int64(entryHeaderSize) + int64(size), err
+}
+
+// decodeEntry decodes the payload of an entry after verifying it against the checksum in header
+func decodeEntry(header, payload []byte) (Entry, error) {
+	if len(payload) < 17 || crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+		return Entry{}, ErrCorruptLog
+	}
+
+	entry := Entry{
+		Index: binary.LittleEndian.Uint64(payload),
+		Term:  binary.LittleEndian.Uint64(payload[8:]),
+		Type:  EntryType(payload[16]),
+		Data:  payload[17:],
+	}
+
+	return entry, nil
+}
+
+// encodeSnapshot encodes snap followed by a checksum of the encoding
+func encodeSnapshot(snap *snapshot) []byte {
+	buf := append([]byte{}, snapshotMagic...)
+	buf = binary.LittleEndian.AppendUint64(buf, snap.Index)
+	buf = binary.LittleEndian.AppendUint64(buf, snap.Term)
+	buf = appendStrings(buf, snap.Members)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(snap.Data)))
+	buf = append(buf, snap.Data...)
+
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func decodeSnapshot(data []byte) (*snapshot, error) {
+	if len(data) < len(snapshotMagic)+4 || !bytes.Equal(data[:len(snapshotMagic)], snapshotMagic) {
+		return nil, ErrCorruptLog
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, ErrCorruptLog
+	}
+
+	d := decoder{buf: body[len(snapshotMagic):]}
+	snap := &snapshot{Index: d.uint64(), Term: d.uint64(), Members: d.strings()}
+	snap.Data = d.bytes(d.uint64())
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	return snap, nil
+}
+
+// appendStrings encodes the number of strings followed by every string with its length
+func appendStrings(buf []byte, strs []string) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(strs)))
+	for _, s := range strs {
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(s)))
+		buf = append(buf, s...)
+	}
+
+	return buf
+}
+
+// decoder reads values from a buffer, the first error is kept and makes further reads return zero values
+type decoder struct {
+	buf []byte
+	err error
+}
+
+var errShortBuffer = errors.New("unexpected end of raft message")
+
+func (d *decoder) bytes(n uint64) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if uint64(len(d.buf)) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+
+	return 0
+}
+
+func (d *decoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+
+	return 0
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(uint64(d.uint32())))
+}
+
+func (d *decoder) strings() []string {
+	n := d.uint32()
+	if d.err != nil || uint64(n) > uint64(len(d.buf)) {
+		d.err = errShortBuffer
+		return nil
+	}
+
+	strs := make([]string, 0, n)
+	for i := uint32(0); i < n; i++ {
+		strs = append(strs, d.string())
+	}
+
+	return strs
+}
@@ -0,0 +1,113 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package raft
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestStorage_Log(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	dir := t.TempDir()
+	s, err := openStorage(dir)
+	assert.Nil(err)
+
+	entries := []Entry{{Index: 1, Term: 1, Type: EntryConfig, Data: appendStrings(nil, []string{"a"})}, {Index: 2, Term: 1, Data: []byte("x")}}
+	assert.Nil(s.appendEntries(entries))
+	assert.Nil(s.appendEntries([]Entry{{Index: 3, Term: 2, Type: EntryNoop, Data: []byte{}}}))
+	assert.Nil(s.saveState(2, "b"))
+	assert.Nil(s.close())
+
+	// a partially written entry is dropped
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(err)
+	f.Write(appendEntry(nil, Entry{Index: 4, Term: 2, Data: []byte("torn")})[:10])
+	f.Close()
+
+	s, err = openStorage(dir)
+	assert.Nil(err)
+	loaded, err := s.loadLog()
+	assert.Nil(err)
+	assert.Len(loaded, 3)
+	assert.Equal("x", string(loaded[1].Data))
+	assert.Equal(EntryNoop, loaded[2].Type)
+
+	term, votedFor, err := s.loadState()
+	assert.Nil(err)
+	assert.Equal(uint64(2), term)
+	assert.Equal("b", votedFor)
+
+	assert.Nil(s.rewriteLog(loaded[1:2]))
+	assert.Nil(s.appendEntries([]Entry{{Index: 3, Term: 3, Data: []byte("y")}}))
+	loaded, err = s.loadLog()
+	assert.Nil(err)
+	assert.Len(loaded, 2)
+	assert.Equal(uint64(3), loaded[1].Term)
+	assert.Nil(s.close())
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s, err := openStorage(t.TempDir())
+	assert.Nil(err)
+	defer s.close()
+
+	snap, err := s.loadSnapshot()
+	assert.Nil(err)
+	assert.Nil(snap)
+
+	want := &snapshot{Index: 10, Term: 3, Members: []string{"a", "b"}, Data: []byte("data")}
+	assert.Nil(s.saveSnapshot(want))
+	snap, err = s.loadSnapshot()
+	assert.Nil(err)
+	assert.Equal(want, snap)
+
+	data := encodeSnapshot(want)
+	data[len(snapshotMagic)+2] ^= 0xff
+	_, err = decodeSnapshot(data)
+	assert.Equal(ErrCorruptLog, err)
+}
+
+func TestAppendRequest_Binary(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	req := &AppendRequest{Term: 4, Leader: "a:1", PrevLogIndex: 7, PrevLogTerm: 3, LeaderCommit: 6,
+		Entries: []Entry{{Index: 8, Term: 4, Data: []byte("set k v")}, {Index: 9, Term: 4, Type: EntryNoop, Data: []byte{}}}}
+	data, err := req.MarshalBinary()
+	assert.Nil(err)
+
+	decoded := &AppendRequest{}
+	assert.Nil(decoded.UnmarshalBinary(data))
+	assert.Equal(req, decoded)
+
+	assert.NotNil(decoded.UnmarshalBinary(data[:len(data)-1]))
+}
@@ -0,0 +1,109 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package replication
+
+// Backlog keeps the most recent part of the replication stream so replicas can continue after short disconnects
+// It is not safe for concurrent use
+type Backlog struct {
+	buf []byte
+
+	// next is the position in buf where the next byte is written
+	next int
+
+	// histlen is the number of valid bytes in buf
+	histlen int
+
+	// offset is the replication offset after the last written byte
+	offset int64
+}
+
+// NewBacklog returns a backlog holding up to size bytes of a stream which continues at offset
+func NewBacklog(size int, offset int64) *Backlog {
+	if size <= 0 {
+		size = DefaultBacklogSize
+	}
+
+	return &Backlog{buf: make([]byte, size), offset: offset}
+}
+
+// Write appends p to the backlog, the oldest bytes are dropped when the backlog is full
+func (b *Backlog) Write(p []byte) {
+	b.offset += int64(len(p))
+
+	// only the tail fits when p is larger than the backlog
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+
+	for len(p) > 0 {
+		n := copy(b.buf[b.next:], p)
+		p = p[n:]
+		b.next = (b.next + n) % len(b.buf)
+		b.histlen += n
+	}
+
+	if b.histlen > len(b.buf) {
+		b.histlen = len(b.buf)
+	}
+}
+
+// Offset returns the replication offset after the last written byte
+func (b *Backlog) Offset() int64 {
+	return b.offset
+}
+
+// Start returns the replication offset before the first byte held by the backlog
+func (b *Backlog) Start() int64 {
+	return b.offset - int64(b.histlen)
+}
+
+// Len returns the number of bytes held by the backlog
+func (b *Backlog) Len() int {
+	return b.histlen
+}
+
+// Size returns the capacity of the backlog
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// Since returns a copy of the stream following offset, ok is false when the backlog does not cover offset
+func (b *Backlog) Since(offset int64) (data []byte, ok bool) {
+	if offset < b.Start() || offset > b.offset {
+		return nil, false
+	}
+
+	n := int(b.offset - offset)
+	data = make([]byte, 0, n)
+
+	from := b.next - n
+	if from < 0 {
+		data = append(data, b.buf[len(b.buf)+from:]...)
+		from = 0
+	}
+
+	return append(data, b.buf[from:b.next]...), true
+}
@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package replication
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestBacklog_Since(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	b := NewBacklog(8, 100)
+	assert.Equal(int64(100), b.Offset())
+	assert.Equal(int64(100), b.Start())
+
+	data, ok := b.Since(100)
+	assert.True(ok)
+	assert.Empty(data)
+
+	b.Write([]byte("abcde"))
+	assert.Equal(int64(105), b.Offset())
+	assert.Equal(5, b.Len())
+
+	data, ok = b.Since(102)
+	assert.True(ok)
+	assert.Equal("cde", string(data))
+
+	_, ok = b.Since(99)
+	assert.False(ok)
+	_, ok = b.Since(106)
+	assert.False(ok)
+}
+
+func TestBacklog_Wrap(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	b := NewBacklog(8, 0)
+	b.Write([]byte("abcdef"))
+	b.Write([]byte("ghij"))
+
+	assert.Equal(int64(10), b.Offset())
+	assert.Equal(int64(2), b.Start())
+	assert.Equal(8, b.Len())
+
+	data, ok := b.Since(2)
+	assert.True(ok)
+	assert.Equal("cdefghij", string(data))
+
+	data, ok = b.Since(7)
+	assert.True(ok)
+	assert.Equal("hij", string(data))
+
+	_, ok = b.Since(1)
+	assert.False(ok)
+
+	// writes larger than the backlog keep only the tail
+	b.Write([]byte("0123456789"))
+	assert.Equal(int64(20), b.Offset())
+	data, ok = b.Since(12)
+	assert.True(ok)
+	assert.Equal("23456789", string(data))
+}
+
+func TestParseReplicaOf(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	host, port, err := ParseReplicaOf("127.0.0.1 7088")
+	assert.Nil(err)
+	assert.Equal("127.0.0.1", host)
+	assert.Equal(7088, port)
+
+	for _, s := range []string{"", "127.0.0.1", "127.0.0.1 port", "127.0.0.1 0", "a 1 2"} {
+		_, _, err = ParseReplicaOf(s)
+		assert.Equal(ErrInvalidReplicaOf, err, s)
+	}
+}
+
+func TestNewID(t *testing.T) {
+	id := NewID()
+	testifyAssert.Len(t, id, IDLength)
+	testifyAssert.NotEqual(t, id, NewID())
+}
@@ -0,0 +1,112 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBacklogSize is the default size of the replication backlog in bytes
+	DefaultBacklogSize = 1024 * 1024
+
+	// DefaultTimeout is the default time after which a silent replication link is considered broken
+	DefaultTimeout = 60 * time.Second
+
+	// DefaultPingPeriod is the default interval of pings sent to replicas
+	DefaultPingPeriod = 10 * time.Second
+
+	// IDLength is the length of a replication ID
+	IDLength = 40
+)
+
+// ErrInvalidReplicaOf is returned when a replicaof option is not in host port format
+var ErrInvalidReplicaOf = errors.New("replicaof should be in <host> <port> format")
+
+// Config configures replication
+type Config struct {
+	// ListeningPort is the port announced to the master when running as a replica
+	ListeningPort int
+
+	// BacklogSize is the size of the replication backlog in bytes
+	BacklogSize int
+
+	// ReadOnly rejects writes of clients while running as a replica
+	ReadOnly bool
+
+	// Timeout is the time after which a silent replication link is considered broken
+	Timeout time.Duration
+
+	// PingPeriod is the interval of pings sent to replicas
+	PingPeriod time.Duration
+}
+
+// WithDefaults fills unset values with defaults
+func (conf Config) WithDefaults() Config {
+	if conf.BacklogSize <= 0 {
+		conf.BacklogSize = DefaultBacklogSize
+	}
+
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultTimeout
+	}
+
+	if conf.PingPeriod <= 0 {
+		conf.PingPeriod = DefaultPingPeriod
+	}
+
+	return conf
+}
+
+// NewID returns a random replication ID
+func NewID() string {
+	b := make([]byte, IDLength/2)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// ParseReplicaOf parses a replicaof option in host port format
+func ParseReplicaOf(s string) (host string, port int, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return "", 0, ErrInvalidReplicaOf
+	}
+
+	port, err = strconv.Atoi(fields[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, ErrInvalidReplicaOf
+	}
+
+	return fields[0], port, nil
+}
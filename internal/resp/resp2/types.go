@@ -166,3 +166,25 @@ func (a ArrayReply) ToBytes() []byte {
 
 	return buf.Bytes()
 }
+
+// PushReply is data sent to a client without being requested, e.g. an invalidation of its client side cache
+// RESP2 has no push type, so it is framed as a pub/sub message of Channel carrying Data
+type PushReply struct {
+	Kind    string
+	Channel string
+	Data    protocol.Reply
+}
+
+// NewPushReply creates a new PushReply of kind sent on channel
+func NewPushReply(kind, channel string, data protocol.Reply) *PushReply {
+	return &PushReply{Kind: kind, Channel: channel, Data: data}
+}
+
+// ToBytes returns byte representation of PushReply
+func (p PushReply) ToBytes() []byte {
+	return NewArrayReply(false, []protocol.Reply{
+		NewBulkStringReply(false, "message"),
+		NewBulkStringReply(false, p.Channel),
+		p.Data,
+	}).ToBytes()
+}
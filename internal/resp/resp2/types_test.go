@@ -80,3 +80,13 @@ func TestArrayReply(t *testing.T) {
 	reply = NewArrayReply(true, []protocol.Reply{})
 	testifyAssert.Equal(t, []byte("*-1\r\n"), reply.ToBytes())
 }
+
+func TestPushReply(t *testing.T) {
+	reply := NewPushReply("invalidate", "__redis__:invalidate", NewArrayReply(false, []protocol.Reply{
+		NewBulkStringReply(false, "foo"),
+	}))
+	testifyAssert.Equal(t, []byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n"), reply.ToBytes())
+
+	reply = NewPushReply("invalidate", "__redis__:invalidate", NewArrayReply(true, nil))
+	testifyAssert.Equal(t, []byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*-1\r\n"), reply.ToBytes())
+}
@@ -11,21 +11,37 @@ import (
 
 // resp3 protocol type
 const (
-	Resp3SimpleString   = '+' // +<string>\n
-	Resp3BlobString     = '$' // $<length>\n<bytes>\n
-	Resp3VerbatimString = '=' // =<length>\n<format(3 bytes)>\n<bytes>\n
-	Resp3SimpleError    = '-' // -<string>\n
-	Resp3BolbError      = '!' // !<length>\n<bytes>\n
-	Resp3Number         = ':' // :<number>\n
-	Resp3Double         = ',' // ,<floating-point-number>\n
-	Resp3BigNumber      = '(' // (<big number>\n
-	Resp3Null           = '_' // _\n
-	Resp3Boolean        = '#' // #t\n or #f\n
-	Resp3Array          = '*' // *<elements number>\n... numelements other types ...
-	Resp3Map            = '%' // %<elements number>\n... numelements other types ...
-	Resp3Set            = '~' // ~<elements number>\n... numelements other types ...
+	Resp3SimpleString   = '+' // +<string>\r\n
+	Resp3BlobString     = '$' // $<length>\r\n<bytes>\r\n
+	Resp3VerbatimString = '=' // =<length>\r\n<format(3 bytes)>:<bytes>\r\n
+	Resp3SimpleError    = '-' // -<string>\r\n
+	Resp3BolbError      = '!' // !<length>\r\n<bytes>\r\n
+	Resp3Number         = ':' // :<number>\r\n
+	Resp3Double         = ',' // ,<floating-point-number>\r\n
+	Resp3BigNumber      = '(' // (<big number>\r\n
+	Resp3Null           = '_' // _\r\n
+	Resp3Boolean        = '#' // #t\r\n or #f\r\n
+	Resp3Array          = '*' // *<elements number>\r\n... numelements other types ...
+	Resp3Map            = '%' // %<elements number>\r\n... numelements key value pairs ...
+	Resp3Set            = '~' // ~<elements number>\r\n... numelements other types ...
+	Resp3Attribute      = '|' // |<elements number>\r\n... numelements key value pairs ... followed by the described reply
+	Resp3Push           = '>' // ><elements number>\r\n... numelements other types ...
 )
 
+// streamed types
+const (
+	Resp3StreamedLength = '?' // $?\r\n or *?\r\n, the length is not known in advance
+	Resp3StreamedChunk  = ';' // ;<length>\r\n<bytes>\r\n, a part of a streamed blob string, ;0\r\n is the last one
+	Resp3StreamedEnd    = '.' // .\r\n ends a streamed aggregate
+)
+
+// MaxBlobLength is the greatest length of a blob string read by the parser, like the proto-max-bulk-len of Redis
+// The length is checked before the payload is allocated, streamed blob strings are limited in total
+const MaxBlobLength = 512 << 20
+
+// CR is \r
+const CR = '\r'
+
 // LF is \n
 const LF = '\n'
 
@@ -33,12 +49,14 @@ const LF = '\n'
 type Resp3 struct {
 	Type    byte
 	Str     string
+	Format  string // format of a verbatim string, e.g. txt or mkd
 	Err     error
 	Integer int
 	Boolean bool
 	Double  float64
 	BigInt  *big.Int
-	Elems   []*Resp3
+	Elems   []*Resp3 // elements of an aggregate, maps and attributes hold keys and values one after another
+	Attrs   *Resp3   // attributes sent ahead of the reply, nil if there were none
 }
 
 // NewSliceResp3 convert string slice to resp3 protocol raw string
@@ -46,13 +64,13 @@ func NewSliceResp3(slices []string) string {
 	buf := new(strings.Builder)
 	buf.WriteByte('*')
 	buf.WriteString(strconv.Itoa(len(slices)))
-	buf.WriteByte('\n')
+	buf.WriteString(CRLF)
 	for _, v := range slices {
 		buf.WriteByte('$')
 		buf.WriteString(strconv.Itoa(len(v)))
-		buf.WriteByte('\n')
+		buf.WriteString(CRLF)
 		buf.WriteString(v)
-		buf.WriteByte('\n')
+		buf.WriteString(CRLF)
 	}
 	return buf.String()
 }
@@ -65,7 +83,6 @@ func (r *Resp3) RenderString() string {
 // ProtocolString convert resp3 to protocol raw string
 func (r *Resp3) ProtocolString() string {
 	buf := new(strings.Builder)
-	buf.WriteByte(r.Type)
 	r.protocolString(buf)
 	return buf.String()
 }
@@ -117,12 +134,23 @@ func (r *Resp3) command() (string, error) {
 }
 
 func (r *Resp3) protocolString(buf *strings.Builder) {
+	if r.Attrs != nil {
+		r.Attrs.protocolString(buf)
+	}
+
+	buf.WriteByte(r.Type)
 	switch r.Type {
 	case Resp3SimpleString:
 		buf.WriteString(r.Str)
 	case Resp3BlobString:
 		buf.WriteString(strconv.Itoa(len(r.Str)))
-		buf.WriteByte('\n')
+		buf.WriteString(CRLF)
+		buf.WriteString(r.Str)
+	case Resp3VerbatimString:
+		buf.WriteString(strconv.Itoa(len(r.Format) + 1 + len(r.Str)))
+		buf.WriteString(CRLF)
+		buf.WriteString(r.Format)
+		buf.WriteByte(':')
 		buf.WriteString(r.Str)
 	case Resp3SimpleError:
 		if r.Err != nil {
@@ -130,12 +158,12 @@ func (r *Resp3) protocolString(buf *strings.Builder) {
 		}
 	case Resp3BolbError:
 		if r.Err == nil {
-			buf.WriteString("0\n")
-			return
+			buf.WriteString("0" + CRLF)
+			break
 		}
 		e := r.Err.Error()
 		buf.WriteString(strconv.Itoa(len(e)))
-		buf.WriteByte('\n')
+		buf.WriteString(CRLF)
 		buf.WriteString(e)
 	case Resp3Number:
 		buf.WriteString(strconv.Itoa(r.Integer))
@@ -150,24 +178,36 @@ func (r *Resp3) protocolString(buf *strings.Builder) {
 		} else {
 			buf.WriteByte('f')
 		}
-	case Resp3Array, Resp3Set:
-		buf.WriteString(strconv.Itoa(len(r.Elems)))
-		buf.WriteByte('\n')
+	case Resp3Array, Resp3Set, Resp3Push, Resp3Map, Resp3Attribute:
+		n := len(r.Elems)
+		if r.Type == Resp3Map || r.Type == Resp3Attribute {
+			n /= 2
+		}
+		buf.WriteString(strconv.Itoa(n))
+		buf.WriteString(CRLF)
 
 		for _, v := range r.Elems {
-			buf.WriteByte(v.Type)
 			v.protocolString(buf)
 		}
 		return
 	}
 
-	buf.WriteByte('\n')
+	buf.WriteString(CRLF)
 }
 
 func (r *Resp3) renderString(pre string) string {
+	if r.Attrs != nil {
+		attrs := *r.Attrs
+		reply := *r
+		reply.Attrs = nil
+		return attrs.renderString(pre) + "\n" + reply.renderString(pre)
+	}
+
 	switch r.Type {
 	case Resp3SimpleString, Resp3BlobString:
 		return fmt.Sprintf("%s%q", pre, r.Str)
+	case Resp3VerbatimString:
+		return pre + strings.Replace(r.Str, "\n", "\n"+pre, -1)
 	case Resp3SimpleError, Resp3BolbError:
 		return pre + "(error) " + r.Err.Error()
 	case Resp3Number:
@@ -183,19 +223,38 @@ func (r *Resp3) renderString(pre string) string {
 			return pre + "(boolean) true"
 		}
 		return pre + "(boolean) false"
-	case Resp3Array, Resp3Set:
+	case Resp3Array, Resp3Set, Resp3Push:
 		str := new(strings.Builder)
 		str.WriteString(pre)
-		if r.Type == Resp3Array {
+		switch r.Type {
+		case Resp3Array:
 			str.WriteString("(array)")
-		} else {
+		case Resp3Set:
 			str.WriteString("(set)")
+		default:
+			str.WriteString("(push)")
 		}
 		for _, elem := range r.Elems {
 			str.WriteString("\n")
 			str.WriteString(elem.renderString(pre + "\t"))
 		}
 		return str.String()
+	case Resp3Map, Resp3Attribute:
+		str := new(strings.Builder)
+		str.WriteString(pre)
+		if r.Type == Resp3Map {
+			str.WriteString("(map)")
+		} else {
+			str.WriteString("(attribute)")
+		}
+		// values follow their key on the same line
+		for i := 0; i+1 < len(r.Elems); i += 2 {
+			str.WriteString("\n")
+			str.WriteString(r.Elems[i].renderString(pre + "\t"))
+			str.WriteString(" => ")
+			str.WriteString(strings.TrimPrefix(r.Elems[i+1].renderString(pre+"\t"), pre+"\t"))
+		}
+		return str.String()
 	}
 
 	return pre + "(error) unknown protocol type: " + string(r.Type)
@@ -3,6 +3,7 @@ package resp3
 import (
 	"bufio"
 	"errors"
+	"io"
 	"math/big"
 	"strconv"
 	"strings"
@@ -50,7 +51,7 @@ func (r *Parser) parse() (*Resp3, error) {
 
 	switch b {
 	case Resp3SimpleString, Resp3SimpleError:
-		str, err := r.stringBeforeLF()
+		str, err := r.stringBeforeCRLF()
 		if err != nil {
 			return nil, err
 		}
@@ -58,29 +59,35 @@ func (r *Parser) parse() (*Resp3, error) {
 			return &Resp3{Type: b, Str: str}, nil
 		}
 		return &Resp3{Type: b, Err: errors.New(str)}, nil
-	case Resp3BlobString, Resp3BolbError:
-		length, err := r.intBeforeLF()
+	case Resp3BlobString, Resp3BolbError, Resp3VerbatimString:
+		bs, err := r.blob(b)
 		if err != nil {
 			return nil, err
+		} else if bs == nil {
+			// resp2 peers send $-1 for null
+			return &Resp3{Type: Resp3Null}, nil
 		}
 
-		bs, err := r.readLengthBytesWithLF(length)
-		if err != nil {
-			return nil, err
+		switch b {
+		case Resp3BlobString:
+			return &Resp3{Type: b, Str: string(bs)}, nil
+		case Resp3BolbError:
+			return &Resp3{Type: b, Err: errors.New(string(bs))}, nil
 		}
 
-		if b == Resp3BlobString {
-			return &Resp3{Type: b, Str: string(bs)}, nil
+		// verbatim strings start with a format of 3 bytes followed by a colon
+		if len(bs) < 4 || bs[3] != ':' {
+			return nil, &protocol.ErrUnexpectString{Str: "<format>:"}
 		}
-		return &Resp3{Type: b, Err: errors.New(string(bs))}, nil
+		return &Resp3{Type: b, Format: string(bs[:3]), Str: string(bs[4:])}, nil
 	case Resp3Number:
-		integer, err := r.intBeforeLF()
+		integer, err := r.intBeforeCRLF()
 		if err != nil {
 			return nil, err
 		}
 		return &Resp3{Type: b, Integer: integer}, nil
 	case Resp3Double:
-		str, err := r.stringBeforeLF()
+		str, err := r.stringBeforeCRLF()
 		if err != nil {
 			return nil, err
 		}
@@ -90,7 +97,7 @@ func (r *Parser) parse() (*Resp3, error) {
 		}
 		return &Resp3{Type: b, Double: f}, nil
 	case Resp3BigNumber:
-		str, err := r.stringBeforeLF()
+		str, err := r.stringBeforeCRLF()
 		if err != nil {
 			return nil, err
 		}
@@ -100,12 +107,12 @@ func (r *Parser) parse() (*Resp3, error) {
 		}
 		return &Resp3{Type: b, BigInt: bigInt}, nil
 	case Resp3Null:
-		if _, err := r.readLengthBytesWithLF(0); err != nil {
+		if _, err := r.readLengthBytesWithCRLF(0); err != nil {
 			return nil, err
 		}
 		return &Resp3{Type: b}, nil
 	case Resp3Boolean:
-		buf, err := r.readLengthBytesWithLF(1)
+		buf, err := r.readLengthBytesWithCRLF(1)
 		if err != nil {
 			return nil, err
 		}
@@ -117,47 +124,173 @@ func (r *Parser) parse() (*Resp3, error) {
 			return &Resp3{Type: b, Boolean: false}, nil
 		}
 		return nil, &protocol.ErrUnexpectString{Str: "t/f"}
-	case Resp3Array, Resp3Set:
-		length, err := r.intBeforeLF()
+	case Resp3Array, Resp3Set, Resp3Push, Resp3Map:
+		return r.aggregate(b)
+	case Resp3Attribute:
+		attrs, err := r.aggregate(b)
+		if err != nil {
+			return nil, err
+		}
+
+		// attributes describe the reply following them
+		resp, err := r.parse()
+		if err != nil {
+			return nil, err
+		}
+		if resp.Attrs != nil {
+			attrs.Elems = append(attrs.Elems, resp.Attrs.Elems...)
+		}
+		resp.Attrs = attrs
+		return resp, nil
+	}
+
+	return nil, &protocol.ErrProtocolType{Type: b}
+}
+
+// blob reads the payload of a blob type, which is nil for a null blob
+func (r *Parser) blob(b byte) ([]byte, error) {
+	str, err := r.stringBeforeCRLF()
+	if err != nil {
+		return nil, err
+	}
+
+	if str == string(Resp3StreamedLength) && b == Resp3BlobString {
+		return r.chunks()
+	}
+
+	length, err := strconv.Atoi(str)
+	if err != nil {
+		return nil, &protocol.ErrCastFailedToInt{Val: str}
+	} else if length < 0 {
+		if b == Resp3BlobString {
+			return nil, nil
+		}
+		return nil, &protocol.ErrUnexpectString{Str: str}
+	}
+
+	bs, err := r.readLengthBytesWithCRLF(length)
+	if err != nil {
+		return nil, err
+	} else if bs == nil {
+		bs = []byte{}
+	}
+	return bs, nil
+}
+
+// chunks reads the chunks of a streamed blob string until the empty one
+func (r *Parser) chunks() ([]byte, error) {
+	bs := []byte{}
+	for {
+		if b, err := r.reader.ReadByte(); err != nil {
+			return nil, err
+		} else if b != Resp3StreamedChunk {
+			return nil, &protocol.ErrUnexpectString{Str: string(Resp3StreamedChunk)}
+		}
+
+		length, err := r.intBeforeCRLF()
+		if err != nil {
+			return nil, err
+		} else if length < 0 {
+			return nil, &protocol.ErrUnexpectString{Str: strconv.Itoa(length)}
+		} else if length == 0 {
+			return bs, nil
+		}
+
+		if length > MaxBlobLength-len(bs) {
+			return nil, &protocol.ErrUnexpectString{Str: strconv.Itoa(length)}
+		}
+
+		chunk, err := r.readLengthBytesWithCRLF(length)
 		if err != nil {
 			return nil, err
 		}
-		resp := &Resp3{Type: b}
-		for i := 0; i < length; i++ {
-			elem, err := r.Parse()
+		bs = append(bs, chunk...)
+	}
+}
+
+// aggregate reads the elements of an aggregate type, either counted or streamed until an end mark
+func (r *Parser) aggregate(b byte) (*Resp3, error) {
+	str, err := r.stringBeforeCRLF()
+	if err != nil {
+		return nil, err
+	}
+
+	streamed := str == string(Resp3StreamedLength)
+	length := 0
+	if !streamed {
+		if length, err = strconv.Atoi(str); err != nil {
+			return nil, &protocol.ErrCastFailedToInt{Val: str}
+		} else if length < 0 {
+			// resp2 peers send *-1 for null
+			if b == Resp3Array {
+				return &Resp3{Type: Resp3Null}, nil
+			}
+			return nil, &protocol.ErrUnexpectString{Str: str}
+		}
+	}
+
+	// maps and attributes hold a key and a value for each element
+	pairs := b == Resp3Map || b == Resp3Attribute
+	if pairs {
+		length *= 2
+	}
+
+	resp := &Resp3{Type: b}
+	for i := 0; streamed || i < length; i++ {
+		if streamed {
+			end, err := r.streamEnd()
 			if err != nil {
 				return nil, err
+			} else if end {
+				if pairs && i%2 == 1 {
+					return nil, &protocol.ErrUnexpectString{Str: string(Resp3StreamedEnd)}
+				}
+				break
 			}
-			resp.Elems = append(resp.Elems, elem)
 		}
-		return resp, nil
+
+		elem, err := r.parse()
+		if err != nil {
+			return nil, err
+		}
+		resp.Elems = append(resp.Elems, elem)
 	}
+	return resp, nil
+}
 
-	return nil, &protocol.ErrProtocolType{Type: b}
+// streamEnd consumes the end mark of a streamed aggregate if it is next
+func (r *Parser) streamEnd() (bool, error) {
+	buf, err := r.reader.Peek(1)
+	if err != nil {
+		return false, err
+	} else if buf[0] != Resp3StreamedEnd {
+		return false, nil
+	}
+
+	r.reader.ReadByte()
+	if _, err := r.readLengthBytesWithCRLF(0); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (r *Parser) stringBeforeLF() (string, error) {
+func (r *Parser) stringBeforeCRLF() (string, error) {
 	buf, err := r.reader.ReadBytes(LF)
 	if err != nil {
 		return "", err
 	}
-	bs, err := trimLastLF(buf)
+	bs, err := trimCRLF(buf)
 	if err != nil {
 		return "", err
 	}
 	return string(bs), nil
 }
 
-func (r *Parser) intBeforeLF() (int, error) {
-	buf, err := r.reader.ReadBytes(LF)
+func (r *Parser) intBeforeCRLF() (int, error) {
+	s, err := r.stringBeforeCRLF()
 	if err != nil {
 		return 0, err
 	}
-	bs, err := trimLastLF(buf)
-	if err != nil {
-		return 0, err
-	}
-	s := string(bs)
 	i, err := strconv.Atoi(s)
 	if err != nil {
 		return 0, &protocol.ErrCastFailedToInt{Val: s}
@@ -165,32 +298,36 @@ func (r *Parser) intBeforeLF() (int, error) {
 	return i, nil
 }
 
-func (r *Parser) readLengthBytesWithLF(length int) ([]byte, error) {
-	if length == 0 {
-		if b, err := r.reader.ReadByte(); err != nil {
-			return nil, err
-		} else if b != LF {
-			return nil, &protocol.ErrUnexpectString{Str: "<LF>"}
-		}
-		return nil, nil
+// readLengthBytesWithCRLF reads length bytes followed by CRLF, lengths above MaxBlobLength are rejected before
+// anything is allocated
+func (r *Parser) readLengthBytesWithCRLF(length int) ([]byte, error) {
+	if length > MaxBlobLength {
+		return nil, &protocol.ErrUnexpectString{Str: strconv.Itoa(length)}
 	}
 
-	buf := make([]byte, length+1)
-	n, err := r.reader.Read(buf)
-	if err != nil {
-		return nil, err
-	} else if n < length+1 {
+	buf := make([]byte, length+2)
+	if _, err := io.ReadFull(r.reader, buf); err == io.ErrUnexpectedEOF {
 		return nil, &protocol.ErrUnexpectedLineEnd{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if length == 0 {
+		if buf[0] != CR || buf[1] != LF {
+			return nil, &protocol.ErrUnexpectString{Str: "<CRLF>"}
+		}
+		return nil, nil
 	}
 
-	return trimLastLF(buf)
+	return trimCRLF(buf)
 }
 
-func trimLastLF(buf []byte) ([]byte, error) {
+// trimCRLF trims the trailing CRLF from buf, it fails if buf does not end with CRLF
+func trimCRLF(buf []byte) ([]byte, error) {
 	bufLen := len(buf)
-	if len(buf) == 0 || buf[bufLen-1] != LF {
+	if bufLen < 2 || buf[bufLen-2] != CR || buf[bufLen-1] != LF {
 		return nil, &protocol.ErrUnexpectedLineEnd{}
 	}
 
-	return buf[:bufLen-1], nil
+	return buf[:bufLen-2], nil
 }
@@ -43,79 +43,82 @@ func TestResp3Parser(t *testing.T) {
 	testCases := []TestResp3{
 		// simple renderString
 		{protocol: "+renderString", err: "EOF"},
-		{resp3: &Resp3{Type: Resp3SimpleString, Str: ""}, protocol: "+\n", render: `""`},
-		{resp3: &Resp3{Type: Resp3SimpleString, Str: "hello"}, protocol: "+hello\n", render: `"hello"`},
-		{resp3: &Resp3{Type: Resp3SimpleString, Str: "hello world"}, protocol: "+hello world\n", render: `"hello world"`},
+		{resp3: &Resp3{Type: Resp3SimpleString, Str: ""}, protocol: "+\r\n", render: `""`},
+		{resp3: &Resp3{Type: Resp3SimpleString, Str: "hello"}, protocol: "+hello\r\n", render: `"hello"`},
+		{resp3: &Resp3{Type: Resp3SimpleString, Str: "hello world"}, protocol: "+hello world\r\n", render: `"hello world"`},
 
 		// blob renderString
-		{protocol: "$1\n\n", err: "unexpected line end"},
-		{protocol: "$1\naa\n", err: "unexpected line end"},
-		{resp3: &Resp3{Type: Resp3BlobString, Str: ""}, protocol: "$0\n\n", render: `""`},
-		{resp3: &Resp3{Type: Resp3BlobString, Str: "hello"}, protocol: "$5\nhello\n", render: `"hello"`},
-		{resp3: &Resp3{Type: Resp3BlobString, Str: "hello\nworld"}, protocol: "$11\nhello\nworld\n", render: "\"hello\\nworld\""},
+		{protocol: "$1\r\n\r\n", err: "unexpected line end"},
+		{protocol: "$1\r\naa\r\n", err: "unexpected line end"},
+		{protocol: "$9223372036854775807\r\n", err: "unexpect string: 9223372036854775807"},
+		{protocol: "$536870913\r\n", err: "unexpect string: 536870913"},
+		{protocol: "!9223372036854775807\r\n", err: "unexpect string: 9223372036854775807"},
+		{resp3: &Resp3{Type: Resp3BlobString, Str: ""}, protocol: "$0\r\n\r\n", render: `""`},
+		{resp3: &Resp3{Type: Resp3BlobString, Str: "hello"}, protocol: "$5\r\nhello\r\n", render: `"hello"`},
+		{resp3: &Resp3{Type: Resp3BlobString, Str: "hello\nworld"}, protocol: "$11\r\nhello\nworld\r\n", render: "\"hello\\nworld\""},
 
 		// simple error
 		{protocol: "-renderString", err: "EOF"},
-		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("")}, protocol: "-\n", render: `(error) `},
-		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("hello")}, protocol: "-hello\n", render: `(error) hello`},
-		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("hello world")}, protocol: "-hello world\n", render: `(error) hello world`},
+		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("")}, protocol: "-\r\n", render: `(error) `},
+		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("hello")}, protocol: "-hello\r\n", render: `(error) hello`},
+		{resp3: &Resp3{Type: Resp3SimpleError, Err: errors.New("hello world")}, protocol: "-hello world\r\n", render: `(error) hello world`},
 
 		// blob error
-		{protocol: "!1\n\n", err: "unexpected line end"},
-		{protocol: "!1\naa\n", err: "unexpected line end"},
-		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("")}, protocol: "!0\n\n", render: `(error) `},
-		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("hello")}, protocol: "!5\nhello\n", render: `(error) hello`},
-		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("hello\nworld")}, protocol: "!11\nhello\nworld\n", render: "(error) hello\nworld"},
+		{protocol: "!1\r\n\r\n", err: "unexpected line end"},
+		{protocol: "!1\r\naa\r\n", err: "unexpected line end"},
+		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("")}, protocol: "!0\r\n\r\n", render: `(error) `},
+		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("hello")}, protocol: "!5\r\nhello\r\n", render: `(error) hello`},
+		{resp3: &Resp3{Type: Resp3BolbError, Err: errors.New("hello\nworld")}, protocol: "!11\r\nhello\nworld\r\n", render: "(error) hello\nworld"},
 
 		// number
 		{protocol: ":invalid", err: "EOF"},
-		{protocol: ":invalid\n", err: "ERR: error casting invalid to int"},
-		{resp3: &Resp3{Type: Resp3Number, Integer: -1}, protocol: ":-1\n", render: `(integer) -1`},
-		{resp3: &Resp3{Type: Resp3Number, Integer: 0}, protocol: ":0\n", render: `(integer) 0`},
-		{resp3: &Resp3{Type: Resp3Number, Integer: 100}, protocol: ":100\n", render: `(integer) 100`},
+		{protocol: ":invalid\r\n", err: "ERR: error casting invalid to int"},
+		{resp3: &Resp3{Type: Resp3Number, Integer: -1}, protocol: ":-1\r\n", render: `(integer) -1`},
+		{resp3: &Resp3{Type: Resp3Number, Integer: 0}, protocol: ":0\r\n", render: `(integer) 0`},
+		{resp3: &Resp3{Type: Resp3Number, Integer: 100}, protocol: ":100\r\n", render: `(integer) 100`},
 
 		// double
 		{protocol: ",invalid", err: "EOF"},
-		{protocol: ",invalid\n", err: "convert invalid to double fail, because of strconv.ParseFloat: parsing \"invalid\": invalid syntax"},
-		{resp3: &Resp3{Type: Resp3Double, Double: -1}, protocol: ",-1\n", render: "(double) -1"},
-		{resp3: &Resp3{Type: Resp3Double, Double: 0}, protocol: ",0\n", render: "(double) 0"},
-		{resp3: &Resp3{Type: Resp3Double, Double: 10}, protocol: ",10\n", render: "(double) 10"},
-		{resp3: &Resp3{Type: Resp3Double, Double: 1.23}, protocol: ",1.23\n", render: "(double) 1.23"},
-		{protocol: ",.1\n", render: "(double) 0.1"},
-		{protocol: ",1.\n", render: "(double) 1"},
+		{protocol: ",invalid\r\n", err: "convert invalid to double fail, because of strconv.ParseFloat: parsing \"invalid\": invalid syntax"},
+		{resp3: &Resp3{Type: Resp3Double, Double: -1}, protocol: ",-1\r\n", render: "(double) -1"},
+		{resp3: &Resp3{Type: Resp3Double, Double: 0}, protocol: ",0\r\n", render: "(double) 0"},
+		{resp3: &Resp3{Type: Resp3Double, Double: 10}, protocol: ",10\r\n", render: "(double) 10"},
+		{resp3: &Resp3{Type: Resp3Double, Double: 1.23}, protocol: ",1.23\r\n", render: "(double) 1.23"},
+		{protocol: ",.1\r\n", render: "(double) 0.1"},
+		{protocol: ",1.\r\n", render: "(double) 1"},
 
 		// big number
 		{protocol: "(invalid", err: "EOF"},
-		{protocol: "(invalid\n", err: "convert invalid to Big Number fail"},
-		{resp3: &Resp3{Type: Resp3BigNumber, BigInt: bigNumber}, protocol: "(3492890328409238509324850943850943825024385\n", render: "(big number) 3492890328409238509324850943850943825024385"},
+		{protocol: "(invalid\r\n", err: "convert invalid to Big Number fail"},
+		{resp3: &Resp3{Type: Resp3BigNumber, BigInt: bigNumber}, protocol: "(3492890328409238509324850943850943825024385\r\n", render: "(big number) 3492890328409238509324850943850943825024385"},
 
 		// null
-		{protocol: "_invalid", err: "unexpect string: <LF>"},
-		{protocol: "_invalid\n", err: "unexpect string: <LF>"},
-		{resp3: &Resp3{Type: Resp3Null}, protocol: "_\n", render: "(null)"},
+		{protocol: "_invalid", err: "unexpect string: <CRLF>"},
+		{protocol: "_invalid\r\n", err: "unexpect string: <CRLF>"},
+		{resp3: &Resp3{Type: Resp3Null}, protocol: "_\r\n", render: "(null)"},
 
 		// boolean
 		{protocol: "#", err: "EOF"},
-		{protocol: "#\n", err: "unexpected line end"},
-		{protocol: "#x\n", err: "unexpect string: t/f"},
+		{protocol: "#\r\n", err: "unexpected line end"},
+		{protocol: "#x\r\n", err: "unexpect string: t/f"},
 		{protocol: "#invalid", err: "unexpected line end"},
-		{protocol: "#invalid\n", err: "unexpected line end"},
-		{resp3: &Resp3{Type: Resp3Boolean, Boolean: true}, protocol: "#t\n", render: "(boolean) true"},
-		{resp3: &Resp3{Type: Resp3Boolean, Boolean: false}, protocol: "#f\n", render: "(boolean) false"},
+		{protocol: "#invalid\r\n", err: "unexpected line end"},
+		{resp3: &Resp3{Type: Resp3Boolean, Boolean: true}, protocol: "#t\r\n", render: "(boolean) true"},
+		{resp3: &Resp3{Type: Resp3Boolean, Boolean: false}, protocol: "#f\r\n", render: "(boolean) false"},
 
 		// array
 		{protocol: "*", err: "EOF"},
-		{protocol: "*\n", err: "ERR: error casting  to int"},
+		{protocol: "*\r\n", err: "ERR: error casting  to int"},
 		{protocol: "*invalid", err: "EOF"},
-		{protocol: "*invalid\n", err: "ERR: error casting invalid to int"},
-		{protocol: "*1\n\n", err: "unknown protocol type: \n"},
-		{protocol: "*1\ninvalid\n", err: "unknown protocol type: i"},
-		{protocol: "*3\n:1\n:2\n", err: "EOF"},
+		{protocol: "*invalid\r\n", err: "ERR: error casting invalid to int"},
+		{protocol: "*1\r\n\r\n", err: "unknown protocol type: \r"},
+		{protocol: "*1\r\ninvalid\r\n", err: "unknown protocol type: i"},
+		{protocol: "*3\r\n:1\r\n:2\r\n", err: "EOF"},
 		{resp3: &Resp3{Type: Resp3Array, Elems: []*Resp3{
 			{Type: Resp3Number, Integer: 1},
 			{Type: Resp3Number, Integer: 2},
 			{Type: Resp3Number, Integer: 3},
-		}}, protocol: "*3\n:1\n:2\n:3\n", render: "(array)\n\t(integer) 1\n\t(integer) 2\n\t(integer) 3"},
+		}}, protocol: "*3\r\n:1\r\n:2\r\n:3\r\n", render: "(array)\n\t(integer) 1\n\t(integer) 2\n\t(integer) 3"},
 		{resp3: &Resp3{Type: Resp3Array, Elems: []*Resp3{
 			{Type: Resp3Array, Elems: []*Resp3{
 				{Type: Resp3Number, Integer: 1},
@@ -123,21 +126,21 @@ func TestResp3Parser(t *testing.T) {
 				{Type: Resp3Number, Integer: 2},
 			}},
 			{Type: Resp3Boolean, Boolean: false},
-		}}, protocol: "*2\n*3\n:1\n$5\nhello\n:2\n#f\n", render: "(array)\n\t(array)\n\t\t(integer) 1\n\t\t\"hello\"\n\t\t(integer) 2\n\t(boolean) false"},
+		}}, protocol: "*2\r\n*3\r\n:1\r\n$5\r\nhello\r\n:2\r\n#f\r\n", render: "(array)\n\t(array)\n\t\t(integer) 1\n\t\t\"hello\"\n\t\t(integer) 2\n\t(boolean) false"},
 
 		// set
 		{protocol: "~", err: "EOF"},
-		{protocol: "~\n", err: "ERR: error casting  to int"},
+		{protocol: "~\r\n", err: "ERR: error casting  to int"},
 		{protocol: "~invalid", err: "EOF"},
-		{protocol: "~invalid\n", err: "ERR: error casting invalid to int"},
-		{protocol: "~1\n\n", err: "unknown protocol type: \n"},
-		{protocol: "~1\ninvalid\n", err: "unknown protocol type: i"},
-		{protocol: "~3\n:1\n:2\n", err: "EOF"},
+		{protocol: "~invalid\r\n", err: "ERR: error casting invalid to int"},
+		{protocol: "~1\r\n\r\n", err: "unknown protocol type: \r"},
+		{protocol: "~1\r\ninvalid\r\n", err: "unknown protocol type: i"},
+		{protocol: "~3\r\n:1\r\n:2\r\n", err: "EOF"},
 		{resp3: &Resp3{Type: Resp3Set, Elems: []*Resp3{
 			{Type: Resp3Number, Integer: 1},
 			{Type: Resp3Number, Integer: 2},
 			{Type: Resp3Number, Integer: 3},
-		}}, protocol: "~3\n:1\n:2\n:3\n", render: "(set)\n\t(integer) 1\n\t(integer) 2\n\t(integer) 3"},
+		}}, protocol: "~3\r\n:1\r\n:2\r\n:3\r\n", render: "(set)\n\t(integer) 1\n\t(integer) 2\n\t(integer) 3"},
 		{resp3: &Resp3{Type: Resp3Set, Elems: []*Resp3{
 			{Type: Resp3Array, Elems: []*Resp3{
 				{Type: Resp3Number, Integer: 1},
@@ -145,7 +148,83 @@ func TestResp3Parser(t *testing.T) {
 				{Type: Resp3Number, Integer: 2},
 			}},
 			{Type: Resp3Boolean, Boolean: false},
-		}}, protocol: "~2\n*3\n:1\n$5\nhello\n:2\n#f\n", render: "(set)\n\t(array)\n\t\t(integer) 1\n\t\t\"hello\"\n\t\t(integer) 2\n\t(boolean) false"},
+		}}, protocol: "~2\r\n*3\r\n:1\r\n$5\r\nhello\r\n:2\r\n#f\r\n", render: "(set)\n\t(array)\n\t\t(integer) 1\n\t\t\"hello\"\n\t\t(integer) 2\n\t(boolean) false"},
+
+		// line ends
+		{protocol: "+hello\n", err: "unexpected line end"},
+		{protocol: ":1\r", err: "EOF"},
+		{protocol: "$5\r\nhello\n", err: "unexpected line end"},
+
+		// resp2 nulls
+		{protocol: "$-1\r\n", render: "(null)"},
+		{protocol: "*-1\r\n", render: "(null)"},
+		{protocol: "~-1\r\n", err: "unexpect string: -1"},
+
+		// verbatim string
+		{protocol: "=3\r\ntxt\r\n", err: "unexpect string: <format>:"},
+		{protocol: "=5\r\ntxt-a\r\n", err: "unexpect string: <format>:"},
+		{resp3: &Resp3{Type: Resp3VerbatimString, Format: "txt", Str: ""}, protocol: "=4\r\ntxt:\r\n", render: ""},
+		{resp3: &Resp3{Type: Resp3VerbatimString, Format: "txt", Str: "Some string"}, protocol: "=15\r\ntxt:Some string\r\n", render: "Some string"},
+		{resp3: &Resp3{Type: Resp3Array, Elems: []*Resp3{
+			{Type: Resp3VerbatimString, Format: "mkd", Str: "# a\nb"},
+		}}, protocol: "*1\r\n=9\r\nmkd:# a\nb\r\n", render: "(array)\n\t# a\n\tb"},
+
+		// map
+		{protocol: "%", err: "EOF"},
+		{protocol: "%invalid\r\n", err: "ERR: error casting invalid to int"},
+		{protocol: "%1\r\n+a\r\n", err: "EOF"},
+		{resp3: &Resp3{Type: Resp3Map}, protocol: "%0\r\n", render: "(map)"},
+		{resp3: &Resp3{Type: Resp3Map, Elems: []*Resp3{
+			{Type: Resp3SimpleString, Str: "first"},
+			{Type: Resp3Number, Integer: 1},
+			{Type: Resp3SimpleString, Str: "second"},
+			{Type: Resp3Array, Elems: []*Resp3{
+				{Type: Resp3Number, Integer: 2},
+				{Type: Resp3Null},
+			}},
+		}}, protocol: "%2\r\n+first\r\n:1\r\n+second\r\n*2\r\n:2\r\n_\r\n", render: "(map)\n\t\"first\" => (integer) 1\n\t\"second\" => (array)\n\t\t(integer) 2\n\t\t(null)"},
+
+		// push
+		{protocol: ">", err: "EOF"},
+		{protocol: ">2\r\n+message\r\n", err: "EOF"},
+		{resp3: &Resp3{Type: Resp3Push, Elems: []*Resp3{
+			{Type: Resp3BlobString, Str: "message"},
+			{Type: Resp3BlobString, Str: "channel"},
+			{Type: Resp3BlobString, Str: "hello"},
+		}}, protocol: ">3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n", render: "(push)\n\t\"message\"\n\t\"channel\"\n\t\"hello\""},
+
+		// attribute
+		{protocol: "|1\r\n+ttl\r\n:3\r\n", err: "EOF"},
+		{resp3: &Resp3{Type: Resp3Number, Integer: 10, Attrs: &Resp3{Type: Resp3Attribute, Elems: []*Resp3{
+			{Type: Resp3SimpleString, Str: "ttl"},
+			{Type: Resp3Number, Integer: 3},
+		}}}, protocol: "|1\r\n+ttl\r\n:3\r\n:10\r\n", render: "(attribute)\n\t\"ttl\" => (integer) 3\n(integer) 10"},
+		{resp3: &Resp3{Type: Resp3Array, Elems: []*Resp3{
+			{Type: Resp3Number, Integer: 1, Attrs: &Resp3{Type: Resp3Attribute, Elems: []*Resp3{
+				{Type: Resp3SimpleString, Str: "a"},
+				{Type: Resp3Boolean, Boolean: true},
+			}}},
+		}}, protocol: "*1\r\n|1\r\n+a\r\n#t\r\n:1\r\n", render: "(array)\n\t(attribute)\n\t\t\"a\" => (boolean) true\n\t(integer) 1"},
+
+		// streamed blob string
+		{protocol: "$?\r\n;4\r\nHell\r\n", err: "EOF"},
+		{protocol: "$?\r\n:4\r\n", err: "unexpect string: ;"},
+		{protocol: "$?\r\n;2\r\nHell\r\n", err: "unexpected line end"},
+		{protocol: "!?\r\n", err: "ERR: error casting ? to int"},
+		{protocol: "$?\r\n;9223372036854775807\r\n", err: "unexpect string: 9223372036854775807"},
+		{protocol: "$?\r\n;4\r\nHell\r\n;536870909\r\n", err: "unexpect string: 536870909"},
+		{protocol: "$?\r\n;0\r\n", render: `""`},
+		{protocol: "$?\r\n;4\r\nHell\r\n;5\r\no wor\r\n;1\r\nd\r\n;0\r\n", render: `"Hello word"`},
+
+		// streamed aggregates
+		{protocol: "*?\r\n:1\r\n", err: "EOF"},
+		{protocol: "*?\r\n:1\r\n.invalid\r\n", err: "unexpect string: <CRLF>"},
+		{protocol: "%?\r\n+a\r\n.\r\n", err: "unexpect string: ."},
+		{protocol: "*?\r\n.\r\n", render: "(array)"},
+		{protocol: "*?\r\n:1\r\n*?\r\n:2\r\n.\r\n$?\r\n;1\r\na\r\n;0\r\n.\r\n", render: "(array)\n\t(integer) 1\n\t(array)\n\t\t(integer) 2\n\t\"a\""},
+		{protocol: "~?\r\n+a\r\n+b\r\n.\r\n", render: "(set)\n\t\"a\"\n\t\"b\""},
+		{protocol: "%?\r\n+a\r\n:1\r\n.\r\n", render: "(map)\n\t\"a\" => (integer) 1"},
+		{protocol: ">?\r\n+pubsub\r\n.\r\n", render: "(push)\n\t\"pubsub\""},
 	}
 
 	for _, testCase := range testCases {
@@ -164,3 +243,15 @@ func TestResp3Parser(t *testing.T) {
 		}
 	}
 }
+
+func TestResp3ParserLargeBlob(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	// larger than the buffer of the reader, so it arrives in several reads
+	str := strings.Repeat("kache", 10000)
+	resp := &Resp3{Type: Resp3BlobString, Str: str}
+	parser := NewResp3Parser(bufio.NewReader(strings.NewReader(resp.ProtocolString())))
+	result, err := parser.Parse()
+	assert.Nil(err)
+	assert.Equal(resp, result)
+}
@@ -0,0 +1,250 @@
+package resp3
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+)
+
+// CRLF represents line ending \r\n used in replies sent by the server
+const CRLF = "\r\n"
+
+// MapReply is used to return an ordered list of key value pairs
+type MapReply struct {
+	// Reps holds keys and values one after another
+	Reps []protocol.Reply
+}
+
+// NewMapReply creates a new MapReply, reps should contain keys and values one after another
+func NewMapReply(reps []protocol.Reply) *MapReply {
+	return &MapReply{Reps: reps}
+}
+
+// ToBytes returns byte representation of MapReply
+func (m MapReply) ToBytes() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteByte(Resp3Map)
+	buf.WriteString(strconv.Itoa(len(m.Reps) / 2))
+	buf.WriteString(CRLF)
+	for _, value := range m.Reps {
+		buf.Write(value.ToBytes())
+	}
+
+	return buf.Bytes()
+}
+
+// PushReply is used to send out of band data like published messages
+type PushReply struct {
+	Reps []protocol.Reply
+}
+
+// NewPushReply creates a new PushReply
+func NewPushReply(reps []protocol.Reply) *PushReply {
+	return &PushReply{Reps: reps}
+}
+
+// ToBytes returns byte representation of PushReply
+func (p PushReply) ToBytes() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteByte(Resp3Push)
+	buf.WriteString(strconv.Itoa(len(p.Reps)))
+	buf.WriteString(CRLF)
+	for _, value := range p.Reps {
+		buf.Write(value.ToBytes())
+	}
+
+	return buf.Bytes()
+}
+
+// aggregate encodes the header of an aggregate type followed by its elements
+func aggregate(t byte, n int, reps []protocol.Reply) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteByte(t)
+	buf.WriteString(strconv.Itoa(n))
+	buf.WriteString(CRLF)
+	for _, value := range reps {
+		buf.Write(value.ToBytes())
+	}
+
+	return buf.Bytes()
+}
+
+// SetReply is used to return an unordered collection of distinct elements
+type SetReply struct {
+	Reps []protocol.Reply
+}
+
+// NewSetReply creates a new SetReply
+func NewSetReply(reps []protocol.Reply) *SetReply {
+	return &SetReply{Reps: reps}
+}
+
+// ToBytes returns byte representation of SetReply
+func (s SetReply) ToBytes() []byte {
+	return aggregate(Resp3Set, len(s.Reps), s.Reps)
+}
+
+// NullReply represents a missing value of any type
+type NullReply struct{}
+
+// NewNullReply creates a new NullReply
+func NewNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// ToBytes returns byte representation of NullReply
+func (n NullReply) ToBytes() []byte {
+	return []byte("_\r\n")
+}
+
+// BooleanReply is used to return true or false
+type BooleanReply struct {
+	Value bool
+}
+
+// NewBooleanReply creates a new BooleanReply
+func NewBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{Value: value}
+}
+
+// ToBytes returns byte representation of BooleanReply
+func (b BooleanReply) ToBytes() []byte {
+	if b.Value {
+		return []byte("#t\r\n")
+	}
+
+	return []byte("#f\r\n")
+}
+
+// DoubleReply is used to return a floating point number
+type DoubleReply struct {
+	Value float64
+}
+
+// NewDoubleReply creates a new DoubleReply
+func NewDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{Value: value}
+}
+
+// FormatDouble converts a floating point number to its representation in a double reply
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ToBytes returns byte representation of DoubleReply
+func (d DoubleReply) ToBytes() []byte {
+	return []byte(string(Resp3Double) + FormatDouble(d.Value) + CRLF)
+}
+
+// BigNumberReply is used to return an integer outside of the range of 64 bit integers
+type BigNumberReply struct {
+	Value *big.Int
+}
+
+// NewBigNumberReply creates a new BigNumberReply
+func NewBigNumberReply(value *big.Int) *BigNumberReply {
+	return &BigNumberReply{Value: value}
+}
+
+// ToBytes returns byte representation of BigNumberReply
+func (b BigNumberReply) ToBytes() []byte {
+	return []byte(string(Resp3BigNumber) + b.Value.String() + CRLF)
+}
+
+// VerbatimStringReply is a string which should be shown to users without escaping, Format is a three letter type
+// of the text like txt or mkd
+type VerbatimStringReply struct {
+	Format string
+	Str    string
+}
+
+// NewVerbatimStringReply creates a new VerbatimStringReply
+func NewVerbatimStringReply(format, str string) *VerbatimStringReply {
+	return &VerbatimStringReply{Format: format, Str: str}
+}
+
+// ToBytes returns byte representation of VerbatimStringReply
+func (v VerbatimStringReply) ToBytes() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteByte(Resp3VerbatimString)
+	buf.WriteString(strconv.Itoa(len(v.Format) + 1 + len(v.Str)))
+	buf.WriteString(CRLF)
+	buf.WriteString(v.Format)
+	buf.WriteByte(':')
+	buf.WriteString(v.Str)
+	buf.WriteString(CRLF)
+
+	return buf.Bytes()
+}
+
+// FromResp2 converts the null bulk strings and null arrays of a RESP2 reply to RESP3 nulls
+// Aggregates are copied when one of their elements changes, other replies are returned as they are
+func FromResp2(reply protocol.Reply) protocol.Reply {
+	converted, _ := fromResp2(reply)
+	return converted
+}
+
+// fromResp2 converts a reply and reports whether it changed
+func fromResp2(reply protocol.Reply) (protocol.Reply, bool) {
+	switch r := reply.(type) {
+	case *resp2.BulkStringReply:
+		if r.IsNull {
+			return NewNullReply(), true
+		}
+	case *resp2.ArrayReply:
+		if r.IsNull {
+			return NewNullReply(), true
+		}
+		if reps, changed := fromResp2All(r.Reps); changed {
+			return resp2.NewArrayReply(false, reps), true
+		}
+	case *MapReply:
+		if reps, changed := fromResp2All(r.Reps); changed {
+			return NewMapReply(reps), true
+		}
+	case *SetReply:
+		if reps, changed := fromResp2All(r.Reps); changed {
+			return NewSetReply(reps), true
+		}
+	case *PushReply:
+		if reps, changed := fromResp2All(r.Reps); changed {
+			return NewPushReply(reps), true
+		}
+	case *resp2.PushReply:
+		// RESP3 has a push type, so the data is sent without being framed as a pub/sub message
+		data, _ := fromResp2(r.Data)
+		return NewPushReply([]protocol.Reply{resp2.NewBulkStringReply(false, r.Kind), data}), true
+	}
+
+	return reply, false
+}
+
+func fromResp2All(reps []protocol.Reply) ([]protocol.Reply, bool) {
+	var converted []protocol.Reply
+	for i, rep := range reps {
+		c, changed := fromResp2(rep)
+		if changed && converted == nil {
+			converted = make([]protocol.Reply, len(reps))
+			copy(converted, reps[:i])
+		}
+
+		if converted != nil {
+			converted[i] = c
+		}
+	}
+
+	return converted, converted != nil
+}
@@ -0,0 +1,111 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package resp3
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/internal/resp/resp2"
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestMapReply(t *testing.T) {
+	reply := NewMapReply([]protocol.Reply{resp2.NewBulkStringReply(false, "f"), resp2.NewIntegerReply(1)})
+	testifyAssert.Implements(t, (*protocol.Reply)(nil), reply)
+	testifyAssert.Equal(t, []byte("%1\r\n$1\r\nf\r\n:1\r\n"), reply.ToBytes())
+}
+
+func TestSetReply(t *testing.T) {
+	reply := NewSetReply([]protocol.Reply{resp2.NewBulkStringReply(false, "a"), resp2.NewBulkStringReply(false, "b")})
+	testifyAssert.Equal(t, []byte("~2\r\n$1\r\na\r\n$1\r\nb\r\n"), reply.ToBytes())
+
+	reply = NewSetReply(nil)
+	testifyAssert.Equal(t, []byte("~0\r\n"), reply.ToBytes())
+}
+
+func TestNullReply(t *testing.T) {
+	testifyAssert.Equal(t, []byte("_\r\n"), NewNullReply().ToBytes())
+}
+
+func TestBooleanReply(t *testing.T) {
+	testifyAssert.Equal(t, []byte("#t\r\n"), NewBooleanReply(true).ToBytes())
+	testifyAssert.Equal(t, []byte("#f\r\n"), NewBooleanReply(false).ToBytes())
+}
+
+func TestDoubleReply(t *testing.T) {
+	testifyAssert.Equal(t, []byte(",1.5\r\n"), NewDoubleReply(1.5).ToBytes())
+	testifyAssert.Equal(t, []byte(",-10\r\n"), NewDoubleReply(-10).ToBytes())
+	testifyAssert.Equal(t, []byte(",inf\r\n"), NewDoubleReply(math.Inf(1)).ToBytes())
+	testifyAssert.Equal(t, []byte(",-inf\r\n"), NewDoubleReply(math.Inf(-1)).ToBytes())
+	testifyAssert.Equal(t, []byte(",nan\r\n"), NewDoubleReply(math.NaN()).ToBytes())
+}
+
+func TestBigNumberReply(t *testing.T) {
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	testifyAssert.Equal(t, []byte("(3492890328409238509324850943850943825024385\r\n"), NewBigNumberReply(n).ToBytes())
+}
+
+func TestVerbatimStringReply(t *testing.T) {
+	reply := NewVerbatimStringReply("txt", "Some string")
+	testifyAssert.Equal(t, []byte("=15\r\ntxt:Some string\r\n"), reply.ToBytes())
+}
+
+func TestPushReply(t *testing.T) {
+	reply := NewPushReply([]protocol.Reply{resp2.NewBulkStringReply(false, "message"), resp2.NewBulkStringReply(false, "ch")})
+	testifyAssert.Equal(t, []byte(">2\r\n$7\r\nmessage\r\n$2\r\nch\r\n"), reply.ToBytes())
+}
+
+func TestFromResp2(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.Equal([]byte("_\r\n"), FromResp2(resp2.NewBulkStringReply(true, "")).ToBytes())
+	assert.Equal([]byte("_\r\n"), FromResp2(resp2.NewArrayReply(true, nil)).ToBytes())
+
+	// replies without nulls are kept
+	bulk := resp2.NewBulkStringReply(false, "foo")
+	assert.True(bulk == FromResp2(bulk))
+
+	arr := resp2.NewArrayReply(false, []protocol.Reply{bulk, resp2.NewIntegerReply(1)})
+	assert.True(arr == FromResp2(arr))
+
+	// nested nulls are converted without changing the original reply
+	nested := resp2.NewArrayReply(false, []protocol.Reply{
+		bulk,
+		NewMapReply([]protocol.Reply{bulk, resp2.NewBulkStringReply(true, "")}),
+		resp2.NewArrayReply(true, nil),
+	})
+	assert.Equal([]byte("*3\r\n$3\r\nfoo\r\n%1\r\n$3\r\nfoo\r\n_\r\n_\r\n"), FromResp2(nested).ToBytes())
+	assert.Equal([]byte("*3\r\n$3\r\nfoo\r\n%1\r\n$3\r\nfoo\r\n$-1\r\n*-1\r\n"), nested.ToBytes())
+
+	// resp2 pushes are sent as push frames of their kind
+	push := resp2.NewPushReply("invalidate", "__redis__:invalidate", resp2.NewArrayReply(false, []protocol.Reply{bulk}))
+	assert.Equal([]byte(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"), FromResp2(push).ToBytes())
+	push = resp2.NewPushReply("invalidate", "__redis__:invalidate", resp2.NewArrayReply(true, nil))
+	assert.Equal([]byte(">2\r\n$10\r\ninvalidate\r\n_\r\n"), FromResp2(push).ToBytes())
+}
@@ -28,12 +28,19 @@ package srv
 import (
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/kasvith/kache/internal/client"
 
+	"github.com/kasvith/kache/internal/cluster"
 	"github.com/kasvith/kache/internal/config"
+	"github.com/kasvith/kache/internal/db"
 	"github.com/kasvith/kache/internal/klogs"
+	"github.com/kasvith/kache/internal/persistence"
+	"github.com/kasvith/kache/internal/raft"
+	"github.com/kasvith/kache/internal/replication"
 )
 
 // Start the tcp server
@@ -46,6 +53,114 @@ func Start(config config.AppConfig) {
 		os.Exit(3)
 	}
 
+	client.InitDatabases(config.Databases)
+
+	rules, err := persistence.ParseSaveRules(config.Save)
+	if err != nil {
+		klogs.Logger.Fatalf("invalid save rules: %s", err.Error())
+		os.Exit(2)
+	}
+
+	client.InitSnapshots(filepath.Join(config.Dir, config.DBFilename), rules)
+	aofPath := filepath.Join(config.Dir, config.AppendFilename)
+
+	if config.RaftEnabled && (config.ClusterEnabled || config.ReplicaOf != "" || config.ImportRDB != "") {
+		klogs.Logger.Fatal("raft mode can not be combined with cluster mode, replicaof or importRdb")
+		os.Exit(2)
+	}
+
+	switch {
+	case config.RaftEnabled:
+		// the data set is restored from the raft snapshot and log
+	case config.ImportRDB != "":
+		err = client.ImportRDB(config.ImportRDB)
+	case config.AppendOnly:
+		err = client.LoadAOF(aofPath, config.AOFLoadTruncated)
+	default:
+		err = client.LoadSnapshot()
+	}
+
+	if err != nil {
+		klogs.Logger.Fatalf("error loading data: %s", err.Error())
+		os.Exit(2)
+	}
+
+	if config.AppendOnly {
+		if err := client.OpenAOF(aofPath, config.AppendFsync); err != nil {
+			klogs.Logger.Fatalf("error opening append only file: %s", err.Error())
+			os.Exit(2)
+		}
+
+		// imported keys are not in the append only file yet
+		if config.ImportRDB != "" {
+			if err := client.RewriteAOF(); err != nil {
+				klogs.Logger.Fatalf("error rewriting append only file: %s", err.Error())
+				os.Exit(2)
+			}
+		}
+	}
+
+	client.InitChangeFeed(config.ChangeFeedSize)
+	client.StartActiveExpire(db.ExpireConfig{
+		Hz:           config.Hz,
+		KeysPerLoop:  config.ActiveExpireKeysPerLoop,
+		StalePercent: config.ActiveExpireStalePercent,
+		TimePercent:  config.ActiveExpireTimePercent,
+	})
+	client.StartSnapshotter()
+
+	client.InitReplication(replication.Config{
+		ListeningPort: config.Port,
+		BacklogSize:   config.ReplBacklogSize,
+		ReadOnly:      config.ReplicaReadOnly,
+		Timeout:       time.Duration(config.ReplTimeout) * time.Second,
+		PingPeriod:    time.Duration(config.ReplPingReplicaPeriod) * time.Second,
+	})
+	client.StartReplication()
+
+	if config.ReplicaOf != "" {
+		host, port, err := replication.ParseReplicaOf(config.ReplicaOf)
+		if err != nil {
+			klogs.Logger.Fatalf("invalid replicaof: %s", err.Error())
+			os.Exit(2)
+		}
+
+		client.SetMaster(host, port)
+	}
+
+	if config.ClusterEnabled {
+		err := client.InitCluster(cluster.Config{
+			ConfigFile:  filepath.Join(config.Dir, config.ClusterConfigFile),
+			Host:        config.Host,
+			Port:        config.Port,
+			NodeTimeout: time.Duration(config.ClusterNodeTimeout) * time.Millisecond,
+		})
+		if err != nil {
+			klogs.Logger.Fatalf("error loading cluster config: %s", err.Error())
+			os.Exit(2)
+		}
+
+		client.StartCluster()
+	}
+
+	if config.RaftEnabled {
+		err := client.InitRaft(raft.Config{
+			ID:                addr,
+			Dir:               filepath.Join(config.Dir, config.RaftDir),
+			Peers:             config.RaftPeers,
+			Bootstrap:         config.RaftBootstrap,
+			ElectionTimeout:   time.Duration(config.RaftElectionTimeout) * time.Millisecond,
+			HeartbeatInterval: time.Duration(config.RaftHeartbeatInterval) * time.Millisecond,
+			SnapshotThreshold: uint64(config.RaftSnapshotThreshold),
+		})
+		if err != nil {
+			klogs.Logger.Fatalf("error loading raft state: %s", err.Error())
+			os.Exit(2)
+		}
+
+		client.StartRaft()
+	}
+
 	klogs.Logger.Infof("application is ready to accept connections on port %d", config.Port)
 
 	for {
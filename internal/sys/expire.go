@@ -28,8 +28,18 @@ import (
 	"time"
 )
 
-// GetTTL unix time stamp for a key
+// GetTTL unix time stamp in milliseconds for a key which expires after val in given scale
 func GetTTL(val int64, scale time.Duration) int64 {
-	tt := time.Now().Add(time.Duration(val) * scale).Unix()
+	tt := UnixMillis(time.Now().Add(time.Duration(val) * scale))
 	return tt
 }
+
+// UnixMillis returns t as a unix time stamp in milliseconds
+func UnixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// NowMillis returns current unix time stamp in milliseconds
+func NowMillis() int64 {
+	return UnixMillis(time.Now())
+}
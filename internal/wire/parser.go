@@ -30,8 +30,12 @@ import (
 	"strings"
 
 	"github.com/kasvith/kache/internal/protocol"
+	"github.com/kasvith/kache/pkg/util"
 )
 
+// MaxInlineLength is the maximum length of an inline command in bytes
+const MaxInlineLength = 64 * 1024
+
 // Parser is used to parse wire protocol
 type Parser struct {
 	r *bufio.Reader
@@ -43,8 +47,9 @@ func NewParser(r *bufio.Reader) *Parser {
 }
 
 // Parse and return a Command and an error
+// Arguments are separated by white space and can be quoted like the inline commands of redis
 func (p Parser) Parse() (*protocol.Command, error) {
-	str, err := p.r.ReadString('\n')
+	str, err := p.readLine()
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +67,10 @@ func (p Parser) Parse() (*protocol.Command, error) {
 	}
 
 	str = str[:remLen]
-	tokens := strings.Split(str, " ")
+	tokens, err := util.SplitSpacesWithQuotes(str)
+	if err != nil {
+		return nil, protocol.ErrUnbalancedQuotes{}
+	}
 
 	if len(tokens) > 0 {
 		return &protocol.Command{Name: strings.ToLower(tokens[0]), Args: tokens[1:]}, nil
@@ -70,3 +78,31 @@ func (p Parser) Parse() (*protocol.Command, error) {
 
 	return &protocol.Command{}, nil
 }
+
+// readLine reads a line without buffering more than MaxInlineLength bytes
+// The rest of a longer line is discarded, so the next line can be parsed
+func (p Parser) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		if len(line)+len(chunk) > MaxInlineLength {
+			for err == bufio.ErrBufferFull {
+				_, err = p.r.ReadSlice('\n')
+			}
+			if err != nil {
+				return "", err
+			}
+
+			return "", protocol.ErrInlineTooBig{}
+		}
+
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		return string(line), nil
+	}
+}
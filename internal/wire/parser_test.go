@@ -30,6 +30,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/kasvith/kache/internal/protocol"
 	testifyAssert "github.com/stretchr/testify/assert"
 )
 
@@ -64,3 +65,53 @@ func TestParser_ParseEmpty(t *testing.T) {
 	testifyAssert.Equal(t, "", cmd.Name)
 	testifyAssert.Equal(t, 0, len(cmd.Args))
 }
+
+func TestParser_ParseQuotes(t *testing.T) {
+	p := getParser("set  greeting \"hello world\\n\\x41\" 'it\\'s'\r\n   \r\nget greeting\n")
+	cmd, err := p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "set", cmd.Name)
+	testifyAssert.Equal(t, []string{"greeting", "hello world\nA", "it's"}, cmd.Args)
+
+	// white space only lines have no command
+	cmd, err = p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "", cmd.Name)
+
+	cmd, err = p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "get", cmd.Name)
+	testifyAssert.Equal(t, []string{"greeting"}, cmd.Args)
+}
+
+func TestParser_ParseUnbalancedQuotes(t *testing.T) {
+	p := getParser("set greeting \"hello\r\nset greeting 'a'b\r\nping\r\n")
+	_, err := p.Parse()
+	testifyAssert.Equal(t, protocol.ErrUnbalancedQuotes{}, err)
+
+	_, err = p.Parse()
+	testifyAssert.Equal(t, protocol.ErrUnbalancedQuotes{}, err)
+
+	// the next line is parsed after an error
+	cmd, err := p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "ping", cmd.Name)
+}
+
+func TestParser_ParseTooBig(t *testing.T) {
+	p := getParser("set key " + strings.Repeat("a", MaxInlineLength) + "\r\nping\r\n")
+	_, err := p.Parse()
+	testifyAssert.Equal(t, protocol.ErrInlineTooBig{}, err)
+
+	// the rest of the line is discarded
+	cmd, err := p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "ping", cmd.Name)
+
+	// a line of the maximum length is parsed
+	p = getParser("echo " + strings.Repeat("a", MaxInlineLength-7) + "\r\n")
+	cmd, err = p.Parse()
+	testifyAssert.Nil(t, err)
+	testifyAssert.Equal(t, "echo", cmd.Name)
+	testifyAssert.Equal(t, MaxInlineLength-7, len(cmd.Args[0]))
+}
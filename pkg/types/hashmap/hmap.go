@@ -28,11 +28,16 @@ import (
 	"errors"
 	"strconv"
 	"sync"
+
+	"github.com/kasvith/kache/pkg/types/index"
 )
 
 // HashMap is a thread safe hashmap with RWMutex
 type HashMap struct {
-	m   map[string]string
+	m map[string]string
+
+	// idx allows iterating with a cursor and picking random keys, it is built the first time it is needed
+	idx *index.Index
 	mux *sync.RWMutex
 }
 
@@ -41,18 +46,39 @@ func New() *HashMap {
 	return &HashMap{m: make(map[string]string), mux: &sync.RWMutex{}}
 }
 
+// index returns the index of the keys building it on first use, caller must hold the write lock
+func (m *HashMap) index() *index.Index {
+	if m.idx == nil {
+		m.idx = index.New()
+		for key := range m.m {
+			m.idx.Add(key)
+		}
+	}
+
+	return m.idx
+}
+
+// setLocked stores a value and indexes new keys, caller must hold the write lock
+func (m *HashMap) setLocked(key, value string) {
+	if _, found := m.m[key]; !found && m.idx != nil {
+		m.idx.Add(key)
+	}
+
+	m.m[key] = value
+}
+
 // Set key value tuple
 // It will return 0 when key was already in the map, 1 when new key inserted
 func (m *HashMap) Set(key, value string) int {
 	m.mux.Lock()
 
-	if value, found := m.m[key]; found {
+	if _, found := m.m[key]; found {
 		m.m[key] = value
 		m.mux.Unlock()
 		return 0
 	}
 
-	m.m[key] = value
+	m.setLocked(key, value)
 	m.mux.Unlock()
 	return 1
 }
@@ -66,7 +92,7 @@ func (m *HashMap) Setx(key, value string) int {
 		return 0
 	}
 
-	m.m[key] = value
+	m.setLocked(key, value)
 	m.mux.Unlock()
 	return 1
 }
@@ -81,7 +107,7 @@ func (m *HashMap) SetBulk(fields []string) (string, error) {
 	}
 
 	for i := 0; i < len(fields); i += 2 {
-		m.m[fields[i]] = fields[i+1]
+		m.setLocked(fields[i], fields[i+1])
 	}
 
 	m.mux.Unlock()
@@ -96,6 +122,14 @@ func (m *HashMap) Get(key string) string {
 	return val
 }
 
+// Find a value from a key, found is false when key is not in map
+func (m *HashMap) Find(key string) (val string, found bool) {
+	m.mux.RLock()
+	val, found = m.m[key]
+	m.mux.RUnlock()
+	return
+}
+
 // GetBulk returns an array of values for given keys, with nil values
 func (m *HashMap) GetBulk(keys []string) []string {
 	m.mux.RLock()
@@ -165,6 +199,9 @@ func (m *HashMap) Delete(keys []string) int {
 	for _, key := range keys {
 		if _, found := m.m[key]; found {
 			delete(m.m, key)
+			if m.idx != nil {
+				m.idx.Remove(key)
+			}
 			deleted++
 		}
 	}
@@ -194,7 +231,7 @@ func (m *HashMap) IncrementBy(key string, amount int) (int, error) {
 	target, found := m.m[key]
 
 	if !found {
-		m.m[key] = strconv.Itoa(amount)
+		m.setLocked(key, strconv.Itoa(amount))
 		m.mux.Unlock()
 		return amount, nil
 	}
@@ -221,7 +258,7 @@ func (m *HashMap) IncrementByFloat(key string, amount float64) (float64, error)
 	target, found := m.m[key]
 
 	if !found {
-		m.m[key] = strconv.FormatFloat(amount, 'f', 6, 64)
+		m.setLocked(key, strconv.FormatFloat(amount, 'f', -1, 64))
 		m.mux.Unlock()
 		return amount, nil
 	}
@@ -235,7 +272,7 @@ func (m *HashMap) IncrementByFloat(key string, amount float64) (float64, error)
 	}
 
 	newVal := targetVal + amount
-	m.m[key] = strconv.FormatFloat(newVal, 'f', 6, 64)
+	m.m[key] = strconv.FormatFloat(newVal, 'f', -1, 64)
 	m.mux.Unlock()
 	return newVal, nil
 }
@@ -255,3 +292,31 @@ func (m *HashMap) FLen(key string) int {
 	m.mux.RUnlock()
 	return length
 }
+
+// RandomKey returns a random key, found is false when the map is empty
+func (m *HashMap) RandomKey() (key string, found bool) {
+	m.mux.Lock()
+	key, found = m.index().Random()
+	m.mux.Unlock()
+	return
+}
+
+// RandomKeys returns up to count distinct random keys
+func (m *HashMap) RandomKeys(count int) []string {
+	m.mux.Lock()
+	keys := m.index().Sample(count)
+	m.mux.Unlock()
+	return keys
+}
+
+// Scan calls fn for key,value tuples starting from cursor until at least count keys were visited
+// It returns the next cursor, a returned cursor of 0 means the iteration is completed
+// Every key which was in the map during the whole iteration is visited at least once
+func (m *HashMap) Scan(cursor uint64, count int, fn func(key, value string)) uint64 {
+	m.mux.Lock()
+	cursor = m.index().Scan(cursor, count, func(key string) {
+		fn(key, m.m[key])
+	})
+	m.mux.Unlock()
+	return cursor
+}
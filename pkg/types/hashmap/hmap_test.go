@@ -262,3 +262,79 @@ func TestHashMap_Vals(t *testing.T) {
 	assert.Len(vals, 10)
 	assert.ElementsMatch(elements, vals)
 }
+
+func TestHashMap_SetOverwrite(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+
+	hm.Set("mykey", "myval")
+	hm.Set("mykey", "updated")
+	assert.Equal("updated", hm.Get("mykey"))
+}
+
+func TestHashMap_Find(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+
+	hm.Set("mykey", "")
+
+	val, found := hm.Find("mykey")
+	assert.True(found)
+	assert.Equal("", val)
+
+	_, found = hm.Find("unknown")
+	assert.False(found)
+}
+
+func TestHashMap_RandomKeys(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+
+	_, found := hm.RandomKey()
+	assert.False(found)
+
+	hm.SetBulk([]string{"a", "1", "b", "2", "c", "3"})
+	hm.Delete([]string{"c"})
+
+	key, found := hm.RandomKey()
+	assert.True(found)
+	assert.Contains([]string{"a", "b"}, key)
+
+	assert.Len(hm.RandomKeys(1), 1)
+	assert.ElementsMatch([]string{"a", "b"}, hm.RandomKeys(5))
+}
+
+func TestHashMap_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	hm := New()
+
+	for i := 0; i < 100; i++ {
+		hm.Set(strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	hm.IncrementBy("counter", 1)
+
+	// the index is built by the first scan
+	assert.Nil(hm.idx)
+
+	seen := make(map[string]string)
+	cursor := hm.Scan(0, 10, func(key, value string) {
+		seen[key] = value
+	})
+	assert.NotEqual(uint64(0), cursor)
+	assert.Equal(101, hm.idx.Len())
+
+	for cursor != 0 {
+		cursor = hm.Scan(cursor, 10, func(key, value string) {
+			seen[key] = value
+		})
+	}
+
+	assert.Len(seen, 101)
+	assert.Equal("v7", seen["7"])
+	assert.Equal("1", seen["counter"])
+
+	// the index follows later changes
+	hm.Delete([]string{"7"})
+	hm.Set("new", "v")
+	assert.ElementsMatch(hm.Keys(), hm.idx.Keys())
+}
@@ -0,0 +1,312 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package index provides a set of strings which can be iterated with a cursor and sampled randomly
+package index
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+// minBuckets is the minimum number of buckets of an Index
+const minBuckets = 16
+
+// bucketsPerKey limits the buckets visited by Scan for every requested key, so sparse tables do not take too long
+const bucketsPerKey = 10
+
+// rehashEmptyVisits limits the empty buckets a single rehash step skips
+const rehashEmptyVisits = 10
+
+// Index places keys in a power of two sized bucket table which can be iterated with a cursor
+// Cursors are advanced by incrementing the reversed bits of the bucket index, so when the table grows
+// or shrinks between two calls buckets which were already visited are not visited again and every key which
+// was present during the whole iteration is returned at least once
+// Like the dict of Redis the table is resized incrementally, every Add and Remove moves a bucket of the old table
+// to the new one, so resizing a large index never stops its owner for long
+// Index is not thread safe, its owner must guard it
+type Index struct {
+	seed maphash.Seed
+
+	// buckets is the table keys are added to while no resize is in progress
+	buckets [][]string
+
+	// resized is the table keys are moved to during a resize, nil otherwise
+	resized [][]string
+
+	// moved is the number of buckets of buckets which were moved to resized
+	moved int
+
+	count int
+}
+
+// New creates an empty Index
+func New() *Index {
+	return &Index{seed: maphash.MakeSeed(), buckets: make([][]string, minBuckets)}
+}
+
+func (idx *Index) hash(key string) uint64 {
+	return maphash.String(idx.seed, key)
+}
+
+func (idx *Index) resizing() bool {
+	return idx.resized != nil
+}
+
+// bucketOf returns the bucket holding key
+func (idx *Index) bucketOf(key string) *[]string {
+	h := idx.hash(key)
+	if b := h & uint64(len(idx.buckets)-1); !idx.resizing() || int(b) >= idx.moved {
+		return &idx.buckets[b]
+	}
+
+	return &idx.resized[h&uint64(len(idx.resized)-1)]
+}
+
+// Len returns the number of keys in the index
+func (idx *Index) Len() int {
+	return idx.count
+}
+
+// Contains reports whether key is in the index
+func (idx *Index) Contains(key string) bool {
+	for _, k := range *idx.bucketOf(key) {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add inserts a key which is not in the index yet
+func (idx *Index) Add(key string) {
+	idx.rehashStep()
+
+	bucket := idx.bucketOf(key)
+	*bucket = append(*bucket, key)
+	idx.count++
+
+	if !idx.resizing() && idx.count > len(idx.buckets) {
+		idx.startResize(len(idx.buckets) * 2)
+	}
+}
+
+// Remove deletes a key from the index
+func (idx *Index) Remove(key string) {
+	idx.rehashStep()
+
+	bucket := idx.bucketOf(key)
+	for i, k := range *bucket {
+		if k != key {
+			continue
+		}
+
+		last := len(*bucket) - 1
+		(*bucket)[i] = (*bucket)[last]
+		(*bucket)[last] = ""
+		*bucket = (*bucket)[:last]
+		idx.count--
+		break
+	}
+
+	if !idx.resizing() && len(idx.buckets) > minBuckets && idx.count*8 < len(idx.buckets) {
+		idx.startResize(len(idx.buckets) / 2)
+	}
+}
+
+// startResize allocates a table with size buckets, keys are moved to it by rehashStep
+func (idx *Index) startResize(size int) {
+	idx.resized = make([][]string, size)
+	idx.moved = 0
+}
+
+// rehashStep moves the next bucket holding keys to the resized table, it completes the resize once every bucket
+// was moved
+func (idx *Index) rehashStep() {
+	if !idx.resizing() {
+		return
+	}
+
+	mask := uint64(len(idx.resized) - 1)
+	for empty := 0; idx.moved < len(idx.buckets); idx.moved++ {
+		bucket := idx.buckets[idx.moved]
+		if len(bucket) == 0 {
+			if empty++; empty > rehashEmptyVisits {
+				return
+			}
+			continue
+		}
+
+		for _, key := range bucket {
+			b := idx.hash(key) & mask
+			idx.resized[b] = append(idx.resized[b], key)
+		}
+		idx.buckets[idx.moved] = nil
+		idx.moved++
+		break
+	}
+
+	if idx.moved == len(idx.buckets) {
+		idx.buckets, idx.resized, idx.moved = idx.resized, nil, 0
+	}
+}
+
+// Keys returns all keys of the index
+func (idx *Index) Keys() []string {
+	keys := make([]string, 0, idx.count)
+	for _, bucket := range idx.buckets {
+		keys = append(keys, bucket...)
+	}
+	for _, bucket := range idx.resized {
+		keys = append(keys, bucket...)
+	}
+
+	return keys
+}
+
+// nextCursor increments the reversed cursor considering only the bits of mask
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// scanBucket calls fn for every key of the bucket pointed by cursor and returns the next cursor
+// During a resize the bucket of the smaller table is visited along with the buckets of the larger table it expands to
+func (idx *Index) scanBucket(cursor uint64, fn func(key string)) uint64 {
+	if !idx.resizing() {
+		mask := uint64(len(idx.buckets) - 1)
+		for _, key := range idx.buckets[cursor&mask] {
+			fn(key)
+		}
+
+		return nextCursor(cursor, mask)
+	}
+
+	small, large := idx.buckets, idx.resized
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+
+	smallMask, largeMask := uint64(len(small)-1), uint64(len(large)-1)
+	for _, key := range small[cursor&smallMask] {
+		fn(key)
+	}
+
+	// buckets of the larger table share the lower bits of the bucket of the smaller table
+	for {
+		for _, key := range large[cursor&largeMask] {
+			fn(key)
+		}
+
+		cursor = nextCursor(cursor, largeMask)
+		if cursor&(smallMask^largeMask) == 0 {
+			return cursor
+		}
+	}
+}
+
+// Scan calls fn for the keys of the buckets starting from cursor until at least count keys were visited
+// It returns the next cursor, a returned cursor of 0 means the iteration is completed
+func (idx *Index) Scan(cursor uint64, count int, fn func(key string)) uint64 {
+	buckets := math.MaxInt
+	if count <= math.MaxInt/bucketsPerKey {
+		buckets = count * bucketsPerKey
+	}
+
+	visited := 0
+	for ; buckets > 0; buckets-- {
+		cursor = idx.scanBucket(cursor, func(key string) {
+			visited++
+			fn(key)
+		})
+
+		if cursor == 0 || visited >= count {
+			break
+		}
+	}
+
+	return cursor
+}
+
+// Random returns a random key, found is false when the index is empty
+// Keys sharing a bucket with others are picked slightly less often
+func (idx *Index) Random() (key string, found bool) {
+	if idx.count == 0 {
+		return "", false
+	}
+
+	// the table shrinks when it is less than 1/8 full, so an occupied bucket is found after a few tries
+	// buckets which were moved during a resize are empty and not picked
+	for {
+		i := idx.moved + rand.Intn(len(idx.buckets)+len(idx.resized)-idx.moved)
+		bucket := idx.resized
+		if i < len(idx.buckets) {
+			bucket = idx.buckets
+		} else {
+			i -= len(idx.buckets)
+		}
+
+		if len(bucket[i]) > 0 {
+			return bucket[i][rand.Intn(len(bucket[i]))], true
+		}
+	}
+}
+
+// Sample returns up to count distinct random keys
+func (idx *Index) Sample(count int) []string {
+	if count >= idx.count {
+		return idx.Keys()
+	}
+
+	// shuffling the keys is cheaper than drawing random keys until most of them are found
+	if count*3 > idx.count {
+		keys := idx.Keys()
+		for i := 0; i < count; i++ {
+			j := i + rand.Intn(len(keys)-i)
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+
+		return keys[:count]
+	}
+
+	seen := make(map[string]struct{}, count)
+	keys := make([]string, 0, count)
+	for len(keys) < count {
+		key, _ := idx.Random()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
@@ -0,0 +1,277 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package index
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func newTestIndex(n int) *Index {
+	idx := New()
+	for i := 0; i < n; i++ {
+		idx.Add(strconv.Itoa(i))
+	}
+
+	return idx
+}
+
+func TestIndex_AddRemove(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(100)
+
+	assert.Equal(100, idx.Len())
+	assert.Len(idx.Keys(), 100)
+
+	for i := 0; i < 90; i++ {
+		idx.Remove(strconv.Itoa(i))
+	}
+
+	assert.Equal(10, idx.Len())
+	assert.ElementsMatch([]string{"90", "91", "92", "93", "94", "95", "96", "97", "98", "99"}, idx.Keys())
+
+	// the table shrinks with the keys
+	assert.True(idx.tableSize() < 128)
+}
+
+// tableSize returns the size of the table the index is resizing to or its current size
+func (idx *Index) tableSize() int {
+	if idx.resizing() {
+		return len(idx.resized)
+	}
+
+	return len(idx.buckets)
+}
+
+func TestIndex_IncrementalResize(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := New()
+
+	n := 0
+	for ; !idx.resizing(); n++ {
+		idx.Add(strconv.Itoa(n))
+	}
+	size := len(idx.buckets)
+
+	// a single step does not move the whole table
+	idx.Add(strconv.Itoa(n))
+	n++
+	assert.True(idx.resizing())
+	assert.True(idx.moved <= rehashEmptyVisits+1)
+
+	for i := 0; i < n; i++ {
+		assert.True(idx.Contains(strconv.Itoa(i)))
+	}
+	assert.Len(unique(idx.Keys()), n)
+
+	for idx.resizing() {
+		idx.Add(strconv.Itoa(n))
+		n++
+	}
+
+	assert.Equal(size*2, len(idx.buckets))
+	assert.Equal(n, idx.Len())
+	assert.Len(unique(idx.Keys()), n)
+}
+
+func TestIndex_ScanWhileResizing(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	for _, grow := range []bool{true, false} {
+		idx, next := newTestIndex(1000), 1000
+		if !grow {
+			// the table shrinks once a few more keys are removed
+			for ; next > 130; next-- {
+				idx.Remove(strconv.Itoa(next - 1))
+			}
+		}
+
+		for idx.resizing() {
+			idx.Add("filler")
+			idx.Remove("filler")
+		}
+
+		seen := make(map[string]int)
+		cursor, resized := uint64(0), false
+		for {
+			cursor = idx.Scan(cursor, 5, func(key string) {
+				seen[key]++
+			})
+			resized = resized || idx.resizing()
+
+			// keep the index resizing while the iteration runs
+			for i := 0; i < 3; i++ {
+				if grow {
+					idx.Add(strconv.Itoa(next))
+					next++
+				} else if next > 0 {
+					next--
+					if next >= 100 {
+						idx.Remove(strconv.Itoa(next))
+					}
+				}
+			}
+
+			if cursor == 0 {
+				break
+			}
+		}
+
+		// keys present during the whole iteration are returned
+		kept := 100
+		if grow {
+			kept = 1000
+		}
+
+		assert.True(resized)
+
+		for i := 0; i < kept; i++ {
+			assert.Contains(seen, strconv.Itoa(i))
+		}
+	}
+}
+
+func unique(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+
+	return set
+}
+
+func TestIndex_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(1000)
+
+	seen := make(map[string]int)
+	cursor, calls := uint64(0), 0
+	for {
+		cursor = idx.Scan(cursor, 10, func(key string) {
+			seen[key]++
+		})
+		calls++
+
+		// keys added during the iteration must not hide the ones present from the start
+		idx.Add("new" + strconv.Itoa(calls))
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.True(calls > 1)
+	for i := 0; i < 1000; i++ {
+		assert.Contains(seen, strconv.Itoa(i))
+	}
+}
+
+func TestIndex_ScanShrink(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(1000)
+
+	seen := make(map[string]bool)
+	cursor := idx.Scan(0, 100, func(key string) {
+		seen[key] = true
+	})
+
+	// removing keys shrinks the table during the iteration
+	for i := 100; i < 1000; i++ {
+		idx.Remove(strconv.Itoa(i))
+	}
+
+	for cursor != 0 {
+		cursor = idx.Scan(cursor, 100, func(key string) {
+			seen[key] = true
+		})
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.True(seen[strconv.Itoa(i)])
+	}
+}
+
+func TestIndex_ScanLargeCount(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(100)
+
+	n := 0
+	cursor := idx.Scan(0, math.MaxInt, func(key string) {
+		n++
+	})
+
+	assert.Equal(uint64(0), cursor)
+	assert.Equal(100, n)
+}
+
+func TestIndex_Random(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := New()
+
+	_, found := idx.Random()
+	assert.False(found)
+
+	idx.Add("a")
+	idx.Add("b")
+	for i := 0; i < 10; i++ {
+		key, found := idx.Random()
+		assert.True(found)
+		assert.Contains([]string{"a", "b"}, key)
+	}
+}
+
+func TestIndex_Sample(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(1000)
+
+	for _, count := range []int{0, 1, 10, 500, 999, 1000, 5000} {
+		keys := idx.Sample(count)
+		assert.Len(keys, min(count, 1000))
+
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			assert.False(seen[key], "duplicate key %s", key)
+			seen[key] = true
+		}
+	}
+
+	// sampling does not modify the index
+	assert.Equal(1000, idx.Len())
+}
+
+func TestIndex_Contains(t *testing.T) {
+	assert := testifyAssert.New(t)
+	idx := newTestIndex(10)
+
+	assert.True(idx.Contains("3"))
+	assert.False(idx.Contains("10"))
+
+	idx.Remove("3")
+	assert.False(idx.Contains("3"))
+}
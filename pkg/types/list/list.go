@@ -247,3 +247,130 @@ func (list *TList) Trim(start, stop int) {
 		list.TPop()
 	}
 }
+
+// Index returns the value at given position, negative positions are counted from the tail
+func (list *TList) Index(pos int) (string, bool) {
+	list.mux.RLock()
+
+	pos = list.convertPos(pos)
+	if pos < 0 || pos > list.Len()-1 {
+		list.mux.RUnlock()
+		return "", false
+	}
+
+	str := util.ToString(list.findAtIndex(pos).Value)
+	list.mux.RUnlock()
+	return str, true
+}
+
+// Set replaces the value at given position, it will return false when position is out of range
+func (list *TList) Set(pos int, val string) bool {
+	list.mux.Lock()
+
+	pos = list.convertPos(pos)
+	if pos < 0 || pos > list.Len()-1 {
+		list.mux.Unlock()
+		return false
+	}
+
+	list.findAtIndex(pos).Value = val
+	list.mux.Unlock()
+	return true
+}
+
+// Insert inserts val before or after the first occurrence of pivot
+// It returns the new length of the list or -1 when pivot was not found
+func (list *TList) Insert(before bool, pivot, val string) int {
+	list.mux.Lock()
+
+	for e := list.Head(); e != nil; e = e.Next() {
+		if util.ToString(e.Value) != pivot {
+			continue
+		}
+
+		if before {
+			list.list.InsertBefore(val, e)
+		} else {
+			list.list.InsertAfter(val, e)
+		}
+
+		length := list.Len()
+		list.mux.Unlock()
+		return length
+	}
+
+	list.mux.Unlock()
+	return -1
+}
+
+// Remove removes occurrences of val from the list
+// When count > 0 it removes count elements moving from head to tail, when count < 0 it removes from tail to head
+// and when count is 0 all matching elements are removed. It returns the number of removed elements
+func (list *TList) Remove(count int, val string) int {
+	list.mux.Lock()
+
+	removed := 0
+	if count >= 0 {
+		for e := list.Head(); e != nil && (count == 0 || removed < count); {
+			next := e.Next()
+			if util.ToString(e.Value) == val {
+				list.list.Remove(e)
+				removed++
+			}
+			e = next
+		}
+	} else {
+		for e := list.Tail(); e != nil && removed < -count; {
+			prev := e.Prev()
+			if util.ToString(e.Value) == val {
+				list.list.Remove(e)
+				removed++
+			}
+			e = prev
+		}
+	}
+
+	list.mux.Unlock()
+	return removed
+}
+
+// Positions returns the indexes of the elements matching val
+// rank selects the first match to return, negative ranks search from the tail. count limits the number of matches
+// where 0 means all matches and maxLen limits the number of compared elements where 0 means the whole list
+func (list *TList) Positions(val string, rank, count, maxLen int) []int {
+	list.mux.RLock()
+
+	res := make([]int, 0)
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+
+	length := list.Len()
+	e, i := list.Head(), 0
+	if rank < 0 {
+		e, i = list.Tail(), length-1
+	}
+
+	for compared := 0; e != nil && (maxLen == 0 || compared < maxLen); compared++ {
+		if util.ToString(e.Value) == val {
+			if skip > 0 {
+				skip--
+			} else {
+				res = append(res, i)
+				if count != 0 && len(res) == count {
+					break
+				}
+			}
+		}
+
+		if rank > 0 {
+			e, i = e.Next(), i+1
+		} else {
+			e, i = e.Prev(), i-1
+		}
+	}
+
+	list.mux.RUnlock()
+	return res
+}
@@ -443,3 +443,79 @@ func TestTList_TrimHead(t *testing.T) {
 	assert.Equal(8, l.Len())
 	assert.Equal([]string{"7", "6", "5", "4", "3", "2", "1", "0"}, l.Range(0, -1))
 }
+
+func TestTList_Index(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+
+	l.TPush([]string{"a", "b", "c"})
+
+	val, ok := l.Index(0)
+	assert.True(ok)
+	assert.Equal("a", val)
+
+	val, ok = l.Index(-1)
+	assert.True(ok)
+	assert.Equal("c", val)
+
+	_, ok = l.Index(3)
+	assert.False(ok)
+
+	_, ok = l.Index(-4)
+	assert.False(ok)
+}
+
+func TestTList_Set(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+
+	l.TPush([]string{"a", "b", "c"})
+
+	assert.True(l.Set(1, "x"))
+	assert.True(l.Set(-1, "y"))
+	assert.False(l.Set(10, "z"))
+	assert.Equal([]string{"a", "x", "y"}, l.Range(0, -1))
+}
+
+func TestTList_Insert(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+
+	l.TPush([]string{"a", "c"})
+
+	assert.Equal(3, l.Insert(true, "c", "b"))
+	assert.Equal(4, l.Insert(false, "c", "d"))
+	assert.Equal(-1, l.Insert(false, "z", "e"))
+	assert.Equal([]string{"a", "b", "c", "d"}, l.Range(0, -1))
+}
+
+func TestTList_Remove(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+
+	l.TPush([]string{"a", "b", "a", "c", "a"})
+	assert.Equal(1, l.Remove(1, "a"))
+	assert.Equal([]string{"b", "a", "c", "a"}, l.Range(0, -1))
+
+	assert.Equal(1, l.Remove(-1, "a"))
+	assert.Equal([]string{"b", "a", "c"}, l.Range(0, -1))
+
+	l.TPush([]string{"a", "a"})
+	assert.Equal(3, l.Remove(0, "a"))
+	assert.Equal([]string{"b", "c"}, l.Range(0, -1))
+}
+
+func TestTList_Positions(t *testing.T) {
+	assert := testifyAssert.New(t)
+	l := New()
+
+	l.TPush([]string{"a", "b", "c", "1", "2", "3", "c", "c"})
+
+	assert.Equal([]int{2}, l.Positions("c", 1, 1, 0))
+	assert.Equal([]int{6}, l.Positions("c", 2, 1, 0))
+	assert.Equal([]int{7}, l.Positions("c", -1, 1, 0))
+	assert.Equal([]int{2, 6, 7}, l.Positions("c", 1, 0, 0))
+	assert.Equal([]int{7, 6}, l.Positions("c", -1, 2, 0))
+	assert.Equal([]int{}, l.Positions("c", 1, 0, 2))
+	assert.Equal([]int{}, l.Positions("z", 1, 0, 0))
+}
@@ -26,17 +26,39 @@ package set
 
 import (
 	"sync"
+
+	"github.com/kasvith/kache/pkg/types/index"
 )
 
 // Set is a string set data structure implemented with a hashmap
+// An index of the members allows iterating with a cursor and picking random members, it is built the first time
+// it is needed
 type Set struct {
 	m   map[string]int
+	idx *index.Index
 	mux *sync.RWMutex
 }
 
 // New creates a new Set
 func New() *Set {
-	return &Set{m: make(map[string]int), mux: &sync.RWMutex{}}
+	return newSet(make(map[string]int))
+}
+
+// newSet creates a Set holding the keys of m
+func newSet(m map[string]int) *Set {
+	return &Set{m: m, mux: &sync.RWMutex{}}
+}
+
+// index returns the index of the members building it on first use, caller must hold the write lock
+func (set *Set) index() *index.Index {
+	if set.idx == nil {
+		set.idx = index.New()
+		for key := range set.m {
+			set.idx.Add(key)
+		}
+	}
+
+	return set.idx
 }
 
 // NewFromSlice creates a new set from a string slice
@@ -46,7 +68,23 @@ func NewFromSlice(data []string) *Set {
 		m[value] = 1
 	}
 
-	return &Set{m: m, mux: &sync.RWMutex{}}
+	return newSet(m)
+}
+
+// addLocked adds a key which is not in the set, caller must hold the write lock
+func (set *Set) addLocked(key string) {
+	set.m[key] = 1
+	if set.idx != nil {
+		set.idx.Add(key)
+	}
+}
+
+// deleteLocked removes a key which is in the set, caller must hold the write lock
+func (set *Set) deleteLocked(key string) {
+	delete(set.m, key)
+	if set.idx != nil {
+		set.idx.Remove(key)
+	}
 }
 
 // getMap gets a copy of underlying map from Set
@@ -71,7 +109,7 @@ func (set *Set) Add(keys []string) int {
 
 	for _, key := range keys {
 		if _, found := set.m[key]; !found {
-			set.m[key] = 1
+			set.addLocked(key)
 			added++
 		}
 	}
@@ -144,7 +182,7 @@ func (set *Set) DiffS(sets []Set) *Set {
 		}
 	}
 
-	return &Set{m: dup, mux: &sync.RWMutex{}}
+	return newSet(dup)
 }
 
 // Exists find a key is in set
@@ -205,9 +243,11 @@ func Move(key string, src, dest *Set) int {
 	src.mux.Lock()
 
 	if _, found := src.m[key]; found {
-		delete(src.m, key)
+		src.deleteLocked(key)
 		dest.mux.Lock()
-		dest.m[key] = 1
+		if _, found := dest.m[key]; !found {
+			dest.addLocked(key)
+		}
 		dest.mux.Unlock()
 		src.mux.Unlock()
 		return 1
@@ -224,7 +264,7 @@ func (set *Set) Delete(keys []string) int {
 	deleted := 0
 	for _, key := range keys {
 		if _, ok := set.m[key]; ok {
-			delete(set.m, key)
+			set.deleteLocked(key)
 			deleted++
 		}
 	}
@@ -272,7 +312,60 @@ func UnionS(sets []Set) *Set {
 		}
 	}
 
-	return &Set{m: m, mux: &sync.RWMutex{}}
+	return newSet(m)
+}
+
+// Pop removes and returns up to count random elements from the set
+func (set *Set) Pop(count int) []string {
+	set.mux.Lock()
+
+	res := set.index().Sample(count)
+	for _, key := range res {
+		set.deleteLocked(key)
+	}
+
+	set.mux.Unlock()
+	return res
+}
+
+// RandomMember returns a random element without removing it, found is false when the set is empty
+func (set *Set) RandomMember() (member string, found bool) {
+	set.mux.Lock()
+	member, found = set.index().Random()
+	set.mux.Unlock()
+	return
+}
+
+// Random returns random elements from the set without removing them
+// When count is positive distinct elements are returned, up to the size of the set
+// When count is negative the same element may be returned multiple times and exactly -count elements are returned
+func (set *Set) Random(count int) []string {
+	set.mux.Lock()
+	defer set.mux.Unlock()
+
+	if count >= 0 {
+		return set.index().Sample(count)
+	}
+
+	res := []string{}
+	if len(set.m) == 0 {
+		return res
+	}
+
+	for i := 0; i > count; i-- {
+		member, _ := set.index().Random()
+		res = append(res, member)
+	}
+
+	return res
 }
 
-// TODO implement pop and randomelement
+// Scan calls fn for elements starting from cursor until at least count elements were visited
+// It returns the next cursor, a returned cursor of 0 means the iteration is completed
+// Every element which was in the set during the whole iteration is visited at least once
+func (set *Set) Scan(cursor uint64, count int, fn func(member string)) uint64 {
+	set.mux.Lock()
+	cursor = set.index().Scan(cursor, count, fn)
+	set.mux.Unlock()
+	return cursor
+}
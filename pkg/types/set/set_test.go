@@ -25,6 +25,7 @@
 package set
 
 import (
+	"strconv"
 	"testing"
 
 	testifyAssert "github.com/stretchr/testify/assert"
@@ -192,3 +193,118 @@ func TestUnionS(t *testing.T) {
 	union := UnionS([]Set{*set1, *set2, *set3})
 	assert.ElementsMatch([]string{"a", "b", "c", "d", "g", "f"}, union.Elems())
 }
+
+func TestSet_Pop(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := New()
+
+	set.Add([]string{"a", "b", "c"})
+
+	res := set.Pop(2)
+	assert.Len(res, 2)
+	assert.Equal(1, set.Card())
+	for _, v := range res {
+		assert.Equal(0, set.Exists(v))
+	}
+
+	res = set.Pop(10)
+	assert.Len(res, 1)
+	assert.Equal(0, set.Card())
+}
+
+func TestSet_Random(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := New()
+
+	assert.Len(set.Random(5), 0)
+
+	set.Add([]string{"a", "b", "c"})
+
+	res := set.Random(2)
+	assert.Len(res, 2)
+	assert.NotEqual(res[0], res[1])
+	assert.Equal(3, set.Card())
+
+	assert.ElementsMatch([]string{"a", "b", "c"}, set.Random(10))
+
+	res = set.Random(-10)
+	assert.Len(res, 10)
+	for _, v := range res {
+		assert.Equal(1, set.Exists(v))
+	}
+}
+
+func TestSet_PopDrain(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := New()
+
+	members := make([]string, 10000)
+	for i := range members {
+		members[i] = strconv.Itoa(i)
+	}
+	set.Add(members)
+
+	// popping one member at a time does not copy the whole set every time
+	popped := make(map[string]bool)
+	for set.Card() > 0 {
+		res := set.Pop(1)
+		assert.Len(res, 1)
+		assert.False(popped[res[0]])
+		popped[res[0]] = true
+	}
+
+	assert.Len(popped, 10000)
+	assert.Len(set.Pop(1), 0)
+}
+
+func TestSet_RandomMember(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := New()
+
+	_, found := set.RandomMember()
+	assert.False(found)
+
+	set.Add([]string{"a", "b"})
+	Move("b", set, New())
+
+	member, found := set.RandomMember()
+	assert.True(found)
+	assert.Equal("a", member)
+}
+
+func TestSet_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	set := New()
+
+	members := make([]string, 100)
+	for i := range members {
+		members[i] = strconv.Itoa(i)
+	}
+	set.Add(members)
+	set.Delete([]string{"0"})
+
+	// the index is built by the first scan
+	assert.Nil(set.idx)
+
+	seen := make(map[string]bool)
+	cursor := set.Scan(0, 10, func(member string) {
+		seen[member] = true
+	})
+	assert.NotEqual(uint64(0), cursor)
+	assert.Equal(99, set.idx.Len())
+
+	for cursor != 0 {
+		cursor = set.Scan(cursor, 10, func(member string) {
+			seen[member] = true
+		})
+	}
+
+	assert.Len(seen, 99)
+	assert.False(seen["0"])
+
+	// the index follows later changes
+	set.Delete([]string{"1"})
+	set.Add([]string{"new"})
+	set.Pop(5)
+	assert.ElementsMatch(set.Elems(), set.idx.Keys())
+}
@@ -0,0 +1,700 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package stream
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	// ErrNoGroup is returned when a consumer group does not exist
+	ErrNoGroup = errors.New("no such consumer group")
+
+	// ErrGroupExists is returned when a consumer group is created with the name of an existing one
+	ErrGroupExists = errors.New("consumer group name already exists")
+)
+
+// group is a consumer group which delivers every entry to one of its consumers
+type group struct {
+	name   string
+	lastID ID
+
+	// entriesRead is the number of entries delivered to the group, -1 when it is not known
+	entriesRead int64
+
+	// pel holds the entries delivered to consumers and not acknowledged yet
+	pel       *rax
+	consumers map[string]*consumer
+}
+
+// consumer is a member of a consumer group
+type consumer struct {
+	name       string
+	seenTime   int64
+	activeTime int64
+	pel        *rax
+}
+
+// pending is an entry delivered to a consumer which is not acknowledged yet
+type pending struct {
+	id            ID
+	consumer      *consumer
+	deliveryTime  int64
+	deliveryCount int64
+}
+
+// PendingEntry describes an entry delivered to a consumer which is not acknowledged yet
+type PendingEntry struct {
+	ID            ID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount int64
+}
+
+// ConsumerInfo describes a consumer of a group
+type ConsumerInfo struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	Pending    int
+}
+
+// GroupInfo describes a consumer group, PEL is only filled when the full details are requested
+type GroupInfo struct {
+	Name        string
+	LastID      ID
+	EntriesRead int64
+
+	// Lag is the number of entries not delivered to the group yet, -1 when it is not known
+	Lag int64
+
+	Pending   int
+	Consumers []ConsumerInfo
+	PEL       []PendingEntry
+}
+
+// PendingSummary summarizes the pending entries of a group
+type PendingSummary struct {
+	Count    int
+	Min, Max ID
+
+	// Consumers holds the number of pending entries of consumers which have any
+	Consumers []ConsumerInfo
+}
+
+// ClaimOptions changes how entries are claimed
+type ClaimOptions struct {
+	// DeliveryTime is set as the delivery time of claimed entries, 0 uses the current time
+	DeliveryTime int64
+
+	// RetryCount is set as the delivery count of claimed entries when it is >= 0
+	RetryCount int64
+
+	// Force creates pending entries for IDs of the stream which are not delivered to any consumer
+	Force bool
+
+	// JustID does not increment the delivery count
+	JustID bool
+
+	// LastID is set as the last ID of the group when it is greater
+	LastID *ID
+}
+
+func (s *Stream) group(name string) (*group, error) {
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+
+	return g, nil
+}
+
+// consumer returns the consumer of a group with name, it is created when it does not exist
+func (g *group) consumer(name string, now int64) *consumer {
+	c, ok := g.consumers[name]
+	if !ok {
+		c = &consumer{name: name, seenTime: now, activeTime: -1, pel: newRax()}
+		g.consumers[name] = c
+	}
+
+	return c
+}
+
+// assign makes c the owner of a pending entry
+func (p *pending) assign(c *consumer) {
+	if p.consumer == c {
+		return
+	}
+
+	if p.consumer != nil {
+		p.consumer.pel.remove(p.id.key())
+	}
+
+	p.consumer = c
+	c.pel.insert(p.id.key(), p)
+}
+
+// removePending removes a pending entry from the group and its consumer
+func (g *group) removePending(p *pending) {
+	g.pel.remove(p.id.key())
+	if p.consumer != nil {
+		p.consumer.pel.remove(p.id.key())
+	}
+}
+
+// hasTombstones reports whether entries with IDs greater than or equal to start were deleted
+func (s *Stream) hasTombstones(start ID) bool {
+	if s.length == 0 || s.maxDeletedID == MinID {
+		return false
+	}
+
+	return !s.maxDeletedID.Less(s.firstID) && !s.maxDeletedID.Less(start)
+}
+
+// estimateEntriesRead estimates the number of entries added up to id, -1 when it can not be known
+func (s *Stream) estimateEntriesRead(id ID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	switch c := id.Compare(s.lastID); {
+	case s.length == 0 && c <= 0, c == 0:
+		return int64(s.entriesAdded)
+	case c > 0:
+		return -1
+	}
+
+	// without deleted entries in the middle the distance from the first entry is known
+	if s.maxDeletedID == MinID || s.maxDeletedID.Less(s.firstID) {
+		switch c := id.Compare(s.firstID); {
+		case c < 0:
+			return int64(s.entriesAdded) - int64(s.length)
+		case c == 0:
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+
+	return -1
+}
+
+// lag returns the number of entries not delivered to the group yet, -1 when it can not be known
+func (s *Stream) lag(g *group) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+
+	if g.entriesRead >= 0 && !s.hasTombstones(g.lastID) {
+		return int64(s.entriesAdded) - g.entriesRead
+	}
+
+	if read := s.estimateEntriesRead(g.lastID); read >= 0 {
+		return int64(s.entriesAdded) - read
+	}
+
+	return -1
+}
+
+// CreateGroup creates a consumer group delivering entries after id
+// entriesRead is the number of entries already read by the group, -1 when it is not known
+func (s *Stream) CreateGroup(name string, id ID, entriesRead int64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.groups[name]; ok {
+		return ErrGroupExists
+	}
+
+	s.groups[name] = &group{name: name, lastID: id, entriesRead: entriesRead, pel: newRax(), consumers: make(map[string]*consumer)}
+	return nil
+}
+
+// DestroyGroup removes a consumer group with its consumers and pending entries
+func (s *Stream) DestroyGroup(name string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+
+	delete(s.groups, name)
+	return true
+}
+
+// SetGroupID changes the last ID delivered to a group
+func (s *Stream) SetGroupID(name string, id ID, entriesRead int64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(name)
+	if err != nil {
+		return err
+	}
+
+	g.lastID, g.entriesRead = id, entriesRead
+	return nil
+}
+
+// CreateConsumer creates a consumer in a group, created is false when it already exists
+func (s *Stream) CreateConsumer(groupName, name string, now int64) (created bool, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := g.consumers[name]; ok {
+		return false, nil
+	}
+
+	g.consumer(name, now)
+	return true, nil
+}
+
+// DeleteConsumer removes a consumer and its pending entries from a group, it returns the number of pending entries
+// the consumer had or -1 when it does not exist
+func (s *Stream) DeleteConsumer(groupName, name string) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return 0, err
+	}
+
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1, nil
+	}
+
+	n := c.pel.size
+	c.pel.ascend(nil, func(_ []byte, v interface{}) bool {
+		g.pel.remove(v.(*pending).id.key())
+		return true
+	})
+
+	delete(g.consumers, name)
+	return n, nil
+}
+
+// ReadGroup delivers entries to a consumer of a group, the consumer is created when it does not exist
+// When history is false up to count entries never delivered to the group are returned and added to the pending
+// entries of the consumer unless noAck is set. Otherwise the pending entries of the consumer with IDs greater than
+// or equal to start are returned, entries deleted meanwhile are returned without fields
+func (s *Stream) ReadGroup(groupName, consumerName string, start ID, history bool, count int, noAck bool, now int64) ([]Entry, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := g.consumer(consumerName, now)
+	c.seenTime = now
+
+	if history {
+		entries := make([]Entry, 0)
+		c.pel.ascend(start.key(), func(_ []byte, v interface{}) bool {
+			p := v.(*pending)
+			entry, ok := s.lookup(p.id)
+			if !ok {
+				entry = Entry{ID: p.id}
+			} else {
+				p.deliveryTime = now
+				p.deliveryCount++
+			}
+
+			entries = append(entries, entry)
+			return count <= 0 || len(entries) < count
+		})
+
+		return entries, nil
+	}
+
+	next, ok := g.lastID.Next()
+	if !ok {
+		return make([]Entry, 0), nil
+	}
+
+	entries := s.rangeLocked(next, MaxID, count, false)
+	for _, entry := range entries {
+		if g.entriesRead >= 0 && !s.hasTombstones(entry.ID) {
+			g.entriesRead++
+		} else {
+			g.entriesRead = s.estimateEntriesRead(entry.ID)
+		}
+		g.lastID = entry.ID
+
+		if noAck {
+			continue
+		}
+
+		// the entry might be pending already when the last ID of the group was moved back
+		p, found := g.pel.find(entry.ID.key())
+		if !found {
+			p = &pending{id: entry.ID}
+			g.pel.insert(entry.ID.key(), p)
+		}
+
+		p.(*pending).assign(c)
+		p.(*pending).deliveryTime, p.(*pending).deliveryCount = now, 1
+	}
+
+	if len(entries) > 0 {
+		c.activeTime = now
+	}
+
+	return entries, nil
+}
+
+// Ack removes entries from the pending entries of a group and returns the number of removed entries
+func (s *Stream) Ack(groupName string, ids []ID) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return 0, err
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if p, ok := g.pel.find(id.key()); ok {
+			g.removePending(p.(*pending))
+			acked++
+		}
+	}
+
+	return acked, nil
+}
+
+// Pending summarizes the pending entries of a group
+func (s *Stream) Pending(groupName string) (PendingSummary, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return PendingSummary{}, err
+	}
+
+	summary := PendingSummary{Count: g.pel.size, Consumers: make([]ConsumerInfo, 0)}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+
+	g.pel.ascend(nil, func(k []byte, _ interface{}) bool {
+		summary.Min = idFromKey(k)
+		return false
+	})
+	g.pel.descend(nil, func(k []byte, _ interface{}) bool {
+		summary.Max = idFromKey(k)
+		return false
+	})
+
+	for _, c := range g.sortedConsumers() {
+		if c.pel.size > 0 {
+			summary.Consumers = append(summary.Consumers, c.info())
+		}
+	}
+
+	return summary, nil
+}
+
+// PendingRange returns up to count pending entries of a group with IDs between start and end which were delivered
+// at least minIdle milliseconds before now, only entries of consumerName are returned when it is not empty
+func (s *Stream) PendingRange(groupName string, start, end ID, count int, consumerName string, minIdle, now int64) ([]PendingEntry, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PendingEntry, 0)
+	pel := g.pel
+	if consumerName != "" {
+		c, ok := g.consumers[consumerName]
+		if !ok {
+			return entries, nil
+		}
+		pel = c.pel
+	}
+
+	if count <= 0 || end.Less(start) {
+		return entries, nil
+	}
+
+	pel.ascend(start.key(), func(_ []byte, v interface{}) bool {
+		p := v.(*pending)
+		if end.Less(p.id) {
+			return false
+		}
+
+		if now-p.deliveryTime >= minIdle {
+			entries = append(entries, p.info())
+		}
+
+		return len(entries) < count
+	})
+
+	return entries, nil
+}
+
+// Claim changes the owner of pending entries which were delivered at least minIdle milliseconds before now
+// It returns the claimed entries and the IDs of pending entries which were removed since they were deleted from
+// the stream. Entries are returned without fields when JustID is set
+func (s *Stream) Claim(groupName, consumerName string, minIdle int64, ids []ID, opts ClaimOptions, now int64) ([]Entry, []ID, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.LastID != nil && g.lastID.Less(*opts.LastID) {
+		g.lastID = *opts.LastID
+	}
+
+	c := g.consumer(consumerName, now)
+	c.seenTime = now
+
+	claimed := make([]Entry, 0)
+	var deleted []ID
+	for _, id := range ids {
+		entry, exists := s.lookup(id)
+
+		v, found := g.pel.find(id.key())
+		if !found {
+			if !opts.Force || !exists {
+				continue
+			}
+
+			v = &pending{id: id, deliveryTime: now}
+			g.pel.insert(id.key(), v)
+		}
+
+		p := v.(*pending)
+		if !exists {
+			g.removePending(p)
+			deleted = append(deleted, id)
+			continue
+		}
+
+		if found && minIdle > 0 && now-p.deliveryTime < minIdle {
+			continue
+		}
+
+		s.claim(p, c, opts, now)
+		if opts.JustID {
+			entry.Fields = nil
+		}
+		claimed = append(claimed, entry)
+	}
+
+	if len(claimed) > 0 {
+		c.activeTime = now
+	}
+
+	return claimed, deleted, nil
+}
+
+func (s *Stream) claim(p *pending, c *consumer, opts ClaimOptions, now int64) {
+	p.assign(c)
+
+	p.deliveryTime = now
+	if opts.DeliveryTime != 0 {
+		p.deliveryTime = opts.DeliveryTime
+	}
+
+	switch {
+	case opts.RetryCount >= 0:
+		p.deliveryCount = opts.RetryCount
+	case !opts.JustID:
+		p.deliveryCount++
+	}
+}
+
+// AutoClaim claims up to count pending entries with IDs greater than or equal to start which were delivered at
+// least minIdle milliseconds before now. It returns the ID to continue from, MinID when the whole group was scanned,
+// the claimed entries and the IDs of pending entries which were removed since they were deleted from the stream
+func (s *Stream) AutoClaim(groupName, consumerName string, minIdle int64, start ID, count int, justID bool, now int64) (ID, []Entry, []ID, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return ID{}, nil, nil, err
+	}
+
+	c := g.consumer(consumerName, now)
+	c.seenTime = now
+
+	// visiting deleted entries is bounded too so a single call never scans the whole group
+	attempts := count * 10
+	claimed := make([]Entry, 0)
+	deleted := make([]ID, 0)
+	var visit []*pending
+
+	next, claimable := MinID, 0
+	g.pel.ascend(start.key(), func(_ []byte, v interface{}) bool {
+		p := v.(*pending)
+		if attempts == 0 || claimable == count {
+			next = p.id
+			return false
+		}
+		attempts--
+
+		if _, exists := s.lookup(p.id); !exists {
+			visit = append(visit, p)
+		} else if now-p.deliveryTime >= minIdle {
+			visit = append(visit, p)
+			claimable++
+		}
+		return true
+	})
+
+	for _, p := range visit {
+		entry, exists := s.lookup(p.id)
+		if !exists {
+			g.removePending(p)
+			deleted = append(deleted, p.id)
+			continue
+		}
+
+		s.claim(p, c, ClaimOptions{RetryCount: -1, JustID: justID}, now)
+		if justID {
+			entry.Fields = nil
+		}
+		claimed = append(claimed, entry)
+	}
+
+	if len(claimed) > 0 {
+		c.activeTime = now
+	}
+
+	return next, claimed, deleted, nil
+}
+
+func (c *consumer) info() ConsumerInfo {
+	return ConsumerInfo{Name: c.name, SeenTime: c.seenTime, ActiveTime: c.activeTime, Pending: c.pel.size}
+}
+
+func (p *pending) info() PendingEntry {
+	return PendingEntry{ID: p.id, Consumer: p.consumer.name, DeliveryTime: p.deliveryTime, DeliveryCount: p.deliveryCount}
+}
+
+func (g *group) sortedConsumers() []*consumer {
+	consumers := make([]*consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].name < consumers[j].name })
+	return consumers
+}
+
+// Groups returns the consumer groups ordered by name, pending entries are included when full is set
+func (s *Stream) Groups(full bool) []GroupInfo {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	groups := make([]GroupInfo, 0, len(names))
+	for _, name := range names {
+		g := s.groups[name]
+		info := GroupInfo{Name: name, LastID: g.lastID, EntriesRead: g.entriesRead, Lag: s.lag(g), Pending: g.pel.size}
+
+		for _, c := range g.sortedConsumers() {
+			info.Consumers = append(info.Consumers, c.info())
+		}
+
+		if full {
+			g.pel.ascend(nil, func(_ []byte, v interface{}) bool {
+				info.PEL = append(info.PEL, v.(*pending).info())
+				return true
+			})
+		}
+
+		groups = append(groups, info)
+	}
+
+	return groups
+}
+
+// Consumers returns the consumers of a group ordered by name
+func (s *Stream) Consumers(groupName string) ([]ConsumerInfo, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	g, err := s.group(groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	consumers := make([]ConsumerInfo, 0, len(g.consumers))
+	for _, c := range g.sortedConsumers() {
+		consumers = append(consumers, c.info())
+	}
+
+	return consumers, nil
+}
+
+// RestoreGroup recreates a consumer group described by Groups with its consumers and pending entries
+func (s *Stream) RestoreGroup(info GroupInfo) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.groups[info.Name]; ok {
+		return ErrGroupExists
+	}
+
+	g := &group{name: info.Name, lastID: info.LastID, entriesRead: info.EntriesRead, pel: newRax(), consumers: make(map[string]*consumer)}
+	for _, ci := range info.Consumers {
+		c := g.consumer(ci.Name, ci.SeenTime)
+		c.activeTime = ci.ActiveTime
+	}
+
+	for _, pe := range info.PEL {
+		p := &pending{id: pe.ID, deliveryTime: pe.DeliveryTime, deliveryCount: pe.DeliveryCount}
+		g.pel.insert(pe.ID.key(), p)
+		p.assign(g.consumer(pe.Consumer, pe.DeliveryTime))
+	}
+
+	s.groups[info.Name] = g
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidID is returned when an ID can not be parsed
+var ErrInvalidID = errors.New("Invalid stream ID specified as stream command argument")
+
+// ID identifies an entry of a stream by the milliseconds time it was added and a sequence number
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	// MinID is the smallest possible ID
+	MinID = ID{}
+
+	// MaxID is the greatest possible ID
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+// ParseID parses an ID given as ms-seq or ms, seq is used as the sequence number when it is missing
+func ParseID(s string, seq uint64) (ID, error) {
+	msPart, seqPart := s, ""
+	if i := strings.IndexByte(s, '-'); i != -1 {
+		msPart, seqPart = s[:i], s[i+1:]
+	}
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, ErrInvalidID
+	}
+
+	if seqPart != "" || len(msPart) != len(s) {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return ID{}, ErrInvalidID
+		}
+	}
+
+	return ID{Ms: ms, Seq: seq}, nil
+}
+
+// String returns the ID formatted as ms-seq
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1 when id is smaller than other, 1 when it is greater and 0 when they are equal
+func (id ID) Compare(other ID) int {
+	switch {
+	case id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq):
+		return -1
+	case id == other:
+		return 0
+	}
+
+	return 1
+}
+
+// Less reports whether id is smaller than other
+func (id ID) Less(other ID) bool {
+	return id.Compare(other) < 0
+}
+
+// Next returns the smallest ID greater than id, ok is false when id is MaxID
+func (id ID) Next() (next ID, ok bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return ID{Ms: id.Ms + 1}, true
+	}
+
+	return id, false
+}
+
+// Prev returns the greatest ID smaller than id, ok is false when id is MinID
+func (id ID) Prev() (prev ID, ok bool) {
+	switch {
+	case id.Seq > 0:
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	case id.Ms > 0:
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+
+	return id, false
+}
+
+// key encodes the ID so byte wise ordering matches the ordering of IDs
+func (id ID) key() []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, id.Ms)
+	binary.BigEndian.PutUint64(k[8:], id.Seq)
+	return k
+}
+
+// idFromKey decodes a key created by ID.key
+func idFromKey(k []byte) ID {
+	return ID{Ms: binary.BigEndian.Uint64(k), Seq: binary.BigEndian.Uint64(k[8:])}
+}
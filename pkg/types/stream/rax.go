@@ -0,0 +1,233 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package stream
+
+import (
+	"bytes"
+	"sort"
+)
+
+// rax is a radix tree mapping byte keys to values, edges with a single child are compressed into one node
+// Keys are iterated in byte wise order which makes it a good ordered index for big endian encoded IDs
+type rax struct {
+	root  *raxNode
+	size  int
+	nodes int
+}
+
+type raxNode struct {
+	// prefix is the label of the edge leading to the node
+	prefix []byte
+
+	// children are sorted by the first byte of their prefix
+	children []*raxNode
+
+	isKey bool
+	value interface{}
+}
+
+func newRax() *rax {
+	return &rax{root: &raxNode{}, nodes: 1}
+}
+
+// commonPrefix returns the length of the common prefix of a and b
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// child returns the index of the child whose prefix starts with b and whether it exists
+func (n *raxNode) child(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].prefix[0] >= b })
+	return i, i < len(n.children) && n.children[i].prefix[0] == b
+}
+
+func (n *raxNode) insertChild(i int, c *raxNode) {
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = c
+}
+
+// insert sets the value of key, it returns false when the key already existed
+func (r *rax) insert(key []byte, value interface{}) bool {
+	n := r.root
+	for {
+		if len(key) == 0 {
+			added := !n.isKey
+			n.isKey, n.value = true, value
+			if added {
+				r.size++
+			}
+			return added
+		}
+
+		i, ok := n.child(key[0])
+		if !ok {
+			n.insertChild(i, &raxNode{prefix: append([]byte(nil), key...), isKey: true, value: value})
+			r.size++
+			r.nodes++
+			return true
+		}
+
+		c := n.children[i]
+		common := commonPrefix(c.prefix, key)
+		if common < len(c.prefix) {
+			// split the edge at the end of the common prefix
+			split := &raxNode{prefix: c.prefix[:common:common], children: []*raxNode{c}}
+			c.prefix = c.prefix[common:]
+			n.children[i] = split
+			r.nodes++
+			c = split
+		}
+
+		n, key = c, key[common:]
+	}
+}
+
+// find returns the value of key
+func (r *rax) find(key []byte) (interface{}, bool) {
+	n := r.root
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok || !bytes.HasPrefix(key, n.children[i].prefix) {
+			return nil, false
+		}
+
+		n, key = n.children[i], key[len(n.children[i].prefix):]
+	}
+
+	return n.value, n.isKey
+}
+
+// remove deletes key, it returns false when the key did not exist
+func (r *rax) remove(key []byte) bool {
+	path := []*raxNode{r.root}
+	n := r.root
+	for len(key) > 0 {
+		i, ok := n.child(key[0])
+		if !ok || !bytes.HasPrefix(key, n.children[i].prefix) {
+			return false
+		}
+
+		n, key = n.children[i], key[len(n.children[i].prefix):]
+		path = append(path, n)
+	}
+
+	if !n.isKey {
+		return false
+	}
+
+	n.isKey, n.value = false, nil
+	r.size--
+
+	// drop nodes which became useless, a node left with a single child and no key is merged with the child
+	for i := len(path) - 1; i > 0; i-- {
+		n, parent := path[i], path[i-1]
+		if n.isKey || len(n.children) > 1 {
+			break
+		}
+
+		j, _ := parent.child(n.prefix[0])
+		r.nodes--
+		if len(n.children) == 1 {
+			c := n.children[0]
+			c.prefix = append(append([]byte(nil), n.prefix...), c.prefix...)
+			parent.children[j] = c
+			break
+		}
+
+		parent.children = append(parent.children[:j], parent.children[j+1:]...)
+	}
+
+	return true
+}
+
+// ascend calls fn for keys greater than or equal to from in ascending order until fn returns false
+// All keys are visited when from is nil
+func (r *rax) ascend(from []byte, fn func(key []byte, value interface{}) bool) {
+	r.root.ascend(nil, from, from != nil, fn)
+}
+
+func (n *raxNode) ascend(path, from []byte, bounded bool, fn func([]byte, interface{}) bool) bool {
+	full := append(path[:len(path):len(path)], n.prefix...)
+	if bounded {
+		switch c := bytes.Compare(full, from[:min(len(full), len(from))]); {
+		case c < 0:
+			return true
+		case c > 0:
+			bounded = false
+		}
+	}
+
+	if n.isKey && (!bounded || len(full) >= len(from)) {
+		if !fn(full, n.value) {
+			return false
+		}
+	}
+
+	for _, c := range n.children {
+		if !c.ascend(full, from, bounded, fn) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// descend calls fn for keys less than or equal to from in descending order until fn returns false
+// All keys are visited when from is nil
+func (r *rax) descend(from []byte, fn func(key []byte, value interface{}) bool) {
+	r.root.descend(nil, from, from != nil, fn)
+}
+
+func (n *raxNode) descend(path, from []byte, bounded bool, fn func([]byte, interface{}) bool) bool {
+	full := append(path[:len(path):len(path)], n.prefix...)
+	if bounded {
+		switch c := bytes.Compare(full, from[:min(len(full), len(from))]); {
+		case c > 0:
+			return true
+		case c < 0:
+			bounded = false
+		}
+	}
+
+	for i := len(n.children) - 1; i >= 0; i-- {
+		if !n.children[i].descend(full, from, bounded, fn) {
+			return false
+		}
+	}
+
+	// a key is a prefix of the keys below it so it comes last
+	if n.isKey {
+		return fn(full, n.value)
+	}
+
+	return true
+}
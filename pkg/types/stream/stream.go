@@ -0,0 +1,562 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+// Package stream implements an append only log of entries identified by increasing IDs with consumer groups
+package stream
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+const (
+	// maxBlockEntries is the number of entries packed in a block before a new one is started
+	maxBlockEntries = 100
+
+	// DefaultTrimLimit is the number of entries removed at most by an approximate trim without a limit
+	DefaultTrimLimit = 100 * maxBlockEntries
+)
+
+var (
+	// ErrIDTooSmall is returned when an entry is added with an ID which is not greater than the last ID
+	ErrIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+
+	// ErrIDZero is returned when an entry is added with the ID 0-0
+	ErrIDZero = errors.New("The ID specified in XADD must be greater than 0-0")
+
+	// ErrIDExhausted is returned when no ID greater than the last ID is left
+	ErrIDExhausted = errors.New("The stream has exhausted the last possible ID, unable to add more items")
+
+	// ErrSetIDTooSmall is returned when the last ID is set to an ID smaller than the last entry
+	ErrSetIDTooSmall = errors.New("The ID specified in XSETID is smaller than the target stream top item")
+
+	// ErrSetIDBelowDeleted is returned when the last ID is set to an ID smaller than the max deleted ID
+	ErrSetIDBelowDeleted = errors.New("The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+
+	// ErrEntriesAddedTooSmall is returned when the number of added entries is set below the length of the stream
+	ErrEntriesAddedTooSmall = errors.New("The entries_added specified in XSETID is smaller than the target stream length")
+)
+
+// Entry is an entry of a stream, Fields holds field names and values one after another
+type Entry struct {
+	ID     ID
+	Fields []string
+}
+
+// block packs consecutive entries, entries with the same field names as the first entry of the block only store values
+type block struct {
+	fields  []string
+	entries []packed
+	live    int
+}
+
+type packed struct {
+	id      ID
+	deleted bool
+
+	// shared is set when the entry has the field names of the block and values only holds the values
+	shared bool
+	values []string
+}
+
+func newBlock(id ID, fields []string) *block {
+	names := make([]string, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		names = append(names, fields[i])
+	}
+
+	b := &block{fields: names}
+	b.add(id, fields)
+	return b
+}
+
+func (b *block) add(id ID, fields []string) {
+	p := packed{id: id, shared: len(fields) == 2*len(b.fields)}
+	for i := 0; p.shared && i < len(b.fields); i++ {
+		p.shared = fields[2*i] == b.fields[i]
+	}
+
+	if p.shared {
+		p.values = make([]string, len(b.fields))
+		for i := range b.fields {
+			p.values[i] = fields[2*i+1]
+		}
+	} else {
+		p.values = append([]string(nil), fields...)
+	}
+
+	b.entries = append(b.entries, p)
+	b.live++
+}
+
+// entry unpacks the entry at index i
+func (b *block) entry(i int) Entry {
+	p := b.entries[i]
+	if !p.shared {
+		return Entry{ID: p.id, Fields: p.values}
+	}
+
+	fields := make([]string, 0, 2*len(p.values))
+	for j, v := range p.values {
+		fields = append(fields, b.fields[j], v)
+	}
+
+	return Entry{ID: p.id, Fields: fields}
+}
+
+// search returns the index of the entry with id or the index where it would be
+func (b *block) search(id ID) (int, bool) {
+	i := sort.Search(len(b.entries), func(i int) bool { return !b.entries[i].id.Less(id) })
+	return i, i < len(b.entries) && b.entries[i].id == id
+}
+
+// Stream is a thread safe stream
+// Entries are packed in blocks indexed by a radix tree keyed with the ID of their first entry
+type Stream struct {
+	blocks *rax
+	tail   *block
+
+	length       int
+	lastID       ID
+	firstID      ID
+	maxDeletedID ID
+	entriesAdded uint64
+
+	groups map[string]*group
+	mux    *sync.RWMutex
+}
+
+// New creates a new Stream
+func New() *Stream {
+	return &Stream{blocks: newRax(), groups: make(map[string]*group), mux: &sync.RWMutex{}}
+}
+
+// Len returns the number of entries of the stream
+func (s *Stream) Len() int {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.length
+}
+
+// LastID returns the ID of the last entry ever added
+func (s *Stream) LastID() ID {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.lastID
+}
+
+// NextID returns the ID of an entry added at now milliseconds
+func (s *Stream) NextID(now uint64) (ID, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if now > s.lastID.Ms {
+		return ID{Ms: now}, nil
+	}
+
+	next, ok := s.lastID.Next()
+	if !ok {
+		return ID{}, ErrIDExhausted
+	}
+
+	return next, nil
+}
+
+// NextSeq returns the ID of an entry added with the milliseconds part ms and the next sequence number
+func (s *Stream) NextSeq(ms uint64) (ID, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	switch {
+	case ms > s.lastID.Ms:
+		return ID{Ms: ms}, nil
+	case ms < s.lastID.Ms:
+		return ID{}, ErrIDTooSmall
+	}
+
+	next, ok := s.lastID.Next()
+	if !ok || next.Ms != ms {
+		return ID{}, ErrIDTooSmall
+	}
+
+	return next, nil
+}
+
+// Add appends an entry, id must be greater than the last ID
+func (s *Stream) Add(id ID, fields []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if id == MinID {
+		return ErrIDZero
+	}
+
+	if !s.lastID.Less(id) {
+		return ErrIDTooSmall
+	}
+
+	if s.tail != nil && len(s.tail.entries) < maxBlockEntries {
+		s.tail.add(id, fields)
+	} else {
+		s.tail = newBlock(id, fields)
+		s.blocks.insert(id.key(), s.tail)
+	}
+
+	if s.length == 0 {
+		s.firstID = id
+	}
+
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+	return nil
+}
+
+// blockOf returns the key and the block which could hold id
+func (s *Stream) blockOf(id ID) (key []byte, b *block) {
+	s.blocks.descend(id.key(), func(k []byte, v interface{}) bool {
+		key, b = k, v.(*block)
+		return false
+	})
+
+	return
+}
+
+// lookup returns the entry with id
+func (s *Stream) lookup(id ID) (Entry, bool) {
+	_, b := s.blockOf(id)
+	if b == nil {
+		return Entry{}, false
+	}
+
+	i, ok := b.search(id)
+	if !ok || b.entries[i].deleted {
+		return Entry{}, false
+	}
+
+	return b.entry(i), true
+}
+
+// Range returns up to count entries with IDs between start and end inclusive, count <= 0 returns all of them
+// Entries are ordered from the greatest ID when reverse is true
+func (s *Stream) Range(start, end ID, count int, reverse bool) []Entry {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	return s.rangeLocked(start, end, count, reverse)
+}
+
+func (s *Stream) rangeLocked(start, end ID, count int, reverse bool) []Entry {
+	entries := make([]Entry, 0)
+	if end.Less(start) {
+		return entries
+	}
+
+	full := func() bool {
+		return count > 0 && len(entries) >= count
+	}
+
+	if reverse {
+		s.blocks.descend(end.key(), func(_ []byte, v interface{}) bool {
+			b := v.(*block)
+			for i := len(b.entries) - 1; i >= 0; i-- {
+				p := b.entries[i]
+				switch {
+				case p.deleted || end.Less(p.id):
+					continue
+				case p.id.Less(start):
+					return false
+				}
+
+				if entries = append(entries, b.entry(i)); full() {
+					return false
+				}
+			}
+			return true
+		})
+
+		return entries
+	}
+
+	from, _ := s.blockOf(start)
+	s.blocks.ascend(from, func(_ []byte, v interface{}) bool {
+		b := v.(*block)
+		for i := range b.entries {
+			p := b.entries[i]
+			switch {
+			case p.deleted || p.id.Less(start):
+				continue
+			case end.Less(p.id):
+				return false
+			}
+
+			if entries = append(entries, b.entry(i)); full() {
+				return false
+			}
+		}
+		return true
+	})
+
+	return entries
+}
+
+// removeBlock drops a block from the radix tree
+func (s *Stream) removeBlock(key []byte, b *block) {
+	s.blocks.remove(key)
+	if s.tail == b {
+		s.tail = nil
+	}
+}
+
+// deleteEntry marks the entry at index i of a block as deleted, the block is dropped when it has no entries left
+func (s *Stream) deleteEntry(key []byte, b *block, i int) {
+	b.entries[i].deleted = true
+	b.live--
+	s.length--
+
+	if b.live == 0 {
+		s.removeBlock(key, b)
+	}
+}
+
+// updateFirstID records the ID of the first entry after entries were removed from the head
+func (s *Stream) updateFirstID() {
+	s.firstID = MinID
+	s.blocks.ascend(nil, func(_ []byte, v interface{}) bool {
+		for _, p := range v.(*block).entries {
+			if !p.deleted {
+				s.firstID = p.id
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Delete removes entries by ID and returns the number of removed entries
+func (s *Stream) Delete(ids []ID) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	removed := 0
+	for _, id := range ids {
+		key, b := s.blockOf(id)
+		if b == nil {
+			continue
+		}
+
+		i, ok := b.search(id)
+		if !ok || b.entries[i].deleted {
+			continue
+		}
+
+		s.deleteEntry(key, b, i)
+		if s.maxDeletedID.Less(id) {
+			s.maxDeletedID = id
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		s.updateFirstID()
+	}
+
+	return removed
+}
+
+// TrimOptions selects the entries removed by Trim
+type TrimOptions struct {
+	// MaxLen is the number of entries kept when trimming by length
+	MaxLen int
+
+	// MinID is the smallest ID kept when ByMinID is set
+	MinID   ID
+	ByMinID bool
+
+	// Approx only removes whole blocks so the stream may keep some entries which should be trimmed
+	Approx bool
+
+	// Limit is the maximum number of removed entries when trimming approximately, 0 means no limit
+	Limit int
+}
+
+// Trim removes entries from the head of the stream and returns the number of removed entries
+func (s *Stream) Trim(opts TrimOptions) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// an entry is removed when trim returns true for it
+	trim := func(id ID) bool {
+		if opts.ByMinID {
+			return id.Less(opts.MinID)
+		}
+
+		return s.length > opts.MaxLen
+	}
+
+	type located struct {
+		key []byte
+		b   *block
+	}
+
+	var blocks []located
+	s.blocks.ascend(nil, func(k []byte, v interface{}) bool {
+		blocks = append(blocks, located{key: k, b: v.(*block)})
+		return true
+	})
+
+	removed := 0
+	for _, l := range blocks {
+		b := l.b
+		last := b.entries[len(b.entries)-1].id
+
+		whole := s.length-b.live >= opts.MaxLen
+		if opts.ByMinID {
+			whole = last.Less(opts.MinID)
+		}
+
+		if whole {
+			if opts.Approx && opts.Limit > 0 && removed+b.live > opts.Limit {
+				break
+			}
+
+			s.removeBlock(l.key, b)
+			s.length -= b.live
+			removed += b.live
+			continue
+		}
+
+		if opts.Approx {
+			break
+		}
+
+		for i := range b.entries {
+			if b.entries[i].deleted {
+				continue
+			}
+
+			if !trim(b.entries[i].id) {
+				break
+			}
+
+			s.deleteEntry(l.key, b, i)
+			removed++
+		}
+		break
+	}
+
+	if removed > 0 {
+		s.updateFirstID()
+	}
+
+	return removed
+}
+
+// SetID sets the last ID of the stream, the number of added entries when entriesAdded >= 0 and the max deleted ID
+// when maxDeletedID is not nil
+func (s *Stream) SetID(id ID, entriesAdded int64, maxDeletedID *ID) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if maxDeletedID != nil && id.Less(*maxDeletedID) {
+		return ErrSetIDBelowDeleted
+	}
+
+	if entriesAdded >= 0 && entriesAdded < int64(s.length) {
+		return ErrEntriesAddedTooSmall
+	}
+
+	if s.length > 0 {
+		var last ID
+		s.blocks.descend(nil, func(_ []byte, v interface{}) bool {
+			b := v.(*block)
+			for i := len(b.entries) - 1; i >= 0; i-- {
+				if !b.entries[i].deleted {
+					last = b.entries[i].id
+					return false
+				}
+			}
+			return true
+		})
+
+		if id.Less(last) {
+			return ErrSetIDTooSmall
+		}
+	}
+
+	s.lastID = id
+	if entriesAdded >= 0 {
+		s.entriesAdded = uint64(entriesAdded)
+	}
+	if maxDeletedID != nil {
+		s.maxDeletedID = *maxDeletedID
+	}
+
+	return nil
+}
+
+// Info describes a stream
+type Info struct {
+	Length       int
+	Blocks       int
+	Nodes        int
+	LastID       ID
+	MaxDeletedID ID
+	FirstID      ID
+	EntriesAdded uint64
+	Groups       int
+
+	// First and Last are nil when the stream is empty
+	First *Entry
+	Last  *Entry
+}
+
+// Info returns the details of the stream
+func (s *Stream) Info() Info {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	info := Info{
+		Length:       s.length,
+		Blocks:       s.blocks.size,
+		Nodes:        s.blocks.nodes,
+		LastID:       s.lastID,
+		MaxDeletedID: s.maxDeletedID,
+		FirstID:      s.firstID,
+		EntriesAdded: s.entriesAdded,
+		Groups:       len(s.groups),
+	}
+
+	if first := s.rangeLocked(MinID, MaxID, 1, false); len(first) > 0 {
+		info.First = &first[0]
+	}
+
+	if last := s.rangeLocked(MinID, MaxID, 1, true); len(last) > 0 {
+		info.Last = &last[0]
+	}
+
+	return info
+}
@@ -0,0 +1,431 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package stream
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func ids(entries []Entry) []string {
+	res := make([]string, len(entries))
+	for i, e := range entries {
+		res[i] = e.ID.String()
+	}
+
+	return res
+}
+
+func newTestStream(n int) *Stream {
+	s := New()
+	for i := 1; i <= n; i++ {
+		s.Add(ID{Ms: uint64(i)}, []string{"n", strconv.Itoa(i)})
+	}
+
+	return s
+}
+
+func TestParseID(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	id, err := ParseID("5-3", 0)
+	assert.Nil(err)
+	assert.Equal(ID{Ms: 5, Seq: 3}, id)
+
+	id, err = ParseID("5", 7)
+	assert.Nil(err)
+	assert.Equal(ID{Ms: 5, Seq: 7}, id)
+	assert.Equal("5-7", id.String())
+
+	for _, s := range []string{"", "-", "5-", "-5", "a-1", "1-b", "1-2-3", "+1"} {
+		_, err = ParseID(s, 0)
+		assert.Equal(ErrInvalidID, err, s)
+	}
+}
+
+func TestID_NextPrev(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	next, ok := ID{Ms: 1, Seq: MaxID.Seq}.Next()
+	assert.True(ok)
+	assert.Equal(ID{Ms: 2}, next)
+
+	_, ok = MaxID.Next()
+	assert.False(ok)
+
+	prev, ok := ID{Ms: 2}.Prev()
+	assert.True(ok)
+	assert.Equal(ID{Ms: 1, Seq: MaxID.Seq}, prev)
+
+	_, ok = MinID.Prev()
+	assert.False(ok)
+}
+
+func TestRax(t *testing.T) {
+	assert := testifyAssert.New(t)
+	r := newRax()
+
+	keys := make(map[uint64]bool)
+	for i := 0; i < 2000; i++ {
+		k := uint64(rand.Intn(5000))
+		assert.Equal(!keys[k], r.insert(ID{Ms: k}.key(), k))
+		keys[k] = true
+	}
+
+	for k := range keys {
+		if k%3 == 0 {
+			assert.True(r.remove(ID{Ms: k}.key()))
+			delete(keys, k)
+		}
+	}
+	assert.False(r.remove(ID{Ms: 3}.key()))
+	assert.Equal(len(keys), r.size)
+
+	var want []uint64
+	for k := range keys {
+		want = append(want, k)
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i] < want[j] })
+
+	var got []uint64
+	r.ascend(nil, func(_ []byte, v interface{}) bool {
+		got = append(got, v.(uint64))
+		return true
+	})
+	assert.Equal(want, got)
+
+	for _, k := range want {
+		v, ok := r.find(ID{Ms: k}.key())
+		assert.True(ok)
+		assert.Equal(k, v)
+	}
+
+	// bounded iteration in both directions
+	from := want[len(want)/2]
+	got = got[:0]
+	r.ascend(ID{Ms: from}.key(), func(_ []byte, v interface{}) bool {
+		got = append(got, v.(uint64))
+		return true
+	})
+	assert.Equal(want[len(want)/2:], got)
+
+	got = got[:0]
+	r.descend(ID{Ms: from, Seq: 1}.key(), func(_ []byte, v interface{}) bool {
+		got = append(got, v.(uint64))
+		return true
+	})
+	assert.Equal(len(want)/2+1, len(got))
+	assert.Equal(from, got[0])
+	assert.Equal(want[0], got[len(got)-1])
+
+	for _, k := range want {
+		assert.True(r.remove(ID{Ms: k}.key()))
+	}
+	assert.Equal(0, r.size)
+	assert.Equal(1, r.nodes)
+}
+
+func TestStream_Add(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := New()
+
+	assert.Equal(ErrIDZero, s.Add(MinID, []string{"a", "1"}))
+	assert.Nil(s.Add(ID{Ms: 5}, []string{"a", "1"}))
+	assert.Equal(ErrIDTooSmall, s.Add(ID{Ms: 5}, []string{"a", "1"}))
+	assert.Equal(ErrIDTooSmall, s.Add(ID{Ms: 4}, []string{"a", "1"}))
+
+	id, err := s.NextID(3)
+	assert.Nil(err)
+	assert.Equal(ID{Ms: 5, Seq: 1}, id)
+
+	id, _ = s.NextID(10)
+	assert.Equal(ID{Ms: 10}, id)
+
+	id, _ = s.NextSeq(5)
+	assert.Equal(ID{Ms: 5, Seq: 1}, id)
+	_, err = s.NextSeq(4)
+	assert.Equal(ErrIDTooSmall, err)
+
+	// entries with other fields than the first entry of the block
+	assert.Nil(s.Add(ID{Ms: 6}, []string{"b", "2", "c", "3"}))
+	assert.Equal(2, s.Len())
+
+	entries := s.Range(MinID, MaxID, 0, false)
+	assert.Equal([]Entry{{ID: ID{Ms: 5}, Fields: []string{"a", "1"}}, {ID: ID{Ms: 6}, Fields: []string{"b", "2", "c", "3"}}}, entries)
+}
+
+func TestStream_Range(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(350)
+
+	entries := s.Range(MinID, MaxID, 0, false)
+	assert.Equal(350, len(entries))
+	assert.Equal([]string{"n", "1"}, entries[0].Fields)
+
+	entries = s.Range(ID{Ms: 99}, ID{Ms: 102}, 0, false)
+	assert.Equal([]string{"99-0", "100-0", "101-0", "102-0"}, ids(entries))
+
+	entries = s.Range(ID{Ms: 99}, ID{Ms: 102}, 2, true)
+	assert.Equal([]string{"102-0", "101-0"}, ids(entries))
+
+	entries = s.Range(ID{Ms: 340}, MaxID, 0, true)
+	assert.Equal(11, len(entries))
+
+	assert.Equal(0, len(s.Range(ID{Ms: 5}, ID{Ms: 4}, 0, false)))
+	assert.Equal(0, len(s.Range(ID{Ms: 351}, MaxID, 0, false)))
+}
+
+func TestStream_Delete(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(150)
+
+	assert.Equal(2, s.Delete([]ID{{Ms: 1}, {Ms: 3}, {Ms: 3}, {Ms: 1000}}))
+	assert.Equal(148, s.Len())
+	assert.Equal([]string{"2-0", "4-0"}, ids(s.Range(MinID, ID{Ms: 4}, 0, false)))
+
+	info := s.Info()
+	assert.Equal(ID{Ms: 3}, info.MaxDeletedID)
+	assert.Equal(ID{Ms: 2}, info.FirstID)
+	assert.Equal(uint64(150), info.EntriesAdded)
+
+	// removing every entry of a block drops it
+	var block []ID
+	for i := 101; i <= 150; i++ {
+		block = append(block, ID{Ms: uint64(i)})
+	}
+	assert.Equal(50, s.Delete(block))
+	assert.Equal(1, s.Info().Blocks)
+	assert.Equal(ID{Ms: 150}, s.LastID())
+
+	assert.Nil(s.Add(ID{Ms: 151}, []string{"n", "151"}))
+	assert.Equal(2, s.Info().Blocks)
+}
+
+func TestStream_Trim(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	s := newTestStream(250)
+	assert.Equal(100, s.Trim(TrimOptions{MaxLen: 100, Approx: true}))
+	assert.Equal(150, s.Len())
+	assert.Equal(0, s.Trim(TrimOptions{MaxLen: 90, Approx: true}))
+
+	assert.Equal(60, s.Trim(TrimOptions{MaxLen: 90}))
+	assert.Equal(90, s.Len())
+	assert.Equal(ID{Ms: 161}, s.Info().FirstID)
+
+	s = newTestStream(250)
+	assert.Equal(100, s.Trim(TrimOptions{MaxLen: 0, Approx: true, Limit: 150}))
+
+	s = newTestStream(250)
+	assert.Equal(119, s.Trim(TrimOptions{MinID: ID{Ms: 120}, ByMinID: true}))
+	assert.Equal("120-0", ids(s.Range(MinID, MaxID, 1, false))[0])
+
+	s = newTestStream(250)
+	assert.Equal(250, s.Trim(TrimOptions{MaxLen: 0}))
+	assert.Equal(0, s.Len())
+	assert.Equal(ID{Ms: 250}, s.LastID())
+}
+
+func TestStream_SetID(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(5)
+
+	assert.Equal(ErrSetIDTooSmall, s.SetID(ID{Ms: 4}, -1, nil))
+	assert.Equal(ErrEntriesAddedTooSmall, s.SetID(ID{Ms: 10}, 3, nil))
+	assert.Equal(ErrSetIDBelowDeleted, s.SetID(ID{Ms: 10}, -1, &ID{Ms: 11}))
+
+	assert.Nil(s.SetID(ID{Ms: 10}, 20, &ID{Ms: 7}))
+	info := s.Info()
+	assert.Equal(ID{Ms: 10}, info.LastID)
+	assert.Equal(uint64(20), info.EntriesAdded)
+	assert.Equal(ID{Ms: 7}, info.MaxDeletedID)
+	assert.Equal(ErrIDTooSmall, s.Add(ID{Ms: 9}, []string{"a", "b"}))
+}
+
+func TestStream_ReadGroup(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(5)
+
+	_, err := s.ReadGroup("g", "c", MinID, false, 0, false, 0)
+	assert.Equal(ErrNoGroup, err)
+
+	assert.Nil(s.CreateGroup("g", MinID, -1))
+	assert.Equal(ErrGroupExists, s.CreateGroup("g", MinID, -1))
+	assert.Equal(int64(5), s.Groups(false)[0].Lag)
+
+	entries, err := s.ReadGroup("g", "alice", MinID, false, 2, false, 100)
+	assert.Nil(err)
+	assert.Equal([]string{"1-0", "2-0"}, ids(entries))
+
+	entries, _ = s.ReadGroup("g", "bob", MinID, false, 0, false, 200)
+	assert.Equal([]string{"3-0", "4-0", "5-0"}, ids(entries))
+
+	entries, _ = s.ReadGroup("g", "bob", MinID, false, 0, false, 200)
+	assert.Equal(0, len(entries))
+
+	info := s.Groups(false)[0]
+	assert.Equal(ID{Ms: 5}, info.LastID)
+	assert.Equal(int64(5), info.EntriesRead)
+	assert.Equal(int64(0), info.Lag)
+	assert.Equal(5, info.Pending)
+
+	// history of the consumer, deleted entries have no fields
+	s.Delete([]ID{{Ms: 4}})
+	entries, _ = s.ReadGroup("g", "bob", MinID, true, 0, false, 300)
+	assert.Equal([]string{"3-0", "4-0", "5-0"}, ids(entries))
+	assert.Nil(entries[1].Fields)
+
+	acked, err := s.Ack("g", []ID{{Ms: 1}, {Ms: 1}, {Ms: 9}})
+	assert.Nil(err)
+	assert.Equal(1, acked)
+
+	summary, _ := s.Pending("g")
+	assert.Equal(4, summary.Count)
+	assert.Equal(ID{Ms: 2}, summary.Min)
+	assert.Equal(ID{Ms: 5}, summary.Max)
+	assert.Equal([]ConsumerInfo{{Name: "alice", SeenTime: 100, ActiveTime: 100, Pending: 1}, {Name: "bob", SeenTime: 300, ActiveTime: 200, Pending: 3}}, summary.Consumers)
+
+	pending, _ := s.PendingRange("g", MinID, MaxID, 10, "bob", 0, 400)
+	assert.Equal([]PendingEntry{{ID: ID{Ms: 3}, Consumer: "bob", DeliveryTime: 300, DeliveryCount: 2}, {ID: ID{Ms: 4}, Consumer: "bob", DeliveryTime: 200, DeliveryCount: 1}, {ID: ID{Ms: 5}, Consumer: "bob", DeliveryTime: 300, DeliveryCount: 2}}, pending)
+
+	pending, _ = s.PendingRange("g", MinID, MaxID, 10, "", 150, 400)
+	assert.Equal(2, len(pending))
+
+	// no ack reads only move the last ID
+	s.Add(ID{Ms: 6}, []string{"n", "6"})
+	entries, _ = s.ReadGroup("g", "bob", MinID, false, 0, true, 500)
+	assert.Equal([]string{"6-0"}, ids(entries))
+	summary, _ = s.Pending("g")
+	assert.Equal(4, summary.Count)
+
+	n, err := s.DeleteConsumer("g", "bob")
+	assert.Nil(err)
+	assert.Equal(3, n)
+	summary, _ = s.Pending("g")
+	assert.Equal(1, summary.Count)
+
+	assert.True(s.DestroyGroup("g"))
+	assert.False(s.DestroyGroup("g"))
+}
+
+func TestStream_Claim(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(5)
+	s.CreateGroup("g", MinID, -1)
+	s.ReadGroup("g", "alice", MinID, false, 0, false, 100)
+
+	// entries delivered recently are not claimed
+	claimed, _, err := s.Claim("g", "bob", 50, []ID{{Ms: 1}, {Ms: 2}}, ClaimOptions{RetryCount: -1}, 120)
+	assert.Nil(err)
+	assert.Equal(0, len(claimed))
+
+	claimed, _, _ = s.Claim("g", "bob", 50, []ID{{Ms: 1}, {Ms: 2}}, ClaimOptions{RetryCount: -1}, 200)
+	assert.Equal([]string{"1-0", "2-0"}, ids(claimed))
+	assert.Equal([]string{"n", "1"}, claimed[0].Fields)
+
+	pending, _ := s.PendingRange("g", MinID, ID{Ms: 1}, 10, "", 0, 200)
+	assert.Equal([]PendingEntry{{ID: ID{Ms: 1}, Consumer: "bob", DeliveryTime: 200, DeliveryCount: 2}}, pending)
+
+	// deleted entries are removed from the pending entries
+	s.Delete([]ID{{Ms: 3}})
+	claimed, deleted, _ := s.Claim("g", "bob", 0, []ID{{Ms: 3}, {Ms: 4}}, ClaimOptions{RetryCount: 7, JustID: true, DeliveryTime: 50}, 300)
+	assert.Equal([]ID{{Ms: 3}}, deleted)
+	assert.Equal([]Entry{{ID: ID{Ms: 4}}}, claimed)
+	pending, _ = s.PendingRange("g", ID{Ms: 4}, ID{Ms: 4}, 10, "", 0, 300)
+	assert.Equal(int64(7), pending[0].DeliveryCount)
+	assert.Equal(int64(50), pending[0].DeliveryTime)
+
+	// force creates pending entries of undelivered entries
+	s.Add(ID{Ms: 6}, []string{"n", "6"})
+	last := ID{Ms: 6}
+	claimed, _, _ = s.Claim("g", "carol", 0, []ID{{Ms: 6}, {Ms: 7}}, ClaimOptions{RetryCount: -1, Force: true, LastID: &last}, 400)
+	assert.Equal([]string{"6-0"}, ids(claimed))
+	assert.Equal(ID{Ms: 6}, s.Groups(false)[0].LastID)
+}
+
+func TestStream_AutoClaim(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(10)
+	s.CreateGroup("g", MinID, -1)
+	s.ReadGroup("g", "alice", MinID, false, 0, false, 100)
+	s.Delete([]ID{{Ms: 2}})
+
+	next, claimed, deleted, err := s.AutoClaim("g", "bob", 50, MinID, 3, false, 200)
+	assert.Nil(err)
+	assert.Equal(ID{Ms: 5}, next)
+	assert.Equal([]string{"1-0", "3-0", "4-0"}, ids(claimed))
+	assert.Equal([]ID{{Ms: 2}}, deleted)
+
+	next, claimed, _, _ = s.AutoClaim("g", "bob", 50, next, 10, true, 200)
+	assert.Equal(MinID, next)
+	assert.Equal(6, len(claimed))
+	assert.Nil(claimed[0].Fields)
+
+	summary, _ := s.Pending("g")
+	assert.Equal(9, summary.Count)
+	assert.Equal([]ConsumerInfo{{Name: "bob", SeenTime: 200, ActiveTime: 200, Pending: 9}}, summary.Consumers)
+}
+
+func TestStream_Lag(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(5)
+	s.CreateGroup("g", ID{Ms: 2}, -1)
+	assert.Equal(int64(-1), s.Groups(false)[0].Lag)
+
+	s.CreateGroup("h", ID{Ms: 5}, -1)
+	assert.Equal(int64(0), s.Groups(false)[1].Lag)
+
+	s.ReadGroup("g", "c", MinID, false, 1, false, 0)
+	assert.Equal(int64(-1), s.Groups(false)[0].Lag)
+
+	s.SetGroupID("g", MinID, 0)
+	s.ReadGroup("g", "c", MinID, false, 1, false, 0)
+	assert.Equal(int64(4), s.Groups(false)[0].Lag)
+
+	// deleted entries after the last ID make the lag unknown
+	s.Delete([]ID{{Ms: 3}})
+	assert.Equal(int64(-1), s.Groups(false)[0].Lag)
+}
+
+func TestStream_RestoreGroup(t *testing.T) {
+	assert := testifyAssert.New(t)
+	s := newTestStream(5)
+	s.CreateGroup("g", MinID, -1)
+	s.ReadGroup("g", "alice", MinID, false, 2, false, 100)
+	s.ReadGroup("g", "bob", MinID, false, 1, false, 200)
+	s.CreateConsumer("g", "carol", 300)
+
+	groups := s.Groups(true)
+
+	restored := newTestStream(5)
+	assert.Nil(restored.RestoreGroup(groups[0]))
+	assert.Equal(groups, restored.Groups(true))
+	assert.Equal(ErrGroupExists, restored.RestoreGroup(groups[0]))
+}
@@ -0,0 +1,156 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package zset
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidScoreBound is returned when a score range item can not be parsed
+	ErrInvalidScoreBound = errors.New("min or max is not a float")
+
+	// ErrInvalidLexBound is returned when a lexicographical range item can not be parsed
+	ErrInvalidLexBound = errors.New("min or max not valid string range item")
+)
+
+// ScoreBound is a limit of a score range
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// ParseScoreBound parses a score range item like 1.5, (1.5, -inf or +inf
+func ParseScoreBound(s string) (ScoreBound, error) {
+	bound := ScoreBound{}
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(val) {
+		return bound, ErrInvalidScoreBound
+	}
+
+	bound.Value = val
+	return bound, nil
+}
+
+// lte reports whether the bound as a minimum accepts v
+func (b ScoreBound) lte(v float64) bool {
+	if b.Exclusive {
+		return b.Value < v
+	}
+
+	return b.Value <= v
+}
+
+// gte reports whether the bound as a maximum accepts v
+func (b ScoreBound) gte(v float64) bool {
+	if b.Exclusive {
+		return b.Value > v
+	}
+
+	return b.Value >= v
+}
+
+// LexBound is a limit of a lexicographical range
+type LexBound struct {
+	Value     string
+	Exclusive bool
+
+	// Inf is -1 for negative infinity(-), 1 for positive infinity(+) and 0 for a regular value
+	Inf int
+}
+
+// ParseLexBound parses a lexicographical range item like [a, (a, - or +
+func ParseLexBound(s string) (LexBound, error) {
+	switch {
+	case s == "-":
+		return LexBound{Inf: -1}, nil
+	case s == "+":
+		return LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return LexBound{Value: s[1:], Exclusive: true}, nil
+	}
+
+	return LexBound{}, ErrInvalidLexBound
+}
+
+// lte reports whether the bound as a minimum accepts s
+func (b LexBound) lte(s string) bool {
+	switch b.Inf {
+	case -1:
+		return true
+	case 1:
+		return false
+	}
+
+	if b.Exclusive {
+		return b.Value < s
+	}
+
+	return b.Value <= s
+}
+
+// gte reports whether the bound as a maximum accepts s
+func (b LexBound) gte(s string) bool {
+	switch b.Inf {
+	case 1:
+		return true
+	case -1:
+		return false
+	}
+
+	if b.Exclusive {
+		return b.Value > s
+	}
+
+	return b.Value >= s
+}
+
+// compareLexBounds compares two bounds ignoring exclusiveness
+func compareLexBounds(a, b LexBound) int {
+	if a.Inf == b.Inf && a.Inf != 0 {
+		return 0
+	}
+
+	if a.Inf == -1 || b.Inf == 1 {
+		return -1
+	}
+
+	if a.Inf == 1 || b.Inf == -1 {
+		return 1
+	}
+
+	return strings.Compare(a.Value, b.Value)
+}
@@ -0,0 +1,333 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package zset
+
+import (
+	"math/rand"
+)
+
+const (
+	// maxLevel is the maximum number of levels a skiplist can grow to, enough for 2^64 elements
+	maxLevel = 32
+
+	// levelProbability is the probability of a node having one more level
+	levelProbability = 0.25
+)
+
+// level holds the forward pointer of a node in a given level and the number of nodes it spans over
+type level struct {
+	forward *node
+	span    int
+}
+
+// node is an element in the skiplist
+type node struct {
+	member   string
+	score    float64
+	backward *node
+	level    []level
+}
+
+// skiplist keeps elements ordered by score and member, spans in each level are used to calculate ranks
+type skiplist struct {
+	header *node
+	tail   *node
+	length int
+	level  int
+}
+
+func newNode(lvl int, score float64, member string) *node {
+	return &node{member: member, score: score, level: make([]level, lvl)}
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{header: newNode(maxLevel, 0, ""), level: 1}
+}
+
+// randomLevel returns a random level for a new node, higher levels are less likely
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Float64() < levelProbability {
+		lvl++
+	}
+
+	return lvl
+}
+
+// lessThan reports whether n is ordered before the element with given score and member
+func (n *node) lessThan(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// insert adds a new element, caller must make sure the element is not already in the list
+func (sl *skiplist) insert(score float64, member string) *node {
+	var update [maxLevel]*node
+	var rank [maxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i != sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.level[i].forward != nil && x.level[i].forward.lessThan(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > sl.level {
+		for i := sl.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = lvl
+	}
+
+	x = newNode(lvl, score, member)
+	for i := 0; i < lvl; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// untouched levels span over the new node
+	for i := lvl; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+
+	sl.length++
+	return x
+}
+
+// deleteNode unlinks x from the list, update holds the nodes pointing to x in each level
+func (sl *skiplist) deleteNode(x *node, update []*node) {
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+
+	sl.length--
+}
+
+// delete removes the element with given score and member, it returns false when element was not found
+func (sl *skiplist) delete(score float64, member string) bool {
+	update := make([]*node, maxLevel)
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.lessThan(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x != nil && x.score == score && x.member == member {
+		sl.deleteNode(x, update)
+		return true
+	}
+
+	return false
+}
+
+// rank returns the 1 based rank of the element, 0 is returned when element was not found
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !(score < x.level[i].forward.score ||
+			(score == x.level[i].forward.score && member < x.level[i].forward.member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if x != sl.header && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+// byRank returns the element at the 1 based rank
+func (sl *skiplist) byRank(rank int) *node {
+	traversed := 0
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// isInRange reports whether some part of the list falls in the score range
+func (sl *skiplist) isInRange(min, max ScoreBound) bool {
+	if min.Value > max.Value || (min.Value == max.Value && (min.Exclusive || max.Exclusive)) {
+		return false
+	}
+
+	if sl.tail == nil || !min.lte(sl.tail.score) {
+		return false
+	}
+
+	first := sl.header.level[0].forward
+	return first != nil && max.gte(first.score)
+}
+
+// firstInRange returns the first element in the score range
+func (sl *skiplist) firstInRange(min, max ScoreBound) *node {
+	if !sl.isInRange(min, max) {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !min.lte(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !max.gte(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+// lastInRange returns the last element in the score range
+func (sl *skiplist) lastInRange(min, max ScoreBound) *node {
+	if !sl.isInRange(min, max) {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && max.gte(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == sl.header || !min.lte(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+// isInLexRange reports whether some part of the list falls in the lexicographical range
+func (sl *skiplist) isInLexRange(min, max LexBound) bool {
+	if cmp := compareLexBounds(min, max); cmp > 0 || (cmp == 0 && (min.Exclusive || max.Exclusive)) {
+		return false
+	}
+
+	if sl.tail == nil || !min.lte(sl.tail.member) {
+		return false
+	}
+
+	first := sl.header.level[0].forward
+	return first != nil && max.gte(first.member)
+}
+
+// firstInLexRange returns the first element in the lexicographical range
+func (sl *skiplist) firstInLexRange(min, max LexBound) *node {
+	if !sl.isInLexRange(min, max) {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !min.lte(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !max.gte(x.member) {
+		return nil
+	}
+
+	return x
+}
+
+// lastInLexRange returns the last element in the lexicographical range
+func (sl *skiplist) lastInLexRange(min, max LexBound) *node {
+	if !sl.isInLexRange(min, max) {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && max.gte(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == sl.header || !min.lte(x.member) {
+		return nil
+	}
+
+	return x
+}
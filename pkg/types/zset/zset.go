@@ -0,0 +1,448 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package zset
+
+import (
+	"errors"
+	"math"
+	"sync"
+
+	"github.com/kasvith/kache/pkg/types/index"
+)
+
+const (
+	// FlagNX only adds new elements and never updates existing ones
+	FlagNX = 1 << iota
+
+	// FlagXX only updates existing elements and never adds new ones
+	FlagXX
+
+	// FlagGT only updates existing elements when the new score is greater than the current one
+	FlagGT
+
+	// FlagLT only updates existing elements when the new score is less than the current one
+	FlagLT
+
+	// FlagIncr increments the score of the element instead of setting it
+	FlagIncr
+)
+
+// AddResult is the outcome of adding an element to a ZSet
+type AddResult int
+
+const (
+	// Nop means the ZSet was not modified
+	Nop = AddResult(iota)
+
+	// Added means a new element was added
+	Added
+
+	// Updated means the score of an existing element was changed
+	Updated
+)
+
+// ErrNotANumber is returned when an increment results in NaN
+var ErrNotANumber = errors.New("resulting score is not a number (NaN)")
+
+// Element is a member of a ZSet with its score
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// ZSet is a thread safe sorted set implemented with a skiplist and a hashmap
+// The hashmap gives O(1) score lookups while the skiplist gives O(log n) rank and range operations
+type ZSet struct {
+	dict map[string]float64
+	zsl  *skiplist
+
+	// idx allows iterating with a cursor, it is built the first time it is needed
+	idx *index.Index
+	mux *sync.RWMutex
+}
+
+// New creates a new ZSet
+func New() *ZSet {
+	return &ZSet{dict: make(map[string]float64), zsl: newSkiplist(), mux: &sync.RWMutex{}}
+}
+
+// index returns the index of the members building it on first use, caller must hold the write lock
+func (z *ZSet) index() *index.Index {
+	if z.idx == nil {
+		z.idx = index.New()
+		for member := range z.dict {
+			z.idx.Add(member)
+		}
+	}
+
+	return z.idx
+}
+
+// Add adds or updates a member according to flags
+// It returns the resulting score of the member and whether it was added, updated or left untouched
+func (z *ZSet) Add(score float64, member string, flags int) (float64, AddResult, error) {
+	z.mux.Lock()
+	defer z.mux.Unlock()
+
+	cur, found := z.dict[member]
+	if !found {
+		if flags&FlagXX != 0 {
+			return 0, Nop, nil
+		}
+
+		z.dict[member] = score
+		z.zsl.insert(score, member)
+		if z.idx != nil {
+			z.idx.Add(member)
+		}
+		return score, Added, nil
+	}
+
+	if flags&FlagNX != 0 {
+		return cur, Nop, nil
+	}
+
+	if flags&FlagIncr != 0 {
+		score += cur
+		if math.IsNaN(score) {
+			return 0, Nop, ErrNotANumber
+		}
+	}
+
+	if (flags&FlagLT != 0 && score >= cur) || (flags&FlagGT != 0 && score <= cur) {
+		return cur, Nop, nil
+	}
+
+	if score == cur {
+		return cur, Nop, nil
+	}
+
+	z.zsl.delete(cur, member)
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return score, Updated, nil
+}
+
+// Score returns the score of a member
+func (z *ZSet) Score(member string) (score float64, found bool) {
+	z.mux.RLock()
+	score, found = z.dict[member]
+	z.mux.RUnlock()
+	return
+}
+
+// Rank returns the 0 based rank of a member, ordered from the highest score when reverse is true
+func (z *ZSet) Rank(member string, reverse bool) (int, bool) {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	score, found := z.dict[member]
+	if !found {
+		return 0, false
+	}
+
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.zsl.length - rank, true
+	}
+
+	return rank - 1, true
+}
+
+// Len returns the number of elements in the ZSet
+func (z *ZSet) Len() int {
+	z.mux.RLock()
+	length := len(z.dict)
+	z.mux.RUnlock()
+	return length
+}
+
+// Remove removes members and returns the number of removed members
+func (z *ZSet) Remove(members []string) int {
+	z.mux.Lock()
+
+	removed := 0
+	for _, member := range members {
+		if score, found := z.dict[member]; found {
+			z.zsl.delete(score, member)
+			delete(z.dict, member)
+			if z.idx != nil {
+				z.idx.Remove(member)
+			}
+			removed++
+		}
+	}
+
+	z.mux.Unlock()
+	return removed
+}
+
+// removeNodes removes given nodes from the ZSet
+func (z *ZSet) removeNodes(nodes []*node) int {
+	for _, n := range nodes {
+		z.zsl.delete(n.score, n.member)
+		delete(z.dict, n.member)
+		if z.idx != nil {
+			z.idx.Remove(n.member)
+		}
+	}
+
+	return len(nodes)
+}
+
+// toElements converts nodes to elements
+func toElements(nodes []*node) []Element {
+	res := make([]Element, len(nodes))
+	for i, n := range nodes {
+		res[i] = Element{Member: n.member, Score: n.score}
+	}
+
+	return res
+}
+
+// normalizeRange converts indexes which can be negative into a positive range, ok is false when range is empty
+func normalizeRange(start, stop, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop || start >= length {
+		return 0, 0, false
+	}
+
+	return start, stop, true
+}
+
+// rangeNodes returns nodes between 0 based start and stop ranks
+func (z *ZSet) rangeNodes(start, stop int, reverse bool) []*node {
+	start, stop, ok := normalizeRange(start, stop, z.zsl.length)
+	if !ok {
+		return []*node{}
+	}
+
+	res := make([]*node, 0, stop-start+1)
+	if reverse {
+		for x := z.zsl.byRank(z.zsl.length - start); x != nil && len(res) < cap(res); x = x.backward {
+			res = append(res, x)
+		}
+		return res
+	}
+
+	for x := z.zsl.byRank(start + 1); x != nil && len(res) < cap(res); x = x.level[0].forward {
+		res = append(res, x)
+	}
+
+	return res
+}
+
+// Range returns elements between 0 based start and stop ranks, negative ranks are counted from the end
+func (z *ZSet) Range(start, stop int, reverse bool) []Element {
+	z.mux.RLock()
+	res := toElements(z.rangeNodes(start, stop, reverse))
+	z.mux.RUnlock()
+	return res
+}
+
+// RemoveRange removes elements between 0 based start and stop ranks
+func (z *ZSet) RemoveRange(start, stop int) int {
+	z.mux.Lock()
+	removed := z.removeNodes(z.rangeNodes(start, stop, false))
+	z.mux.Unlock()
+	return removed
+}
+
+// rangeByScoreNodes returns nodes in the score range skipping offset elements, a negative count returns all
+func (z *ZSet) rangeByScoreNodes(min, max ScoreBound, reverse bool, offset, count int) []*node {
+	res := make([]*node, 0)
+
+	if reverse {
+		x := z.zsl.lastInRange(min, max)
+		for ; x != nil && offset > 0; offset-- {
+			x = x.backward
+		}
+
+		for ; x != nil && min.lte(x.score) && (count < 0 || len(res) < count); x = x.backward {
+			res = append(res, x)
+		}
+		return res
+	}
+
+	x := z.zsl.firstInRange(min, max)
+	for ; x != nil && offset > 0; offset-- {
+		x = x.level[0].forward
+	}
+
+	for ; x != nil && max.gte(x.score) && (count < 0 || len(res) < count); x = x.level[0].forward {
+		res = append(res, x)
+	}
+
+	return res
+}
+
+// RangeByScore returns elements in the score range
+// offset elements are skipped and at most count elements are returned, a negative count returns all
+func (z *ZSet) RangeByScore(min, max ScoreBound, reverse bool, offset, count int) []Element {
+	z.mux.RLock()
+	res := toElements(z.rangeByScoreNodes(min, max, reverse, offset, count))
+	z.mux.RUnlock()
+	return res
+}
+
+// RemoveRangeByScore removes elements in the score range
+func (z *ZSet) RemoveRangeByScore(min, max ScoreBound) int {
+	z.mux.Lock()
+	removed := z.removeNodes(z.rangeByScoreNodes(min, max, false, 0, -1))
+	z.mux.Unlock()
+	return removed
+}
+
+// Count returns the number of elements in the score range
+func (z *ZSet) Count(min, max ScoreBound) int {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	first := z.zsl.firstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+
+	last := z.zsl.lastInRange(min, max)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// rangeByLexNodes returns nodes in the lexicographical range skipping offset elements, a negative count returns all
+func (z *ZSet) rangeByLexNodes(min, max LexBound, reverse bool, offset, count int) []*node {
+	res := make([]*node, 0)
+
+	if reverse {
+		x := z.zsl.lastInLexRange(min, max)
+		for ; x != nil && offset > 0; offset-- {
+			x = x.backward
+		}
+
+		for ; x != nil && min.lte(x.member) && (count < 0 || len(res) < count); x = x.backward {
+			res = append(res, x)
+		}
+		return res
+	}
+
+	x := z.zsl.firstInLexRange(min, max)
+	for ; x != nil && offset > 0; offset-- {
+		x = x.level[0].forward
+	}
+
+	for ; x != nil && max.gte(x.member) && (count < 0 || len(res) < count); x = x.level[0].forward {
+		res = append(res, x)
+	}
+
+	return res
+}
+
+// RangeByLex returns elements in the lexicographical range, it assumes all elements have the same score
+// offset elements are skipped and at most count elements are returned, a negative count returns all
+func (z *ZSet) RangeByLex(min, max LexBound, reverse bool, offset, count int) []Element {
+	z.mux.RLock()
+	res := toElements(z.rangeByLexNodes(min, max, reverse, offset, count))
+	z.mux.RUnlock()
+	return res
+}
+
+// RemoveRangeByLex removes elements in the lexicographical range
+func (z *ZSet) RemoveRangeByLex(min, max LexBound) int {
+	z.mux.Lock()
+	removed := z.removeNodes(z.rangeByLexNodes(min, max, false, 0, -1))
+	z.mux.Unlock()
+	return removed
+}
+
+// LexCount returns the number of elements in the lexicographical range
+func (z *ZSet) LexCount(min, max LexBound) int {
+	z.mux.RLock()
+	defer z.mux.RUnlock()
+
+	first := z.zsl.firstInLexRange(min, max)
+	if first == nil {
+		return 0
+	}
+
+	last := z.zsl.lastInLexRange(min, max)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+// PopMin removes and returns up to count elements with the lowest scores
+func (z *ZSet) PopMin(count int) []Element {
+	if count <= 0 {
+		return []Element{}
+	}
+
+	z.mux.Lock()
+	nodes := z.rangeNodes(0, count-1, false)
+	z.removeNodes(nodes)
+	z.mux.Unlock()
+	return toElements(nodes)
+}
+
+// PopMax removes and returns up to count elements with the highest scores
+func (z *ZSet) PopMax(count int) []Element {
+	if count <= 0 {
+		return []Element{}
+	}
+
+	z.mux.Lock()
+	nodes := z.rangeNodes(0, count-1, true)
+	z.removeNodes(nodes)
+	z.mux.Unlock()
+	return toElements(nodes)
+}
+
+// Elements returns all elements ordered by score
+func (z *ZSet) Elements() []Element {
+	return z.Range(0, -1, false)
+}
+
+// Scan calls fn for elements starting from cursor until at least count elements were visited
+// It returns the next cursor, a returned cursor of 0 means the iteration is completed
+// Every element which was in the ZSet during the whole iteration is visited at least once
+func (z *ZSet) Scan(cursor uint64, count int, fn func(elem Element)) uint64 {
+	z.mux.Lock()
+	cursor = z.index().Scan(cursor, count, func(member string) {
+		fn(Element{Member: member, Score: z.dict[member]})
+	})
+	z.mux.Unlock()
+	return cursor
+}
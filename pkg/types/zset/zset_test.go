@@ -0,0 +1,296 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package zset
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func members(elems []Element) []string {
+	res := make([]string, len(elems))
+	for i, e := range elems {
+		res[i] = e.Member
+	}
+
+	return res
+}
+
+func newTestZSet() *ZSet {
+	z := New()
+	z.Add(1, "a", 0)
+	z.Add(2, "b", 0)
+	z.Add(3, "c", 0)
+	z.Add(4, "d", 0)
+	z.Add(5, "e", 0)
+	return z
+}
+
+func TestZSet_Add(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+
+	score, res, err := z.Add(1, "a", 0)
+	assert.Nil(err)
+	assert.Equal(Added, res)
+	assert.Equal(float64(1), score)
+
+	_, res, _ = z.Add(1, "a", 0)
+	assert.Equal(Nop, res)
+
+	_, res, _ = z.Add(2, "a", 0)
+	assert.Equal(Updated, res)
+	assert.Equal(1, z.Len())
+
+	score, _ = z.Score("a")
+	assert.Equal(float64(2), score)
+}
+
+func TestZSet_AddFlags(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+
+	_, res, _ := z.Add(1, "a", FlagXX)
+	assert.Equal(Nop, res)
+	assert.Equal(0, z.Len())
+
+	z.Add(5, "a", 0)
+	_, res, _ = z.Add(1, "a", FlagNX)
+	assert.Equal(Nop, res)
+
+	_, res, _ = z.Add(1, "a", FlagGT)
+	assert.Equal(Nop, res)
+
+	_, res, _ = z.Add(10, "a", FlagGT)
+	assert.Equal(Updated, res)
+
+	_, res, _ = z.Add(20, "a", FlagLT)
+	assert.Equal(Nop, res)
+
+	score, res, _ := z.Add(-5, "a", FlagIncr)
+	assert.Equal(Updated, res)
+	assert.Equal(float64(5), score)
+
+	z.Add(math.Inf(1), "b", 0)
+	_, _, err := z.Add(math.Inf(-1), "b", FlagIncr)
+	assert.Equal(ErrNotANumber, err)
+}
+
+func TestZSet_Rank(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	rank, found := z.Rank("a", false)
+	assert.True(found)
+	assert.Equal(0, rank)
+
+	rank, _ = z.Rank("d", false)
+	assert.Equal(3, rank)
+
+	rank, _ = z.Rank("d", true)
+	assert.Equal(1, rank)
+
+	_, found = z.Rank("x", false)
+	assert.False(found)
+}
+
+func TestZSet_Range(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	assert.Equal([]string{"a", "b", "c", "d", "e"}, members(z.Range(0, -1, false)))
+	assert.Equal([]string{"b", "c"}, members(z.Range(1, 2, false)))
+	assert.Equal([]string{"e", "d"}, members(z.Range(0, 1, true)))
+	assert.Equal([]string{"d", "e"}, members(z.Range(-2, 100, false)))
+	assert.Equal([]string{}, members(z.Range(3, 1, false)))
+}
+
+func TestZSet_RangeByScore(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	min, _ := ParseScoreBound("2")
+	max, _ := ParseScoreBound("(4")
+	assert.Equal([]string{"b", "c"}, members(z.RangeByScore(min, max, false, 0, -1)))
+	assert.Equal([]string{"c", "b"}, members(z.RangeByScore(min, max, true, 0, -1)))
+	assert.Equal(2, z.Count(min, max))
+
+	min, _ = ParseScoreBound("-inf")
+	max, _ = ParseScoreBound("+inf")
+	assert.Equal([]string{"b", "c"}, members(z.RangeByScore(min, max, false, 1, 2)))
+	assert.Equal([]string{"d", "c"}, members(z.RangeByScore(min, max, true, 1, 2)))
+	assert.Equal(5, z.Count(min, max))
+
+	min, _ = ParseScoreBound("(5")
+	assert.Equal(0, z.Count(min, max))
+
+	_, err := ParseScoreBound("abc")
+	assert.Equal(ErrInvalidScoreBound, err)
+}
+
+func TestZSet_RangeByLex(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+	for _, m := range []string{"a", "b", "c", "d", "e"} {
+		z.Add(0, m, 0)
+	}
+
+	min, _ := ParseLexBound("[b")
+	max, _ := ParseLexBound("(d")
+	assert.Equal([]string{"b", "c"}, members(z.RangeByLex(min, max, false, 0, -1)))
+	assert.Equal(2, z.LexCount(min, max))
+
+	min, _ = ParseLexBound("-")
+	max, _ = ParseLexBound("+")
+	assert.Equal([]string{"e", "d"}, members(z.RangeByLex(min, max, true, 0, 2)))
+	assert.Equal(5, z.LexCount(min, max))
+
+	_, err := ParseLexBound("b")
+	assert.Equal(ErrInvalidLexBound, err)
+}
+
+func TestZSet_RemoveRanges(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	assert.Equal(2, z.RemoveRange(0, 1))
+	assert.Equal([]string{"c", "d", "e"}, members(z.Elements()))
+
+	min, _ := ParseScoreBound("4")
+	max, _ := ParseScoreBound("5")
+	assert.Equal(2, z.RemoveRangeByScore(min, max))
+	assert.Equal([]string{"c"}, members(z.Elements()))
+
+	lmin, _ := ParseLexBound("-")
+	lmax, _ := ParseLexBound("+")
+	assert.Equal(1, z.RemoveRangeByLex(lmin, lmax))
+	assert.Equal(0, z.Len())
+}
+
+func TestZSet_Pop(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	assert.Equal([]Element{{"a", 1}, {"b", 2}}, z.PopMin(2))
+	assert.Equal([]Element{{"e", 5}}, z.PopMax(1))
+	assert.Equal([]Element{}, z.PopMax(0))
+	assert.Equal([]string{"c", "d"}, members(z.Elements()))
+	assert.Equal([]string{"d", "c"}, members(z.PopMax(10)))
+	assert.Equal(0, z.Len())
+}
+
+func TestZSet_Remove(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := newTestZSet()
+
+	assert.Equal(2, z.Remove([]string{"a", "c", "x"}))
+	assert.Equal([]string{"b", "d", "e"}, members(z.Elements()))
+
+	_, found := z.Score("a")
+	assert.False(found)
+}
+
+func TestZSet_RandomizedRanks(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+	scores := make(map[string]float64)
+
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rand.Intn(500))
+		score := float64(rand.Intn(100))
+		if rand.Intn(4) == 0 {
+			z.Remove([]string{member})
+			delete(scores, member)
+			continue
+		}
+
+		z.Add(score, member, 0)
+		scores[member] = score
+	}
+
+	expected := make([]Element, 0, len(scores))
+	for m, s := range scores {
+		expected = append(expected, Element{Member: m, Score: s})
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Score == expected[j].Score {
+			return expected[i].Member < expected[j].Member
+		}
+		return expected[i].Score < expected[j].Score
+	})
+
+	assert.Equal(expected, z.Elements())
+	for i, e := range expected {
+		rank, found := z.Rank(e.Member, false)
+		assert.True(found)
+		assert.Equal(i, rank)
+	}
+}
+
+func TestZSet_Scan(t *testing.T) {
+	assert := testifyAssert.New(t)
+	z := New()
+
+	for i := 0; i < 100; i++ {
+		z.Add(float64(i), strconv.Itoa(i), 0)
+	}
+	z.Remove([]string{"0"})
+	z.PopMax(1)
+
+	// the index is built by the first scan
+	assert.Nil(z.idx)
+
+	seen := make(map[string]float64)
+	cursor := z.Scan(0, 10, func(elem Element) {
+		seen[elem.Member] = elem.Score
+	})
+	assert.NotEqual(uint64(0), cursor)
+	assert.Equal(98, z.idx.Len())
+
+	for cursor != 0 {
+		cursor = z.Scan(cursor, 10, func(elem Element) {
+			seen[elem.Member] = elem.Score
+		})
+	}
+
+	assert.Len(seen, 98)
+	assert.Equal(float64(42), seen["42"])
+	assert.NotContains(seen, "0")
+	assert.NotContains(seen, "99")
+
+	// the index follows later changes
+	z.Remove([]string{"1"})
+	z.Add(500, "new", 0)
+	z.PopMin(2)
+	assert.Equal(96, z.idx.Len())
+	assert.True(z.idx.Contains("new"))
+	assert.False(z.idx.Contains("2"))
+}
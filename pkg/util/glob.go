@@ -0,0 +1,128 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package util
+
+// GlobMatch reports whether str matches the glob style pattern
+// '*' matches any sequence of characters, '?' matches a single character, [abc] matches one of the characters
+// inside brackets where [^abc] negates and [a-z] matches a range, and a backslash escapes the next character
+func GlobMatch(pattern, str string) bool {
+	p, s := 0, 0
+
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// collapse consecutive stars
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+
+			if p+1 == len(pattern) {
+				return true
+			}
+
+			for i := s; i <= len(str); i++ {
+				if GlobMatch(pattern[p+1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+
+			var matched bool
+			matched, p = matchClass(pattern, p+1, str[s])
+			if !matched {
+				return false
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if s >= len(str) || pattern[p] != str[s] {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+
+	return s == len(str)
+}
+
+// matchClass matches c against a bracket expression starting at pos(just after '[')
+// it returns whether c matched and the position of the closing bracket
+func matchClass(pattern string, pos int, c byte) (bool, int) {
+	not := false
+	if pos < len(pattern) && pattern[pos] == '^' {
+		not = true
+		pos++
+	}
+
+	matched := false
+	for ; pos < len(pattern) && pattern[pos] != ']'; pos++ {
+		switch {
+		case pattern[pos] == '\\' && pos+1 < len(pattern):
+			pos++
+			if pattern[pos] == c {
+				matched = true
+			}
+		case pos+2 < len(pattern) && pattern[pos+1] == '-' && pattern[pos+2] != ']':
+			start, end := pattern[pos], pattern[pos+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pos += 2
+		default:
+			if pattern[pos] == c {
+				matched = true
+			}
+		}
+	}
+
+	// unterminated bracket, treat the end of pattern as the closing bracket
+	if pos >= len(pattern) {
+		pos = len(pattern) - 1
+	}
+
+	if not {
+		matched = !matched
+	}
+
+	return matched, pos
+}
@@ -0,0 +1,69 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2019 Kasun Vithanage
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ *
+ */
+
+package util
+
+import (
+	"testing"
+
+	testifyAssert "github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.True(GlobMatch("*", ""))
+	assert.True(GlobMatch("*", "anything"))
+	assert.True(GlobMatch("h?llo", "hello"))
+	assert.True(GlobMatch("h*llo", "heeeello"))
+	assert.True(GlobMatch("h*llo", "hllo"))
+	assert.True(GlobMatch("user:*:name", "user:1000:name"))
+	assert.True(GlobMatch("user/*", "user/a/b"))
+	assert.False(GlobMatch("h?llo", "hllo"))
+	assert.False(GlobMatch("h*llo", "hello world"))
+	assert.False(GlobMatch("hello", "hello!"))
+}
+
+func TestGlobMatchClass(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.True(GlobMatch("h[ae]llo", "hello"))
+	assert.True(GlobMatch("h[ae]llo", "hallo"))
+	assert.False(GlobMatch("h[ae]llo", "hillo"))
+	assert.True(GlobMatch("h[^e]llo", "hallo"))
+	assert.False(GlobMatch("h[^e]llo", "hello"))
+	assert.True(GlobMatch("h[a-b]llo", "hbllo"))
+	assert.False(GlobMatch("h[a-b]llo", "hcllo"))
+	assert.True(GlobMatch("[*]", "*"))
+}
+
+func TestGlobMatchEscape(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	assert.True(GlobMatch(`h\*llo`, "h*llo"))
+	assert.False(GlobMatch(`h\*llo`, "hello"))
+	assert.True(GlobMatch(`h\?llo`, "h?llo"))
+	assert.True(GlobMatch(`[\]]`, "]"))
+}
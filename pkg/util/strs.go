@@ -43,59 +43,109 @@ func ToString(i interface{}) string {
 	return ""
 }
 
-// SplitSpacesWithQuotes will split the string by spaces and preserve texts inside " " marks
-// error is returned when an unbalanced quote was found in the string
+// SplitSpacesWithQuotes will split the string by white space like the inline commands of redis
+// Texts inside " " marks are kept together and support escapes like \n, \t and \xHH, texts inside ' ' marks
+// only support \' and texts without marks are taken as they are. An error is returned when a quote is not closed
+// or is not followed by white space
 func SplitSpacesWithQuotes(s string) ([]string, error) {
 	var ret []string
-	var buf = new(strings.Builder) // not in quote string buffer
-	var scanned string
-	var err error
 
-	for pos := 0; pos < len(s); pos++ {
-		char := s[pos]
+	for pos := 0; ; {
+		// white space between arguments is collapsed
+		for pos < len(s) && isSpace(s[pos]) {
+			pos++
+		}
 
-		switch char {
-		case ' ':
-			if buf.Len() > 0 {
-				ret = append(ret, buf.String())
-				buf.Reset()
-			}
-		case '"':
-			pos, scanned, err = scanForByte(s, pos, '"')
-			if err != nil {
-				return nil, err
+		if pos == len(s) {
+			return ret, nil
+		}
+
+		var buf = new(strings.Builder)
+		for pos < len(s) && !isSpace(s[pos]) {
+			switch char := s[pos]; char {
+			case '"', '\'':
+				end, err := scanQuoted(s, pos, buf)
+				if err != nil {
+					return nil, err
+				}
+
+				// a closing quote must end the argument
+				if end+1 < len(s) && !isSpace(s[end+1]) {
+					return nil, ErrUnbalancedQuotes
+				}
+				pos = end + 1
+			default:
+				buf.WriteByte(char)
+				pos++
 			}
-			ret = append(ret, scanned)
-		default:
-			buf.WriteByte(char)
 		}
-	}
 
-	if buf.Len() > 0 {
 		ret = append(ret, buf.String())
 	}
-
-	return ret, nil
 }
 
-func scanForByte(s string, pos int, r byte) (int, string, error) {
-	var ret = new(strings.Builder)
+// scanQuoted writes the text quoted at pos to buf and returns the position of the closing quote
+func scanQuoted(s string, pos int, buf *strings.Builder) (int, error) {
+	quote := s[pos]
 	for pos++; pos < len(s); pos++ {
 		char := s[pos]
 
-		switch char {
-		case '\\':
-			if pos >= len(s)-1 {
-				return 0, "", ErrUnbalancedQuotes
+		switch {
+		case char == quote:
+			return pos, nil
+		case char != '\\' || pos+1 >= len(s):
+			buf.WriteByte(char)
+		case quote == '\'':
+			// single quotes only escape themselves
+			if s[pos+1] == '\'' {
+				pos++
 			}
-			pos++
-			ret.WriteByte(s[pos])
-		case r:
-			return pos, ret.String(), nil
+			buf.WriteByte(s[pos])
+		case s[pos+1] == 'x' && pos+3 < len(s) && isHex(s[pos+2]) && isHex(s[pos+3]):
+			buf.WriteByte(unhex(s[pos+2])<<4 | unhex(s[pos+3]))
+			pos += 3
 		default:
-			ret.WriteByte(char)
+			pos++
+			buf.WriteByte(unescape(s[pos]))
 		}
 	}
 
-	return 0, "", ErrUnbalancedQuotes
+	return 0, ErrUnbalancedQuotes
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+
+	return c - 'A' + 10
+}
+
+// unescape returns the character escaped by a backslash, unknown escapes are the character itself
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+
+	return c
 }
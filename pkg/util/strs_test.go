@@ -60,6 +60,37 @@ func TestSplitSpacesWithQuotes(t *testing.T) {
 	assert.Len(res, 0)
 }
 
+func TestSplitSpacesWithQuotesEscapes(t *testing.T) {
+	assert := testifyAssert.New(t)
+
+	testCases := []struct {
+		s        string
+		expected []string
+	}{
+		{"", nil},
+		{" \t \r\n ", nil},
+		{"set  greeting\t\"hello world\"", []string{"set", "greeting", "hello world"}},
+		{`"" ''`, []string{"", ""}},
+		{`"a\nb\r\t\b\a\\c\q"`, []string{"a\nb\r\t\b\a\\cq"}},
+		{`"\x41\x7a\x00" "\xZZ" "\x4"`, []string{"Az\x00", "xZZ", "x4"}},
+		{`'it\'s' '\n\x41' '"'`, []string{"it's", `\n\x41`, `"`}},
+		{`foo"bar baz" a'b c'`, []string{"foobar baz", "ab c"}},
+	}
+
+	for _, testCase := range testCases {
+		res, err := SplitSpacesWithQuotes(testCase.s)
+		assert.Nil(err, testCase.s)
+		assert.Equal(testCase.expected, res, testCase.s)
+	}
+
+	// quotes must be closed and followed by white space
+	for _, s := range []string{`"foo`, `'foo`, `"foo\"`, `"foo\`, `'foo\'`, `"foo"bar`, `'foo'"bar"`, `a\"b`} {
+		res, err := SplitSpacesWithQuotes(s)
+		assert.Equal(ErrUnbalancedQuotes, err, s)
+		assert.Len(res, 0)
+	}
+}
+
 func BenchmarkSplitSpacesWithQuotes(b *testing.B) {
 	testString := ` foo     bar "foo bar bar"    foo     bar "foo bar bar" foo     bar "foo bar bar" foo     bar "foo bar bar" \"`
 	for i := 0; i < b.N; i++ {